@@ -1,13 +1,30 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"io"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"redditclone/internal/ban"
+	"redditclone/internal/community"
 	"redditclone/internal/handler"
+	"redditclone/internal/mail"
 	"redditclone/internal/middleware"
+	"redditclone/internal/persist"
 	"redditclone/internal/post"
+	"redditclone/internal/report"
+	"redditclone/internal/reset"
+	"redditclone/internal/search"
+	"redditclone/internal/session"
+	"redditclone/internal/storage"
+	"redditclone/internal/token"
 	"redditclone/internal/user"
 	"strings"
+	"syscall"
 	"time"
 )
 
@@ -20,41 +37,290 @@ func stripTrailingSlash(next http.Handler) http.Handler {
 	})
 }
 
+// every runs f every interval until ctx is done.
+func every(ctx context.Context, interval time.Duration, f func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			f()
+		}
+	}
+}
+
 func main() {
-	// Initialize repositories (in-memory)
-	userRepo := user.NewMemoryRepo()
-	postRepo := post.NewMemoryRepo()
+	backend := flag.String("storage", "memory", "storage backend: memory or sqlite")
+	dsn := flag.String("db", "redditclone.db", "SQLite database file (sqlite storage only)")
+	dataDir := flag.String("data-dir", "", "directory for snapshots and the operation log (memory storage only); empty keeps data in memory")
+	snapshotInterval := flag.Duration("snapshot-interval", 5*time.Minute, "how often to compact the operation log into a snapshot")
+	restoreWindow := flag.Duration("restore-window", 24*time.Hour, "how long after deletion authors may restore posts and comments")
+	retention := flag.Duration("retention", 30*24*time.Hour, "how long deleted posts and comments are kept before being purged")
+	jwtKeys := flag.String("jwt-keys", "", "JSON file with the issuer, audience and keys of access tokens; empty signs with HS256 and $JWT_SECRET")
+	accessTTL := flag.Duration("access-ttl", 15*time.Minute, "how long access tokens are valid")
+	refreshTTL := flag.Duration("refresh-ttl", 30*24*time.Hour, "how long a session lasts without being refreshed")
+	resetTTL := flag.Duration("reset-ttl", time.Hour, "how long password reset tokens work")
+	resetURL := flag.String("reset-url", "", "frontend page password reset links point to, given the token as ?token=; empty mails the bare token")
+	smtpAddr := flag.String("smtp-addr", "", "SMTP server (host:port) to send mail through, with the password in $SMTP_PASSWORD; empty writes mail to -mail-file")
+	smtpUser := flag.String("smtp-user", "", "SMTP username; empty sends without authenticating")
+	mailFrom := flag.String("mail-from", "redditclone@localhost", "sender address of outgoing mail")
+	mailFile := flag.String("mail-file", "", "file to append outgoing mail to when there is no -smtp-addr; empty writes it to the log")
+	deletedVotes := flag.String("deleted-votes", string(post.KeepVotes), "what becomes of the votes of deleted accounts: keep or remove")
+	rateLimits := flag.String("rate-limits", "", "requests allowed per client and route class over the defaults, such as write=30/1m,auth=10/1m; class=0 lifts a limit")
+	rateStore := flag.String("rate-store", "memory", "where rate limit buckets are kept: memory, or sqlite to share them between servers using the same -db")
+	admins := flag.String("admins", "", "comma-separated usernames to make admins at startup; they must be registered already")
+	flag.Parse()
+
+	votePolicy, err := post.ParseVotePolicy(*deletedVotes)
+	if err != nil {
+		log.Fatalf("-deleted-votes: %v", err)
+	}
+	limits, err := middleware.ParseRateLimits(*rateLimits)
+	if err != nil {
+		log.Fatalf("-rate-limits: %v", err)
+	}
+
+	// Initialize repositories
+	var userRepo user.Repo
+	var postRepo post.Repo
+	var communityRepo community.Repo
+	var reportRepo report.Repo
+	var banRepo ban.Repo
+	var sessionRepo session.Repo
+	var resetRepo reset.Repo
+	var buckets middleware.RateStore = middleware.NewMemoryRateStore()
+	// Stores closed, in order, once the server has shut down
+	var closers []io.Closer
+	// Background work stops on SIGINT or SIGTERM, and so does the server
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	switch *backend {
+	case "memory":
+		memUsers := user.NewMemoryRepo()
+		memPosts := post.NewMemoryRepo()
+		memCommunities := community.NewMemoryRepo()
+		memReports := report.NewMemoryRepo()
+		memBans := ban.NewMemoryRepo()
+		memSessions := session.NewMemoryRepo()
+		memResets := reset.NewMemoryRepo()
+		userRepo, postRepo, communityRepo, reportRepo = memUsers, memPosts, memCommunities, memReports
+		banRepo, sessionRepo, resetRepo = memBans, memSessions, memResets
+		if *dataDir != "" {
+			store, err := persist.Open(*dataDir, memUsers, memPosts, memCommunities, memReports, memBans, memSessions, memResets)
+			if err != nil {
+				log.Fatalf("open data dir: %v", err)
+			}
+			closers = append(closers, store)
+			go store.Run(ctx, *snapshotInterval)
+			userRepo, postRepo, communityRepo, reportRepo = store.Users(), store.Posts(), store.Communities(), store.Reports()
+			banRepo, sessionRepo, resetRepo = store.Bans(), store.Sessions(), store.Resets()
+		}
+	case "sqlite":
+		db, err := storage.Open("sqlite", *dsn)
+		if err != nil {
+			log.Fatalf("open database: %v", err)
+		}
+		closers = append(closers, db)
+		userRepo = user.NewSQLRepo(db)
+		postRepo = post.NewSQLRepo(db)
+		communityRepo = community.NewSQLRepo(db)
+		reportRepo = report.NewSQLRepo(db)
+		banRepo = ban.NewSQLRepo(db)
+		sessionRepo = session.NewSQLRepo(db)
+		resetRepo = reset.NewSQLRepo(db)
+		if *rateStore == "sqlite" {
+			buckets = middleware.NewSQLRateStore(db)
+		}
+	default:
+		log.Fatalf("unknown storage backend %q", *backend)
+	}
+	switch {
+	case *rateStore == "sqlite" && *backend != "sqlite":
+		log.Fatal("-rate-store sqlite needs -storage sqlite")
+	case *rateStore != "sqlite" && *rateStore != "memory":
+		log.Fatalf("unknown rate limit store %q", *rateStore)
+	}
+
+	// Load the keys access tokens are signed and verified with
+	tokenConfig := token.DefaultConfig()
+	if *jwtKeys != "" {
+		var err error
+		if tokenConfig, err = token.LoadConfig(*jwtKeys); err != nil {
+			log.Fatalf("load token keys: %v", err)
+		}
+	} else if os.Getenv(token.SecretEnv) == "" {
+		log.Printf("no -jwt-keys or $%s: signing with a random key, tokens will not survive a restart", token.SecretEnv)
+	}
+	tokenConfig.TTL = *accessTTL
+	tokens, err := token.New(tokenConfig)
+	if err != nil {
+		log.Fatalf("load token keys: %v", err)
+	}
+
+	// Pick how mail, such as password resets, is delivered
+	var mailer mail.Mailer = mail.NewFile(*mailFile, *mailFrom)
+	if *smtpAddr != "" {
+		mailer = mail.NewSMTP(*smtpAddr, *mailFrom, *smtpUser, os.Getenv("SMTP_PASSWORD"))
+	}
+
+	// Bootstrap the first admins; later ones can be appointed over the API
+	for _, name := range strings.Split(*admins, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if _, err := userRepo.SetAdmin(name, true); err != nil {
+			log.Printf("make %q an admin: %v", name, err)
+		}
+	}
+
+	// Keep the search index in step with every change to posts
+	searchIndex := search.NewIndex()
+	if err := searchIndex.Build(postRepo); err != nil {
+		log.Fatalf("build search index: %v", err)
+	}
+	postRepo = search.Indexed(postRepo, searchIndex)
 
 	// Initialize handlers
-	userHandler := handler.NewUserHandler(userRepo)
-	postHandler := handler.NewPostHandler(postRepo)
+	userHandler := handler.NewUserHandler(userRepo, communityRepo, sessionRepo, tokens, *refreshTTL)
+	postHandler := handler.NewPostHandler(postRepo, communityRepo, reportRepo, *restoreWindow)
+	communityHandler := handler.NewCommunityHandler(communityRepo, userRepo)
+	reportHandler := handler.NewReportHandler(reportRepo, postRepo, communityRepo)
+	profileHandler := handler.NewProfileHandler(userRepo, postRepo)
+	searchHandler := handler.NewSearchHandler(searchIndex, postRepo)
+	banHandler := handler.NewBanHandler(banRepo, userRepo, communityRepo)
+	accountHandler := handler.NewAccountHandler(userRepo, postRepo, communityRepo, sessionRepo, resetRepo, mailer, votePolicy, *resetTTL, *resetURL)
+
+	// Hard-delete soft-deleted content once retention expires
+	go post.RunPurge(ctx, postRepo, max(*retention, *restoreWindow), time.Hour)
+
+	// Ended sessions are dropped once the access tokens issued for them
+	// have expired too, and reset tokens once they are used or expire
+	go every(ctx, time.Hour, func() {
+		if _, err := sessionRepo.Prune(time.Now().Add(-*accessTTL)); err != nil {
+			log.Printf("prune sessions: %v", err)
+		}
+		if _, err := resetRepo.Prune(time.Now()); err != nil {
+			log.Printf("prune reset tokens: %v", err)
+		}
+	})
 
 	// Main router
 	mux := http.NewServeMux()
 
+	// Both auth middlewares check the token's session and the bans against
+	// the user on every request
+	auth := middleware.Auth(tokens, banRepo, sessionRepo)
+	optionalAuth := middleware.OptionalAuth(tokens, banRepo, sessionRepo)
+
+	// Every route is limited per user, or per IP for anonymous callers, by
+	// the class of what it does
+	limiter := middleware.NewRateLimiter(buckets, limits)
+	readLimit := func(h http.HandlerFunc) http.Handler {
+		return limiter.Limit(middleware.ClassRead, h)
+	}
+	writeLimit := func(h http.HandlerFunc) http.Handler {
+		return limiter.Limit(middleware.ClassWrite, h)
+	}
+	voteLimit := func(h http.HandlerFunc) http.Handler {
+		return limiter.Limit(middleware.ClassVote, h)
+	}
+	authLimit := func(h http.HandlerFunc) http.Handler {
+		return limiter.Limit(middleware.ClassAuth, h)
+	}
+
+	// publicRead serves a public read to anyone. A signed-in caller is
+	// known to it, so they are limited per user rather than per IP and
+	// listings leave out the posts they hid.
+	publicRead := func(h http.HandlerFunc) http.Handler {
+		return optionalAuth(readLimit(h))
+	}
+
 	// --- Public routes ---
 	// User routes
-	mux.HandleFunc("POST /api/register", userHandler.Register)
-	mux.HandleFunc("POST /api/login", userHandler.Login)
-	mux.HandleFunc("GET /api/user/{USER_LOGIN}", postHandler.ListByUser)
+	mux.Handle("POST /api/register", authLimit(userHandler.Register))
+	mux.Handle("POST /api/login", authLimit(userHandler.Login))
+	mux.Handle("POST /api/refresh", authLimit(userHandler.Refresh))
+	mux.Handle("POST /api/logout", authLimit(userHandler.Logout))
+	mux.Handle("POST /api/password/forgot", authLimit(accountHandler.ForgotPassword))
+	mux.Handle("POST /api/password/reset", authLimit(accountHandler.ResetPassword))
+	mux.HandleFunc("GET /.well-known/jwks.json", userHandler.JWKS)
+	mux.Handle("GET /api/user/{USER_LOGIN}", publicRead(postHandler.ListByUser))
+	mux.Handle("GET /api/user/{USER_LOGIN}/profile", publicRead(profileHandler.Profile))
+	mux.Handle("GET /api/user/{USER_LOGIN}/posts", publicRead(postHandler.ListByUser))
+	mux.Handle("GET /api/user/{USER_LOGIN}/comments", publicRead(profileHandler.Comments))
 
 	// Post routes
-	mux.HandleFunc("GET /api/posts", postHandler.List)
-	mux.HandleFunc("GET /api/posts/{CATEGORY_NAME}", postHandler.ListByCategory)
-	mux.HandleFunc("GET /api/post/{POST_ID}", postHandler.GetByID)
+	mux.Handle("GET /api/posts", publicRead(postHandler.List))
+	mux.Handle("GET /api/posts/{CATEGORY_NAME}", publicRead(postHandler.ListByCategory))
+	mux.Handle("GET /api/post/{POST_ID}", publicRead(postHandler.GetByID))
+	mux.Handle("GET /api/post/{POST_ID}/history", publicRead(postHandler.History))
+	mux.Handle("GET /api/post/{POST_ID}/{COMMENT_ID}/history", publicRead(postHandler.CommentHistory))
+	mux.Handle("GET /api/search", publicRead(searchHandler.Search))
+
+	// Community routes
+	mux.Handle("GET /api/categories", publicRead(communityHandler.List))
+	mux.Handle("GET /api/category/{CATEGORY_NAME}", publicRead(communityHandler.Get))
+
+	// The feed is personal for signed-in users and falls back to the
+	// default communities for everyone else
+	mux.Handle("GET /api/feed", publicRead(postHandler.Feed))
 
 	// --- Authenticated routes ---
 	authMux := http.NewServeMux()
-	authMux.HandleFunc("POST /api/posts", postHandler.Add)
-	authMux.HandleFunc("POST /api/post/{POST_ID}", postHandler.AddComment)
-	authMux.HandleFunc("DELETE /api/post/{POST_ID}/{COMMENT_ID}", postHandler.DeleteComment)
-	authMux.HandleFunc("GET /api/post/{POST_ID}/upvote", postHandler.Upvote)
-	authMux.HandleFunc("GET /api/post/{POST_ID}/downvote", postHandler.Downvote)
-	authMux.HandleFunc("GET /api/post/{POST_ID}/unvote", postHandler.Unvote)
-	authMux.HandleFunc("DELETE /api/post/{POST_ID}", postHandler.Delete)
+	authMux.Handle("POST /api/posts", writeLimit(postHandler.Add))
+	authMux.Handle("POST /api/categories", writeLimit(communityHandler.Create))
+	authMux.Handle("POST /api/category/{CATEGORY_NAME}/subscribe", writeLimit(communityHandler.Subscribe))
+	authMux.Handle("POST /api/category/{CATEGORY_NAME}/unsubscribe", writeLimit(communityHandler.Unsubscribe))
+	authMux.Handle("GET /api/subscriptions", readLimit(communityHandler.Subscriptions))
+	authMux.Handle("POST /api/category/{CATEGORY_NAME}/moderators", writeLimit(communityHandler.AddModerator))
+	authMux.Handle("POST /api/post/{POST_ID}", writeLimit(postHandler.AddComment))
+	authMux.Handle("DELETE /api/post/{POST_ID}/{COMMENT_ID}", writeLimit(postHandler.DeleteComment))
+	authMux.Handle("GET /api/post/{POST_ID}/upvote", voteLimit(postHandler.Upvote))
+	authMux.Handle("GET /api/post/{POST_ID}/downvote", voteLimit(postHandler.Downvote))
+	authMux.Handle("GET /api/post/{POST_ID}/unvote", voteLimit(postHandler.Unvote))
+	authMux.Handle("GET /api/post/{POST_ID}/{COMMENT_ID}/upvote", voteLimit(postHandler.UpvoteComment))
+	authMux.Handle("GET /api/post/{POST_ID}/{COMMENT_ID}/downvote", voteLimit(postHandler.DownvoteComment))
+	authMux.Handle("GET /api/post/{POST_ID}/{COMMENT_ID}/unvote", voteLimit(postHandler.UnvoteComment))
+	authMux.Handle("POST /api/post/{POST_ID}/save", writeLimit(postHandler.Save))
+	authMux.Handle("POST /api/post/{POST_ID}/unsave", writeLimit(postHandler.Unsave))
+	authMux.Handle("POST /api/post/{POST_ID}/hide", writeLimit(postHandler.Hide))
+	authMux.Handle("POST /api/post/{POST_ID}/unhide", writeLimit(postHandler.Unhide))
+	authMux.Handle("GET /api/user/me/saved", readLimit(postHandler.ListSaved))
+	authMux.Handle("GET /api/sessions", readLimit(userHandler.Sessions))
+	authMux.Handle("DELETE /api/sessions/{SESSION_ID}", writeLimit(userHandler.RevokeSession))
+	authMux.Handle("PUT /api/user/me/password", authLimit(accountHandler.ChangePassword))
+	authMux.Handle("PUT /api/user/me/email", writeLimit(accountHandler.SetEmail))
+	authMux.Handle("GET /api/user/me/export", readLimit(accountHandler.Export))
+	authMux.Handle("DELETE /api/user/me", authLimit(accountHandler.DeleteAccount))
+	authMux.Handle("DELETE /api/post/{POST_ID}", writeLimit(postHandler.Delete))
+	authMux.Handle("PUT /api/post/{POST_ID}", writeLimit(postHandler.Edit))
+	authMux.Handle("PUT /api/post/{POST_ID}/{COMMENT_ID}", writeLimit(postHandler.EditComment))
+	authMux.Handle("POST /api/post/{POST_ID}/restore", writeLimit(postHandler.Restore))
+	authMux.Handle("POST /api/post/{POST_ID}/{COMMENT_ID}/restore", writeLimit(postHandler.RestoreComment))
+	authMux.Handle("POST /api/post/{POST_ID}/report", writeLimit(reportHandler.ReportPost))
+	authMux.Handle("POST /api/post/{POST_ID}/{COMMENT_ID}/report", writeLimit(reportHandler.ReportComment))
+
+	// Moderation: only moderators of the category may see its queue and
+	// resolve reports
+	authMux.Handle("GET /api/category/{CATEGORY_NAME}/queue", readLimit(reportHandler.Queue))
+	authMux.Handle("POST /api/post/{POST_ID}/resolve", writeLimit(reportHandler.ResolvePost))
+	authMux.Handle("POST /api/post/{POST_ID}/{COMMENT_ID}/resolve", writeLimit(reportHandler.ResolveComment))
+	authMux.Handle("GET /api/category/{CATEGORY_NAME}/bans", readLimit(banHandler.ListCategory))
+	authMux.Handle("POST /api/category/{CATEGORY_NAME}/bans", writeLimit(banHandler.BanFromCategory))
+	authMux.Handle("DELETE /api/category/{CATEGORY_NAME}/bans/{USER_LOGIN}", writeLimit(banHandler.UnbanFromCategory))
+
+	// Admin only
+	authMux.Handle("PUT /api/user/{USER_LOGIN}/admin", middleware.RequireAdmin(writeLimit(userHandler.SetAdmin)))
+	authMux.Handle("DELETE /api/category/{CATEGORY_NAME}/moderators/{USER_LOGIN}",
+		middleware.RequireAdmin(writeLimit(communityHandler.RemoveModerator)))
+	authMux.Handle("GET /api/bans", middleware.RequireAdmin(readLimit(banHandler.List)))
+	authMux.Handle("POST /api/user/{USER_LOGIN}/ban", middleware.RequireAdmin(writeLimit(banHandler.BanUser)))
+	authMux.Handle("DELETE /api/user/{USER_LOGIN}/ban", middleware.RequireAdmin(writeLimit(banHandler.UnbanUser)))
 
 	// Apply Auth middleware to the authenticated router
-	mux.Handle("/api/", middleware.Auth(authMux))
+	mux.Handle("/api/", auth(authMux))
 
 	// --- Static file serving ---
 	// Serve static files from the html directory
@@ -73,8 +339,30 @@ func main() {
 		WriteTimeout: 10 * time.Second,
 		IdleTimeout:  120 * time.Second,
 	}
-	err := server.ListenAndServe()
+	served := make(chan error, 1)
+	go func() { served <- server.ListenAndServe() }()
+	select {
+	case err = <-served:
+		log.Printf("server: %v", err)
+	case <-ctx.Done():
+		log.Println("Shutting down")
+	}
+	stop()
+
+	// Let requests in flight finish before the stores they write to close,
+	// so that the final snapshot includes them
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	if serr := server.Shutdown(shutdownCtx); serr != nil && !errors.Is(serr, http.ErrServerClosed) {
+		log.Printf("shut down server: %v", serr)
+	}
+	for _, c := range closers {
+		if cerr := c.Close(); cerr != nil {
+			log.Printf("close storage: %v", cerr)
+			err = cerr
+		}
+	}
 	if err != nil {
-		log.Fatal(err)
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,203 @@
+package ban
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	ErrNotFound    = errors.New("ban not found")
+	ErrNoReason    = errors.New("reason required")
+	ErrTooLong     = errors.New("reason too long")
+	ErrBadDuration = errors.New("duration must be positive")
+)
+
+// MaxReasonLength is the longest reason a ban may give.
+const MaxReasonLength = 300
+
+// Ban keeps a user out of the whole site, or out of one category when
+// Category is set. A ban that expires is a suspension.
+type Ban struct {
+	UserID   string    `json:"user_id"`
+	Username string    `json:"username"`
+	Category string    `json:"category,omitempty"`
+	Reason   string    `json:"reason"`
+	By       string    `json:"by"`       // ID of the admin or moderator who banned the user
+	ByAdmin  bool      `json:"by_admin"` // whether By banned the user as an admin
+	Created  time.Time `json:"created"`
+	Expires  time.Time `json:"expires,omitzero"` // zero for a permanent ban
+}
+
+// New validates a ban of the user by the admin or moderator by; byAdmin
+// tells which. A zero duration bans the user for good.
+func New(userID, username, category, reason, by string, byAdmin bool, created time.Time, duration time.Duration) (*Ban, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, ErrNoReason
+	}
+	if utf8.RuneCountInString(reason) > MaxReasonLength {
+		return nil, ErrTooLong
+	}
+	if duration < 0 {
+		return nil, ErrBadDuration
+	}
+	b := &Ban{
+		UserID:   userID,
+		Username: username,
+		Category: category,
+		Reason:   reason,
+		By:       by,
+		ByAdmin:  byAdmin,
+		Created:  created,
+	}
+	if duration > 0 {
+		b.Expires = created.Add(duration)
+	}
+	return b, nil
+}
+
+// InForce reports whether the ban still applies at now.
+func (b *Ban) InForce(now time.Time) bool {
+	return b.Expires.IsZero() || now.Before(b.Expires)
+}
+
+type Repo interface {
+	// Put bans a user, replacing an earlier ban of the user from the same
+	// category or from the site.
+	Put(b *Ban) (*Ban, error)
+	// Get returns the ban of a user from a category, or from the site when
+	// category is empty, expired or not.
+	Get(userID, category string) (*Ban, error)
+	// Lift removes the ban of a user from a category, or from the site when
+	// category is empty.
+	Lift(userID, category string) error
+	// List returns the bans in force in a category, or the site-wide ones
+	// when category is empty, newest first.
+	List(category string, now time.Time) ([]*Ban, error)
+	// Active returns every ban in force against a user.
+	Active(userID string, now time.Time) ([]*Ban, error)
+}
+
+type MemoryRepo struct {
+	mu   sync.RWMutex
+	bans map[string]map[string]*Ban // user ID -> category -> ban
+}
+
+func NewMemoryRepo() *MemoryRepo {
+	return &MemoryRepo{bans: make(map[string]map[string]*Ban)}
+}
+
+func (r *MemoryRepo) Put(b *Ban) (*Ban, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.put(b)
+	cp := *b
+	return &cp, nil
+}
+
+func (r *MemoryRepo) put(b *Ban) {
+	if r.bans[b.UserID] == nil {
+		r.bans[b.UserID] = make(map[string]*Ban)
+	}
+	cp := *b
+	r.bans[b.UserID][b.Category] = &cp
+}
+
+func (r *MemoryRepo) Get(userID, category string) (*Ban, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	b, ok := r.bans[userID][category]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	cp := *b
+	return &cp, nil
+}
+
+func (r *MemoryRepo) Lift(userID, category string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.bans[userID][category]; !ok {
+		return ErrNotFound
+	}
+	delete(r.bans[userID], category)
+	if len(r.bans[userID]) == 0 {
+		delete(r.bans, userID)
+	}
+	return nil
+}
+
+func (r *MemoryRepo) List(category string, now time.Time) ([]*Ban, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	list := []*Ban{}
+	for _, byCategory := range r.bans {
+		if b, ok := byCategory[category]; ok && b.InForce(now) {
+			cp := *b
+			list = append(list, &cp)
+		}
+	}
+	sortNewest(list)
+	return list, nil
+}
+
+func (r *MemoryRepo) Active(userID string, now time.Time) ([]*Ban, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	list := []*Ban{}
+	for _, b := range r.bans[userID] {
+		if b.InForce(now) {
+			cp := *b
+			list = append(list, &cp)
+		}
+	}
+	sortNewest(list)
+	return list, nil
+}
+
+// Records returns every stored ban, including expired ones.
+func (r *MemoryRepo) Records() []*Ban {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var list []*Ban
+	for _, byCategory := range r.bans {
+		for _, b := range byCategory {
+			cp := *b
+			list = append(list, &cp)
+		}
+	}
+	sortNewest(list)
+	return list
+}
+
+// Load inserts a previously stored ban.
+func (r *MemoryRepo) Load(b *Ban) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.put(b)
+}
+
+// sortNewest orders bans newest first, ties broken by user ID and category.
+func sortNewest(list []*Ban) {
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if !a.Created.Equal(b.Created) {
+			return a.Created.After(b.Created)
+		}
+		if a.UserID != b.UserID {
+			return a.UserID < b.UserID
+		}
+		return a.Category < b.Category
+	})
+}
@@ -0,0 +1,111 @@
+package ban
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// SQLRepo stores bans in the bans table created by the storage package
+// migrations.
+type SQLRepo struct {
+	db *sql.DB
+}
+
+func NewSQLRepo(db *sql.DB) *SQLRepo {
+	return &SQLRepo{db: db}
+}
+
+func (r *SQLRepo) Put(b *Ban) (*Ban, error) {
+	var expires sql.NullInt64
+	if !b.Expires.IsZero() {
+		expires = sql.NullInt64{Int64: b.Expires.UnixMicro(), Valid: true}
+	}
+	_, err := r.db.Exec(`INSERT INTO bans (user_id, category, username, reason, banned_by, by_admin, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (user_id, category) DO UPDATE SET username = excluded.username, reason = excluded.reason,
+			banned_by = excluded.banned_by, by_admin = excluded.by_admin, created_at = excluded.created_at,
+			expires_at = excluded.expires_at`,
+		b.UserID, b.Category, b.Username, b.Reason, b.By, b.ByAdmin, b.Created.UnixMicro(), expires)
+	if err != nil {
+		return nil, err
+	}
+	cp := *b
+	cp.Created = time.UnixMicro(b.Created.UnixMicro())
+	if expires.Valid {
+		cp.Expires = time.UnixMicro(expires.Int64)
+	}
+	return &cp, nil
+}
+
+func (r *SQLRepo) Lift(userID, category string) error {
+	res, err := r.db.Exec(`DELETE FROM bans WHERE user_id = ? AND category = ?`, userID, category)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *SQLRepo) Get(userID, category string) (*Ban, error) {
+	b, err := scanBan(r.db.QueryRow(`SELECT `+columns+` FROM bans WHERE user_id = ? AND category = ?`, userID, category))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return b, err
+}
+
+func (r *SQLRepo) List(category string, now time.Time) ([]*Ban, error) {
+	return r.query(`category = ?`, now, category)
+}
+
+func (r *SQLRepo) Active(userID string, now time.Time) ([]*Ban, error) {
+	return r.query(`user_id = ?`, now, userID)
+}
+
+// query returns the bans matching where that are in force at now, newest
+// first.
+func (r *SQLRepo) query(where string, now time.Time, args ...any) ([]*Ban, error) {
+	args = append(args, now.UnixMicro())
+	rows, err := r.db.Query(`SELECT `+columns+`
+		FROM bans WHERE `+where+` AND (expires_at IS NULL OR expires_at > ?)
+		ORDER BY created_at DESC, user_id, category`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []*Ban{}
+	for rows.Next() {
+		b, err := scanBan(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, b)
+	}
+	return list, rows.Err()
+}
+
+const columns = `user_id, category, username, reason, banned_by, by_admin, created_at, expires_at`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanBan(row scanner) (*Ban, error) {
+	b := &Ban{}
+	var created int64
+	var expires sql.NullInt64
+	if err := row.Scan(&b.UserID, &b.Category, &b.Username, &b.Reason, &b.By, &b.ByAdmin, &created, &expires); err != nil {
+		return nil, err
+	}
+	b.Created = time.UnixMicro(created)
+	if expires.Valid {
+		b.Expires = time.UnixMicro(expires.Int64)
+	}
+	return b, nil
+}
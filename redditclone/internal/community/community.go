@@ -0,0 +1,330 @@
+package community
+
+import (
+	"errors"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	ErrNotFound     = errors.New("community not found")
+	ErrExists       = errors.New("community already exists")
+	ErrBadName      = errors.New("bad community name")
+	ErrTooLong      = errors.New("description or rule too long")
+	ErrTooManyRules = errors.New("too many rules")
+)
+
+// Limits on what a community may be created with.
+const (
+	MaxDescriptionLength = 500
+	MaxRules             = 15
+	MaxRuleLength        = 300
+)
+
+// Defaults are the communities every installation starts with. The bundled
+// frontend only offers these when submitting a post.
+var Defaults = []string{"music", "funny", "videos", "programming", "news", "fashion"}
+
+// nameRe is what community names look like: they appear in URLs as is.
+var nameRe = regexp.MustCompile(`^[a-z0-9_]{3,21}$`)
+
+// Member is a user with a role in a community.
+type Member struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+}
+
+// Community is a category posts are filed under. The creator is its first
+// moderator; the default communities have no creator. Subscribers is only
+// filled in by the repos and is not stored with the community.
+type Community struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Rules       []string  `json:"rules"`
+	Creator     *Member   `json:"creator,omitempty"`
+	Moderators  []*Member `json:"moderators"`
+	Subscribers int       `json:"subscribers"`
+	Created     time.Time `json:"created,omitzero"`
+}
+
+// New validates a community about to be created by creator.
+func New(name, description string, rules []string, creator Member, created time.Time) (*Community, error) {
+	if !nameRe.MatchString(name) {
+		return nil, ErrBadName
+	}
+	description = strings.TrimSpace(description)
+	if utf8.RuneCountInString(description) > MaxDescriptionLength {
+		return nil, ErrTooLong
+	}
+	if len(rules) > MaxRules {
+		return nil, ErrTooManyRules
+	}
+	kept := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		if utf8.RuneCountInString(rule) > MaxRuleLength {
+			return nil, ErrTooLong
+		}
+		kept = append(kept, rule)
+	}
+
+	return &Community{
+		Name:        name,
+		Description: description,
+		Rules:       kept,
+		Creator:     &creator,
+		Moderators:  []*Member{{ID: creator.ID, Username: creator.Username}},
+		Created:     created,
+	}, nil
+}
+
+// IsModerator reports whether the user moderates the community.
+func (c *Community) IsModerator(userID string) bool {
+	return slices.ContainsFunc(c.Moderators, func(m *Member) bool { return m.ID == userID })
+}
+
+// Clone returns a deep copy of the community.
+func (c *Community) Clone() *Community {
+	cp := *c
+	cp.Rules = slices.Clone(c.Rules)
+	if c.Creator != nil {
+		creator := *c.Creator
+		cp.Creator = &creator
+	}
+	cp.Moderators = make([]*Member, len(c.Moderators))
+	for i, m := range c.Moderators {
+		mod := *m
+		cp.Moderators[i] = &mod
+	}
+	return &cp
+}
+
+type Repo interface {
+	Create(c *Community) (*Community, error)
+	Get(name string) (*Community, error)
+	List() ([]*Community, error)
+	// Subscribe and Unsubscribe are idempotent.
+	Subscribe(name, userID string) (*Community, error)
+	Unsubscribe(name, userID string) (*Community, error)
+	// Subscriptions returns the names of the communities a user subscribed
+	// to, in order.
+	Subscriptions(userID string) ([]string, error)
+	// AddModerator and RemoveModerator are idempotent. Moderators are listed
+	// in the order they were appointed.
+	AddModerator(name string, m Member) (*Community, error)
+	RemoveModerator(name, userID string) (*Community, error)
+	// Moderated returns the names of the communities a user moderates, in
+	// order.
+	Moderated(userID string) ([]string, error)
+}
+
+type MemoryRepo struct {
+	mu          sync.RWMutex
+	communities map[string]*Community
+	subscribers map[string]map[string]bool // community -> user IDs
+	subscribed  map[string]map[string]bool // user ID -> communities
+}
+
+// NewMemoryRepo returns a repo holding the default communities.
+func NewMemoryRepo() *MemoryRepo {
+	r := &MemoryRepo{
+		communities: make(map[string]*Community),
+		subscribers: make(map[string]map[string]bool),
+		subscribed:  make(map[string]map[string]bool),
+	}
+	for _, name := range Defaults {
+		r.communities[name] = &Community{Name: name, Rules: []string{}, Moderators: []*Member{}}
+	}
+	return r
+}
+
+func (r *MemoryRepo) Create(c *Community) (*Community, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.communities[c.Name]; ok {
+		return nil, ErrExists
+	}
+	r.communities[c.Name] = c.Clone()
+	return r.get(c.Name)
+}
+
+func (r *MemoryRepo) Get(name string) (*Community, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.get(name)
+}
+
+func (r *MemoryRepo) get(name string) (*Community, error) {
+	c, ok := r.communities[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	c = c.Clone()
+	c.Subscribers = len(r.subscribers[name])
+	return c, nil
+}
+
+// List returns every community ordered by name.
+func (r *MemoryRepo) List() ([]*Community, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	list := make([]*Community, 0, len(r.communities))
+	for name := range r.communities {
+		c, _ := r.get(name)
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+func (r *MemoryRepo) Subscribe(name, userID string) (*Community, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.communities[name]; !ok {
+		return nil, ErrNotFound
+	}
+	r.subscribe(name, userID)
+	return r.get(name)
+}
+
+func (r *MemoryRepo) subscribe(name, userID string) {
+	if r.subscribers[name] == nil {
+		r.subscribers[name] = make(map[string]bool)
+	}
+	if r.subscribed[userID] == nil {
+		r.subscribed[userID] = make(map[string]bool)
+	}
+	r.subscribers[name][userID] = true
+	r.subscribed[userID][name] = true
+}
+
+func (r *MemoryRepo) Unsubscribe(name, userID string) (*Community, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.communities[name]; !ok {
+		return nil, ErrNotFound
+	}
+	delete(r.subscribers[name], userID)
+	if len(r.subscribers[name]) == 0 {
+		delete(r.subscribers, name)
+	}
+	delete(r.subscribed[userID], name)
+	if len(r.subscribed[userID]) == 0 {
+		delete(r.subscribed, userID)
+	}
+	return r.get(name)
+}
+
+func (r *MemoryRepo) Subscriptions(userID string) ([]string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.subscribed[userID]))
+	for name := range r.subscribed[userID] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (r *MemoryRepo) AddModerator(name string, m Member) (*Community, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.communities[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if !c.IsModerator(m.ID) {
+		c.Moderators = append(c.Moderators, &m)
+	}
+	return r.get(name)
+}
+
+func (r *MemoryRepo) RemoveModerator(name, userID string) (*Community, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.communities[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	c.Moderators = slices.DeleteFunc(c.Moderators, func(m *Member) bool { return m.ID == userID })
+	return r.get(name)
+}
+
+func (r *MemoryRepo) Moderated(userID string) ([]string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := []string{}
+	for name, c := range r.communities {
+		if c.IsModerator(userID) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// SubscriptionRecords returns the subscriptions of every user, keyed by user ID.
+func (r *MemoryRepo) SubscriptionRecords() map[string][]string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	records := make(map[string][]string, len(r.subscribed))
+	for userID, names := range r.subscribed {
+		for name := range names {
+			records[userID] = append(records[userID], name)
+		}
+		sort.Strings(records[userID])
+	}
+	return records
+}
+
+// LoadSubscriptions restores the subscriptions of a user.
+func (r *MemoryRepo) LoadSubscriptions(userID string, names []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, name := range names {
+		r.subscribe(name, userID)
+	}
+}
+
+// Records returns every stored community.
+func (r *MemoryRepo) Records() []*Community {
+	list, _ := r.List()
+	return list
+}
+
+// Load inserts a previously stored community, replacing one of the same name.
+func (r *MemoryRepo) Load(c *Community) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.communities[c.Name] = c.Clone()
+}
+
+// Ensure adds a bare community for a category that posts were filed under
+// before communities had to be created, unless it exists already.
+func (r *MemoryRepo) Ensure(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.communities[name]; !ok {
+		r.communities[name] = &Community{Name: name, Rules: []string{}, Moderators: []*Member{}}
+	}
+}
@@ -0,0 +1,222 @@
+package community
+
+import (
+	"database/sql"
+	"encoding/json"
+)
+
+// SQLRepo stores communities in the communities and community_moderators
+// tables created by the storage package migrations.
+type SQLRepo struct {
+	db *sql.DB
+}
+
+func NewSQLRepo(db *sql.DB) *SQLRepo {
+	return &SQLRepo{db: db}
+}
+
+func (r *SQLRepo) Create(c *Community) (*Community, error) {
+	rules, err := json.Marshal(c.Rules)
+	if err != nil {
+		return nil, err
+	}
+	var creatorID, creatorUsername sql.NullString
+	if c.Creator != nil {
+		creatorID = sql.NullString{String: c.Creator.ID, Valid: true}
+		creatorUsername = sql.NullString{String: c.Creator.Username, Valid: true}
+	}
+	var created sql.NullTime
+	if !c.Created.IsZero() {
+		created = sql.NullTime{Time: c.Created, Valid: true}
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`INSERT INTO communities (name, description, rules, creator_id, creator_username, created)
+		VALUES (?, ?, ?, ?, ?, ?) ON CONFLICT (name) DO NOTHING`,
+		c.Name, c.Description, string(rules), creatorID, creatorUsername, created)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, ErrExists
+	}
+	for i, m := range c.Moderators {
+		_, err = tx.Exec(`INSERT INTO community_moderators (community, user_id, username, position) VALUES (?, ?, ?, ?)`,
+			c.Name, m.ID, m.Username, i)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return r.Get(c.Name)
+}
+
+func (r *SQLRepo) Get(name string) (*Community, error) {
+	list, err := r.query(`WHERE name = ?`, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(list) == 0 {
+		return nil, ErrNotFound
+	}
+	return list[0], nil
+}
+
+// List returns every community ordered by name.
+func (r *SQLRepo) List() ([]*Community, error) {
+	return r.query(``)
+}
+
+func (r *SQLRepo) query(where string, args ...any) ([]*Community, error) {
+	rows, err := r.db.Query(`SELECT name, description, rules, creator_id, creator_username, created,
+			(SELECT COUNT(*) FROM community_subscribers s WHERE s.community = communities.name)
+		FROM communities `+where+` ORDER BY name`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []*Community
+	byName := make(map[string]*Community)
+	for rows.Next() {
+		c := &Community{Moderators: []*Member{}}
+		var rules string
+		var creatorID, creatorUsername sql.NullString
+		var created sql.NullTime
+		if err = rows.Scan(&c.Name, &c.Description, &rules, &creatorID, &creatorUsername, &created, &c.Subscribers); err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal([]byte(rules), &c.Rules); err != nil {
+			return nil, err
+		}
+		if creatorID.Valid {
+			c.Creator = &Member{ID: creatorID.String, Username: creatorUsername.String}
+		}
+		if created.Valid {
+			c.Created = created.Time
+		}
+		list = append(list, c)
+		byName[c.Name] = c
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(list) == 0 {
+		return list, nil
+	}
+
+	if err = r.loadModerators(byName, where, args...); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (r *SQLRepo) loadModerators(byName map[string]*Community, where string, args ...any) error {
+	rows, err := r.db.Query(`SELECT community, user_id, username FROM community_moderators
+		WHERE community IN (SELECT name FROM communities `+where+`) ORDER BY community, position`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		m := &Member{}
+		if err = rows.Scan(&name, &m.ID, &m.Username); err != nil {
+			return err
+		}
+		if c, ok := byName[name]; ok {
+			c.Moderators = append(c.Moderators, m)
+		}
+	}
+	return rows.Err()
+}
+
+func (r *SQLRepo) Subscribe(name, userID string) (*Community, error) {
+	return r.subscription(name, `INSERT INTO community_subscribers (community, user_id) VALUES (?, ?)
+		ON CONFLICT DO NOTHING`, userID)
+}
+
+func (r *SQLRepo) Unsubscribe(name, userID string) (*Community, error) {
+	return r.subscription(name, `DELETE FROM community_subscribers WHERE community = ? AND user_id = ?`, userID)
+}
+
+// subscription runs a statement taking the community name and the user ID
+// and returns the community.
+func (r *SQLRepo) subscription(name, stmt, userID string) (*Community, error) {
+	if _, err := r.Get(name); err != nil {
+		return nil, err
+	}
+	if _, err := r.db.Exec(stmt, name, userID); err != nil {
+		return nil, err
+	}
+	return r.Get(name)
+}
+
+func (r *SQLRepo) Subscriptions(userID string) ([]string, error) {
+	rows, err := r.db.Query(`SELECT community FROM community_subscribers WHERE user_id = ? ORDER BY community`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+func (r *SQLRepo) AddModerator(name string, m Member) (*Community, error) {
+	if _, err := r.Get(name); err != nil {
+		return nil, err
+	}
+	_, err := r.db.Exec(`INSERT INTO community_moderators (community, user_id, username, position)
+		SELECT ?, ?, ?, COALESCE(MAX(position) + 1, 0) FROM community_moderators WHERE community = ?
+		ON CONFLICT DO NOTHING`, name, m.ID, m.Username, name)
+	if err != nil {
+		return nil, err
+	}
+	return r.Get(name)
+}
+
+func (r *SQLRepo) RemoveModerator(name, userID string) (*Community, error) {
+	if _, err := r.Get(name); err != nil {
+		return nil, err
+	}
+	if _, err := r.db.Exec(`DELETE FROM community_moderators WHERE community = ? AND user_id = ?`, name, userID); err != nil {
+		return nil, err
+	}
+	return r.Get(name)
+}
+
+func (r *SQLRepo) Moderated(userID string) ([]string, error) {
+	rows, err := r.db.Query(`SELECT community FROM community_moderators WHERE user_id = ? ORDER BY community`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
@@ -0,0 +1,346 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"redditclone/internal/community"
+	"redditclone/internal/mail"
+	"redditclone/internal/middleware"
+	"redditclone/internal/post"
+	"redditclone/internal/reset"
+	"redditclone/internal/session"
+	"redditclone/internal/user"
+)
+
+// mailTimeout bounds how long sending a reset email may take.
+const mailTimeout = 30 * time.Second
+
+// AccountHandler lets users change their password and email, reset a
+// forgotten password through a link mailed to them, export their data and
+// delete their account.
+type AccountHandler struct {
+	users       user.Repo
+	posts       post.Repo
+	communities community.Repo
+	sessions    session.Repo
+	resets      reset.Repo
+	mailer      mail.Mailer
+	// votes says what becomes of the votes of deleted accounts.
+	votes post.VotePolicy
+	// resetTTL is how long a reset token works.
+	resetTTL time.Duration
+	// resetURL is the frontend page reset links point to; the token is
+	// added as ?token=. Without it the email only carries the token.
+	resetURL string
+}
+
+func NewAccountHandler(users user.Repo, posts post.Repo, communities community.Repo, sessions session.Repo, resets reset.Repo,
+	mailer mail.Mailer, votes post.VotePolicy, resetTTL time.Duration, resetURL string) *AccountHandler {
+	return &AccountHandler{
+		users:       users,
+		posts:       posts,
+		communities: communities,
+		sessions:    sessions,
+		resets:      resets,
+		mailer:      mailer,
+		votes:       votes,
+		resetTTL:    resetTTL,
+		resetURL:    resetURL,
+	}
+}
+
+// accountExport is the archive of a user's data: the account, the
+// communities they joined and moderate, and everything they posted, deleted
+// content included, and voted on.
+type accountExport struct {
+	Exported      time.Time   `json:"exported"`
+	Account       accountView `json:"account"`
+	Subscriptions []string    `json:"subscriptions"`
+	Moderates     []string    `json:"moderates"`
+	*post.Activity
+}
+
+type accountView struct {
+	ID         string    `json:"id"`
+	Username   string    `json:"username"`
+	Email      string    `json:"email,omitempty"`
+	Registered time.Time `json:"registered,omitzero"`
+	Admin      bool      `json:"admin"`
+}
+
+// ChangePassword sets a new password after checking the current one. Every
+// other session of the user is ended; the one making the request stays.
+func (h *AccountHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Password    string `json:"password"`
+		NewPassword string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"message": "invalid request"}`, http.StatusBadRequest)
+		return
+	}
+	caller, ok := middleware.GetUser(r.Context())
+	if !ok {
+		http.Error(w, `{"error": "auth error"}`, http.StatusInternalServerError)
+		return
+	}
+	if u, err := h.users.Authorize(caller.Username, req.Password); err != nil || u == nil {
+		http.Error(w, `{"message": "wrong password"}`, http.StatusForbidden)
+		return
+	}
+	h.setPassword(w, caller.ID, caller.Username, req.NewPassword, caller.SessionID)
+}
+
+// SetEmail sets the address password resets are sent to; an empty email
+// removes it.
+func (h *AccountHandler) SetEmail(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"message": "invalid request"}`, http.StatusBadRequest)
+		return
+	}
+	caller, ok := middleware.GetUser(r.Context())
+	if !ok {
+		http.Error(w, `{"error": "auth error"}`, http.StatusInternalServerError)
+		return
+	}
+	u, err := h.users.SetEmail(caller.Username, req.Email)
+	if errors.Is(err, user.ErrBadEmail) {
+		http.Error(w, `{"message": "invalid email"}`, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"username": u.Username, "email": u.Email})
+}
+
+// ForgotPassword mails a reset token to the user, if they gave an email.
+// The answer is the same whether or not the user exists or has an email.
+func (h *AccountHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Username string `json:"username"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"message": "invalid request"}`, http.StatusBadRequest)
+		return
+	}
+
+	u, err := h.users.GetByUsername(req.Username)
+	switch {
+	case errors.Is(err, user.ErrNotFound):
+	case err != nil:
+		http.Error(w, `{"message": "db error"}`, http.StatusInternalServerError)
+		return
+	case u.Email != "":
+		t, token, err := reset.New(u.ID, u.Username, time.Now(), h.resetTTL)
+		if err == nil {
+			_, err = h.resets.Create(t)
+		}
+		if err != nil {
+			http.Error(w, `{"message": "could not create token"}`, http.StatusInternalServerError)
+			return
+		}
+		// Sent in the background, so a slow mail server does not hold up
+		// the response.
+		go h.sendReset(u, token)
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{
+		"message": "if the user has an email, a reset link was sent to it",
+	})
+}
+
+func (h *AccountHandler) sendReset(u *user.User, token string) {
+	ctx, cancel := context.WithTimeout(context.Background(), mailTimeout)
+	defer cancel()
+
+	body := fmt.Sprintf("Someone asked to reset the password of %s.\n\n", u.Username)
+	if h.resetURL != "" {
+		body += fmt.Sprintf("Choose a new one at %s?token=%s\n\n", h.resetURL, url.QueryEscape(token))
+	} else {
+		body += fmt.Sprintf("Your reset token is %s\n\n", token)
+	}
+	body += fmt.Sprintf("It works once and expires in %s. If you did not ask for it, ignore this email.\n", h.resetTTL)
+
+	err := h.mailer.Send(ctx, mail.Message{To: u.Email, Subject: "Password reset", Body: body})
+	if err != nil {
+		log.Printf("mail: reset for %s: %v", u.Username, err)
+	}
+}
+
+// ResetPassword sets a new password with a mailed reset token and ends
+// every session of the user.
+func (h *AccountHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token       string `json:"token"`
+		NewPassword string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"message": "invalid request"}`, http.StatusBadRequest)
+		return
+	}
+	// Checked before the token is used up, so a too short password can be
+	// retried with the same token.
+	if len(req.NewPassword) < user.MinPasswordLength {
+		writePasswordTooShort(w)
+		return
+	}
+	t, err := h.resets.Consume(reset.Hash(req.Token), time.Now())
+	if errors.Is(err, reset.ErrInvalid) {
+		http.Error(w, `{"message": "invalid or expired token"}`, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"message": "db error"}`, http.StatusInternalServerError)
+		return
+	}
+	// The token is refused if its user has gone, even if someone else has
+	// registered the name since.
+	u, err := h.users.GetByUsername(t.Username)
+	if errors.Is(err, user.ErrNotFound) || err == nil && u.ID != t.UserID {
+		http.Error(w, `{"message": "invalid or expired token"}`, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"message": "db error"}`, http.StatusInternalServerError)
+		return
+	}
+	h.setPassword(w, u.ID, u.Username, req.NewPassword, "")
+}
+
+// setPassword changes the password of a user and ends their sessions but
+// the one with the ID keep. Reset tokens the user still holds stop working.
+func (h *AccountHandler) setPassword(w http.ResponseWriter, userID, username, password, keep string) {
+	if len(password) < user.MinPasswordLength {
+		writePasswordTooShort(w)
+		return
+	}
+	if _, err := h.users.SetPassword(username, password); err != nil {
+		writeUserError(w, err)
+		return
+	}
+	if err := h.resets.DeleteUser(userID); err != nil {
+		http.Error(w, `{"message": "db error"}`, http.StatusInternalServerError)
+		return
+	}
+	n, err := h.sessions.RevokeUser(userID, keep, time.Now())
+	if err != nil {
+		http.Error(w, `{"message": "db error"}`, http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"message": "success", "sessions_revoked": n})
+}
+
+// Export serves the user's data as a JSON file.
+func (h *AccountHandler) Export(w http.ResponseWriter, r *http.Request) {
+	caller, ok := middleware.GetUser(r.Context())
+	if !ok {
+		http.Error(w, `{"error": "auth error"}`, http.StatusInternalServerError)
+		return
+	}
+	u, err := h.users.GetByUsername(caller.Username)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	export := accountExport{
+		Exported: time.Now(),
+		Account:  accountView{ID: u.ID, Username: u.Username, Email: u.Email, Registered: u.Registered, Admin: u.Admin},
+	}
+	if export.Subscriptions, err = h.communities.Subscriptions(u.ID); err != nil {
+		writeCommunityError(w, err)
+		return
+	}
+	if export.Moderates, err = h.communities.Moderated(u.ID); err != nil {
+		writeCommunityError(w, err)
+		return
+	}
+	if export.Activity, err = h.posts.Activity(u.ID); err != nil {
+		writeRepoError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="redditclone-%s.json"`, url.PathEscape(u.Username)))
+	writeJSON(w, http.StatusOK, export)
+}
+
+// DeleteAccount deletes the user after checking their password. Their
+// posts and comments stay, credited to "[deleted]"; their votes stay or go
+// as the vote policy says. The user loses their moderator roles and
+// subscriptions, and every session and reset token ends before the user
+// record goes.
+func (h *AccountHandler) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"message": "invalid request"}`, http.StatusBadRequest)
+		return
+	}
+	caller, ok := middleware.GetUser(r.Context())
+	if !ok {
+		http.Error(w, `{"error": "auth error"}`, http.StatusInternalServerError)
+		return
+	}
+	if u, err := h.users.Authorize(caller.Username, req.Password); err != nil || u == nil {
+		http.Error(w, `{"message": "wrong password"}`, http.StatusForbidden)
+		return
+	}
+
+	// Every step can be repeated, and the user goes last: if one fails, a
+	// retry, signed in again if the sessions have ended, finishes the job.
+	if err := h.posts.Forget(caller.ID, h.votes); err != nil {
+		writeRepoError(w, err)
+		return
+	}
+	moderated, err := h.communities.Moderated(caller.ID)
+	if err != nil {
+		writeCommunityError(w, err)
+		return
+	}
+	for _, name := range moderated {
+		if _, err = h.communities.RemoveModerator(name, caller.ID); err != nil {
+			writeCommunityError(w, err)
+			return
+		}
+	}
+	subscriptions, err := h.communities.Subscriptions(caller.ID)
+	if err != nil {
+		writeCommunityError(w, err)
+		return
+	}
+	for _, name := range subscriptions {
+		if _, err = h.communities.Unsubscribe(name, caller.ID); err != nil {
+			writeCommunityError(w, err)
+			return
+		}
+	}
+	if err = h.resets.DeleteUser(caller.ID); err != nil {
+		http.Error(w, `{"message": "db error"}`, http.StatusInternalServerError)
+		return
+	}
+	if _, err = h.sessions.RevokeUser(caller.ID, "", time.Now()); err != nil {
+		http.Error(w, `{"message": "db error"}`, http.StatusInternalServerError)
+		return
+	}
+	if err = h.users.Delete(caller.Username); err != nil {
+		writeUserError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "success"})
+}
+
+func writePasswordTooShort(w http.ResponseWriter) {
+	http.Error(w, fmt.Sprintf(`{"message": "password must be at least %d characters"}`, user.MinPasswordLength), http.StatusBadRequest)
+}
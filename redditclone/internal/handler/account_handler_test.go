@@ -0,0 +1,199 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"redditclone/internal/post"
+	"redditclone/internal/reset"
+	"redditclone/internal/user"
+)
+
+// failingForget fails to let go of a user's content.
+type failingForget struct {
+	post.Repo
+}
+
+func (failingForget) Forget(string, post.VotePolicy) error {
+	return errors.New("disk full")
+}
+
+// failingDelete fails to delete users.
+type failingDelete struct {
+	user.Repo
+}
+
+func (failingDelete) Delete(string) error {
+	return errors.New("disk full")
+}
+
+// accountFixture is an account handler over a registered alice holding a
+// reset token.
+type accountFixture struct {
+	*testServer
+	h     *AccountHandler
+	token string // alice's reset token
+}
+
+func newAccountFixture(t *testing.T) *accountFixture {
+	t.Helper()
+	f := &accountFixture{testServer: newTestServer()}
+	u := f.user(t, "alice")
+	rt, token, err := reset.New(u.ID, u.Username, time.Now(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.resets.Create(rt); err != nil {
+		t.Fatal(err)
+	}
+	f.token = token
+	f.h = NewAccountHandler(f.users, f.posts, f.communities, f.sessions, f.resets, nil, post.KeepVotes, time.Hour, "")
+	return f
+}
+
+// resetPassword sets the password with the token as /api/password/reset
+// does and returns the status.
+func (f *accountFixture) resetPassword(token, password string) int {
+	body, _ := json.Marshal(map[string]string{"token": token, "new_password": password})
+	w := httptest.NewRecorder()
+	f.h.ResetPassword(w, httptest.NewRequest("POST", "/api/password/reset", strings.NewReader(string(body))))
+	return w.Result().StatusCode
+}
+
+func TestResetPasswordChecksTheUser(t *testing.T) {
+	f := newAccountFixture(t)
+	if err := f.users.Delete("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.users.Register("alice", "newowner1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if status := f.resetPassword(f.token, "takeover1"); status != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400 for the token of the former alice", status)
+	}
+	if _, err := f.users.Authorize("alice", "newowner1"); err != nil {
+		t.Errorf("the new alice's password changed: %v", err)
+	}
+}
+
+func TestResetPasswordUsesTheTokenOnce(t *testing.T) {
+	f := newAccountFixture(t)
+	if status := f.resetPassword(f.token, "password2"); status != http.StatusOK {
+		t.Fatalf("status = %d, want 200", status)
+	}
+	if _, err := f.users.Authorize("alice", "password2"); err != nil {
+		t.Errorf("new password: %v", err)
+	}
+	if status := f.resetPassword(f.token, "password3"); status != http.StatusBadRequest {
+		t.Errorf("second use status = %d, want 400", status)
+	}
+}
+
+func TestAccountChangesEndResetTokens(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(f *accountFixture, token string) *httptest.ResponseRecorder
+	}{
+		{"password change", func(f *accountFixture, token string) *httptest.ResponseRecorder {
+			return f.serve(f.h.ChangePassword, "PUT", "/api/user/me/password",
+				`{"password": "password1", "new_password": "password2"}`, token)
+		}},
+		{"account deletion", func(f *accountFixture, token string) *httptest.ResponseRecorder {
+			return f.serve(f.h.DeleteAccount, "DELETE", "/api/user/me", `{"password": "password1"}`, token)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newAccountFixture(t)
+			w := tt.change(f, f.login(t, "alice"))
+			if got := w.Result().StatusCode; got != http.StatusOK {
+				t.Fatalf("status = %d, want 200", got)
+			}
+			if _, err := f.resets.Consume(reset.Hash(f.token), time.Now()); !errors.Is(err, reset.ErrInvalid) {
+				t.Errorf("reset token after the change: error = %v, want ErrInvalid", err)
+			}
+		})
+	}
+}
+
+func TestDeleteAccountRetry(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler func(f *accountFixture) *AccountHandler
+		signIn  bool // whether the retry needs a new session
+	}{
+		{"content not forgotten", func(f *accountFixture) *AccountHandler {
+			return NewAccountHandler(f.users, failingForget{f.posts}, f.communities, f.sessions, f.resets, nil,
+				post.KeepVotes, time.Hour, "")
+		}, false},
+		{"user not deleted", func(f *accountFixture) *AccountHandler {
+			return NewAccountHandler(failingDelete{f.users}, f.posts, f.communities, f.sessions, f.resets, nil,
+				post.KeepVotes, time.Hour, "")
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newAccountFixture(t)
+			f.moderate(t, "alice", "music")
+			alice := f.user(t, "alice")
+			if _, err := f.communities.Subscribe("news", alice.ID); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := f.posts.Add(&post.Post{ID: "p1", Title: "p1", Author: &post.Author{ID: alice.ID, Username: "alice"},
+				Category: "music", Votes: []*post.Vote{}, Comments: []*post.Comment{}, Created: time.Now(), Type: "text"}); err != nil {
+				t.Fatal(err)
+			}
+			deleteAccount := func(h *AccountHandler, token string) int {
+				return f.serve(h.DeleteAccount, "DELETE", "/api/user/me", `{"password": "password1"}`, token).Result().StatusCode
+			}
+
+			token := f.login(t, "alice")
+			if status := deleteAccount(tt.handler(f), token); status != http.StatusInternalServerError {
+				t.Fatalf("failing deletion: status = %d, want 500", status)
+			}
+			if _, err := f.users.Authorize("alice", "password1"); err != nil {
+				t.Fatalf("account gone after a failed deletion: %v", err)
+			}
+			if tt.signIn {
+				token = f.login(t, "alice")
+			}
+			if status := deleteAccount(f.h, token); status != http.StatusOK {
+				t.Fatalf("retry: status = %d, want 200", status)
+			}
+
+			if _, err := f.users.GetByUsername("alice"); !errors.Is(err, user.ErrNotFound) {
+				t.Errorf("user after the retry: error = %v, want ErrNotFound", err)
+			}
+			p, err := f.posts.GetByID("p1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Author.Username != post.DeletedPlaceholder {
+				t.Errorf("post author = %q, want %q", p.Author.Username, post.DeletedPlaceholder)
+			}
+			moderated, err := f.communities.Moderated(alice.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			subscriptions, err := f.communities.Subscriptions(alice.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(moderated) != 0 || len(subscriptions) != 0 {
+				t.Errorf("moderates %v and subscribes to %v, want nothing", moderated, subscriptions)
+			}
+			if _, err := f.resets.Consume(reset.Hash(f.token), time.Now()); !errors.Is(err, reset.ErrInvalid) {
+				t.Errorf("reset token: error = %v, want ErrInvalid", err)
+			}
+			if status := deleteAccount(f.h, token); status != http.StatusUnauthorized {
+				t.Errorf("session after the retry: status = %d, want 401", status)
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"redditclone/internal/ban"
+	"redditclone/internal/community"
+	"redditclone/internal/middleware"
+	"redditclone/internal/user"
+	"time"
+)
+
+type BanHandler struct {
+	bans        ban.Repo
+	users       user.Repo
+	communities community.Repo
+}
+
+func NewBanHandler(bans ban.Repo, users user.Repo, communities community.Repo) *BanHandler {
+	return &BanHandler{bans: bans, users: users, communities: communities}
+}
+
+// banRequest is the body of a ban. Duration is a Go duration such as "72h";
+// without one the ban is permanent, with one it is a suspension.
+type banRequest struct {
+	Username string `json:"username"`
+	Reason   string `json:"reason"`
+	Duration string `json:"duration"`
+}
+
+// BanUser bans a user from the whole site; only admins may.
+func (h *BanHandler) BanUser(w http.ResponseWriter, r *http.Request) {
+	var req banRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "bad request"}`, http.StatusBadRequest)
+		return
+	}
+	req.Username = r.PathValue("USER_LOGIN")
+	h.ban(w, r, "", req)
+}
+
+// UnbanUser lifts a site-wide ban; only admins may.
+func (h *BanHandler) UnbanUser(w http.ResponseWriter, r *http.Request) {
+	h.lift(w, r, r.PathValue("USER_LOGIN"), "")
+}
+
+// List lists the site-wide bans in force; only admins may.
+func (h *BanHandler) List(w http.ResponseWriter, r *http.Request) {
+	h.list(w, "")
+}
+
+// BanFromCategory bans a user from a category. Moderators of the category
+// may, but only admins may ban its other moderators or an admin, or change
+// a ban an admin placed.
+func (h *BanHandler) BanFromCategory(w http.ResponseWriter, r *http.Request) {
+	var req banRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "bad request"}`, http.StatusBadRequest)
+		return
+	}
+	if !h.moderates(w, r) {
+		return
+	}
+	h.ban(w, r, r.PathValue("CATEGORY_NAME"), req)
+}
+
+// UnbanFromCategory lifts the ban of a user from a category. Moderators of
+// the category may, unless an admin placed the ban.
+func (h *BanHandler) UnbanFromCategory(w http.ResponseWriter, r *http.Request) {
+	if !h.moderates(w, r) {
+		return
+	}
+	h.lift(w, r, r.PathValue("USER_LOGIN"), r.PathValue("CATEGORY_NAME"))
+}
+
+// ListCategory lists the bans in force in a category to its moderators.
+func (h *BanHandler) ListCategory(w http.ResponseWriter, r *http.Request) {
+	if !h.moderates(w, r) {
+		return
+	}
+	h.list(w, r.PathValue("CATEGORY_NAME"))
+}
+
+// ban bans the user named in req from the category, or from the site when
+// category is empty.
+func (h *BanHandler) ban(w http.ResponseWriter, r *http.Request, category string, req banRequest) {
+	caller, ok := middleware.GetUser(r.Context())
+	if !ok {
+		http.Error(w, `{"error": "auth error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	var duration time.Duration
+	if req.Duration != "" {
+		var err error
+		if duration, err = time.ParseDuration(req.Duration); err != nil || duration <= 0 {
+			writeBanError(w, ban.ErrBadDuration)
+			return
+		}
+	}
+
+	u, err := h.users.GetByUsername(req.Username)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+	if u.ID == caller.ID {
+		http.Error(w, `{"error": "cannot ban yourself"}`, http.StatusUnprocessableEntity)
+		return
+	}
+	if category != "" && !caller.IsAdmin() {
+		c, err := h.communities.Get(category)
+		if err != nil {
+			writeCommunityError(w, err)
+			return
+		}
+		if u.Admin || c.IsModerator(u.ID) {
+			http.Error(w, `{"error": "forbidden"}`, http.StatusForbidden)
+			return
+		}
+		if !h.mayChange(w, caller, u.ID, category, false) {
+			return
+		}
+	}
+
+	b, err := ban.New(u.ID, u.Username, category, req.Reason, caller.ID, caller.IsAdmin(), time.Now(), duration)
+	if err != nil {
+		writeBanError(w, err)
+		return
+	}
+	b, err = h.bans.Put(b)
+	if err != nil {
+		writeBanError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, b)
+}
+
+func (h *BanHandler) lift(w http.ResponseWriter, r *http.Request, username, category string) {
+	caller, ok := middleware.GetUser(r.Context())
+	if !ok {
+		http.Error(w, `{"error": "auth error"}`, http.StatusInternalServerError)
+		return
+	}
+	u, err := h.users.GetByUsername(username)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+	if !h.mayChange(w, caller, u.ID, category, true) {
+		return
+	}
+	if err = h.bans.Lift(u.ID, category); err != nil {
+		writeBanError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "success"})
+}
+
+// mayChange reports whether the caller may replace or lift the ban of the
+// user from the category, writing an error response if not. Only admins
+// may change the bans admins placed. A missing ban may be replaced, but
+// not lifted.
+func (h *BanHandler) mayChange(w http.ResponseWriter, caller middleware.UserClaims, userID, category string, lifting bool) bool {
+	if caller.IsAdmin() {
+		return true
+	}
+	b, err := h.bans.Get(userID, category)
+	if errors.Is(err, ban.ErrNotFound) && !lifting {
+		return true
+	}
+	if err != nil {
+		writeBanError(w, err)
+		return false
+	}
+	if b.ByAdmin {
+		http.Error(w, `{"error": "banned by an admin"}`, http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func (h *BanHandler) list(w http.ResponseWriter, category string) {
+	bans, err := h.bans.List(category, time.Now())
+	if err != nil {
+		writeBanError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, bans)
+}
+
+// moderates reports whether the caller moderates the category of the
+// request, writing an error response if not.
+func (h *BanHandler) moderates(w http.ResponseWriter, r *http.Request) bool {
+	caller, ok := middleware.GetUser(r.Context())
+	if !ok {
+		http.Error(w, `{"error": "auth error"}`, http.StatusInternalServerError)
+		return false
+	}
+	name := r.PathValue("CATEGORY_NAME")
+	if _, err := h.communities.Get(name); err != nil {
+		writeCommunityError(w, err)
+		return false
+	}
+	if !middleware.Allowed(caller, middleware.ActionModerate, middleware.Resource{Category: name}) {
+		http.Error(w, `{"error": "forbidden"}`, http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// writeBannedFrom answers a user who is banned from the category they act in.
+func writeBannedFrom(w http.ResponseWriter) {
+	http.Error(w, `{"error": "banned from this category"}`, http.StatusForbidden)
+}
+
+// writeBanError maps ban errors to HTTP responses.
+func writeBanError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ban.ErrNotFound):
+		http.Error(w, `{"error": "ban not found"}`, http.StatusNotFound)
+	case errors.Is(err, ban.ErrNoReason):
+		http.Error(w, `{"error": "reason required"}`, http.StatusBadRequest)
+	case errors.Is(err, ban.ErrTooLong):
+		http.Error(w, `{"error": "reason too long"}`, http.StatusBadRequest)
+	case errors.Is(err, ban.ErrBadDuration):
+		http.Error(w, `{"error": "duration must be positive, such as 72h"}`, http.StatusBadRequest)
+	default:
+		http.Error(w, `{"error": "db error"}`, http.StatusInternalServerError)
+	}
+}
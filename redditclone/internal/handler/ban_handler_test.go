@@ -0,0 +1,148 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+
+	"redditclone/internal/middleware"
+)
+
+// banFixture is a ban handler over registered users: admin Ada, anna who
+// moderates music, and bob.
+type banFixture struct {
+	*testServer
+	h                 *BanHandler
+	adaT, annaT, bobT string // their tokens
+}
+
+func newBanFixture(t *testing.T) *banFixture {
+	t.Helper()
+	f := &banFixture{testServer: newTestServer()}
+	f.user(t, "ada")
+	if _, err := f.users.SetAdmin("ada", true); err != nil {
+		t.Fatal(err)
+	}
+	f.moderate(t, "anna", "music")
+	f.adaT, f.annaT, f.bobT = f.login(t, "ada"), f.login(t, "anna"), f.login(t, "bob")
+	f.h = NewBanHandler(f.bans, f.users, f.communities)
+	return f
+}
+
+// banBob bans bob from the category, or from the site when it is empty, and
+// returns the status.
+func (f *banFixture) banBob(category, token string) int {
+	if category == "" {
+		return f.serve(f.h.BanUser, "POST", "/api/bans/bob", `{"reason": "spam"}`, token,
+			"USER_LOGIN", "bob").Result().StatusCode
+	}
+	return f.serve(f.h.BanFromCategory, "POST", "/api/c/"+category+"/bans", `{"username": "bob", "reason": "spam"}`, token,
+		"CATEGORY_NAME", category).Result().StatusCode
+}
+
+// unbanBob lifts the ban of bob from the category, or from the site when it
+// is empty, and returns the status.
+func (f *banFixture) unbanBob(category, token string) int {
+	if category == "" {
+		return f.serve(f.h.UnbanUser, "DELETE", "/api/bans/bob", "", token,
+			"USER_LOGIN", "bob").Result().StatusCode
+	}
+	return f.serve(f.h.UnbanFromCategory, "DELETE", "/api/c/"+category+"/bans/bob", "", token,
+		"CATEGORY_NAME", category, "USER_LOGIN", "bob").Result().StatusCode
+}
+
+// postToMusic answers 201 if the caller may post in music.
+func postToMusic(w http.ResponseWriter, r *http.Request) {
+	u, _ := middleware.GetUser(r.Context())
+	if !middleware.Allowed(u, middleware.ActionPost, middleware.Resource{Category: "music"}) {
+		writeBannedFrom(w)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func TestBanTakesEffectOnNextRequest(t *testing.T) {
+	tests := []struct {
+		name     string
+		category string
+		by       func(f *banFixture) string
+	}{
+		{"site ban", "", func(f *banFixture) string { return f.adaT }},
+		{"category ban by a moderator", "music", func(f *banFixture) string { return f.annaT }},
+		{"category ban by an admin", "music", func(f *banFixture) string { return f.adaT }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newBanFixture(t)
+			post := func() int {
+				return f.serve(postToMusic, "POST", "/api/posts", "", f.bobT).Result().StatusCode
+			}
+			if status := post(); status != http.StatusCreated {
+				t.Fatalf("status before the ban = %d, want 201", status)
+			}
+			if status := f.banBob(tt.category, tt.by(f)); status != http.StatusCreated {
+				t.Fatalf("ban status = %d, want 201", status)
+			}
+			// Bob's token predates the ban, yet the ban holds.
+			if status := post(); status != http.StatusForbidden {
+				t.Errorf("status after the ban = %d, want 403", status)
+			}
+			if status := f.unbanBob(tt.category, tt.by(f)); status != http.StatusOK {
+				t.Fatalf("unban status = %d, want 200", status)
+			}
+			if status := post(); status != http.StatusCreated {
+				t.Errorf("status after the ban was lifted = %d, want 201", status)
+			}
+		})
+	}
+}
+
+func TestModeratorCannotChangeAdminBan(t *testing.T) {
+	tests := []struct {
+		name   string
+		admin  bool // whether an admin placed the ban
+		change func(f *banFixture, token string) int
+		status int
+	}{
+		{"moderator lifts a moderator's ban", false, func(f *banFixture, token string) int {
+			return f.unbanBob("music", token)
+		}, http.StatusOK},
+		{"moderator replaces a moderator's ban", false, func(f *banFixture, token string) int {
+			return f.banBob("music", token)
+		}, http.StatusCreated},
+		{"moderator lifts an admin's ban", true, func(f *banFixture, token string) int {
+			return f.unbanBob("music", token)
+		}, http.StatusForbidden},
+		{"moderator replaces an admin's ban", true, func(f *banFixture, token string) int {
+			return f.banBob("music", token)
+		}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newBanFixture(t)
+			by := f.annaT
+			if tt.admin {
+				by = f.adaT
+			}
+			if status := f.banBob("music", by); status != http.StatusCreated {
+				t.Fatalf("ban status = %d, want 201", status)
+			}
+			if status := tt.change(f, f.annaT); status != tt.status {
+				t.Errorf("status = %d, want %d", status, tt.status)
+			}
+			b, err := f.bans.Get(f.user(t, "bob").ID, "music")
+			if tt.status == http.StatusForbidden && (err != nil || !b.ByAdmin) {
+				t.Errorf("admin's ban after the refusal = %+v, %v, want it kept", b, err)
+			}
+		})
+	}
+}
+
+func TestAdminLiftsModeratorBan(t *testing.T) {
+	f := newBanFixture(t)
+	if status := f.banBob("music", f.annaT); status != http.StatusCreated {
+		t.Fatalf("ban status = %d, want 201", status)
+	}
+	if status := f.unbanBob("music", f.adaT); status != http.StatusOK {
+		t.Errorf("unban status = %d, want 200", status)
+	}
+}
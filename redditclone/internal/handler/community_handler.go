@@ -0,0 +1,184 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"redditclone/internal/community"
+	"redditclone/internal/middleware"
+	"redditclone/internal/user"
+	"time"
+)
+
+type CommunityHandler struct {
+	repo  community.Repo
+	users user.Repo
+}
+
+func NewCommunityHandler(repo community.Repo, users user.Repo) *CommunityHandler {
+	return &CommunityHandler{repo: repo, users: users}
+}
+
+// Create makes the requesting user the creator and first moderator of a new
+// community.
+func (h *CommunityHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name        string   `json:"name"`
+		Description string   `json:"description"`
+		Rules       []string `json:"rules"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "bad request"}`, http.StatusBadRequest)
+		return
+	}
+
+	user, ok := middleware.GetUser(r.Context())
+	if !ok {
+		http.Error(w, `{"error": "auth error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	c, err := community.New(req.Name, req.Description, req.Rules,
+		community.Member{ID: user.ID, Username: user.Username}, time.Now())
+	if err != nil {
+		writeCommunityError(w, err)
+		return
+	}
+	created, err := h.repo.Create(c)
+	if err != nil {
+		writeCommunityError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, created)
+}
+
+func (h *CommunityHandler) List(w http.ResponseWriter, r *http.Request) {
+	list, err := h.repo.List()
+	if err != nil {
+		writeCommunityError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (h *CommunityHandler) Get(w http.ResponseWriter, r *http.Request) {
+	c, err := h.repo.Get(r.PathValue("CATEGORY_NAME"))
+	if err != nil {
+		writeCommunityError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, c)
+}
+
+func (h *CommunityHandler) Subscribe(w http.ResponseWriter, r *http.Request) {
+	h.subscription(w, r, h.repo.Subscribe)
+}
+
+func (h *CommunityHandler) Unsubscribe(w http.ResponseWriter, r *http.Request) {
+	h.subscription(w, r, h.repo.Unsubscribe)
+}
+
+func (h *CommunityHandler) subscription(w http.ResponseWriter, r *http.Request, change func(name, userID string) (*community.Community, error)) {
+	user, ok := middleware.GetUser(r.Context())
+	if !ok {
+		http.Error(w, `{"error": "auth error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	c, err := change(r.PathValue("CATEGORY_NAME"), user.ID)
+	if err != nil {
+		writeCommunityError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, c)
+}
+
+// Subscriptions lists the names of the communities the user subscribed to.
+func (h *CommunityHandler) Subscriptions(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r.Context())
+	if !ok {
+		http.Error(w, `{"error": "auth error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	names, err := h.repo.Subscriptions(user.ID)
+	if err != nil {
+		writeCommunityError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, names)
+}
+
+// AddModerator appoints a moderator of the community. Admins and the
+// community's moderators may appoint; the role is in the new moderator's
+// token from their next login.
+func (h *CommunityHandler) AddModerator(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Username string `json:"username"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "bad request"}`, http.StatusBadRequest)
+		return
+	}
+
+	caller, ok := middleware.GetUser(r.Context())
+	if !ok {
+		http.Error(w, `{"error": "auth error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	name := r.PathValue("CATEGORY_NAME")
+	if _, err := h.repo.Get(name); err != nil {
+		writeCommunityError(w, err)
+		return
+	}
+	if !middleware.Allowed(caller, middleware.ActionAddModerator, middleware.Resource{Category: name}) {
+		http.Error(w, `{"error": "forbidden"}`, http.StatusForbidden)
+		return
+	}
+
+	u, err := h.users.GetByUsername(req.Username)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+	c, err := h.repo.AddModerator(name, community.Member{ID: u.ID, Username: u.Username})
+	if err != nil {
+		writeCommunityError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, c)
+}
+
+// RemoveModerator takes the moderator role away; only admins may.
+func (h *CommunityHandler) RemoveModerator(w http.ResponseWriter, r *http.Request) {
+	u, err := h.users.GetByUsername(r.PathValue("USER_LOGIN"))
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+	c, err := h.repo.RemoveModerator(r.PathValue("CATEGORY_NAME"), u.ID)
+	if err != nil {
+		writeCommunityError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, c)
+}
+
+// writeCommunityError maps community errors to HTTP responses.
+func writeCommunityError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, community.ErrNotFound):
+		http.Error(w, `{"error": "community not found"}`, http.StatusNotFound)
+	case errors.Is(err, community.ErrExists):
+		http.Error(w, `{"error": "community already exists"}`, http.StatusConflict)
+	case errors.Is(err, community.ErrBadName):
+		http.Error(w, `{"error": "name must be 3-21 lowercase letters, digits or underscores"}`, http.StatusBadRequest)
+	case errors.Is(err, community.ErrTooLong):
+		http.Error(w, `{"error": "description or rule too long"}`, http.StatusBadRequest)
+	case errors.Is(err, community.ErrTooManyRules):
+		http.Error(w, `{"error": "too many rules"}`, http.StatusBadRequest)
+	default:
+		http.Error(w, `{"error": "db error"}`, http.StatusInternalServerError)
+	}
+}
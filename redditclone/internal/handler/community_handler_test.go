@@ -0,0 +1,175 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"redditclone/internal/community"
+	"redditclone/internal/middleware"
+)
+
+func TestCreateCommunity(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"valid", `{"name": "jazz_2024", "description": "Swing", "rules": ["Be kind", " "]}`, http.StatusCreated},
+		{"shortest name", `{"name": "abc"}`, http.StatusCreated},
+		{"longest name", `{"name": "` + strings.Repeat("a", 21) + `"}`, http.StatusCreated},
+		{"name too short", `{"name": "ab"}`, http.StatusBadRequest},
+		{"name too long", `{"name": "` + strings.Repeat("a", 22) + `"}`, http.StatusBadRequest},
+		{"uppercase", `{"name": "Jazz"}`, http.StatusBadRequest},
+		{"hyphen", `{"name": "free-jazz"}`, http.StatusBadRequest},
+		{"path in the name", `{"name": "jazz/../admin"}`, http.StatusBadRequest},
+		{"taken name", `{"name": "music"}`, http.StatusConflict},
+		{"description too long", `{"name": "jazz", "description": "` + strings.Repeat("x", community.MaxDescriptionLength+1) + `"}`,
+			http.StatusBadRequest},
+		{"too many rules", `{"name": "jazz", "rules": [` + strings.Repeat(`"r", `, community.MaxRules) + `"r"]}`,
+			http.StatusBadRequest},
+		{"malformed", `{"name": `, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer()
+			h := NewCommunityHandler(s.communities, s.users)
+			w := s.serve(h.Create, "POST", "/api/categories", tt.body, s.login(t, "alice"))
+			if got := w.Result().StatusCode; got != tt.status {
+				t.Fatalf("status = %d, want %d: %s", got, tt.status, w.Body)
+			}
+			if tt.status != http.StatusCreated {
+				return
+			}
+
+			alice := s.user(t, "alice")
+			moderated, err := s.communities.Moderated(alice.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(moderated) != 1 {
+				t.Fatalf("creator moderates %v, want the new community", moderated)
+			}
+			c, err := s.communities.Get(moderated[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Creator == nil || c.Creator.ID != alice.ID {
+				t.Errorf("creator = %+v, want alice", c.Creator)
+			}
+			for _, rule := range c.Rules {
+				if strings.TrimSpace(rule) == "" {
+					t.Errorf("blank rule kept in %q", c.Rules)
+				}
+			}
+		})
+	}
+}
+
+func TestCreateCommunityNeedsLogin(t *testing.T) {
+	s := newTestServer()
+	h := NewCommunityHandler(s.communities, s.users)
+	if status := s.serve(h.Create, "POST", "/api/categories", `{"name": "jazz"}`, "").Result().StatusCode; status != http.StatusUnauthorized {
+		t.Errorf("status = %d, want 401", status)
+	}
+	if _, err := s.communities.Get("jazz"); err != community.ErrNotFound {
+		t.Errorf("community created without a login: %v", err)
+	}
+}
+
+func TestModeratorPermissions(t *testing.T) {
+	tests := []struct {
+		name       string
+		by         string
+		category   string
+		add        string // user to appoint
+		remove     string // user to dismiss, when add is empty
+		status     int
+		moderators []string // of the category afterwards
+	}{
+		{name: "moderator appoints", by: "anna", category: "music", add: "carol",
+			status: http.StatusOK, moderators: []string{"anna", "dan", "carol"}},
+		{name: "admin appoints", by: "ada", category: "music", add: "carol",
+			status: http.StatusOK, moderators: []string{"anna", "dan", "carol"}},
+		{name: "appointing twice", by: "anna", category: "music", add: "dan",
+			status: http.StatusOK, moderators: []string{"anna", "dan"}},
+		{name: "stranger appoints", by: "bob", category: "music", add: "carol",
+			status: http.StatusForbidden, moderators: []string{"anna", "dan"}},
+		{name: "moderator of another community appoints", by: "anna", category: "news", add: "carol",
+			status: http.StatusForbidden, moderators: []string{}},
+		{name: "unknown community", by: "ada", category: "nosuch", add: "carol", status: http.StatusNotFound},
+		{name: "unknown user", by: "anna", category: "music", add: "nobody",
+			status: http.StatusNotFound, moderators: []string{"anna", "dan"}},
+		{name: "admin dismisses", by: "ada", category: "music", remove: "dan",
+			status: http.StatusOK, moderators: []string{"anna"}},
+		{name: "moderator dismisses", by: "anna", category: "music", remove: "dan",
+			status: http.StatusForbidden, moderators: []string{"anna", "dan"}},
+		{name: "moderator steps down", by: "dan", category: "music", remove: "dan",
+			status: http.StatusForbidden, moderators: []string{"anna", "dan"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer()
+			s.user(t, "ada")
+			if _, err := s.users.SetAdmin("ada", true); err != nil {
+				t.Fatal(err)
+			}
+			s.moderate(t, "anna", "music")
+			s.moderate(t, "dan", "music")
+			s.user(t, "carol")
+			h := NewCommunityHandler(s.communities, s.users)
+			token := s.login(t, tt.by)
+
+			target := "/api/category/" + tt.category + "/moderators"
+			var status int
+			if tt.add != "" {
+				status = s.serve(h.AddModerator, "POST", target, `{"username": "`+tt.add+`"}`, token,
+					"CATEGORY_NAME", tt.category).Result().StatusCode
+			} else {
+				status = s.serve(middleware.RequireAdmin(http.HandlerFunc(h.RemoveModerator)).ServeHTTP,
+					"DELETE", target+"/"+tt.remove, "", token,
+					"CATEGORY_NAME", tt.category, "USER_LOGIN", tt.remove).Result().StatusCode
+			}
+			if status != tt.status {
+				t.Errorf("status = %d, want %d", status, tt.status)
+			}
+			if tt.moderators == nil {
+				return
+			}
+
+			c, err := s.communities.Get(tt.category)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, len(c.Moderators))
+			for i, m := range c.Moderators {
+				got[i] = m.Username
+			}
+			if strings.Join(got, ",") != strings.Join(tt.moderators, ",") {
+				t.Errorf("moderators = %v, want %v", got, tt.moderators)
+			}
+		})
+	}
+}
+
+func TestAppointedModeratorActsFromNextLogin(t *testing.T) {
+	s := newTestServer()
+	s.moderate(t, "anna", "music")
+	s.user(t, "dan")
+	h := NewCommunityHandler(s.communities, s.users)
+	appoint := func(token, name string) int {
+		return s.serve(h.AddModerator, "POST", "/api/category/music/moderators", `{"username": "`+name+`"}`, token,
+			"CATEGORY_NAME", "music").Result().StatusCode
+	}
+
+	before := s.login(t, "carol")
+	if status := appoint(s.login(t, "anna"), "carol"); status != http.StatusOK {
+		t.Fatalf("appointing carol: status = %d, want 200", status)
+	}
+	if status := appoint(before, "dan"); status != http.StatusForbidden {
+		t.Errorf("carol's older token: status = %d, want 403", status)
+	}
+	if status := appoint(s.login(t, "carol"), "dan"); status != http.StatusOK {
+		t.Errorf("carol's new token: status = %d, want 200", status)
+	}
+}
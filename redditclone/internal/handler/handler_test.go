@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"redditclone/internal/ban"
+	"redditclone/internal/community"
+	"redditclone/internal/middleware"
+	"redditclone/internal/post"
+	"redditclone/internal/report"
+	"redditclone/internal/reset"
+	"redditclone/internal/session"
+	"redditclone/internal/user"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// testServer holds the memory repos the handlers under test work on and
+// signs users in. Its tokens are the IDs of real sessions, so requests go
+// through the same checks as in production without signing JWTs.
+type testServer struct {
+	users       *user.MemoryRepo
+	posts       *post.MemoryRepo
+	communities *community.MemoryRepo
+	reports     *report.MemoryRepo
+	resets      *reset.MemoryRepo
+	sessions    *session.MemoryRepo
+	bans        *ban.MemoryRepo
+	claims      map[string]jwt.MapClaims // by token
+}
+
+func newTestServer() *testServer {
+	return &testServer{
+		users:       user.NewMemoryRepo(),
+		posts:       post.NewMemoryRepo(),
+		communities: community.NewMemoryRepo(),
+		reports:     report.NewMemoryRepo(),
+		resets:      reset.NewMemoryRepo(),
+		sessions:    session.NewMemoryRepo(),
+		bans:        ban.NewMemoryRepo(),
+		claims:      make(map[string]jwt.MapClaims),
+	}
+}
+
+// Parse implements middleware.Tokens.
+func (s *testServer) Parse(token string) (jwt.MapClaims, error) {
+	claims, ok := s.claims[token]
+	if !ok {
+		return nil, errors.New("unknown token")
+	}
+	return claims, nil
+}
+
+// user returns the user called name, registering them with the password
+// "password1" if there is none yet.
+func (s *testServer) user(t *testing.T, name string) *user.User {
+	t.Helper()
+	u, err := s.users.GetByUsername(name)
+	if errors.Is(err, user.ErrNotFound) {
+		u, err = s.users.Register(name, "password1")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+// moderate makes the user called name a moderator of the category.
+func (s *testServer) moderate(t *testing.T, name, category string) {
+	t.Helper()
+	u := s.user(t, name)
+	if _, err := s.communities.AddModerator(category, community.Member{ID: u.ID, Username: u.Username}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// login starts a session of the user called name and returns a token for
+// it. The token carries the roles the user holds at login, as a signed one
+// would.
+func (s *testServer) login(t *testing.T, name string) string {
+	t.Helper()
+	u := s.user(t, name)
+	moderated, err := s.communities.Moderated(u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess, _, err := session.New(u.ID, u.Username, "test", "127.0.0.1", time.Now(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.sessions.Create(sess); err != nil {
+		t.Fatal(err)
+	}
+	moderates := make([]any, len(moderated))
+	for i, name := range moderated {
+		moderates[i] = name
+	}
+	s.claims[sess.ID] = jwt.MapClaims{
+		"user": map[string]any{"id": u.ID, "username": u.Username, "admin": u.Admin, "moderates": moderates},
+		"sid":  sess.ID,
+	}
+	return sess.ID
+}
+
+// serve runs h behind middleware.Auth, sending token unless it is empty.
+// Path values are set from pairs of names and values.
+func (s *testServer) serve(h http.HandlerFunc, method, target, body, token string, path ...string) *httptest.ResponseRecorder {
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, target, r)
+	for i := 0; i+1 < len(path); i += 2 {
+		req.SetPathValue(path[i], path[i+1])
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	middleware.Auth(s, s.bans, s.sessions)(h).ServeHTTP(w, req)
+	return w
+}
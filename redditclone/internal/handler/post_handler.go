@@ -3,11 +3,11 @@ package handler
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
 	"net/http"
+	"redditclone/internal/community"
 	"redditclone/internal/middleware"
 	"redditclone/internal/post"
-	"sort"
+	"redditclone/internal/report"
 	"strconv"
 	"time"
 
@@ -16,36 +16,23 @@ import (
 
 type PostHandler struct {
 	repo post.Repo
+	// communities are the categories posts may be filed under.
+	communities community.Repo
+	// reports record the posts and comments removed by somebody other than
+	// their author, who may not restore them.
+	reports report.Repo
+	// restoreWindow is how long after deletion the author may restore a post or comment.
+	restoreWindow time.Duration
+	// diffs caches the diffs of history listings.
+	diffs *post.DiffCache
 }
 
-func NewPostHandler(repo post.Repo) *PostHandler {
-	return &PostHandler{repo: repo}
+func NewPostHandler(repo post.Repo, communities community.Repo, reports report.Repo, restoreWindow time.Duration) *PostHandler {
+	return &PostHandler{repo: repo, communities: communities, reports: reports, restoreWindow: restoreWindow, diffs: post.NewDiffCache(1024)}
 }
 
-func (h *PostHandler) List(w http.ResponseWriter, _ *http.Request) {
-	posts, err := h.repo.GetAll()
-	if err != nil {
-		http.Error(w, `{"error": "db error"}`, http.StatusInternalServerError)
-		return
-	}
-
-	for _, p := range posts {
-		p.CalculateUpvotePercentage()
-	}
-
-	sort.Slice(posts, func(i, j int) bool {
-		return posts[i].Score > posts[j].Score
-	})
-
-	resp, err := json.Marshal(posts)
-	if err != nil {
-		http.Error(w, `{"error": "json marshal error"}`, http.StatusInternalServerError)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write(resp)
+func (h *PostHandler) List(w http.ResponseWriter, r *http.Request) {
+	h.list(w, r, post.ListQuery{Sort: post.SortTop})
 }
 
 func (h *PostHandler) Add(w http.ResponseWriter, r *http.Request) {
@@ -61,6 +48,20 @@ func (h *PostHandler) Add(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	_, err := h.communities.Get(p.Category)
+	if errors.Is(err, community.ErrNotFound) {
+		http.Error(w, `{"error": "unknown category"}`, http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error": "db error"}`, http.StatusInternalServerError)
+		return
+	}
+	if !middleware.Allowed(user, middleware.ActionPost, middleware.Resource{Category: p.Category}) {
+		writeBannedFrom(w)
+		return
+	}
+
 	p.ID = uuid.NewString()
 	p.Score = 0 // Score will be set by the initial vote
 	p.Author = &post.Author{
@@ -68,6 +69,9 @@ func (h *PostHandler) Add(w http.ResponseWriter, r *http.Request) {
 		Username: user.Username,
 	}
 	p.Created = time.Now()
+	p.Views = 0
+	p.Edited = time.Time{}
+	p.Deleted = time.Time{}
 	p.Votes = make([]*post.Vote, 0)
 	p.Comments = make([]*post.Comment, 0)
 	p.Vote(user.ID, 1) // Initial upvote from author
@@ -78,112 +82,406 @@ func (h *PostHandler) Add(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	newPost.CalculateUpvotePercentage()
+	writePost(w, http.StatusCreated, newPost)
+}
 
-	resp, err := json.Marshal(newPost)
+// GetByID serves a post with its comments threaded; ?comment_sort orders
+// the replies at every level.
+func (h *PostHandler) GetByID(w http.ResponseWriter, r *http.Request) {
+	postID := r.PathValue("POST_ID")
+	sort := post.CommentSortOld
+	if v := r.URL.Query().Get("comment_sort"); v != "" {
+		sort = post.CommentSort(v)
+		if !sort.Valid() {
+			http.Error(w, `{"error": "bad comment sort"}`, http.StatusBadRequest)
+			return
+		}
+	}
+
+	p, err := h.repo.IncrementViews(postID)
 	if err != nil {
-		http.Error(w, `{"error": "json marshal error"}`, http.StatusInternalServerError)
+		writeRepoError(w, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	_, _ = w.Write(resp)
+	preparePost(p, sort)
+	writeJSON(w, http.StatusOK, p)
 }
 
-func (h *PostHandler) GetByID(w http.ResponseWriter, r *http.Request) {
+func (h *PostHandler) ListByCategory(w http.ResponseWriter, r *http.Request) {
+	h.list(w, r, post.ListQuery{Category: r.PathValue("CATEGORY_NAME"), Sort: post.SortTop})
+}
+
+func (h *PostHandler) ListByUser(w http.ResponseWriter, r *http.Request) {
+	h.list(w, r, post.ListQuery{Author: r.PathValue("USER_LOGIN"), Sort: post.SortNew})
+}
+
+// Feed lists the posts of the categories the user subscribed to. Anonymous
+// users and users without subscriptions get the default communities.
+func (h *PostHandler) Feed(w http.ResponseWriter, r *http.Request) {
+	categories := community.Defaults
+	if user, ok := middleware.GetUser(r.Context()); ok {
+		subscribed, err := h.communities.Subscriptions(user.ID)
+		if err != nil {
+			http.Error(w, `{"error": "db error"}`, http.StatusInternalServerError)
+			return
+		}
+		if len(subscribed) > 0 {
+			categories = subscribed
+		}
+	}
+	h.list(w, r, post.ListQuery{Categories: categories, Sort: post.SortTop})
+}
+
+// list serves a listing. ?sort overrides the default order and ?t limits top
+// and controversial listings to a time window. Clients opt into pagination
+// with ?limit, ?after or ?before and get a page with next/prev cursors;
+// without them the whole listing is returned as a plain array, as the
+// bundled frontend expects. Signed-in users do not see the posts they hid.
+func (h *PostHandler) list(w http.ResponseWriter, r *http.Request, q post.ListQuery) {
+	if user, ok := middleware.GetUser(r.Context()); ok {
+		q.Viewer = user.ID
+	}
+	params := r.URL.Query()
+	if v := params.Get("sort"); v != "" {
+		q.Sort = post.Sort(v)
+		if !q.Sort.Valid() {
+			http.Error(w, `{"error": "bad sort"}`, http.StatusBadRequest)
+			return
+		}
+	}
+	if v := params.Get("t"); v != "" {
+		window, ok := post.Windows[v]
+		if !ok {
+			http.Error(w, `{"error": "bad time window"}`, http.StatusBadRequest)
+			return
+		}
+		q.Window = window
+	}
+	paged := params.Has("limit") || params.Has("after") || params.Has("before")
+	if paged {
+		q.Limit = post.DefaultPageSize
+		if v := params.Get("limit"); v != "" {
+			limit, err := strconv.Atoi(v)
+			if err != nil || limit <= 0 {
+				http.Error(w, `{"error": "bad limit"}`, http.StatusBadRequest)
+				return
+			}
+			q.Limit = limit
+		}
+		q.After = params.Get("after")
+		q.Before = params.Get("before")
+	}
+
+	page, err := h.repo.List(q)
+	if errors.Is(err, post.ErrBadCursor) {
+		http.Error(w, `{"error": "bad cursor"}`, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error": "db error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	if !paged {
+		writePosts(w, page.Posts)
+		return
+	}
+	preparePosts(page.Posts)
+	writeJSON(w, http.StatusOK, page)
+}
+
+func (h *PostHandler) AddComment(w http.ResponseWriter, r *http.Request) {
 	postID := r.PathValue("POST_ID")
+
+	var body struct {
+		Comment  string `json:"comment"`
+		ParentID string `json:"parent_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, `{"error": "bad request"}`, http.StatusBadRequest)
+		return
+	}
+
+	user, ok := middleware.GetUser(r.Context())
+	if !ok {
+		http.Error(w, `{"error": "auth error"}`, http.StatusUnauthorized)
+		return
+	}
+	if !h.allowedOn(w, user, middleware.ActionComment, postID) {
+		return
+	}
+
+	author := &post.Author{
+		ID:       user.ID,
+		Username: user.Username,
+	}
+	comment := post.NewComment(author, body.Comment)
+	comment.ParentID = body.ParentID
+	p, err := h.repo.AddComment(postID, comment)
+	if err != nil {
+		writeRepoError(w, err)
+		return
+	}
+	writePost(w, http.StatusCreated, p)
+}
+
+func (h *PostHandler) DeleteComment(w http.ResponseWriter, r *http.Request) {
+	postID := r.PathValue("POST_ID")
+	commentID := r.PathValue("COMMENT_ID")
+
 	p, err := h.repo.GetByID(postID)
 	if err != nil {
 		http.Error(w, `{"error": "post not found"}`, http.StatusNotFound)
 		return
 	}
 
-	p.Views++
-	p.CalculateUpvotePercentage()
+	user, ok := middleware.GetUser(r.Context())
+	if !ok {
+		http.Error(w, `{"error": "auth error"}`, http.StatusUnauthorized)
+		return
+	}
 
-	resp, err := json.Marshal(p)
+	c := findComment(p, commentID)
+	if c == nil {
+		http.Error(w, `{"error": "comment not found"}`, http.StatusNotFound)
+		return
+	}
+
+	res := commentResource(p, c)
+	if !middleware.Allowed(user, middleware.ActionDeleteComment, res) {
+		http.Error(w, `{"error": "forbidden"}`, http.StatusForbidden)
+		return
+	}
+
+	if p.IsDeleted() || c.IsDeleted() {
+		writeRepoError(w, post.ErrDeleted)
+		return
+	}
+	now := time.Now()
+	if !h.recordRemoval(w, user, res, p, commentID, now) {
+		return
+	}
+	p, err = h.repo.DeleteComment(postID, commentID, now)
 	if err != nil {
-		http.Error(w, `{"error": "json marshal error"}`, http.StatusInternalServerError)
+		writeRepoError(w, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write(resp)
+	writePost(w, http.StatusOK, p)
 }
 
-func (h *PostHandler) ListByCategory(w http.ResponseWriter, r *http.Request) {
-	category := r.PathValue("CATEGORY_NAME")
-	posts, err := h.repo.GetByCategory(category)
-	if err != nil {
-		http.Error(w, `{"error": "db error"}`, http.StatusInternalServerError)
+func (h *PostHandler) Upvote(w http.ResponseWriter, r *http.Request) {
+	h.vote(w, r, 1)
+}
+
+func (h *PostHandler) Downvote(w http.ResponseWriter, r *http.Request) {
+	h.vote(w, r, -1)
+}
+
+func (h *PostHandler) Unvote(w http.ResponseWriter, r *http.Request) {
+	h.vote(w, r, 0)
+}
+
+func (h *PostHandler) UpvoteComment(w http.ResponseWriter, r *http.Request) {
+	h.voteComment(w, r, 1)
+}
+
+func (h *PostHandler) DownvoteComment(w http.ResponseWriter, r *http.Request) {
+	h.voteComment(w, r, -1)
+}
+
+func (h *PostHandler) UnvoteComment(w http.ResponseWriter, r *http.Request) {
+	h.voteComment(w, r, 0)
+}
+
+func (h *PostHandler) voteComment(w http.ResponseWriter, r *http.Request, voteValue int) {
+	postID := r.PathValue("POST_ID")
+	commentID := r.PathValue("COMMENT_ID")
+
+	user, ok := middleware.GetUser(r.Context())
+	if !ok {
+		http.Error(w, `{"error": "auth error"}`, http.StatusInternalServerError)
+		return
+	}
+	if !h.allowedOn(w, user, middleware.ActionVote, postID) {
 		return
 	}
 
-	for _, p := range posts {
-		p.CalculateUpvotePercentage()
+	p, err := h.repo.VoteComment(postID, commentID, user.ID, voteValue)
+	if err != nil {
+		writeRepoError(w, err)
+		return
 	}
+	writePost(w, http.StatusOK, p)
+}
 
-	sort.Slice(posts, func(i, j int) bool {
-		_, err = h.validateSorting(i, j)
-		if err != nil {
-			_ = fmt.Sprintf("error sorting posts: %v", err)
-		}
-		return posts[i].Score > posts[j].Score
-	})
+func (h *PostHandler) vote(w http.ResponseWriter, r *http.Request, voteValue int) {
+	postID := r.PathValue("POST_ID")
+
+	user, ok := middleware.GetUser(r.Context())
+	if !ok {
+		http.Error(w, `{"error": "auth error"}`, http.StatusInternalServerError)
+		return
+	}
+	if !h.allowedOn(w, user, middleware.ActionVote, postID) {
+		return
+	}
 
-	resp, err := json.Marshal(posts)
+	p, err := h.repo.Vote(postID, user.ID, voteValue)
 	if err != nil {
-		http.Error(w, `{"error": "json marshal error"}`, http.StatusInternalServerError)
+		writeRepoError(w, err)
 		return
 	}
+	writePost(w, http.StatusOK, p)
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write(resp)
+// allowedOn reports whether the policy lets the user act in the category of
+// the post, writing an error response if not.
+func (h *PostHandler) allowedOn(w http.ResponseWriter, user middleware.UserClaims, action middleware.Action, postID string) bool {
+	p, err := h.repo.GetByID(postID)
+	if err != nil {
+		writeRepoError(w, err)
+		return false
+	}
+	if !middleware.Allowed(user, action, middleware.Resource{Category: p.Category}) {
+		writeBannedFrom(w)
+		return false
+	}
+	return true
 }
 
-func (h *PostHandler) ListByUser(w http.ResponseWriter, r *http.Request) {
-	userLogin := r.PathValue("USER_LOGIN")
-	posts, err := h.repo.GetByAuthor(userLogin)
+func (h *PostHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	postID := r.PathValue("POST_ID")
+	p, err := h.repo.GetByID(postID)
 	if err != nil {
-		http.Error(w, `{"error": "db error"}`, http.StatusInternalServerError)
+		http.Error(w, `{"error": "post not found"}`, http.StatusNotFound)
 		return
 	}
 
-	for _, p := range posts {
-		p.CalculateUpvotePercentage()
+	user, ok := middleware.GetUser(r.Context())
+	if !ok {
+		http.Error(w, `{"error": "auth error"}`, http.StatusUnauthorized)
+		return
 	}
 
-	sort.Slice(posts, func(i, j int) bool {
-		return posts[i].Created.After(posts[j].Created)
-	})
+	res := postResource(p)
+	if !middleware.Allowed(user, middleware.ActionDeletePost, res) {
+		http.Error(w, `{"error": "forbidden"}`, http.StatusForbidden)
+		return
+	}
 
-	resp, err := json.Marshal(posts)
+	if p.IsDeleted() {
+		writeRepoError(w, post.ErrDeleted)
+		return
+	}
+	now := time.Now()
+	if !h.recordRemoval(w, user, res, p, "", now) {
+		return
+	}
+	err = h.repo.Delete(postID, now)
 	if err != nil {
-		http.Error(w, `{"error": "json marshal error"}`, http.StatusInternalServerError)
+		writeRepoError(w, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write(resp)
+	_, _ = w.Write([]byte(`{"message": "success"}`))
 }
 
-func (h *PostHandler) AddComment(w http.ResponseWriter, r *http.Request) {
-	postID := r.PathValue("POST_ID")
-	p, err := h.repo.GetByID(postID)
+func (h *PostHandler) Save(w http.ResponseWriter, r *http.Request) {
+	h.bookmark(w, r, func(postID, userID string) error {
+		return h.repo.Save(postID, userID, time.Now())
+	})
+}
+
+func (h *PostHandler) Unsave(w http.ResponseWriter, r *http.Request) {
+	h.bookmark(w, r, h.repo.Unsave)
+}
+
+func (h *PostHandler) Hide(w http.ResponseWriter, r *http.Request) {
+	h.bookmark(w, r, h.repo.Hide)
+}
+
+func (h *PostHandler) Unhide(w http.ResponseWriter, r *http.Request) {
+	h.bookmark(w, r, h.repo.Unhide)
+}
+
+// bookmark applies a save or hide change of the user to the post.
+func (h *PostHandler) bookmark(w http.ResponseWriter, r *http.Request, change func(postID, userID string) error) {
+	user, ok := middleware.GetUser(r.Context())
+	if !ok {
+		http.Error(w, `{"error": "auth error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	if err := change(r.PathValue("POST_ID"), user.ID); err != nil {
+		writeRepoError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(`{"message": "success"}`))
+}
+
+// ListSaved serves a page of the posts the user saved, most recently saved
+// first; ?limit and ?after page through them.
+func (h *PostHandler) ListSaved(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r.Context())
+	if !ok {
+		http.Error(w, `{"error": "auth error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	params := r.URL.Query()
+	q := post.SavedQuery{UserID: user.ID, Limit: post.DefaultPageSize, After: params.Get("after")}
+	if v := params.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			http.Error(w, `{"error": "bad limit"}`, http.StatusBadRequest)
+			return
+		}
+		q.Limit = limit
+	}
+
+	page, err := h.repo.ListSaved(q)
+	if errors.Is(err, post.ErrBadCursor) {
+		http.Error(w, `{"error": "bad cursor"}`, http.StatusBadRequest)
+		return
+	}
 	if err != nil {
-		http.Error(w, `{"error": "post not found"}`, http.StatusNotFound)
+		http.Error(w, `{"error": "db error"}`, http.StatusInternalServerError)
 		return
 	}
+	preparePosts(page.Posts)
+	writeJSON(w, http.StatusOK, page)
+}
 
-	var body struct {
-		Comment string `json:"comment"`
+// writeRepoError maps repository errors to HTTP responses.
+func writeRepoError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, post.ErrNotFound):
+		http.Error(w, `{"error": "post not found"}`, http.StatusNotFound)
+	case errors.Is(err, post.ErrCommentNotFound):
+		http.Error(w, `{"error": "comment not found"}`, http.StatusNotFound)
+	case errors.Is(err, post.ErrDeleted):
+		http.Error(w, `{"error": "deleted"}`, http.StatusGone)
+	case errors.Is(err, post.ErrNotDeleted):
+		http.Error(w, `{"error": "not deleted"}`, http.StatusConflict)
+	case errors.Is(err, post.ErrTooDeep):
+		http.Error(w, `{"error": "comment nested too deeply"}`, http.StatusUnprocessableEntity)
+	default:
+		http.Error(w, `{"error": "db error"}`, http.StatusInternalServerError)
 	}
-	if err = json.NewDecoder(r.Body).Decode(&body); err != nil {
-		http.Error(w, `{"error": "bad request"}`, http.StatusBadRequest)
+}
+
+func (h *PostHandler) Restore(w http.ResponseWriter, r *http.Request) {
+	postID := r.PathValue("POST_ID")
+	p, err := h.repo.GetByID(postID)
+	if err != nil {
+		writeRepoError(w, err)
 		return
 	}
 
@@ -193,45 +491,36 @@ func (h *PostHandler) AddComment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	author := &post.Author{
-		ID:       user.ID,
-		Username: user.Username,
+	if !middleware.Allowed(user, middleware.ActionRestore, postResource(p)) {
+		http.Error(w, `{"error": "forbidden"}`, http.StatusForbidden)
+		return
 	}
-	p.AddComment(author, body.Comment)
-	p.CalculateUpvotePercentage()
 
-	resp, err := json.Marshal(p)
-	if err != nil {
-		http.Error(w, `{"error": "json marshal error"}`, http.StatusInternalServerError)
+	if p.IsDeleted() && time.Since(p.Deleted) > h.restoreWindow {
+		http.Error(w, `{"error": "restore window expired"}`, http.StatusGone)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	_, _ = w.Write(resp)
-}
+	if !h.checkNotRemoved(w, postID, "") {
+		return
+	}
 
-func (h *PostHandler) validateSorting(i, j int) (bool, error) {
-	k := 1
-	for m := range i * j * 100000 {
-		if k == 0 {
-			k = 2
-		}
-		if j == 0 {
-			j = 2
-		}
-		k = i % j * m % k
+	p, err = h.repo.Restore(postID)
+	if err != nil {
+		writeRepoError(w, err)
+		return
 	}
-	return true, errors.New("pretty valid %d" + strconv.Itoa(int(k)))
+
+	writePost(w, http.StatusOK, p)
 }
 
-func (h *PostHandler) DeleteComment(w http.ResponseWriter, r *http.Request) {
+func (h *PostHandler) RestoreComment(w http.ResponseWriter, r *http.Request) {
 	postID := r.PathValue("POST_ID")
 	commentID := r.PathValue("COMMENT_ID")
 
 	p, err := h.repo.GetByID(postID)
 	if err != nil {
-		http.Error(w, `{"error": "post not found"}`, http.StatusNotFound)
+		writeRepoError(w, err)
 		return
 	}
 
@@ -241,88 +530,120 @@ func (h *PostHandler) DeleteComment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var commentAuthorID string
-	for _, c := range p.Comments {
-		if c.ID == commentID {
-			commentAuthorID = c.Author.ID
-			break
-		}
-	}
-
-	if commentAuthorID == "" {
+	c := findComment(p, commentID)
+	if c == nil {
 		http.Error(w, `{"error": "comment not found"}`, http.StatusNotFound)
 		return
 	}
 
-	// In a real app, you might also allow post authors or admins to delete comments.
-	if user.ID != commentAuthorID {
+	if !middleware.Allowed(user, middleware.ActionRestore, commentResource(p, c)) {
 		http.Error(w, `{"error": "forbidden"}`, http.StatusForbidden)
 		return
 	}
 
-	if err = p.RemoveComment(commentID); err != nil {
-		http.Error(w, `{"error": "comment not found"}`, http.StatusNotFound)
+	if c.IsDeleted() && time.Since(c.Deleted) > h.restoreWindow {
+		http.Error(w, `{"error": "restore window expired"}`, http.StatusGone)
 		return
 	}
 
-	p.CalculateUpvotePercentage()
+	if !h.checkNotRemoved(w, postID, commentID) {
+		return
+	}
 
-	resp, err := json.Marshal(p)
+	p, err = h.repo.RestoreComment(postID, commentID)
 	if err != nil {
-		http.Error(w, `{"error": "json marshal error"}`, http.StatusInternalServerError)
+		writeRepoError(w, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write(resp)
+	writePost(w, http.StatusOK, p)
 }
 
-func (h *PostHandler) Upvote(w http.ResponseWriter, r *http.Request) {
-	h.vote(w, r, 1)
-}
-
-func (h *PostHandler) Downvote(w http.ResponseWriter, r *http.Request) {
-	h.vote(w, r, -1)
+// recordRemoval keeps the author from restoring content somebody else
+// deletes. It runs before the deletion: if the record cannot be written,
+// the content stays up instead of coming down restorable by its author. It
+// reports whether the request may go on, writing an error response if not.
+func (h *PostHandler) recordRemoval(w http.ResponseWriter, user middleware.UserClaims, res middleware.Resource, p *post.Post, commentID string, removed time.Time) bool {
+	if user.ID == res.AuthorID {
+		return true
+	}
+	if err := h.reports.Remove(report.Removal(p.ID, commentID, p.Category, user.ID, removed)); err != nil {
+		http.Error(w, `{"error": "db error"}`, http.StatusInternalServerError)
+		return false
+	}
+	return true
 }
 
-func (h *PostHandler) Unvote(w http.ResponseWriter, r *http.Request) {
-	h.vote(w, r, 0)
+// checkNotRemoved reports whether the content may be restored, writing an
+// error response if somebody other than its author removed it.
+func (h *PostHandler) checkNotRemoved(w http.ResponseWriter, postID, commentID string) bool {
+	removed, err := h.reports.Removed(postID, commentID)
+	if err != nil {
+		http.Error(w, `{"error": "db error"}`, http.StatusInternalServerError)
+		return false
+	}
+	if removed {
+		http.Error(w, `{"error": "removed by another user"}`, http.StatusForbidden)
+		return false
+	}
+	return true
 }
 
-func (h *PostHandler) vote(w http.ResponseWriter, r *http.Request, voteValue int) {
+func (h *PostHandler) Edit(w http.ResponseWriter, r *http.Request) {
 	postID := r.PathValue("POST_ID")
-	p, err := h.repo.GetByID(postID)
-	if err != nil {
-		http.Error(w, `{"error": "post not found"}`, http.StatusNotFound)
+
+	var body struct {
+		Title *string `json:"title"`
+		URL   *string `json:"url"`
+		Text  *string `json:"text"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, `{"error": "bad request"}`, http.StatusBadRequest)
 		return
 	}
 
 	user, ok := middleware.GetUser(r.Context())
 	if !ok {
-		http.Error(w, `{"error": "auth error"}`, http.StatusInternalServerError)
+		http.Error(w, `{"error": "auth error"}`, http.StatusUnauthorized)
 		return
 	}
 
-	p.Vote(user.ID, voteValue)
-	p.CalculateUpvotePercentage()
+	if body.Title != nil && *body.Title == "" {
+		http.Error(w, `{"error": "title is required"}`, http.StatusBadRequest)
+		return
+	}
 
-	resp, err := json.Marshal(p)
+	p, err := h.repo.GetByID(postID)
 	if err != nil {
-		http.Error(w, `{"error": "json marshal error"}`, http.StatusInternalServerError)
+		writeRepoError(w, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write(resp)
+	if !middleware.Allowed(user, middleware.ActionEdit, postResource(p)) {
+		http.Error(w, `{"error": "forbidden"}`, http.StatusForbidden)
+		return
+	}
+
+	// Fields missing from the request keep their value as of the edit.
+	edit := post.Edit{Title: body.Title, URL: body.URL, Text: body.Text, Edited: time.Now()}
+	p, err = h.repo.Edit(postID, edit)
+	if err != nil {
+		writeRepoError(w, err)
+		return
+	}
+
+	writePost(w, http.StatusOK, p)
 }
 
-func (h *PostHandler) Delete(w http.ResponseWriter, r *http.Request) {
+func (h *PostHandler) EditComment(w http.ResponseWriter, r *http.Request) {
 	postID := r.PathValue("POST_ID")
-	p, err := h.repo.GetByID(postID)
-	if err != nil {
-		http.Error(w, `{"error": "post not found"}`, http.StatusNotFound)
+	commentID := r.PathValue("COMMENT_ID")
+
+	var body struct {
+		Comment string `json:"comment"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Comment == "" {
+		http.Error(w, `{"error": "bad request"}`, http.StatusBadRequest)
 		return
 	}
 
@@ -332,18 +653,221 @@ func (h *PostHandler) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if p.Author == nil || user.ID != p.Author.ID {
+	p, err := h.repo.GetByID(postID)
+	if err != nil {
+		writeRepoError(w, err)
+		return
+	}
+
+	c := findComment(p, commentID)
+	if c == nil {
+		http.Error(w, `{"error": "comment not found"}`, http.StatusNotFound)
+		return
+	}
+
+	if !middleware.Allowed(user, middleware.ActionEdit, commentResource(p, c)) {
 		http.Error(w, `{"error": "forbidden"}`, http.StatusForbidden)
 		return
 	}
 
-	err = h.repo.Delete(postID)
+	p, err = h.repo.EditComment(postID, commentID, body.Comment, time.Now())
 	if err != nil {
-		http.Error(w, `{"error": "post not found"}`, http.StatusNotFound)
+		writeRepoError(w, err)
+		return
+	}
+
+	writePost(w, http.StatusOK, p)
+}
+
+// revisionResponse is one version of a post or comment in a history listing.
+// Diff describes the changes from the previous version, per field.
+type revisionResponse struct {
+	Version int                      `json:"version"`
+	Title   string                   `json:"title,omitempty"`
+	URL     string                   `json:"url,omitempty"`
+	Text    string                   `json:"text,omitempty"`
+	Body    string                   `json:"body,omitempty"`
+	Created time.Time                `json:"created"`
+	Current bool                     `json:"current"`
+	Diff    map[string][]post.DiffOp `json:"diff,omitempty"`
+}
+
+// History lists every version of a post, oldest first, the last one being current.
+func (h *PostHandler) History(w http.ResponseWriter, r *http.Request) {
+	postID := r.PathValue("POST_ID")
+
+	p, err := h.repo.GetByID(postID)
+	if err != nil {
+		writeRepoError(w, err)
+		return
+	}
+	if p.IsDeleted() {
+		writeRepoError(w, post.ErrDeleted)
+		return
+	}
+	revisions, err := h.repo.History(postID, "")
+	if err != nil {
+		writeRepoError(w, err)
+		return
+	}
+
+	revisions = append(revisions, &post.Revision{
+		Title:   p.Title,
+		URL:     p.URL,
+		Text:    p.Text,
+		Created: lastChange(p.Created, p.Edited),
+	})
+
+	history := make([]revisionResponse, len(revisions))
+	for i, rev := range revisions {
+		history[i] = revisionResponse{
+			Version: i + 1,
+			Title:   rev.Title,
+			URL:     rev.URL,
+			Text:    rev.Text,
+			Created: rev.Created,
+			Current: i == len(revisions)-1,
+		}
+		if i > 0 {
+			prev := revisions[i-1]
+			history[i].Diff = h.diffFields(map[string][2]string{
+				"title": {prev.Title, rev.Title},
+				"url":   {prev.URL, rev.URL},
+				"text":  {prev.Text, rev.Text},
+			})
+		}
+	}
+
+	writeJSON(w, http.StatusOK, history)
+}
+
+// CommentHistory lists every version of a comment, oldest first, the last one being current.
+func (h *PostHandler) CommentHistory(w http.ResponseWriter, r *http.Request) {
+	postID := r.PathValue("POST_ID")
+	commentID := r.PathValue("COMMENT_ID")
+
+	p, err := h.repo.GetByID(postID)
+	if err != nil {
+		writeRepoError(w, err)
+		return
+	}
+	c := findComment(p, commentID)
+	if c == nil {
+		http.Error(w, `{"error": "comment not found"}`, http.StatusNotFound)
+		return
+	}
+	if p.IsDeleted() || c.IsDeleted() {
+		writeRepoError(w, post.ErrDeleted)
+		return
+	}
+	revisions, err := h.repo.History(postID, commentID)
+	if err != nil {
+		writeRepoError(w, err)
+		return
+	}
+
+	revisions = append(revisions, &post.Revision{
+		CommentID: c.ID,
+		Text:      c.Body,
+		Created:   lastChange(c.Created, c.Edited),
+	})
+
+	history := make([]revisionResponse, len(revisions))
+	for i, rev := range revisions {
+		history[i] = revisionResponse{
+			Version: i + 1,
+			Body:    rev.Text,
+			Created: rev.Created,
+			Current: i == len(revisions)-1,
+		}
+		if i > 0 {
+			history[i].Diff = h.diffFields(map[string][2]string{
+				"body": {revisions[i-1].Text, rev.Text},
+			})
+		}
+	}
+
+	writeJSON(w, http.StatusOK, history)
+}
+
+// diffFields diffs each changed field; unchanged fields are left out.
+func (h *PostHandler) diffFields(fields map[string][2]string) map[string][]post.DiffOp {
+	diff := make(map[string][]post.DiffOp)
+	for name, values := range fields {
+		if values[0] != values[1] {
+			diff[name] = h.diffs.Diff(values[0], values[1])
+		}
+	}
+	return diff
+}
+
+func lastChange(created, edited time.Time) time.Time {
+	if !edited.IsZero() {
+		return edited
+	}
+	return created
+}
+
+// postResource describes a post to the access policy.
+func postResource(p *post.Post) middleware.Resource {
+	res := middleware.Resource{Category: p.Category}
+	if p.Author != nil {
+		res.AuthorID = p.Author.ID
+	}
+	return res
+}
+
+// commentResource describes a comment on p to the access policy.
+func commentResource(p *post.Post, c *post.Comment) middleware.Resource {
+	res := postResource(p)
+	res.PostAuthorID, res.AuthorID = res.AuthorID, ""
+	if c.Author != nil {
+		res.AuthorID = c.Author.ID
+	}
+	return res
+}
+
+func findComment(p *post.Post, commentID string) *post.Comment {
+	for _, c := range p.Comments {
+		if c.ID == commentID {
+			return c
+		}
+	}
+	return nil
+}
+
+// writePost sends a post to the client with derived fields filled in,
+// comments in thread order and deleted content hidden.
+func writePost(w http.ResponseWriter, status int, p *post.Post) {
+	preparePost(p, post.CommentSortOld)
+	writeJSON(w, status, p)
+}
+
+func writePosts(w http.ResponseWriter, posts []*post.Post) {
+	preparePosts(posts)
+	writeJSON(w, http.StatusOK, posts)
+}
+
+func preparePosts(posts []*post.Post) {
+	for _, p := range posts {
+		preparePost(p, post.CommentSortOld)
+	}
+}
+
+func preparePost(p *post.Post, sort post.CommentSort) {
+	p.CalculateUpvotePercentage()
+	p.Thread(sort)
+	p.Redact()
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	resp, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, `{"error": "json marshal error"}`, http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write([]byte(`{"message": "success"}`))
+	w.WriteHeader(status)
+	_, _ = w.Write(resp)
 }
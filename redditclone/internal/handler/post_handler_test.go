@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"redditclone/internal/post"
+	"redditclone/internal/report"
+)
+
+// failingReports fails to record removals.
+type failingReports struct {
+	report.Repo
+}
+
+func (failingReports) Remove(*report.Report) error {
+	return errors.New("disk full")
+}
+
+// newPostFixture returns a handler over a post p1 by alice in music, which
+// anna moderates, with a comment by bob.
+func newPostFixture(t *testing.T, s *testServer, reports report.Repo) (*PostHandler, string) {
+	t.Helper()
+	s.moderate(t, "anna", "music")
+	alice, bob := s.user(t, "alice"), s.user(t, "bob")
+	p := &post.Post{
+		ID:       "p1",
+		Title:    "title",
+		Author:   &post.Author{ID: alice.ID, Username: alice.Username},
+		Category: "music",
+		Votes:    []*post.Vote{},
+		Comments: []*post.Comment{},
+		Created:  time.Now(),
+		Type:     "text",
+		Text:     "text",
+	}
+	if _, err := s.posts.Add(p); err != nil {
+		t.Fatal(err)
+	}
+	p, err := s.posts.AddComment("p1", post.NewComment(&post.Author{ID: bob.ID, Username: bob.Username}, "hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewPostHandler(s.posts, s.communities, reports, time.Hour), p.Comments[0].ID
+}
+
+func TestDeleteRecordsRemovals(t *testing.T) {
+	tests := []struct {
+		name    string
+		by      string
+		comment bool
+		before  func(t *testing.T, posts *post.MemoryRepo, commentID string)
+		fail    bool // reports cannot be written
+		status  int
+		deleted bool
+		removed bool
+	}{
+		{name: "author deletes a post", by: "alice", status: http.StatusOK, deleted: true},
+		{name: "moderator removes a post", by: "anna", status: http.StatusOK, deleted: true, removed: true},
+		{name: "moderator cannot record the removal", by: "anna", fail: true, status: http.StatusInternalServerError},
+		{name: "author needs no record", by: "alice", fail: true, status: http.StatusOK, deleted: true},
+		{name: "moderator on a post the author deleted", by: "anna", status: http.StatusGone, deleted: true,
+			before: func(t *testing.T, posts *post.MemoryRepo, _ string) {
+				if err := posts.Delete("p1", time.Now()); err != nil {
+					t.Fatal(err)
+				}
+			}},
+		{name: "stranger", by: "bob", status: http.StatusForbidden},
+		{name: "author deletes a comment", by: "bob", comment: true, status: http.StatusOK, deleted: true},
+		{name: "post author removes a comment", by: "alice", comment: true, status: http.StatusOK, deleted: true, removed: true},
+		{name: "comment removal cannot be recorded", by: "anna", comment: true, fail: true, status: http.StatusInternalServerError},
+		{name: "moderator on a comment the author deleted", by: "anna", comment: true, status: http.StatusGone, deleted: true,
+			before: func(t *testing.T, posts *post.MemoryRepo, commentID string) {
+				if _, err := posts.DeleteComment("p1", commentID, time.Now()); err != nil {
+					t.Fatal(err)
+				}
+			}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer()
+			reports := report.Repo(s.reports)
+			if tt.fail {
+				reports = failingReports{reports}
+			}
+			h, commentID := newPostFixture(t, s, reports)
+			if tt.before != nil {
+				tt.before(t, s.posts, commentID)
+			}
+			token := s.login(t, tt.by)
+
+			var w *httptest.ResponseRecorder
+			if tt.comment {
+				w = s.serve(h.DeleteComment, "DELETE", "/api/post/p1/"+commentID, "", token, "POST_ID", "p1", "COMMENT_ID", commentID)
+			} else {
+				w = s.serve(h.Delete, "DELETE", "/api/post/p1", "", token, "POST_ID", "p1")
+			}
+			if got := w.Result().StatusCode; got != tt.status {
+				t.Errorf("status = %d, want %d", got, tt.status)
+			}
+
+			p, err := s.posts.GetByID("p1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			deleted := p.IsDeleted()
+			if tt.comment {
+				deleted = p.Comments[0].IsDeleted()
+			}
+			if deleted != tt.deleted {
+				t.Errorf("deleted = %v, want %v", deleted, tt.deleted)
+			}
+			if tt.fail {
+				return
+			}
+			id := ""
+			if tt.comment {
+				id = commentID
+			}
+			if removed, _ := s.reports.Removed("p1", id); removed != tt.removed {
+				t.Errorf("removal recorded = %v, want %v", removed, tt.removed)
+			}
+		})
+	}
+}
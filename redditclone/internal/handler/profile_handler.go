@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"redditclone/internal/post"
+	"redditclone/internal/user"
+	"strconv"
+	"time"
+)
+
+type ProfileHandler struct {
+	users user.Repo
+	posts post.Repo
+}
+
+func NewProfileHandler(users user.Repo, posts post.Repo) *ProfileHandler {
+	return &ProfileHandler{users: users, posts: posts}
+}
+
+type profileResponse struct {
+	ID         string    `json:"id"`
+	Username   string    `json:"username"`
+	Registered time.Time `json:"registered,omitzero"`
+	post.Karma
+}
+
+// Profile serves a user's registration date and karma.
+func (h *ProfileHandler) Profile(w http.ResponseWriter, r *http.Request) {
+	u, err := h.users.GetByUsername(r.PathValue("USER_LOGIN"))
+	if errors.Is(err, user.ErrNotFound) {
+		http.Error(w, `{"error": "user not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error": "db error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	karma, err := h.posts.Karma(u.ID)
+	if err != nil {
+		http.Error(w, `{"error": "db error"}`, http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, profileResponse{ID: u.ID, Username: u.Username, Registered: u.Registered, Karma: karma})
+}
+
+// Comments serves a page of a user's live comments, newest first, with the
+// posts they were left on. Pages are ?limit long and continue ?after the
+// next cursor of the previous one.
+func (h *ProfileHandler) Comments(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	q := post.CommentQuery{
+		Author: r.PathValue("USER_LOGIN"),
+		Limit:  post.DefaultPageSize,
+		After:  params.Get("after"),
+	}
+	if v := params.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			http.Error(w, `{"error": "bad limit"}`, http.StatusBadRequest)
+			return
+		}
+		q.Limit = limit
+	}
+
+	page, err := h.posts.ListComments(q)
+	if errors.Is(err, post.ErrBadCursor) {
+		http.Error(w, `{"error": "bad cursor"}`, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error": "db error"}`, http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
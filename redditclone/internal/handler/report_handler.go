@@ -0,0 +1,217 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"redditclone/internal/community"
+	"redditclone/internal/middleware"
+	"redditclone/internal/post"
+	"redditclone/internal/report"
+	"time"
+)
+
+type ReportHandler struct {
+	reports     report.Repo
+	posts       post.Repo
+	communities community.Repo
+}
+
+func NewReportHandler(reports report.Repo, posts post.Repo, communities community.Repo) *ReportHandler {
+	return &ReportHandler{reports: reports, posts: posts, communities: communities}
+}
+
+// queueEntry is a queue item with the reported content.
+type queueEntry struct {
+	*report.Item
+	Post    *post.Post    `json:"post"`
+	Comment *post.Comment `json:"comment,omitempty"`
+}
+
+func (h *ReportHandler) ReportPost(w http.ResponseWriter, r *http.Request) {
+	h.report(w, r, "")
+}
+
+func (h *ReportHandler) ReportComment(w http.ResponseWriter, r *http.Request) {
+	h.report(w, r, r.PathValue("COMMENT_ID"))
+}
+
+// report flags a post, or one of its comments, for the moderators of its
+// category.
+func (h *ReportHandler) report(w http.ResponseWriter, r *http.Request, commentID string) {
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "bad request"}`, http.StatusBadRequest)
+		return
+	}
+
+	user, ok := middleware.GetUser(r.Context())
+	if !ok {
+		http.Error(w, `{"error": "auth error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	p, err := h.posts.GetByID(r.PathValue("POST_ID"))
+	if err != nil {
+		writeRepoError(w, err)
+		return
+	}
+	deleted := p.IsDeleted()
+	if commentID != "" {
+		c := findComment(p, commentID)
+		if c == nil {
+			writeRepoError(w, post.ErrCommentNotFound)
+			return
+		}
+		deleted = deleted || c.IsDeleted()
+	}
+	if deleted {
+		writeRepoError(w, post.ErrDeleted)
+		return
+	}
+
+	rep, err := report.New(p.ID, commentID, p.Category, user.ID, req.Reason, time.Now())
+	if err != nil {
+		writeReportError(w, err)
+		return
+	}
+	rep, err = h.reports.Add(rep)
+	if err != nil {
+		writeReportError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, rep)
+}
+
+// Queue lists the reported posts and comments of a category to its
+// moderators. ?sort=reports (the default) puts the most reported first,
+// ?sort=new the most recently reported.
+func (h *ReportHandler) Queue(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUser(r.Context())
+	if !ok {
+		http.Error(w, `{"error": "auth error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	name := r.PathValue("CATEGORY_NAME")
+	if !h.moderates(w, name, user) {
+		return
+	}
+
+	items, err := h.reports.Queue(name, report.Sort(r.URL.Query().Get("sort")))
+	if err != nil {
+		writeReportError(w, err)
+		return
+	}
+	entries := make([]queueEntry, 0, len(items))
+	for _, item := range items {
+		p, err := h.posts.GetByID(item.PostID)
+		if errors.Is(err, post.ErrNotFound) {
+			// Purged since it was reported.
+			continue
+		}
+		if err != nil {
+			writeRepoError(w, err)
+			return
+		}
+		preparePost(p, post.CommentSortOld)
+		entry := queueEntry{Item: item, Post: p}
+		if item.CommentID != "" {
+			entry.Comment = findComment(p, item.CommentID)
+		}
+		// The reported comment is all moderators need from the thread.
+		p.Comments = []*post.Comment{}
+		entries = append(entries, entry)
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+func (h *ReportHandler) ResolvePost(w http.ResponseWriter, r *http.Request) {
+	h.resolve(w, r, "")
+}
+
+func (h *ReportHandler) ResolveComment(w http.ResponseWriter, r *http.Request) {
+	h.resolve(w, r, r.PathValue("COMMENT_ID"))
+}
+
+// resolve closes the pending reports on a post or comment with the action
+// a moderator of its category chose. Removing deletes the content.
+func (h *ReportHandler) resolve(w http.ResponseWriter, r *http.Request, commentID string) {
+	var req struct {
+		Action report.Action `json:"action"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "bad request"}`, http.StatusBadRequest)
+		return
+	}
+
+	user, ok := middleware.GetUser(r.Context())
+	if !ok {
+		http.Error(w, `{"error": "auth error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	p, err := h.posts.GetByID(r.PathValue("POST_ID"))
+	if err != nil {
+		writeRepoError(w, err)
+		return
+	}
+	if !h.moderates(w, p.Category, user) {
+		return
+	}
+
+	now := time.Now()
+	n, err := h.reports.Resolve(p.ID, commentID, req.Action, user.ID, now)
+	if err != nil {
+		writeReportError(w, err)
+		return
+	}
+	if req.Action == report.Remove {
+		if commentID == "" {
+			err = h.posts.Delete(p.ID, now)
+		} else {
+			_, err = h.posts.DeleteComment(p.ID, commentID, now)
+		}
+		if err != nil && !errors.Is(err, post.ErrDeleted) {
+			writeRepoError(w, err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"resolved": n})
+}
+
+// moderates reports whether the user moderates the community, writing an
+// error response if not.
+func (h *ReportHandler) moderates(w http.ResponseWriter, name string, user middleware.UserClaims) bool {
+	if _, err := h.communities.Get(name); err != nil {
+		writeCommunityError(w, err)
+		return false
+	}
+	if !middleware.Allowed(user, middleware.ActionModerate, middleware.Resource{Category: name}) {
+		http.Error(w, `{"error": "forbidden"}`, http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// writeReportError maps report errors to HTTP responses.
+func writeReportError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, report.ErrNotFound):
+		http.Error(w, `{"error": "no pending reports"}`, http.StatusNotFound)
+	case errors.Is(err, report.ErrNoReason):
+		http.Error(w, `{"error": "reason required"}`, http.StatusBadRequest)
+	case errors.Is(err, report.ErrTooLong):
+		http.Error(w, `{"error": "reason too long"}`, http.StatusBadRequest)
+	case errors.Is(err, report.ErrDuplicate):
+		http.Error(w, `{"error": "already reported"}`, http.StatusConflict)
+	case errors.Is(err, report.ErrBadAction):
+		http.Error(w, `{"error": "action must be approve, remove or ignore"}`, http.StatusBadRequest)
+	case errors.Is(err, report.ErrBadSort):
+		http.Error(w, `{"error": "unknown sort"}`, http.StatusBadRequest)
+	default:
+		http.Error(w, `{"error": "db error"}`, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,182 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"redditclone/internal/post"
+	"redditclone/internal/report"
+)
+
+func TestReportTwice(t *testing.T) {
+	s := newTestServer()
+	_, commentID := newPostFixture(t, s, s.reports)
+	h := NewReportHandler(s.reports, s.posts, s.communities)
+	carol, dave, anna := s.login(t, "carol"), s.login(t, "dave"), s.login(t, "anna")
+	reportPost := func(token string) int {
+		return s.serve(h.ReportPost, "POST", "/api/post/p1/report", `{"reason": "spam"}`, token,
+			"POST_ID", "p1").Result().StatusCode
+	}
+	reportComment := func(token string) int {
+		return s.serve(h.ReportComment, "POST", "/api/post/p1/"+commentID+"/report", `{"reason": "spam"}`, token,
+			"POST_ID", "p1", "COMMENT_ID", commentID).Result().StatusCode
+	}
+
+	steps := []struct {
+		name   string
+		send   func() int
+		status int
+	}{
+		{"first report", func() int { return reportPost(carol) }, http.StatusCreated},
+		{"same reporter again", func() int { return reportPost(carol) }, http.StatusConflict},
+		{"another reporter", func() int { return reportPost(dave) }, http.StatusCreated},
+		{"a comment of the post", func() int { return reportComment(carol) }, http.StatusCreated},
+		{"the comment again", func() int { return reportComment(carol) }, http.StatusConflict},
+		{"approved", func() int {
+			return s.serve(h.ResolvePost, "POST", "/api/post/p1/resolve", `{"action": "approve"}`, anna,
+				"POST_ID", "p1").Result().StatusCode
+		}, http.StatusOK},
+		{"reported again after approval", func() int { return reportPost(carol) }, http.StatusCreated},
+	}
+	for _, step := range steps {
+		if status := step.send(); status != step.status {
+			t.Errorf("%s: status = %d, want %d", step.name, status, step.status)
+		}
+	}
+}
+
+func TestQueueOrder(t *testing.T) {
+	s := newTestServer()
+	newPostFixture(t, s, s.reports)
+	for _, id := range []string{"p2", "p3"} {
+		if _, err := s.posts.Add(&post.Post{ID: id, Title: id, Author: &post.Author{ID: "id-alice", Username: "alice"},
+			Category: "music", Votes: []*post.Vote{}, Comments: []*post.Comment{}, Created: time.Now(), Type: "text"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// p2 has the most reports and p1 the latest.
+	start := time.Now().Add(-time.Hour)
+	for i, postID := range []string{"p2", "p2", "p3", "p2", "p3", "p1"} {
+		rep, err := report.New(postID, "", "music", string(rune('a'+i)), "spam", start.Add(time.Duration(i)*time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = s.reports.Add(rep); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := NewReportHandler(s.reports, s.posts, s.communities)
+
+	tests := []struct {
+		name    string
+		by      string
+		query   string
+		status  int
+		want    []string
+		reports []int
+	}{
+		{name: "default", by: "anna", status: http.StatusOK, want: []string{"p2", "p3", "p1"}, reports: []int{3, 2, 1}},
+		{name: "by reports", by: "anna", query: "?sort=reports", status: http.StatusOK,
+			want: []string{"p2", "p3", "p1"}, reports: []int{3, 2, 1}},
+		{name: "new", by: "anna", query: "?sort=new", status: http.StatusOK,
+			want: []string{"p1", "p3", "p2"}, reports: []int{1, 2, 3}},
+		{name: "unknown sort", by: "anna", query: "?sort=top", status: http.StatusBadRequest},
+		{name: "not a moderator", by: "bob", status: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := s.serve(h.Queue, "GET", "/api/category/music/queue"+tt.query, "", s.login(t, tt.by),
+				"CATEGORY_NAME", "music")
+			if got := w.Result().StatusCode; got != tt.status {
+				t.Fatalf("status = %d, want %d", got, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var entries []struct {
+				PostID  string `json:"post_id"`
+				Reports int    `json:"reports"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&entries); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			var reports []int
+			for _, e := range entries {
+				got = append(got, e.PostID)
+				reports = append(reports, e.Reports)
+			}
+			if !slices.Equal(got, tt.want) || !slices.Equal(reports, tt.reports) {
+				t.Errorf("queue = %v with %v reports, want %v with %v", got, reports, tt.want, tt.reports)
+			}
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		name    string
+		by      string
+		comment bool
+		action  string
+		status  int
+		deleted bool
+		restore int // status of the author restoring the content after deleting it
+	}{
+		{name: "remove a post", by: "anna", action: "remove", status: http.StatusOK, deleted: true,
+			restore: http.StatusForbidden},
+		{name: "remove a comment", by: "anna", comment: true, action: "remove", status: http.StatusOK, deleted: true,
+			restore: http.StatusForbidden},
+		{name: "approve a post", by: "anna", action: "approve", status: http.StatusOK, restore: http.StatusOK},
+		{name: "ignore a comment", by: "anna", comment: true, action: "ignore", status: http.StatusOK,
+			restore: http.StatusOK},
+		{name: "unknown action", by: "anna", action: "ban", status: http.StatusBadRequest, restore: http.StatusOK},
+		{name: "not a moderator", by: "carol", action: "remove", status: http.StatusForbidden, restore: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer()
+			ph, commentID := newPostFixture(t, s, s.reports)
+			h := NewReportHandler(s.reports, s.posts, s.communities)
+			target, path, author := "/api/post/p1", []string{"POST_ID", "p1"}, "alice"
+			flag, resolve, del, restore := h.ReportPost, h.ResolvePost, ph.Delete, ph.Restore
+			if tt.comment {
+				target, path, author = target+"/"+commentID, append(path, "COMMENT_ID", commentID), "bob"
+				flag, resolve, del, restore = h.ReportComment, h.ResolveComment, ph.DeleteComment, ph.RestoreComment
+			}
+			if status := s.serve(flag, "POST", target+"/report", `{"reason": "spam"}`, s.login(t, "dave"),
+				path...).Result().StatusCode; status != http.StatusCreated {
+				t.Fatalf("report status = %d, want 201", status)
+			}
+
+			w := s.serve(resolve, "POST", target+"/resolve", `{"action": "`+tt.action+`"}`, s.login(t, tt.by), path...)
+			if got := w.Result().StatusCode; got != tt.status {
+				t.Fatalf("status = %d, want %d", got, tt.status)
+			}
+			p, err := s.posts.GetByID("p1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			deleted := p.IsDeleted()
+			if tt.comment {
+				deleted = p.Comments[0].IsDeleted()
+			}
+			if deleted != tt.deleted {
+				t.Errorf("deleted = %v, want %v", deleted, tt.deleted)
+			}
+
+			token := s.login(t, author)
+			if !deleted {
+				if status := s.serve(del, "DELETE", target, "", token, path...).Result().StatusCode; status != http.StatusOK {
+					t.Fatalf("author's delete status = %d, want 200", status)
+				}
+			}
+			if status := s.serve(restore, "POST", target+"/restore", "", token, path...).Result().StatusCode; status != tt.restore {
+				t.Errorf("author's restore status = %d, want %d", status, tt.restore)
+			}
+		})
+	}
+}
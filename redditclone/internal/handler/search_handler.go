@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"redditclone/internal/middleware"
+	"redditclone/internal/post"
+	"redditclone/internal/search"
+	"strconv"
+)
+
+type SearchHandler struct {
+	index *search.Index
+	repo  post.Repo
+}
+
+func NewSearchHandler(index *search.Index, repo post.Repo) *SearchHandler {
+	return &SearchHandler{index: index, repo: repo}
+}
+
+// Search serves GET /api/search?q=...&category=...&author=...&sort=relevance|new
+// as a page of posts with a next cursor. Signed-in users do not see the posts
+// they hid.
+func (h *SearchHandler) Search(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	q := search.Query{
+		Text:     params.Get("q"),
+		Category: params.Get("category"),
+		Author:   params.Get("author"),
+		Sort:     search.Sort(params.Get("sort")),
+		Limit:    post.DefaultPageSize,
+		After:    params.Get("after"),
+	}
+	if v := params.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			http.Error(w, `{"error": "bad limit"}`, http.StatusBadRequest)
+			return
+		}
+		q.Limit = min(limit, post.MaxPageSize)
+	}
+	if user, ok := middleware.GetUser(r.Context()); ok {
+		hidden, err := h.repo.Hidden(user.ID)
+		if err != nil {
+			http.Error(w, `{"error": "db error"}`, http.StatusInternalServerError)
+			return
+		}
+		q.Skip = make(map[string]bool, len(hidden))
+		for _, id := range hidden {
+			q.Skip[id] = true
+		}
+	}
+
+	res, err := h.index.Search(q)
+	switch {
+	case errors.Is(err, search.ErrEmptyQuery):
+		http.Error(w, `{"error": "empty query"}`, http.StatusBadRequest)
+		return
+	case errors.Is(err, search.ErrBadSort):
+		http.Error(w, `{"error": "bad sort"}`, http.StatusBadRequest)
+		return
+	case errors.Is(err, search.ErrBadCursor):
+		http.Error(w, `{"error": "bad cursor"}`, http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, `{"error": "search error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	page := &post.Page{Posts: make([]*post.Post, 0, len(res.IDs)), Next: res.Next}
+	for _, id := range res.IDs {
+		p, err := h.repo.GetByID(id)
+		if errors.Is(err, post.ErrNotFound) {
+			// Deleted between the search and the lookup.
+			continue
+		}
+		if err != nil {
+			http.Error(w, `{"error": "db error"}`, http.StatusInternalServerError)
+			return
+		}
+		page.Posts = append(page.Posts, p)
+	}
+	preparePosts(page.Posts)
+	writeJSON(w, http.StatusOK, page)
+}
@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+
+	"redditclone/internal/post"
+	"redditclone/internal/search"
+)
+
+func TestSearchSkipsHiddenPosts(t *testing.T) {
+	s := newTestServer()
+	ix := search.NewIndex()
+	repo := search.Indexed(s.posts, ix)
+	for i, id := range []string{"p1", "p2", "p3"} {
+		if _, err := repo.Add(&post.Post{ID: id, Title: "Guitar " + id, Author: &post.Author{ID: "id-carol", Username: "carol"},
+			Category: "music", Votes: []*post.Vote{}, Comments: []*post.Comment{},
+			Created: time.Now().Add(time.Duration(i) * time.Minute), Type: "text"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.posts.Hide("p2", s.user(t, "alice").ID); err != nil {
+		t.Fatal(err)
+	}
+	h := NewSearchHandler(ix, repo)
+
+	// searchAll pages through the results of guitar one post at a time.
+	searchAll := func(t *testing.T, token string) []string {
+		t.Helper()
+		var ids []string
+		after := ""
+		for pages := 0; pages < 5; pages++ {
+			target := "/api/search?q=guitar&sort=new&limit=1&after=" + url.QueryEscape(after)
+			var w *httptest.ResponseRecorder
+			if token == "" {
+				w = httptest.NewRecorder()
+				h.Search(w, httptest.NewRequest("GET", target, nil))
+			} else {
+				w = s.serve(h.Search, "GET", target, "", token)
+			}
+			if w.Result().StatusCode != http.StatusOK {
+				t.Fatalf("status = %d, want 200", w.Result().StatusCode)
+			}
+			var page post.Page
+			if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+				t.Fatal(err)
+			}
+			if len(page.Posts) != 1 {
+				t.Errorf("page %d has %d posts, want 1", pages, len(page.Posts))
+			}
+			for _, p := range page.Posts {
+				ids = append(ids, p.ID)
+			}
+			if page.Next == "" {
+				break
+			}
+			after = page.Next
+		}
+		return ids
+	}
+
+	tests := []struct {
+		name  string
+		token func() string
+		want  []string
+	}{
+		{"user who hid a post", func() string { return s.login(t, "alice") }, []string{"p3", "p1"}},
+		{"another user", func() string { return s.login(t, "bob") }, []string{"p3", "p2", "p1"}},
+		{"anonymous", func() string { return "" }, []string{"p3", "p2", "p1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := searchAll(t, tt.token()); !slices.Equal(got, tt.want) {
+				t.Errorf("results = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
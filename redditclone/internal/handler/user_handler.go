@@ -2,9 +2,15 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"time"
 
+	"redditclone/internal/community"
+	"redditclone/internal/middleware"
+	"redditclone/internal/post"
+	"redditclone/internal/session"
+	"redditclone/internal/token"
 	"redditclone/internal/user"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -12,40 +18,74 @@ import (
 
 type UserHandler struct {
 	repo user.Repo
+	// communities tell which categories a user moderates.
+	communities community.Repo
+	// sessions hold the refresh token of every login.
+	sessions session.Repo
+	// tokens sign the access tokens.
+	tokens *token.Service
+	// refreshTTL is how long a session lasts without being refreshed.
+	refreshTTL time.Duration
 }
 
-func NewUserHandler(repo user.Repo) *UserHandler {
-	return &UserHandler{repo: repo}
+func NewUserHandler(repo user.Repo, communities community.Repo, sessions session.Repo, tokens *token.Service, refreshTTL time.Duration) *UserHandler {
+	return &UserHandler{repo: repo, communities: communities, sessions: sessions, tokens: tokens, refreshTTL: refreshTTL}
 }
 
-var jwtSecret = []byte("supersecretkey") // In production, use env/config
-
+// jwtResponse carries the short-lived access token, still called token as
+// the bundled frontend expects, and the refresh token that renews it.
 type jwtResponse struct {
-	Token string `json:"token"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"` // seconds until the access token expires
+}
+
+// sessionView is a session as its owner sees it.
+type sessionView struct {
+	ID       string    `json:"id"`
+	Device   string    `json:"device"`
+	IP       string    `json:"ip"`
+	Created  time.Time `json:"created"`
+	LastUsed time.Time `json:"last_used"`
+	Expires  time.Time `json:"expires"`
+	// Current marks the session of the request's own token.
+	Current bool `json:"current"`
 }
 
+// Register creates a user. The optional email is where password resets are
+// sent.
 func (h *UserHandler) Register(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		Username string `json:"username"`
 		Password string `json:"password"`
+		Email    string `json:"email"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, `{"message": "invalid request"}`, http.StatusBadRequest)
 		return
 	}
-	u, err := h.repo.Register(req.Username, req.Password)
-	if err != nil {
+	// Content of deleted accounts is credited to this name.
+	if req.Username == post.DeletedPlaceholder {
 		http.Error(w, `{"message": "user exists"}`, http.StatusConflict)
 		return
 	}
-	token, err := generateJWT(u)
+	email, err := user.NormalizeEmail(req.Email)
 	if err != nil {
-		http.Error(w, `{"message": "could not create token"}`, http.StatusInternalServerError)
+		http.Error(w, `{"message": "invalid email"}`, http.StatusBadRequest)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	_ = json.NewEncoder(w).Encode(jwtResponse{Token: token})
+	u, err := h.repo.Register(req.Username, req.Password)
+	if err != nil {
+		http.Error(w, `{"message": "user exists"}`, http.StatusConflict)
+		return
+	}
+	if email != "" {
+		if u, err = h.repo.SetEmail(u.Username, email); err != nil {
+			http.Error(w, `{"message": "db error"}`, http.StatusInternalServerError)
+			return
+		}
+	}
+	h.startSession(w, r, u, http.StatusCreated)
 }
 
 func (h *UserHandler) Login(w http.ResponseWriter, r *http.Request) {
@@ -62,24 +102,225 @@ func (h *UserHandler) Login(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, `{"message": "invalid credentials"}`, http.StatusUnauthorized)
 		return
 	}
-	token, err := generateJWT(u)
+	h.startSession(w, r, u, http.StatusOK)
+}
+
+// Refresh trades a refresh token for a new access token and a new refresh
+// token; the old one stops working. Presenting a refresh token that was
+// already traded means two parties hold the session, so it is revoked.
+func (h *UserHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"message": "invalid request"}`, http.StatusBadRequest)
+		return
+	}
+	id, hash, err := session.ParseToken(req.RefreshToken)
+	if err != nil {
+		http.Error(w, `{"message": "invalid refresh token"}`, http.StatusUnauthorized)
+		return
+	}
+	token, newHash, err := session.NewToken(id)
+	if err != nil {
+		http.Error(w, `{"message": "could not create token"}`, http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	s, err := h.sessions.Rotate(id, hash, newHash, now, now.Add(h.refreshTTL))
+	switch {
+	case errors.Is(err, session.ErrReused):
+		if err = h.sessions.Revoke(id, now); err != nil {
+			http.Error(w, `{"message": "db error"}`, http.StatusInternalServerError)
+			return
+		}
+		http.Error(w, `{"message": "refresh token reused, session revoked"}`, http.StatusUnauthorized)
+		return
+	case errors.Is(err, session.ErrNotFound), errors.Is(err, session.ErrRevoked):
+		http.Error(w, `{"message": "invalid refresh token"}`, http.StatusUnauthorized)
+		return
+	case err != nil:
+		http.Error(w, `{"message": "db error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	// Roles may have changed since the last token; they are read afresh.
+	u, err := h.repo.GetByUsername(s.Username)
+	if errors.Is(err, user.ErrNotFound) {
+		http.Error(w, `{"message": "invalid refresh token"}`, http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"message": "db error"}`, http.StatusInternalServerError)
+		return
+	}
+	h.writeTokens(w, http.StatusOK, u, s.ID, token)
+}
+
+// Logout ends the session of a refresh token. The access tokens issued for
+// it stop working at once too.
+func (h *UserHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"message": "invalid request"}`, http.StatusBadRequest)
+		return
+	}
+	id, hash, err := session.ParseToken(req.RefreshToken)
+	if err != nil {
+		http.Error(w, `{"message": "invalid refresh token"}`, http.StatusUnauthorized)
+		return
+	}
+	// Session IDs are no secret, so only the current token may end one.
+	s, err := h.sessions.Get(id)
+	if errors.Is(err, session.ErrNotFound) || err == nil && !s.Matches(hash) {
+		http.Error(w, `{"message": "invalid refresh token"}`, http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"message": "db error"}`, http.StatusInternalServerError)
+		return
+	}
+	if err = h.sessions.Revoke(id, time.Now()); err != nil {
+		http.Error(w, `{"message": "db error"}`, http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "success"})
+}
+
+// Sessions lists the user's live sessions, most recently used first.
+func (h *UserHandler) Sessions(w http.ResponseWriter, r *http.Request) {
+	caller, ok := middleware.GetUser(r.Context())
+	if !ok {
+		http.Error(w, `{"error": "auth error"}`, http.StatusInternalServerError)
+		return
+	}
+	sessions, err := h.sessions.List(caller.ID, time.Now())
+	if err != nil {
+		http.Error(w, `{"error": "db error"}`, http.StatusInternalServerError)
+		return
+	}
+	views := make([]sessionView, 0, len(sessions))
+	for _, s := range sessions {
+		views = append(views, sessionView{
+			ID:       s.ID,
+			Device:   s.Device,
+			IP:       s.IP,
+			Created:  s.Created,
+			LastUsed: s.LastUsed,
+			Expires:  s.Expires,
+			Current:  s.ID == caller.SessionID,
+		})
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+// RevokeSession ends one of the user's sessions, such as that of a lost
+// device.
+func (h *UserHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	caller, ok := middleware.GetUser(r.Context())
+	if !ok {
+		http.Error(w, `{"error": "auth error"}`, http.StatusInternalServerError)
+		return
+	}
+	s, err := h.sessions.Get(r.PathValue("SESSION_ID"))
+	if errors.Is(err, session.ErrNotFound) || err == nil && s.UserID != caller.ID {
+		http.Error(w, `{"error": "session not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error": "db error"}`, http.StatusInternalServerError)
+		return
+	}
+	if err = h.sessions.Revoke(s.ID, time.Now()); err != nil {
+		http.Error(w, `{"error": "db error"}`, http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "success"})
+}
+
+// SetAdmin grants or revokes the admin role of a user; only admins may. The
+// change is in the user's token from their next login or refresh.
+func (h *UserHandler) SetAdmin(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Admin bool `json:"admin"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "bad request"}`, http.StatusBadRequest)
+		return
+	}
+
+	u, err := h.repo.SetAdmin(r.PathValue("USER_LOGIN"), req.Admin)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"username": u.Username, "admin": u.Admin})
+}
+
+// startSession opens a session of the user on the requesting device and
+// writes its first tokens.
+func (h *UserHandler) startSession(w http.ResponseWriter, r *http.Request, u *user.User, status int) {
+	s, refreshToken, err := session.New(u.ID, u.Username, r.UserAgent(), middleware.ClientIP(r), time.Now(), h.refreshTTL)
 	if err != nil {
 		http.Error(w, `{"message": "could not create token"}`, http.StatusInternalServerError)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	_ = json.NewEncoder(w).Encode(jwtResponse{Token: token})
+	if _, err = h.sessions.Create(s); err != nil {
+		http.Error(w, `{"message": "db error"}`, http.StatusInternalServerError)
+		return
+	}
+	h.writeTokens(w, status, u, s.ID, refreshToken)
 }
 
-func generateJWT(u *user.User) (string, error) {
-	claims := jwt.MapClaims{
-		"user": map[string]string{
-			"id":       u.ID,
-			"username": u.Username,
+// writeTokens issues an access token for the session and writes it with
+// the session's refresh token.
+func (h *UserHandler) writeTokens(w http.ResponseWriter, status int, u *user.User, sessionID, refreshToken string) {
+	token, err := h.generateJWT(u, sessionID)
+	if err != nil {
+		http.Error(w, `{"message": "could not create token"}`, http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, status, jwtResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(h.tokens.TTL() / time.Second),
+	})
+}
+
+// generateJWT issues an access token of the session carrying the user's
+// roles.
+func (h *UserHandler) generateJWT(u *user.User, sessionID string) (string, error) {
+	moderates, err := h.communities.Moderated(u.ID)
+	if err != nil {
+		return "", err
+	}
+	return h.tokens.Sign(jwt.MapClaims{
+		"user": map[string]any{
+			"id":        u.ID,
+			"username":  u.Username,
+			"admin":     u.Admin,
+			"moderates": moderates,
 		},
-		"exp": time.Now().Add(24 * time.Hour).Unix(),
+		"sub": u.ID,
+		"sid": sessionID,
+	})
+}
+
+// JWKS publishes the public keys access tokens are signed with, so other
+// services can verify them.
+func (h *UserHandler) JWKS(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.tokens.JWKS())
+}
+
+// writeUserError maps user errors to HTTP responses.
+func writeUserError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, user.ErrNotFound):
+		http.Error(w, `{"error": "user not found"}`, http.StatusNotFound)
+	default:
+		http.Error(w, `{"error": "db error"}`, http.StatusInternalServerError)
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
 }
@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"redditclone/internal/session"
+	"redditclone/internal/token"
+)
+
+// newUserFixture returns a handler over a registered alice with one session,
+// its ID and its refresh token.
+func newUserFixture(t *testing.T) (*UserHandler, *session.MemoryRepo, string, string) {
+	t.Helper()
+	ts := newTestServer()
+	u := ts.user(t, "alice")
+	s, refreshToken, err := session.New(u.ID, u.Username, "test", "127.0.0.1", time.Now(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ts.sessions.Create(s); err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := token.New(token.Config{
+		SigningKey: "k1",
+		Keys:       []token.KeyConfig{{ID: "k1", Algorithm: token.HS256, Secret: strings.Repeat("s", token.MinSecretLength)}},
+		TTL:        time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewUserHandler(ts.users, ts.communities, ts.sessions, tokens, time.Hour), ts.sessions, s.ID, refreshToken
+}
+
+// postRefreshToken sends a refresh token to h as /api/refresh and
+// /api/logout take it.
+func postRefreshToken(h http.HandlerFunc, refreshToken string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(map[string]string{"refresh_token": refreshToken})
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest("POST", "/", strings.NewReader(string(body))))
+	return w
+}
+
+func TestLogout(t *testing.T) {
+	tests := []struct {
+		name    string
+		token   func(sessionID, refreshToken string) string
+		status  int
+		revoked bool
+	}{
+		{name: "own token", token: func(_, refreshToken string) string { return refreshToken },
+			status: http.StatusOK, revoked: true},
+		{name: "forged secret", token: func(sessionID, _ string) string { return sessionID + ".forged" },
+			status: http.StatusUnauthorized},
+		{name: "token of another session", token: func(sessionID, refreshToken string) string {
+			_, secret, _ := strings.Cut(refreshToken, ".")
+			return "other." + secret
+		}, status: http.StatusUnauthorized},
+		{name: "malformed", token: func(sessionID, _ string) string { return sessionID },
+			status: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, sessions, id, refreshToken := newUserFixture(t)
+			w := postRefreshToken(h.Logout, tt.token(id, refreshToken))
+			if got := w.Result().StatusCode; got != tt.status {
+				t.Errorf("status = %d, want %d", got, tt.status)
+			}
+			s, err := sessions.Get(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if revoked := !s.Revoked.IsZero(); revoked != tt.revoked {
+				t.Errorf("revoked = %v, want %v", revoked, tt.revoked)
+			}
+		})
+	}
+}
+
+func TestRefreshRevokesOnReuse(t *testing.T) {
+	h, sessions, id, first := newUserFixture(t)
+
+	w := postRefreshToken(h.Refresh, first)
+	if w.Result().StatusCode != http.StatusOK {
+		t.Fatalf("first refresh status = %d, want 200", w.Result().StatusCode)
+	}
+	var resp jwtResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	second := resp.RefreshToken
+	if second == "" || second == first || resp.Token == "" {
+		t.Fatalf("refresh answered %+v, want new tokens", resp)
+	}
+
+	// The first token was rotated away, so someone else holds a copy: the
+	// session ends, and the second token stops working too.
+	if w = postRefreshToken(h.Refresh, first); w.Result().StatusCode != http.StatusUnauthorized {
+		t.Errorf("reused token status = %d, want 401", w.Result().StatusCode)
+	}
+	s, err := sessions.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Revoked.IsZero() {
+		t.Error("session still live after its token was reused")
+	}
+	if w = postRefreshToken(h.Refresh, second); w.Result().StatusCode != http.StatusUnauthorized {
+		t.Errorf("refresh after reuse status = %d, want 401", w.Result().StatusCode)
+	}
+}
@@ -0,0 +1,85 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a plain-text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers email.
+type Mailer interface {
+	Send(ctx context.Context, m Message) error
+}
+
+var errHeader = errors.New("line break in mail header")
+
+// format renders m as an RFC 5322 message from the address from.
+func format(from string, m Message) ([]byte, error) {
+	for _, v := range []string{from, m.To, m.Subject} {
+		if strings.ContainsAny(v, "\r\n") {
+			return nil, errHeader
+		}
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", m.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	body := strings.ReplaceAll(m.Body, "\r\n", "\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	if !strings.HasSuffix(body, "\n") {
+		b.WriteString("\r\n")
+	}
+	return b.Bytes(), nil
+}
+
+// File appends every message to a file instead of sending it, or writes it
+// to the log when Path is empty. It is meant for local development.
+type File struct {
+	Path string
+	From string
+
+	mu sync.Mutex
+}
+
+func NewFile(path, from string) *File {
+	return &File{Path: path, From: from}
+}
+
+func (f *File) Send(ctx context.Context, m Message) error {
+	data, err := format(f.From, m)
+	if err != nil {
+		return err
+	}
+	if f.Path == "" {
+		log.Printf("mail: to %s:\n%s", m.To, data)
+		return nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	out, err := os.OpenFile(f.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "%s\r\n", data)
+	return errors.Join(err, out.Close())
+}
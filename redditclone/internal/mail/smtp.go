@@ -0,0 +1,77 @@
+package mail
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/smtp"
+)
+
+// SMTP sends mail through an SMTP server. It upgrades the connection with
+// STARTTLS when the server offers it and authenticates with PLAIN when
+// Username is set, which net/smtp only allows over TLS or to localhost.
+type SMTP struct {
+	Addr     string // host:port
+	From     string
+	Username string
+	Password string
+}
+
+func NewSMTP(addr, from, username, password string) *SMTP {
+	return &SMTP{Addr: addr, From: from, Username: username, Password: password}
+}
+
+func (s *SMTP) Send(ctx context.Context, m Message) error {
+	data, err := format(s.From, m)
+	if err != nil {
+		return err
+	}
+	host, _, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return err
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err = c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if s.Username != "" {
+		if err = c.Auth(smtp.PlainAuth("", s.Username, s.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err = c.Mail(s.From); err != nil {
+		return err
+	}
+	if err = c.Rcpt(m.To); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(data); err != nil {
+		return errors.Join(err, w.Close())
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -2,73 +2,219 @@ package middleware
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
+	"redditclone/internal/ban"
+	"redditclone/internal/session"
 	"strings"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var jwtSecret = []byte("supersecretkey") // In production, use env/config
-
 type contextKey string
 
 const userContextKey = contextKey("user")
 
+// UserClaims is the user a token was issued to, with the roles the user
+// had at the time.
 type UserClaims struct {
 	ID       string `json:"id"`
 	Username string `json:"username"`
+	// Admin is set for site-wide admins.
+	Admin bool `json:"admin,omitempty"`
+	// Moderates lists the categories the user moderates.
+	Moderates []string `json:"moderates,omitempty"`
+	// BannedFrom lists the categories the user is banned from. It is looked
+	// up on every request rather than carried in the token.
+	BannedFrom []string `json:"-"`
+	// SessionID is the session the token was issued for.
+	SessionID string `json:"-"`
 }
 
-// Auth is a middleware to protect routes that require authentication.
-func Auth(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if !strings.HasPrefix(authHeader, "Bearer ") {
-			http.Error(w, `{"message": "missing token"}`, http.StatusUnauthorized)
-			return
-		}
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+// Bans looks up the bans in force against a user; ban.Repo satisfies it.
+type Bans interface {
+	Active(userID string, now time.Time) ([]*ban.Ban, error)
+}
 
-		token, err := jwt.Parse(tokenString, func(_ *jwt.Token) (interface{}, error) {
-			return jwtSecret, nil
-		})
+// Tokens verifies access tokens; *token.Service satisfies it.
+type Tokens interface {
+	Parse(tokenString string) (jwt.MapClaims, error)
+}
 
-		if err != nil || !token.Valid {
-			http.Error(w, `{"message": "invalid token"}`, http.StatusUnauthorized)
-			return
-		}
+// Sessions looks up the session a token was issued for; session.Repo
+// satisfies it.
+type Sessions interface {
+	Get(id string) (*session.Session, error)
+}
 
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			http.Error(w, `{"message": "invalid token claims"}`, http.StatusUnauthorized)
-			return
-		}
+// Auth returns a middleware to protect routes that require authentication.
+// A token only proves who the user is: its session and the bans against the
+// user are checked on every request, so logging out and bans take effect at
+// once instead of when the token expires.
+func Auth(tokens Tokens, bans Bans, sessions Sessions) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authHeader := r.Header.Get("Authorization")
+			if !strings.HasPrefix(authHeader, "Bearer ") {
+				http.Error(w, `{"message": "missing token"}`, http.StatusUnauthorized)
+				return
+			}
+			user, err := parseToken(tokens, strings.TrimPrefix(authHeader, "Bearer "))
+			if err != nil {
+				http.Error(w, `{"message": "`+err.Error()+`"}`, http.StatusUnauthorized)
+				return
+			}
+			revoked, err := sessionRevoked(sessions, user)
+			if err != nil {
+				log.Printf("auth: look up session %s: %v", user.SessionID, err)
+				http.Error(w, `{"message": "db error"}`, http.StatusInternalServerError)
+				return
+			}
+			if revoked {
+				http.Error(w, `{"message": "session revoked"}`, http.StatusUnauthorized)
+				return
+			}
+			siteBan, err := checkBans(bans, &user)
+			if err != nil {
+				log.Printf("auth: look up bans of %s: %v", user.ID, err)
+				http.Error(w, `{"message": "db error"}`, http.StatusInternalServerError)
+				return
+			}
+			if siteBan != nil {
+				writeBanned(w, siteBan)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), userContextKey, user)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
 
-		userMap, ok := claims["user"].(map[string]interface{})
-		if !ok {
-			panic("invalid user data in token")
-		}
+// OptionalAuth returns a middleware that puts the user in the context like
+// Auth when a valid token is sent, and otherwise serves the request
+// anonymously. The bundled frontend sends whatever token it has stored, so
+// an expired token, or one of a banned user, must not break public pages.
+func OptionalAuth(tokens Tokens, bans Bans, sessions Sessions) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authHeader := r.Header.Get("Authorization")
+			if !strings.HasPrefix(authHeader, "Bearer ") {
+				next.ServeHTTP(w, r)
+				return
+			}
+			user, err := parseToken(tokens, strings.TrimPrefix(authHeader, "Bearer "))
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if revoked, err := sessionRevoked(sessions, user); err != nil || revoked {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if siteBan, err := checkBans(bans, &user); err != nil || siteBan != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userContextKey, user)))
+		})
+	}
+}
 
-		id, ok := userMap["id"].(string)
-		if !ok {
-			http.Error(w, `{"message": "invalid user id in token"}`, http.StatusUnauthorized)
-			return
-		}
+// sessionRevoked reports whether the session the token was issued for has
+// ended. Tokens outlive their session by at most their own short lifetime,
+// so only revocation matters, not the session's expiry.
+func sessionRevoked(sessions Sessions, user UserClaims) (bool, error) {
+	s, err := sessions.Get(user.SessionID)
+	if errors.Is(err, session.ErrNotFound) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return !s.Revoked.IsZero(), nil
+}
 
-		username, ok := userMap["username"].(string)
-		if !ok {
-			http.Error(w, `{"message": "invalid username in token"}`, http.StatusUnauthorized)
-			return
+// checkBans fills in the categories the user is banned from and returns the
+// site-wide ban in force against the user, if any.
+func checkBans(bans Bans, user *UserClaims) (*ban.Ban, error) {
+	active, err := bans.Active(user.ID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range active {
+		if b.Category == "" {
+			return b, nil
 		}
+		user.BannedFrom = append(user.BannedFrom, b.Category)
+	}
+	return nil, nil
+}
 
-		user := UserClaims{
-			ID:       id,
-			Username: username,
-		}
+// writeBanned tells a banned user why, and until when for a suspension.
+func writeBanned(w http.ResponseWriter, b *ban.Ban) {
+	resp := struct {
+		Message string    `json:"message"`
+		Reason  string    `json:"reason"`
+		Expires time.Time `json:"expires,omitzero"`
+	}{Message: "account banned", Reason: b.Reason, Expires: b.Expires}
+	if !b.Expires.IsZero() {
+		resp.Message = "account suspended"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	_ = json.NewEncoder(w).Encode(resp)
+}
 
-		ctx := context.WithValue(r.Context(), userContextKey, user)
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+// parseToken returns the user a token was issued to.
+func parseToken(tokens Tokens, tokenString string) (UserClaims, error) {
+	claims, err := tokens.Parse(tokenString)
+	if err != nil {
+		return UserClaims{}, errors.New("invalid token")
+	}
+
+	userMap, ok := claims["user"].(map[string]interface{})
+	if !ok {
+		return UserClaims{}, errors.New("invalid user data in token")
+	}
+
+	id, ok := userMap["id"].(string)
+	if !ok {
+		return UserClaims{}, errors.New("invalid user id in token")
+	}
+
+	username, ok := userMap["username"].(string)
+	if !ok {
+		return UserClaims{}, errors.New("invalid username in token")
+	}
+
+	admin, _ := userMap["admin"].(bool)
+	var moderates []string
+	if list, ok := userMap["moderates"].([]interface{}); ok {
+		for _, v := range list {
+			name, ok := v.(string)
+			if !ok {
+				return UserClaims{}, errors.New("invalid roles in token")
+			}
+			moderates = append(moderates, name)
+		}
+	}
+
+	sessionID, ok := claims["sid"].(string)
+	if !ok || sessionID == "" {
+		return UserClaims{}, errors.New("invalid session in token")
+	}
+
+	return UserClaims{
+		ID:        id,
+		Username:  username,
+		Admin:     admin,
+		Moderates: moderates,
+		SessionID: sessionID,
+	}, nil
 }
 
 // GetUser returns the user from the context.
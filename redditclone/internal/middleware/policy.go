@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+)
+
+// Action is something a user may be allowed to do to a resource.
+type Action string
+
+const (
+	ActionPost          Action = "post"           // submit a post to a category
+	ActionComment       Action = "comment"        // comment on a post
+	ActionVote          Action = "vote"           // vote on a post or comment
+	ActionEdit          Action = "edit"           // edit a post or comment
+	ActionRestore       Action = "restore"        // restore a deleted post or comment
+	ActionDeletePost    Action = "delete_post"    // delete a post
+	ActionDeleteComment Action = "delete_comment" // delete a comment
+	ActionModerate      Action = "moderate"       // see the moderation queue and resolve reports
+	ActionAddModerator  Action = "add_moderator"  // appoint a moderator of a category
+	ActionAdminister    Action = "administer"     // site-wide changes such as granting roles
+)
+
+// Resource is what a policy decision is about. AuthorID is the author of
+// the post or comment and PostAuthorID the author of the post a comment
+// was made on; both are empty for decisions about a whole category.
+type Resource struct {
+	Category     string
+	AuthorID     string
+	PostAuthorID string
+}
+
+// IsAdmin reports whether the user is a site-wide admin.
+func (u UserClaims) IsAdmin() bool {
+	return u.Admin
+}
+
+// CanModerate reports whether the user moderates the category. Admins
+// moderate every category.
+func (u UserClaims) CanModerate(category string) bool {
+	return u.Admin || slices.Contains(u.Moderates, category)
+}
+
+// IsBannedFrom reports whether the user is banned from the category.
+func (u UserClaims) IsBannedFrom(category string) bool {
+	return slices.Contains(u.BannedFrom, category)
+}
+
+// Allowed is the access policy: it reports whether the user may perform the
+// action on the resource.
+//
+// Anyone may post, comment and vote outside the categories they are banned
+// from, and authors edit and restore their own content there. Posts may be
+// deleted by their author and comments by their author or the author of the
+// post; moderators delete anything in their categories and admins anything
+// at all.
+func Allowed(u UserClaims, action Action, res Resource) bool {
+	isAuthor := res.AuthorID != "" && u.ID == res.AuthorID
+	switch action {
+	case ActionPost, ActionComment, ActionVote:
+		return !u.IsBannedFrom(res.Category)
+	case ActionEdit, ActionRestore:
+		return isAuthor && !u.IsBannedFrom(res.Category)
+	case ActionDeletePost:
+		return isAuthor || u.CanModerate(res.Category)
+	case ActionDeleteComment:
+		isPostAuthor := res.PostAuthorID != "" && u.ID == res.PostAuthorID
+		return isAuthor || isPostAuthor || u.CanModerate(res.Category)
+	case ActionModerate, ActionAddModerator:
+		return u.CanModerate(res.Category)
+	case ActionAdminister:
+		return u.IsAdmin()
+	}
+	return false
+}
+
+// RequireAdmin lets only admins through; it must run after Auth.
+func RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := GetUser(r.Context())
+		if !ok || !Allowed(user, ActionAdminister, Resource{}) {
+			http.Error(w, `{"error": "forbidden"}`, http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
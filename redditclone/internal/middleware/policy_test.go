@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestAllowed(t *testing.T) {
+	// A comment by the author on a post by the post author in music.
+	res := Resource{Category: "music", AuthorID: "u-author", PostAuthorID: "u-op"}
+	users := map[string]UserClaims{
+		"author":           {ID: "u-author"},
+		"post author":      {ID: "u-op"},
+		"stranger":         {ID: "u-stranger"},
+		"moderator":        {ID: "u-mod", Moderates: []string{"music"}},
+		"other moderator":  {ID: "u-mod2", Moderates: []string{"news"}},
+		"admin":            {ID: "u-admin", Admin: true},
+		"banned author":    {ID: "u-author", BannedFrom: []string{"music"}},
+		"banned elsewhere": {ID: "u-stranger", BannedFrom: []string{"news"}},
+	}
+	everyone := []string{"author", "post author", "stranger", "moderator", "other moderator", "admin", "banned elsewhere"}
+
+	tests := []struct {
+		action  Action
+		allowed []string
+	}{
+		{ActionPost, everyone},
+		{ActionComment, everyone},
+		{ActionVote, everyone},
+		{ActionEdit, []string{"author"}},
+		{ActionRestore, []string{"author"}},
+		{ActionDeletePost, []string{"author", "moderator", "admin", "banned author"}},
+		{ActionDeleteComment, []string{"author", "post author", "moderator", "admin", "banned author"}},
+		{ActionModerate, []string{"moderator", "admin"}},
+		{ActionAddModerator, []string{"moderator", "admin"}},
+		{ActionAdminister, []string{"admin"}},
+		{"unknown", nil},
+	}
+	for _, tt := range tests {
+		for name, u := range users {
+			want := slices.Contains(tt.allowed, name)
+			if got := Allowed(u, tt.action, res); got != want {
+				t.Errorf("Allowed(%s, %s) = %v, want %v", name, tt.action, got, want)
+			}
+		}
+	}
+}
+
+func TestAllowedWithoutAuthor(t *testing.T) {
+	// A user without an ID is not the author of content that has none.
+	var anonymous UserClaims
+	for _, action := range []Action{ActionEdit, ActionRestore, ActionDeletePost, ActionDeleteComment} {
+		if Allowed(anonymous, action, Resource{Category: "music"}) {
+			t.Errorf("Allowed(anonymous, %s) on a category = true, want false", action)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateClass groups the routes that share a rate limit.
+type RateClass string
+
+const (
+	ClassRead  RateClass = "read"  // listings and other GET requests
+	ClassWrite RateClass = "write" // posting, commenting, editing and the like
+	ClassVote  RateClass = "vote"
+	ClassAuth  RateClass = "auth" // registration, login, password changes and resets, account deletion
+)
+
+// RateLimit lets Requests requests through per Period. Each client has a
+// token bucket holding up to Requests tokens, refilled evenly over Period,
+// so a burst of Requests is allowed after a quiet spell. A zero Requests
+// leaves the class unlimited.
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// rate is how many tokens the bucket gains per microsecond.
+func (l RateLimit) rate() float64 {
+	return float64(l.Requests) / float64(l.Period.Microseconds())
+}
+
+// DefaultRateLimits are the limits of the classes -rate-limits leaves out.
+var DefaultRateLimits = map[RateClass]RateLimit{
+	ClassRead:  {Requests: 300, Period: time.Minute},
+	ClassWrite: {Requests: 30, Period: time.Minute},
+	ClassVote:  {Requests: 120, Period: time.Minute},
+	ClassAuth:  {Requests: 10, Period: time.Minute},
+}
+
+var ErrBadRateLimit = errors.New("rate limits must look like write=30/1m,vote=120/1m")
+
+// ParseRateLimits reads limits such as "write=30/1m,auth=5/1m" over the
+// defaults; "class=0" lifts the limit of a class.
+func ParseRateLimits(s string) (map[RateClass]RateLimit, error) {
+	limits := make(map[RateClass]RateLimit, len(DefaultRateLimits))
+	for class, l := range DefaultRateLimits {
+		limits[class] = l
+	}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(item, "=")
+		class := RateClass(strings.TrimSpace(name))
+		if _, known := DefaultRateLimits[class]; !ok || !known {
+			return nil, fmt.Errorf("%w: %q", ErrBadRateLimit, item)
+		}
+		if strings.TrimSpace(value) == "0" {
+			limits[class] = RateLimit{}
+			continue
+		}
+		requests, period, ok := strings.Cut(value, "/")
+		n, err := strconv.Atoi(strings.TrimSpace(requests))
+		if !ok || err != nil || n < 0 {
+			return nil, fmt.Errorf("%w: %q", ErrBadRateLimit, item)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(period))
+		if err != nil || d < time.Millisecond {
+			return nil, fmt.Errorf("%w: %q", ErrBadRateLimit, item)
+		}
+		limits[class] = RateLimit{Requests: n, Period: d}
+	}
+	return limits, nil
+}
+
+// RateResult is the state of a bucket after a request tried to take a
+// token from it.
+type RateResult struct {
+	Allowed   bool
+	Remaining int
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is how long until a denied request would be let through.
+	RetryAfter time.Duration
+}
+
+// newRateResult describes a bucket of the limit left with tokens.
+func newRateResult(l RateLimit, tokens float64, allowed bool) RateResult {
+	res := RateResult{
+		Allowed:   allowed,
+		Remaining: max(int(math.Floor(tokens)), 0),
+		Reset:     time.Duration((float64(l.Requests)-tokens)/l.rate()) * time.Microsecond,
+	}
+	if !allowed {
+		res.RetryAfter = time.Duration((1-tokens)/l.rate()) * time.Microsecond
+	}
+	return res
+}
+
+// RateStore keeps the token buckets. Take must be atomic for a key, so that
+// servers sharing a store share the limits too.
+type RateStore interface {
+	// Take takes a token from the bucket under key, which starts out full,
+	// if it holds one.
+	Take(ctx context.Context, key string, l RateLimit, now time.Time) (RateResult, error)
+}
+
+// RateLimiter limits requests per route class, counting them for the
+// authenticated user or, without one, for the client IP.
+type RateLimiter struct {
+	store  RateStore
+	limits map[RateClass]RateLimit
+}
+
+func NewRateLimiter(store RateStore, limits map[RateClass]RateLimit) *RateLimiter {
+	return &RateLimiter{store: store, limits: limits}
+}
+
+// Limit applies the limit of the class to next. Responses carry the
+// RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset and
+// RateLimit-Policy headers; requests over the limit get 429 with
+// Retry-After. To count requests per user it must run after Auth or
+// OptionalAuth. If the store fails the request is let through.
+func (rl *RateLimiter) Limit(class RateClass, next http.Handler) http.Handler {
+	l := rl.limits[class]
+	if l.Requests <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := string(class) + ":ip:" + ClientIP(r)
+		if u, ok := GetUser(r.Context()); ok {
+			key = string(class) + ":user:" + u.ID
+		}
+		res, err := rl.store.Take(r.Context(), key, l, time.Now())
+		if err != nil {
+			log.Printf("rate limit: %v", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Set("RateLimit-Limit", strconv.Itoa(l.Requests))
+		h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		h.Set("RateLimit-Reset", strconv.Itoa(seconds(res.Reset)))
+		h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", l.Requests, seconds(l.Period)))
+		if !res.Allowed {
+			h.Set("Retry-After", strconv.Itoa(max(seconds(res.RetryAfter), 1)))
+			http.Error(w, `{"message": "too many requests"}`, http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// seconds rounds d up to whole seconds.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// ClientIP is the address the request came from.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// sweepInterval is how often stores drop the buckets that refilled.
+const sweepInterval = time.Minute
+
+// bucket is a token bucket as of updated. Once full it is forgotten, as a
+// missing bucket counts as full.
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	full    time.Time // when the bucket will be full again
+}
+
+// MemoryRateStore keeps buckets in memory, for a single server.
+type MemoryRateStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func NewMemoryRateStore() *MemoryRateStore {
+	return &MemoryRateStore{buckets: make(map[string]*bucket)}
+}
+
+func (s *MemoryRateStore) Take(ctx context.Context, key string, l RateLimit, now time.Time) (RateResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) >= sweepInterval {
+		for k, b := range s.buckets {
+			if !now.Before(b.full) {
+				delete(s.buckets, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	capacity := float64(l.Requests)
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, updated: now}
+		s.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens = min(capacity, b.tokens+float64(elapsed.Microseconds())*l.rate())
+		b.updated = now
+	}
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	b.full = b.updated.Add(time.Duration((capacity-b.tokens)/l.rate()) * time.Microsecond)
+	return newRateResult(l, b.tokens, allowed), nil
+}
@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// SQLRateStore keeps buckets in the rate_buckets table created by the
+// storage package migrations, so that servers using the same database share
+// the limits. Times are in Unix microseconds.
+type SQLRateStore struct {
+	db *sql.DB
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+func NewSQLRateStore(db *sql.DB) *SQLRateStore {
+	return &SQLRateStore{db: db}
+}
+
+// The bucket is refilled and taken from in a single statement, which keeps
+// Take atomic across servers. ?2 is the capacity, ?3 now and ?4 the rate per
+// microsecond; a server whose clock lags never drains a bucket.
+const (
+	refilled = `MIN(?2, tokens + MAX(0, ?3 - updated) * ?4)`
+	taken    = `(` + refilled + ` >= 1)`
+	left     = `(` + refilled + ` - ` + taken + `)`
+	takeSQL  = `INSERT INTO rate_buckets (key, tokens, updated, full_at, allowed)
+		VALUES (?1, ?2 - 1, ?3, ?3 + 1 / ?4, 1)
+		ON CONFLICT (key) DO UPDATE SET
+			allowed = ` + taken + `,
+			tokens = ` + left + `,
+			full_at = MAX(updated, ?3) + (?2 - ` + left + `) / ?4,
+			updated = MAX(updated, ?3)
+		RETURNING tokens, allowed`
+)
+
+func (s *SQLRateStore) Take(ctx context.Context, key string, l RateLimit, now time.Time) (RateResult, error) {
+	if err := s.sweep(ctx, now); err != nil {
+		return RateResult{}, err
+	}
+	var tokens float64
+	var allowed bool
+	err := s.db.QueryRowContext(ctx, takeSQL, key, float64(l.Requests), now.UnixMicro(), l.rate()).Scan(&tokens, &allowed)
+	if err != nil {
+		return RateResult{}, err
+	}
+	return newRateResult(l, tokens, allowed), nil
+}
+
+// sweep drops the buckets that refilled, at most once per sweepInterval.
+func (s *SQLRateStore) sweep(ctx context.Context, now time.Time) error {
+	s.mu.Lock()
+	due := now.Sub(s.lastSweep) >= sweepInterval
+	if due {
+		s.lastSweep = now
+	}
+	s.mu.Unlock()
+	if !due {
+		return nil
+	}
+	_, err := s.db.ExecContext(ctx, `DELETE FROM rate_buckets WHERE full_at <= ?`, now.UnixMicro())
+	return err
+}
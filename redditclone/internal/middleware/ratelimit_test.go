@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"redditclone/internal/storage"
+)
+
+var testTime = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// forEachStore runs test against an empty store of each implementation.
+func forEachStore(t *testing.T, test func(t *testing.T, s RateStore)) {
+	t.Helper()
+	stores := []struct {
+		name string
+		new  func(t *testing.T) RateStore
+	}{
+		{"memory", func(t *testing.T) RateStore { return NewMemoryRateStore() }},
+		{"sql", func(t *testing.T) RateStore {
+			db, err := storage.Open("sqlite", filepath.Join(t.TempDir(), "test.db"))
+			if err != nil {
+				t.Fatalf("open database: %v", err)
+			}
+			t.Cleanup(func() { db.Close() })
+			return NewSQLRateStore(db)
+		}},
+	}
+	for _, tt := range stores {
+		t.Run(tt.name, func(t *testing.T) {
+			test(t, tt.new(t))
+		})
+	}
+}
+
+func TestRateStoreTake(t *testing.T) {
+	// A token a second, and a burst of three.
+	l := RateLimit{Requests: 3, Period: 3 * time.Second}
+	forEachStore(t, func(t *testing.T, s RateStore) {
+		tests := []struct {
+			name      string
+			key       string
+			at        time.Duration // after testTime
+			allowed   bool
+			remaining int
+			retry     time.Duration // RetryAfter, within a millisecond
+		}{
+			{"burst", "a", 0, true, 2, 0},
+			{"burst", "a", 0, true, 1, 0},
+			{"burst", "a", 0, true, 0, 0},
+			{"over the burst", "a", 0, false, 0, time.Second},
+			{"other key", "b", 0, true, 2, 0},
+			{"half refilled", "a", 500 * time.Millisecond, false, 0, 500 * time.Millisecond},
+			{"refilled a token", "a", 1500 * time.Millisecond, true, 0, 0},
+			{"took the refilled token", "a", 1500 * time.Millisecond, false, 0, 500 * time.Millisecond},
+			{"refilled no more than the burst", "a", time.Hour, true, 2, 0},
+			{"refilled no more than the burst", "a", time.Hour, true, 1, 0},
+			{"refilled no more than the burst", "a", time.Hour, true, 0, 0},
+			{"refilled no more than the burst", "a", time.Hour, false, 0, time.Second},
+		}
+		for i, tt := range tests {
+			res, err := s.Take(context.Background(), tt.key, l, testTime.Add(tt.at))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Allowed != tt.allowed || res.Remaining != tt.remaining {
+				t.Errorf("%d %s: allowed %v with %d left, want %v with %d", i, tt.name,
+					res.Allowed, res.Remaining, tt.allowed, tt.remaining)
+			}
+			if d := res.RetryAfter - tt.retry; d < -time.Millisecond || d > time.Millisecond {
+				t.Errorf("%d %s: RetryAfter = %v, want %v", i, tt.name, res.RetryAfter, tt.retry)
+			}
+		}
+	})
+}
+
+func TestRateLimiterLimit(t *testing.T) {
+	limits := map[RateClass]RateLimit{ClassAuth: {Requests: 2, Period: time.Minute}}
+	h := NewRateLimiter(NewMemoryRateStore(), limits).Limit(ClassAuth,
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	request := func(addr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/api/login", nil)
+		r.RemoteAddr = addr
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	for i := range 2 {
+		if w := request("192.0.2.1:1000"); w.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d, want 200", i, w.Code)
+		}
+	}
+	w := request("192.0.2.1:2000")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status over the limit = %d, want 429", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "30" {
+		t.Errorf("Retry-After = %q, want 30", got)
+	}
+	if got := w.Header().Get("RateLimit-Policy"); got != "2;w=60" {
+		t.Errorf("RateLimit-Policy = %q, want 2;w=60", got)
+	}
+	if w = request("192.0.2.2:1000"); w.Code != http.StatusOK {
+		t.Errorf("another client: status = %d, want 200", w.Code)
+	}
+}
@@ -0,0 +1,428 @@
+package persist
+
+import (
+	"time"
+
+	"redditclone/internal/ban"
+	"redditclone/internal/community"
+	"redditclone/internal/post"
+	"redditclone/internal/report"
+	"redditclone/internal/reset"
+	"redditclone/internal/session"
+	"redditclone/internal/user"
+)
+
+// userRepo logs registrations and changes to users; reads go straight to
+// the memory repo.
+type userRepo struct {
+	*user.MemoryRepo
+	store *Store
+}
+
+func (r *userRepo) Register(username, password string) (*user.User, error) {
+	if _, err := r.MemoryRepo.GetByUsername(username); err == nil {
+		return nil, user.ErrExists
+	}
+	// Hash before taking the store lock: bcrypt is slow on purpose.
+	rec, err := user.NewRecord(username, password)
+	if err != nil {
+		return nil, err
+	}
+	var u *user.User
+	err = r.store.apply(opRegister, rec, func() error {
+		var err error
+		u, err = r.MemoryRepo.Insert(rec)
+		return err
+	})
+	return u, err
+}
+
+func (r *userRepo) SetAdmin(username string, admin bool) (*user.User, error) {
+	var u *user.User
+	err := r.store.apply(opSetAdmin, adminOp{Username: username, Admin: admin}, func() error {
+		var err error
+		u, err = r.MemoryRepo.SetAdmin(username, admin)
+		return err
+	})
+	return u, err
+}
+
+// SetPassword logs the password hash, and not the password.
+func (r *userRepo) SetPassword(username, password string) (*user.User, error) {
+	hash, err := user.HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	var u *user.User
+	err = r.store.apply(opSetPassword, passwordOp{Username: username, PasswordHash: hash}, func() error {
+		var err error
+		u, err = r.MemoryRepo.SetPasswordHash(username, hash)
+		return err
+	})
+	return u, err
+}
+
+func (r *userRepo) SetEmail(username, email string) (*user.User, error) {
+	email, err := user.NormalizeEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	var u *user.User
+	err = r.store.apply(opSetEmail, emailOp{Username: username, Email: email}, func() error {
+		var err error
+		u, err = r.MemoryRepo.SetEmail(username, email)
+		return err
+	})
+	return u, err
+}
+
+func (r *userRepo) Delete(username string) error {
+	return r.store.apply(opDeleteUser, deleteUserOp{Username: username}, func() error {
+		return r.MemoryRepo.Delete(username)
+	})
+}
+
+// postRepo logs every change to posts; reads go straight to the memory repo.
+type postRepo struct {
+	*post.MemoryRepo
+	store *Store
+}
+
+func (r *postRepo) Forget(userID string, votes post.VotePolicy) error {
+	return r.store.apply(opForget, forgetOp{UserID: userID, Votes: votes}, func() error {
+		return r.MemoryRepo.Forget(userID, votes)
+	})
+}
+
+func (r *postRepo) Add(p *post.Post) (*post.Post, error) {
+	var added *post.Post
+	err := r.store.apply(opAddPost, p, func() error {
+		var err error
+		added, err = r.MemoryRepo.Add(p)
+		return err
+	})
+	return added, err
+}
+
+func (r *postRepo) Delete(id string, deleted time.Time) error {
+	return r.store.apply(opDeletePost, deletePostOp{PostID: id, Deleted: deleted}, func() error {
+		return r.MemoryRepo.Delete(id, deleted)
+	})
+}
+
+func (r *postRepo) Restore(id string) (*post.Post, error) {
+	var p *post.Post
+	err := r.store.apply(opRestorePost, deletePostOp{PostID: id}, func() error {
+		var err error
+		p, err = r.MemoryRepo.Restore(id)
+		return err
+	})
+	return p, err
+}
+
+func (r *postRepo) Purge(before time.Time) (int, error) {
+	var n int
+	err := r.store.apply(opPurge, before, func() error {
+		var err error
+		n, err = r.MemoryRepo.Purge(before)
+		if err == nil && n == 0 {
+			return errUnchanged
+		}
+		return err
+	})
+	return n, err
+}
+
+func (r *postRepo) Save(postID, userID string, saved time.Time) error {
+	return r.store.apply(opSave, savedOp{PostID: postID, UserID: userID, Saved: saved}, func() error {
+		return r.MemoryRepo.Save(postID, userID, saved)
+	})
+}
+
+func (r *postRepo) Unsave(postID, userID string) error {
+	return r.store.apply(opUnsave, savedOp{PostID: postID, UserID: userID}, func() error {
+		return r.MemoryRepo.Unsave(postID, userID)
+	})
+}
+
+func (r *postRepo) Hide(postID, userID string) error {
+	return r.store.apply(opHide, savedOp{PostID: postID, UserID: userID}, func() error {
+		return r.MemoryRepo.Hide(postID, userID)
+	})
+}
+
+func (r *postRepo) Unhide(postID, userID string) error {
+	return r.store.apply(opUnhide, savedOp{PostID: postID, UserID: userID}, func() error {
+		return r.MemoryRepo.Unhide(postID, userID)
+	})
+}
+
+func (r *postRepo) Vote(postID, userID string, vote int) (*post.Post, error) {
+	var p *post.Post
+	err := r.store.apply(opVote, voteOp{PostID: postID, UserID: userID, Vote: vote}, func() error {
+		var err error
+		p, err = r.MemoryRepo.Vote(postID, userID, vote)
+		return err
+	})
+	return p, err
+}
+
+func (r *postRepo) VoteComment(postID, commentID, userID string, vote int) (*post.Post, error) {
+	var p *post.Post
+	err := r.store.apply(opVoteComment, voteOp{PostID: postID, CommentID: commentID, UserID: userID, Vote: vote}, func() error {
+		var err error
+		p, err = r.MemoryRepo.VoteComment(postID, commentID, userID, vote)
+		return err
+	})
+	return p, err
+}
+
+func (r *postRepo) AddComment(postID string, comment *post.Comment) (*post.Post, error) {
+	var p *post.Post
+	err := r.store.apply(opAddComment, commentOp{PostID: postID, Comment: comment}, func() error {
+		var err error
+		p, err = r.MemoryRepo.AddComment(postID, comment)
+		return err
+	})
+	return p, err
+}
+
+func (r *postRepo) DeleteComment(postID, commentID string, deleted time.Time) (*post.Post, error) {
+	var p *post.Post
+	err := r.store.apply(opDeleteComment, commentOp{PostID: postID, CommentID: commentID, Deleted: deleted}, func() error {
+		var err error
+		p, err = r.MemoryRepo.DeleteComment(postID, commentID, deleted)
+		return err
+	})
+	return p, err
+}
+
+func (r *postRepo) RestoreComment(postID, commentID string) (*post.Post, error) {
+	var p *post.Post
+	err := r.store.apply(opRestoreComment, commentOp{PostID: postID, CommentID: commentID}, func() error {
+		var err error
+		p, err = r.MemoryRepo.RestoreComment(postID, commentID)
+		return err
+	})
+	return p, err
+}
+
+// IncrementViews counts a view without logging it: views are too frequent
+// to fsync one by one, so they are left to snapshots, and a crash loses the
+// views counted since the last one.
+func (r *postRepo) IncrementViews(postID string) (*post.Post, error) {
+	p, err := r.MemoryRepo.IncrementViews(postID)
+	if err == nil {
+		r.store.unlogged.Store(true)
+	}
+	return p, err
+}
+
+func (r *postRepo) Edit(postID string, edit post.Edit) (*post.Post, error) {
+	var p *post.Post
+	err := r.store.apply(opEditPost, editPostOp{PostID: postID, Edit: edit}, func() error {
+		var err error
+		p, err = r.MemoryRepo.Edit(postID, edit)
+		return err
+	})
+	return p, err
+}
+
+func (r *postRepo) EditComment(postID, commentID, body string, edited time.Time) (*post.Post, error) {
+	var p *post.Post
+	err := r.store.apply(opEditComment, editCommentOp{PostID: postID, CommentID: commentID, Body: body, Edited: edited}, func() error {
+		var err error
+		p, err = r.MemoryRepo.EditComment(postID, commentID, body, edited)
+		return err
+	})
+	return p, err
+}
+
+// communityRepo logs created communities, subscriptions and moderators;
+// reads go straight to the memory repo.
+type communityRepo struct {
+	*community.MemoryRepo
+	store *Store
+}
+
+func (r *communityRepo) Create(c *community.Community) (*community.Community, error) {
+	var created *community.Community
+	err := r.store.apply(opAddCommunity, c, func() error {
+		var err error
+		created, err = r.MemoryRepo.Create(c)
+		return err
+	})
+	return created, err
+}
+
+func (r *communityRepo) Subscribe(name, userID string) (*community.Community, error) {
+	var c *community.Community
+	err := r.store.apply(opSubscribe, subscriptionOp{Community: name, UserID: userID}, func() error {
+		var err error
+		c, err = r.MemoryRepo.Subscribe(name, userID)
+		return err
+	})
+	return c, err
+}
+
+func (r *communityRepo) Unsubscribe(name, userID string) (*community.Community, error) {
+	var c *community.Community
+	err := r.store.apply(opUnsubscribe, subscriptionOp{Community: name, UserID: userID}, func() error {
+		var err error
+		c, err = r.MemoryRepo.Unsubscribe(name, userID)
+		return err
+	})
+	return c, err
+}
+
+func (r *communityRepo) AddModerator(name string, m community.Member) (*community.Community, error) {
+	var c *community.Community
+	err := r.store.apply(opAddModerator, moderatorOp{Community: name, Moderator: m}, func() error {
+		var err error
+		c, err = r.MemoryRepo.AddModerator(name, m)
+		return err
+	})
+	return c, err
+}
+
+func (r *communityRepo) RemoveModerator(name, userID string) (*community.Community, error) {
+	var c *community.Community
+	err := r.store.apply(opRemoveModerator, moderatorOp{Community: name, Moderator: community.Member{ID: userID}}, func() error {
+		var err error
+		c, err = r.MemoryRepo.RemoveModerator(name, userID)
+		return err
+	})
+	return c, err
+}
+
+// reportRepo logs reports and their resolutions; reads go straight to the
+// memory repo.
+type reportRepo struct {
+	*report.MemoryRepo
+	store *Store
+}
+
+func (r *reportRepo) Add(rep *report.Report) (*report.Report, error) {
+	var added *report.Report
+	err := r.store.apply(opReport, rep, func() error {
+		var err error
+		added, err = r.MemoryRepo.Add(rep)
+		return err
+	})
+	return added, err
+}
+
+func (r *reportRepo) Resolve(postID, commentID string, action report.Action, moderatorID string, resolved time.Time) (int, error) {
+	var n int
+	err := r.store.apply(opResolve, resolveOp{PostID: postID, CommentID: commentID, Action: action, ModeratorID: moderatorID, Resolved: resolved}, func() error {
+		var err error
+		n, err = r.MemoryRepo.Resolve(postID, commentID, action, moderatorID, resolved)
+		return err
+	})
+	return n, err
+}
+
+func (r *reportRepo) Remove(rep *report.Report) error {
+	return r.store.apply(opRemove, rep, func() error {
+		return r.MemoryRepo.Remove(rep)
+	})
+}
+
+// banRepo logs bans and lifted bans; reads go straight to the memory repo.
+type banRepo struct {
+	*ban.MemoryRepo
+	store *Store
+}
+
+func (r *banRepo) Put(b *ban.Ban) (*ban.Ban, error) {
+	var put *ban.Ban
+	err := r.store.apply(opBan, b, func() error {
+		var err error
+		put, err = r.MemoryRepo.Put(b)
+		return err
+	})
+	return put, err
+}
+
+func (r *banRepo) Lift(userID, category string) error {
+	return r.store.apply(opLiftBan, liftBanOp{UserID: userID, Category: category}, func() error {
+		return r.MemoryRepo.Lift(userID, category)
+	})
+}
+
+// sessionRepo logs new, rotated and revoked sessions; reads go straight to
+// the memory repo. Prune is not logged: the sessions it drops have ended,
+// and the next snapshot leaves them out.
+type sessionRepo struct {
+	*session.MemoryRepo
+	store *Store
+}
+
+func (r *sessionRepo) Create(sess *session.Session) (*session.Session, error) {
+	var created *session.Session
+	err := r.store.apply(opCreateSession, sess, func() error {
+		var err error
+		created, err = r.MemoryRepo.Create(sess)
+		return err
+	})
+	return created, err
+}
+
+func (r *sessionRepo) Rotate(id, oldHash, newHash string, used, expires time.Time) (*session.Session, error) {
+	var sess *session.Session
+	err := r.store.apply(opRotateSession, rotateSessionOp{ID: id, OldHash: oldHash, NewHash: newHash, Used: used, Expires: expires}, func() error {
+		var err error
+		sess, err = r.MemoryRepo.Rotate(id, oldHash, newHash, used, expires)
+		return err
+	})
+	return sess, err
+}
+
+func (r *sessionRepo) Revoke(id string, revoked time.Time) error {
+	return r.store.apply(opRevokeSession, revokeSessionOp{ID: id, Revoked: revoked}, func() error {
+		return r.MemoryRepo.Revoke(id, revoked)
+	})
+}
+
+func (r *sessionRepo) RevokeUser(userID, except string, revoked time.Time) (int, error) {
+	var n int
+	err := r.store.apply(opRevokeUserSessions, revokeUserSessionsOp{UserID: userID, Except: except, Revoked: revoked}, func() error {
+		var err error
+		n, err = r.MemoryRepo.RevokeUser(userID, except, revoked)
+		return err
+	})
+	return n, err
+}
+
+// resetRepo logs created, used and deleted reset tokens. Like sessions,
+// pruned tokens are left to the next snapshot.
+type resetRepo struct {
+	*reset.MemoryRepo
+	store *Store
+}
+
+func (r *resetRepo) Create(t *reset.Token) (*reset.Token, error) {
+	var created *reset.Token
+	err := r.store.apply(opCreateReset, t, func() error {
+		var err error
+		created, err = r.MemoryRepo.Create(t)
+		return err
+	})
+	return created, err
+}
+
+func (r *resetRepo) Consume(hash string, now time.Time) (*reset.Token, error) {
+	var t *reset.Token
+	err := r.store.apply(opConsumeReset, consumeResetOp{Hash: hash, Used: now}, func() error {
+		var err error
+		t, err = r.MemoryRepo.Consume(hash, now)
+		return err
+	})
+	return t, err
+}
+
+func (r *resetRepo) DeleteUser(userID string) error {
+	return r.store.apply(opDeleteUserResets, deleteUserResetsOp{UserID: userID}, func() error {
+		return r.MemoryRepo.DeleteUser(userID)
+	})
+}
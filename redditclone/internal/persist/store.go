@@ -0,0 +1,831 @@
+package persist
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"redditclone/internal/ban"
+	"redditclone/internal/community"
+	"redditclone/internal/post"
+	"redditclone/internal/report"
+	"redditclone/internal/reset"
+	"redditclone/internal/session"
+	"redditclone/internal/user"
+)
+
+const (
+	logFile      = "ops.log"
+	snapshotFile = "snapshot.json"
+)
+
+// Operation types written to the log.
+const (
+	opRegister           = "register"
+	opSetAdmin           = "set_admin"
+	opSetPassword        = "set_password"
+	opSetEmail           = "set_email"
+	opDeleteUser         = "delete_user"
+	opAddPost            = "add_post"
+	opDeletePost         = "delete_post"
+	opVote               = "vote"
+	opVoteComment        = "vote_comment"
+	opAddComment         = "add_comment"
+	opDeleteComment      = "delete_comment"
+	opEditPost           = "edit_post"
+	opEditComment        = "edit_comment"
+	opRestorePost        = "restore_post"
+	opRestoreComment     = "restore_comment"
+	opPurge              = "purge"
+	opForget             = "forget"
+	opSave               = "save"
+	opUnsave             = "unsave"
+	opHide               = "hide"
+	opUnhide             = "unhide"
+	opAddCommunity       = "add_community"
+	opSubscribe          = "subscribe"
+	opUnsubscribe        = "unsubscribe"
+	opAddModerator       = "add_moderator"
+	opRemoveModerator    = "remove_moderator"
+	opReport             = "report"
+	opResolve            = "resolve"
+	opRemove             = "remove"
+	opBan                = "ban"
+	opLiftBan            = "lift_ban"
+	opCreateSession      = "create_session"
+	opRotateSession      = "rotate_session"
+	opRevokeSession      = "revoke_session"
+	opRevokeUserSessions = "revoke_user_sessions"
+	opCreateReset        = "create_reset"
+	opConsumeReset       = "consume_reset"
+	opDeleteUserResets   = "delete_user_resets"
+)
+
+type record struct {
+	Seq  uint64          `json:"seq"`
+	Op   string          `json:"op"`
+	Data json.RawMessage `json:"data"`
+}
+
+type voteOp struct {
+	PostID    string `json:"post_id"`
+	CommentID string `json:"comment_id,omitempty"`
+	UserID    string `json:"user_id"`
+	Vote      int    `json:"vote"`
+}
+
+type commentOp struct {
+	PostID    string        `json:"post_id"`
+	CommentID string        `json:"comment_id,omitempty"`
+	Comment   *post.Comment `json:"comment,omitempty"`
+	Deleted   time.Time     `json:"deleted,omitzero"`
+}
+
+type deletePostOp struct {
+	PostID  string    `json:"post_id"`
+	Deleted time.Time `json:"deleted,omitzero"`
+}
+
+type editPostOp struct {
+	PostID string    `json:"post_id"`
+	Edit   post.Edit `json:"edit"`
+}
+
+type editCommentOp struct {
+	PostID    string    `json:"post_id"`
+	CommentID string    `json:"comment_id"`
+	Body      string    `json:"body"`
+	Edited    time.Time `json:"edited"`
+}
+
+type savedOp struct {
+	PostID string    `json:"post_id"`
+	UserID string    `json:"user_id"`
+	Saved  time.Time `json:"saved,omitzero"`
+}
+
+type subscriptionOp struct {
+	Community string `json:"community"`
+	UserID    string `json:"user_id"`
+}
+
+type adminOp struct {
+	Username string `json:"username"`
+	Admin    bool   `json:"admin"`
+}
+
+type passwordOp struct {
+	Username     string `json:"username"`
+	PasswordHash string `json:"password_hash"`
+}
+
+type emailOp struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+}
+
+type deleteUserOp struct {
+	Username string `json:"username"`
+}
+
+type forgetOp struct {
+	UserID string          `json:"user_id"`
+	Votes  post.VotePolicy `json:"votes"`
+}
+
+type moderatorOp struct {
+	Community string           `json:"community"`
+	Moderator community.Member `json:"moderator"`
+}
+
+type resolveOp struct {
+	PostID      string        `json:"post_id"`
+	CommentID   string        `json:"comment_id,omitempty"`
+	Action      report.Action `json:"action"`
+	ModeratorID string        `json:"moderator_id"`
+	Resolved    time.Time     `json:"resolved"`
+}
+
+type liftBanOp struct {
+	UserID   string `json:"user_id"`
+	Category string `json:"category,omitempty"`
+}
+
+type rotateSessionOp struct {
+	ID      string    `json:"id"`
+	OldHash string    `json:"old_hash"`
+	NewHash string    `json:"new_hash"`
+	Used    time.Time `json:"used"`
+	Expires time.Time `json:"expires"`
+}
+
+type revokeSessionOp struct {
+	ID      string    `json:"id"`
+	Revoked time.Time `json:"revoked"`
+}
+
+type revokeUserSessionsOp struct {
+	UserID  string    `json:"user_id"`
+	Except  string    `json:"except,omitempty"`
+	Revoked time.Time `json:"revoked"`
+}
+
+type consumeResetOp struct {
+	Hash string    `json:"hash"`
+	Used time.Time `json:"used"`
+}
+
+type deleteUserResetsOp struct {
+	UserID string `json:"user_id"`
+}
+
+type snapshot struct {
+	Seq         uint64                 `json:"seq"`
+	Users       []user.Record          `json:"users"`
+	Posts       []post.Record          `json:"posts"`
+	Communities []*community.Community `json:"communities"`
+	// Subscriptions maps user IDs to the communities they subscribed to.
+	Subscriptions map[string][]string `json:"subscriptions,omitempty"`
+	Reports       []*report.Report    `json:"reports,omitempty"`
+	Bans          []*ban.Ban          `json:"bans,omitempty"`
+	Sessions      []*session.Session  `json:"sessions,omitempty"`
+	Resets        []*reset.Token      `json:"resets,omitempty"`
+}
+
+// errUnchanged is returned by a mutation that turned out to change nothing,
+// so that its record is dropped from the log.
+var errUnchanged = errors.New("persist: nothing changed")
+
+// Store makes the in-memory repos durable. Every mutation but a view is
+// appended to an fsynced operation log before it is applied; Snapshot writes
+// the full state, views included, and truncates the log. On Open the latest
+// snapshot is loaded and the log is replayed on top of it.
+type Store struct {
+	mu      sync.Mutex // serializes mutations, log appends and snapshots
+	dir     string
+	log     *os.File
+	size    int64  // length of the log
+	seq     uint64 // last sequence number applied
+	pending int    // records appended since the last snapshot
+	broken  error  // set when the log could not be brought back in line with memory
+	// unlogged is set by changes kept out of the log, such as views, which
+	// only snapshots persist.
+	unlogged atomic.Bool
+
+	users       *user.MemoryRepo
+	posts       *post.MemoryRepo
+	communities *community.MemoryRepo
+	reports     *report.MemoryRepo
+	bans        *ban.MemoryRepo
+	sessions    *session.MemoryRepo
+	resets      *reset.MemoryRepo
+}
+
+// Open restores the repos from dir and opens the operation log for appending.
+// The repos are expected to be empty.
+func Open(dir string, users *user.MemoryRepo, posts *post.MemoryRepo, communities *community.MemoryRepo, reports *report.MemoryRepo, bans *ban.MemoryRepo, sessions *session.MemoryRepo, resets *reset.MemoryRepo) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	s := &Store{dir: dir, users: users, posts: posts, communities: communities, reports: reports, bans: bans, sessions: sessions, resets: resets}
+	if err := s.loadSnapshot(); err != nil {
+		return nil, fmt.Errorf("load snapshot: %w", err)
+	}
+	if err := s.replay(); err != nil {
+		return nil, fmt.Errorf("replay log: %w", err)
+	}
+	// Data written before communities existed may have posts in categories
+	// nobody created.
+	for _, rec := range posts.Records() {
+		communities.Ensure(rec.Post.Category)
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, logFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	s.log, s.size = f, info.Size()
+	return s, nil
+}
+
+// Users returns a user.Repo that logs every registration and change to users.
+func (s *Store) Users() user.Repo {
+	return &userRepo{MemoryRepo: s.users, store: s}
+}
+
+// Posts returns a post.Repo that logs every change to posts.
+func (s *Store) Posts() post.Repo {
+	return &postRepo{MemoryRepo: s.posts, store: s}
+}
+
+// Communities returns a community.Repo that logs every change to communities.
+func (s *Store) Communities() community.Repo {
+	return &communityRepo{MemoryRepo: s.communities, store: s}
+}
+
+// Reports returns a report.Repo that logs every report and resolution.
+func (s *Store) Reports() report.Repo {
+	return &reportRepo{MemoryRepo: s.reports, store: s}
+}
+
+// Bans returns a ban.Repo that logs every ban and lifted ban.
+func (s *Store) Bans() ban.Repo {
+	return &banRepo{MemoryRepo: s.bans, store: s}
+}
+
+// Sessions returns a session.Repo that logs every change to sessions.
+func (s *Store) Sessions() session.Repo {
+	return &sessionRepo{MemoryRepo: s.sessions, store: s}
+}
+
+// Resets returns a reset.Repo that logs every change to reset tokens.
+func (s *Store) Resets() reset.Repo {
+	return &resetRepo{MemoryRepo: s.resets, store: s}
+}
+
+// Run takes a snapshot every interval until ctx is done.
+func (s *Store) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Snapshot(); err != nil {
+				log.Printf("persist: snapshot failed: %v", err)
+			}
+		}
+	}
+}
+
+// Snapshot writes the current state and truncates the operation log.
+// It does nothing if nothing changed since the last snapshot.
+func (s *Store) Snapshot() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pending == 0 && !s.unlogged.Load() {
+		return nil
+	}
+	return s.snapshot()
+}
+
+func (s *Store) snapshot() (err error) {
+	// Cleared before the state is read, so that a view counted meanwhile
+	// leaves it set for the next snapshot, and set again if this one fails.
+	if s.unlogged.Swap(false) {
+		defer func() {
+			if err != nil {
+				s.unlogged.Store(true)
+			}
+		}()
+	}
+	data, err := json.Marshal(snapshot{
+		Seq:           s.seq,
+		Users:         s.users.Records(),
+		Posts:         s.posts.Records(),
+		Communities:   s.communities.Records(),
+		Subscriptions: s.communities.SubscriptionRecords(),
+		Reports:       s.reports.Records(),
+		Bans:          s.bans.Records(),
+		Sessions:      s.sessions.Records(),
+		Resets:        s.resets.Records(),
+	})
+	if err != nil {
+		return err
+	}
+	if err = writeFileSync(filepath.Join(s.dir, snapshotFile), data); err != nil {
+		return err
+	}
+
+	// The snapshot carries the last sequence number, so a crash before the
+	// truncation below only leaves records that replay will skip.
+	if err = s.log.Truncate(0); err != nil {
+		return err
+	}
+	if err = s.log.Sync(); err != nil {
+		return err
+	}
+	s.size, s.pending = 0, 0
+	return nil
+}
+
+// Close takes a final snapshot and closes the log.
+func (s *Store) Close() error {
+	err := s.Snapshot()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return errors.Join(err, s.log.Close())
+}
+
+// apply appends the operation to the log and then runs mutate to apply it
+// in memory. Holding the lock across both keeps the log order identical to
+// the order in which mutations were applied. A record is only kept once
+// mutate succeeds: if it fails, or returns errUnchanged, the record is cut
+// from the log again, so that a failed request leaves no trace either on
+// disk or in memory.
+func (s *Store) apply(op string, data any, mutate func() error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.broken != nil {
+		return s.broken
+	}
+	offset := s.size
+	if err := s.append(op, data); err != nil {
+		return errors.Join(err, s.rollback(offset))
+	}
+	if err := mutate(); err != nil {
+		if rerr := s.rollback(offset); rerr != nil {
+			return errors.Join(err, rerr)
+		}
+		if errors.Is(err, errUnchanged) {
+			return nil
+		}
+		return err
+	}
+	s.seq++
+	s.pending++
+	return nil
+}
+
+func (s *Store) append(op string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(record{Seq: s.seq + 1, Op: op, Data: payload})
+	if err != nil {
+		return err
+	}
+	if _, err = s.log.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err = s.log.Sync(); err != nil {
+		return err
+	}
+	s.size += int64(len(line)) + 1
+	return nil
+}
+
+// rollback cuts the log back to offset. If that fails, a snapshot of the
+// memory state, which does not include the operation, replaces the log. If
+// that fails too, the store refuses further mutations: the log now holds a
+// record memory does not, and replaying it would diverge.
+func (s *Store) rollback(offset int64) error {
+	err := s.log.Truncate(offset)
+	if err == nil {
+		err = s.log.Sync()
+	}
+	if err == nil {
+		s.size = offset
+		return nil
+	}
+	log.Printf("persist: cannot cut the log back to offset %d: %v", offset, err)
+	if serr := s.snapshot(); serr != nil {
+		s.broken = fmt.Errorf("persist: log out of sync with memory: %w", errors.Join(err, serr))
+		return s.broken
+	}
+	return nil
+}
+
+func (s *Store) loadSnapshot() error {
+	data, err := os.ReadFile(filepath.Join(s.dir, snapshotFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var snap snapshot
+	if err = json.Unmarshal(data, &snap); err != nil {
+		return err
+	}
+	for _, u := range snap.Users {
+		s.users.Load(u)
+	}
+	for _, p := range snap.Posts {
+		if err = s.posts.Load(p); err != nil {
+			return err
+		}
+	}
+	for _, c := range snap.Communities {
+		s.communities.Load(c)
+	}
+	for userID, names := range snap.Subscriptions {
+		s.communities.LoadSubscriptions(userID, names)
+	}
+	for _, rep := range snap.Reports {
+		s.reports.Load(rep)
+	}
+	for _, b := range snap.Bans {
+		s.bans.Load(b)
+	}
+	for _, sess := range snap.Sessions {
+		s.sessions.Load(sess)
+	}
+	for _, t := range snap.Resets {
+		s.resets.Load(t)
+	}
+	s.seq = snap.Seq
+	return nil
+}
+
+// replay applies logged operations newer than the snapshot. The log ends at
+// the first record that is incomplete or unreadable, such as one torn by a
+// crash mid-write: it and everything after it are moved to a side file and
+// cut from the log, with a warning.
+func (s *Store) replay() error {
+	path := filepath.Join(s.dir, logFile)
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var good int64
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) && len(bytes.TrimSpace(line)) == 0 {
+			return nil
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+
+		var rec record
+		if err != nil || json.Unmarshal(line, &rec) != nil || rec.Op == "" {
+			return discardTail(f, path, good)
+		}
+		good += int64(len(line))
+		if rec.Seq <= s.seq {
+			continue
+		}
+		if err = s.replayRecord(rec); err != nil {
+			return fmt.Errorf("record %d (%s): %w", rec.Seq, rec.Op, err)
+		}
+		s.seq = rec.Seq
+		s.pending++
+	}
+}
+
+// discardTail copies the log from offset on to a side file, so that nothing
+// is lost for good, and truncates the log there.
+func discardTail(f *os.File, path string, offset int64) error {
+	tail, err := io.ReadAll(io.NewSectionReader(f, offset, math.MaxInt64-offset))
+	if err != nil {
+		return err
+	}
+	side := fmt.Sprintf("%s.%d.discarded", path, time.Now().Unix())
+	log.Printf("persist: discarding %d bytes of unreadable log records from offset %d to %s", len(tail), offset, side)
+	if err = writeFileSync(side, tail); err != nil {
+		return err
+	}
+	return os.Truncate(path, offset)
+}
+
+func (s *Store) replayRecord(rec record) error {
+	switch rec.Op {
+	case opRegister:
+		var u user.Record
+		if err := json.Unmarshal(rec.Data, &u); err != nil {
+			return err
+		}
+		s.users.Load(u)
+		return nil
+	case opSetPassword:
+		var op passwordOp
+		if err := json.Unmarshal(rec.Data, &op); err != nil {
+			return err
+		}
+		_, err := s.users.SetPasswordHash(op.Username, op.PasswordHash)
+		return err
+	case opSetEmail:
+		var op emailOp
+		if err := json.Unmarshal(rec.Data, &op); err != nil {
+			return err
+		}
+		_, err := s.users.SetEmail(op.Username, op.Email)
+		return err
+	case opDeleteUser:
+		var op deleteUserOp
+		if err := json.Unmarshal(rec.Data, &op); err != nil {
+			return err
+		}
+		return s.users.Delete(op.Username)
+	case opSetAdmin:
+		var op adminOp
+		if err := json.Unmarshal(rec.Data, &op); err != nil {
+			return err
+		}
+		_, err := s.users.SetAdmin(op.Username, op.Admin)
+		return err
+	case opAddCommunity:
+		var c community.Community
+		if err := json.Unmarshal(rec.Data, &c); err != nil {
+			return err
+		}
+		_, err := s.communities.Create(&c)
+		return err
+	case opSubscribe:
+		var op subscriptionOp
+		if err := json.Unmarshal(rec.Data, &op); err != nil {
+			return err
+		}
+		_, err := s.communities.Subscribe(op.Community, op.UserID)
+		return err
+	case opUnsubscribe:
+		var op subscriptionOp
+		if err := json.Unmarshal(rec.Data, &op); err != nil {
+			return err
+		}
+		_, err := s.communities.Unsubscribe(op.Community, op.UserID)
+		return err
+	case opAddModerator:
+		var op moderatorOp
+		if err := json.Unmarshal(rec.Data, &op); err != nil {
+			return err
+		}
+		_, err := s.communities.AddModerator(op.Community, op.Moderator)
+		return err
+	case opRemoveModerator:
+		var op moderatorOp
+		if err := json.Unmarshal(rec.Data, &op); err != nil {
+			return err
+		}
+		_, err := s.communities.RemoveModerator(op.Community, op.Moderator.ID)
+		return err
+	case opReport:
+		var rep report.Report
+		if err := json.Unmarshal(rec.Data, &rep); err != nil {
+			return err
+		}
+		_, err := s.reports.Add(&rep)
+		return err
+	case opRemove:
+		var rep report.Report
+		if err := json.Unmarshal(rec.Data, &rep); err != nil {
+			return err
+		}
+		return s.reports.Remove(&rep)
+	case opResolve:
+		var op resolveOp
+		if err := json.Unmarshal(rec.Data, &op); err != nil {
+			return err
+		}
+		_, err := s.reports.Resolve(op.PostID, op.CommentID, op.Action, op.ModeratorID, op.Resolved)
+		return err
+	case opBan:
+		var b ban.Ban
+		if err := json.Unmarshal(rec.Data, &b); err != nil {
+			return err
+		}
+		_, err := s.bans.Put(&b)
+		return err
+	case opLiftBan:
+		var op liftBanOp
+		if err := json.Unmarshal(rec.Data, &op); err != nil {
+			return err
+		}
+		return s.bans.Lift(op.UserID, op.Category)
+	case opCreateSession:
+		var sess session.Session
+		if err := json.Unmarshal(rec.Data, &sess); err != nil {
+			return err
+		}
+		_, err := s.sessions.Create(&sess)
+		return err
+	case opRotateSession:
+		var op rotateSessionOp
+		if err := json.Unmarshal(rec.Data, &op); err != nil {
+			return err
+		}
+		_, err := s.sessions.Rotate(op.ID, op.OldHash, op.NewHash, op.Used, op.Expires)
+		return err
+	case opRevokeSession:
+		var op revokeSessionOp
+		if err := json.Unmarshal(rec.Data, &op); err != nil {
+			return err
+		}
+		return s.sessions.Revoke(op.ID, op.Revoked)
+	case opRevokeUserSessions:
+		var op revokeUserSessionsOp
+		if err := json.Unmarshal(rec.Data, &op); err != nil {
+			return err
+		}
+		_, err := s.sessions.RevokeUser(op.UserID, op.Except, op.Revoked)
+		return err
+	case opCreateReset:
+		var t reset.Token
+		if err := json.Unmarshal(rec.Data, &t); err != nil {
+			return err
+		}
+		_, err := s.resets.Create(&t)
+		return err
+	case opConsumeReset:
+		var op consumeResetOp
+		if err := json.Unmarshal(rec.Data, &op); err != nil {
+			return err
+		}
+		_, err := s.resets.Consume(op.Hash, op.Used)
+		return err
+	case opDeleteUserResets:
+		var op deleteUserResetsOp
+		if err := json.Unmarshal(rec.Data, &op); err != nil {
+			return err
+		}
+		return s.resets.DeleteUser(op.UserID)
+	case opAddPost:
+		var p post.Post
+		if err := json.Unmarshal(rec.Data, &p); err != nil {
+			return err
+		}
+		_, err := s.posts.Add(&p)
+		return err
+	case opDeletePost:
+		var op deletePostOp
+		if err := json.Unmarshal(rec.Data, &op); err != nil {
+			return err
+		}
+		return s.posts.Delete(op.PostID, op.Deleted)
+	case opRestorePost:
+		var op deletePostOp
+		if err := json.Unmarshal(rec.Data, &op); err != nil {
+			return err
+		}
+		_, err := s.posts.Restore(op.PostID)
+		return err
+	case opRestoreComment:
+		var op commentOp
+		if err := json.Unmarshal(rec.Data, &op); err != nil {
+			return err
+		}
+		_, err := s.posts.RestoreComment(op.PostID, op.CommentID)
+		return err
+	case opPurge:
+		var before time.Time
+		if err := json.Unmarshal(rec.Data, &before); err != nil {
+			return err
+		}
+		_, err := s.posts.Purge(before)
+		return err
+	case opForget:
+		var op forgetOp
+		if err := json.Unmarshal(rec.Data, &op); err != nil {
+			return err
+		}
+		return s.posts.Forget(op.UserID, op.Votes)
+	case opSave, opUnsave, opHide, opUnhide:
+		var op savedOp
+		if err := json.Unmarshal(rec.Data, &op); err != nil {
+			return err
+		}
+		switch rec.Op {
+		case opSave:
+			return s.posts.Save(op.PostID, op.UserID, op.Saved)
+		case opUnsave:
+			return s.posts.Unsave(op.PostID, op.UserID)
+		case opHide:
+			return s.posts.Hide(op.PostID, op.UserID)
+		default:
+			return s.posts.Unhide(op.PostID, op.UserID)
+		}
+	case opVote:
+		var op voteOp
+		if err := json.Unmarshal(rec.Data, &op); err != nil {
+			return err
+		}
+		_, err := s.posts.Vote(op.PostID, op.UserID, op.Vote)
+		return err
+	case opVoteComment:
+		var op voteOp
+		if err := json.Unmarshal(rec.Data, &op); err != nil {
+			return err
+		}
+		_, err := s.posts.VoteComment(op.PostID, op.CommentID, op.UserID, op.Vote)
+		return err
+	case opAddComment:
+		var op commentOp
+		if err := json.Unmarshal(rec.Data, &op); err != nil {
+			return err
+		}
+		_, err := s.posts.AddComment(op.PostID, op.Comment)
+		return err
+	case opDeleteComment:
+		var op commentOp
+		if err := json.Unmarshal(rec.Data, &op); err != nil {
+			return err
+		}
+		_, err := s.posts.DeleteComment(op.PostID, op.CommentID, op.Deleted)
+		return err
+	case opEditPost:
+		var op editPostOp
+		if err := json.Unmarshal(rec.Data, &op); err != nil {
+			return err
+		}
+		_, err := s.posts.Edit(op.PostID, op.Edit)
+		return err
+	case opEditComment:
+		var op editCommentOp
+		if err := json.Unmarshal(rec.Data, &op); err != nil {
+			return err
+		}
+		_, err := s.posts.EditComment(op.PostID, op.CommentID, op.Body, op.Edited)
+		return err
+	default:
+		return fmt.Errorf("unknown operation %q", rec.Op)
+	}
+}
+
+// writeFileSync atomically replaces path with data.
+func writeFileSync(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
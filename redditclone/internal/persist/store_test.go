@@ -0,0 +1,348 @@
+package persist
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"redditclone/internal/ban"
+	"redditclone/internal/community"
+	"redditclone/internal/post"
+	"redditclone/internal/report"
+	"redditclone/internal/reset"
+	"redditclone/internal/session"
+	"redditclone/internal/user"
+)
+
+// openStore opens a store over dir with empty memory repos. The log is
+// closed when the test ends, without a final snapshot, as if the process
+// had crashed.
+func openStore(t *testing.T, dir string) *Store {
+	t.Helper()
+	s, err := Open(dir, user.NewMemoryRepo(), post.NewMemoryRepo(), community.NewMemoryRepo(),
+		report.NewMemoryRepo(), ban.NewMemoryRepo(), session.NewMemoryRepo(), reset.NewMemoryRepo())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { s.log.Close() })
+	return s
+}
+
+func newPost(id string) *post.Post {
+	return &post.Post{
+		ID:       id,
+		Title:    "title " + id,
+		Author:   &post.Author{ID: "u1", Username: "alice"},
+		Category: "music",
+		Votes:    []*post.Vote{},
+		Comments: []*post.Comment{},
+		Created:  time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+		Type:     "text",
+		Text:     "text",
+	}
+}
+
+// populate registers alice and adds posts p1 and p2 with a vote on p1.
+func populate(t *testing.T, s *Store) {
+	t.Helper()
+	if _, err := s.Users().Register("alice", "password1"); err != nil {
+		t.Fatal(err)
+	}
+	posts := s.Posts()
+	for _, id := range []string{"p1", "p2"} {
+		if _, err := posts.Add(newPost(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := posts.Vote("p1", "u2", 1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func logSize(t *testing.T, dir string) int64 {
+	t.Helper()
+	info, err := os.Stat(filepath.Join(dir, logFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size()
+}
+
+func TestReopenRestoresState(t *testing.T) {
+	tests := []struct {
+		name     string
+		snapshot bool
+	}{
+		{"from the log", false},
+		{"from a snapshot", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			s := openStore(t, dir)
+			populate(t, s)
+			if tt.snapshot {
+				if err := s.Snapshot(); err != nil {
+					t.Fatal(err)
+				}
+				if n := logSize(t, dir); n != 0 {
+					t.Errorf("log is %d bytes after a snapshot, want 0", n)
+				}
+			}
+
+			s = openStore(t, dir)
+			if _, err := s.Users().Authorize("alice", "password1"); err != nil {
+				t.Errorf("alice after reopening: %v", err)
+			}
+			p, err := s.Posts().GetByID("p1")
+			if err != nil {
+				t.Fatalf("p1 after reopening: %v", err)
+			}
+			if p.Score != 1 {
+				t.Errorf("p1 score = %d, want 1", p.Score)
+			}
+			if s.seq != 4 {
+				t.Errorf("seq = %d, want 4", s.seq)
+			}
+		})
+	}
+}
+
+func TestReplayDiscardsUnreadableTail(t *testing.T) {
+	tests := []struct {
+		name string
+		tail string
+	}{
+		{"torn last record", `{"seq":5,"op":"add_post","data":{"id":"p3"`},
+		{"garbage", "\x00\x00\x00\n"},
+		{"corrupt record before good ones", `{"seq":5,"op":` + "\n" + `{"seq":6,"op":"vote","data":{"post_id":"p1","user_id":"u3","vote":1}}` + "\n"},
+		{"record without an op", "{}\n"},
+		{"no tail", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			populate(t, openStore(t, dir))
+			good := logSize(t, dir)
+			f, err := os.OpenFile(filepath.Join(dir, logFile), os.O_WRONLY|os.O_APPEND, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = f.WriteString(tt.tail); err != nil {
+				t.Fatal(err)
+			}
+			f.Close()
+
+			s := openStore(t, dir)
+			if n := logSize(t, dir); n != good {
+				t.Errorf("log is %d bytes after replay, want the %d good ones", n, good)
+			}
+			all, err := s.Posts().GetAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(all) != 2 {
+				t.Errorf("%d posts replayed, want p1 and p2", len(all))
+			}
+			p, _ := s.Posts().GetByID("p1")
+			if p.Score != 1 {
+				t.Errorf("p1 score = %d, want 1 without the vote after the corrupt record", p.Score)
+			}
+
+			side, _ := filepath.Glob(filepath.Join(dir, logFile+".*.discarded"))
+			if want := tt.tail != ""; (len(side) == 1) != want {
+				t.Fatalf("discarded files %v, want one: %v", side, want)
+			}
+			if len(side) == 1 {
+				data, err := os.ReadFile(side[0])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(data) != tt.tail {
+					t.Errorf("discarded %q, want %q", data, tt.tail)
+				}
+			}
+
+			// New records go after the good ones and survive another reopen.
+			if _, err = s.Posts().Add(newPost("p4")); err != nil {
+				t.Fatal(err)
+			}
+			if _, err = openStore(t, dir).Posts().GetByID("p4"); err != nil {
+				t.Errorf("p4 after reopening: %v", err)
+			}
+		})
+	}
+}
+
+func TestFailedMutationIsNotLogged(t *testing.T) {
+	dir := t.TempDir()
+	s := openStore(t, dir)
+	populate(t, s)
+	size := logSize(t, dir)
+
+	tests := []struct {
+		name string
+		do   func() error
+	}{
+		{"vote on a missing post", func() error { _, err := s.Posts().Vote("missing", "u2", 1); return err }},
+		{"duplicate post", func() error { _, err := s.Posts().Add(newPost("p1")); return err }},
+		{"duplicate user", func() error { _, err := s.Users().Register("alice", "password2"); return err }},
+		{"missing user", func() error { _, err := s.Users().SetAdmin("bob", true); return err }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.do(); err == nil {
+				t.Fatal("succeeded")
+			}
+			if n := logSize(t, dir); n != size {
+				t.Errorf("log grew from %d to %d bytes", size, n)
+			}
+			if s.seq != 4 || s.pending != 4 {
+				t.Errorf("seq %d with %d pending, want 4 and 4", s.seq, s.pending)
+			}
+		})
+	}
+
+	// An empty purge logs nothing either.
+	if n, err := s.Posts().Purge(time.Now()); err != nil || n != 0 {
+		t.Fatalf("Purge = %d, %v, want nothing purged", n, err)
+	}
+	if n := logSize(t, dir); n != size {
+		t.Errorf("empty purge grew the log from %d to %d bytes", size, n)
+	}
+
+	// The next record follows straight on.
+	if _, err := s.Posts().Vote("p2", "u2", 1); err != nil {
+		t.Fatal(err)
+	}
+	s = openStore(t, dir)
+	if s.seq != 5 {
+		t.Errorf("seq after reopening = %d, want 5", s.seq)
+	}
+}
+
+func TestUserChangesLogHashes(t *testing.T) {
+	dir := t.TempDir()
+	s := openStore(t, dir)
+	users := s.Users()
+	if _, err := users.Register("alice", "password1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.SetPassword("alice", "password2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.SetEmail("alice", "alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, logFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, password := range []string{"password1", "password2"} {
+		if bytes.Contains(data, []byte(password)) {
+			t.Errorf("log contains the plain-text %s", password)
+		}
+	}
+
+	u, err := openStore(t, dir).Users().Authorize("alice", "password2")
+	if err != nil {
+		t.Fatalf("new password after reopening: %v", err)
+	}
+	if u.Email != "alice@example.com" {
+		t.Errorf("email after reopening = %q", u.Email)
+	}
+}
+
+// TestParallelMutationsReplay checks that the log keeps the order in which
+// concurrent mutations were applied, so that replaying it gives the same
+// state. Run with -race.
+func TestParallelMutationsReplay(t *testing.T) {
+	const workers, rounds = 8, 25
+	dir := t.TempDir()
+	s := openStore(t, dir)
+	populate(t, s)
+	posts := s.Posts()
+
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Go(func() {
+			for i := range rounds {
+				user := fmt.Sprintf("u%d", w)
+				if _, err := posts.Vote("p2", user, 1-2*(i%2)); err != nil {
+					t.Error(err)
+					return
+				}
+				if w%2 == 0 && i == rounds/2 {
+					if err := s.Snapshot(); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}
+		})
+	}
+	wg.Wait()
+
+	want, err := posts.GetByID("p2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := openStore(t, dir).Posts().GetByID("p2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Score != want.Score || len(got.Votes) != len(want.Votes) {
+		t.Errorf("score after reopening = %d from %d votes, want %d from %d", got.Score, len(got.Votes), want.Score, len(want.Votes))
+	}
+}
+
+// TestViewsLeftToSnapshots checks that views are kept out of the log and
+// persisted by the next snapshot, even one with no logged operation to
+// write. Run with -race.
+func TestViewsLeftToSnapshots(t *testing.T) {
+	const workers, rounds = 8, 25
+	dir := t.TempDir()
+	s := openStore(t, dir)
+	populate(t, s)
+	if err := s.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	posts := s.Posts()
+
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Go(func() {
+			for i := range rounds {
+				if _, err := posts.IncrementViews("p1"); err != nil {
+					t.Error(err)
+					return
+				}
+				if w%2 == 0 && i == rounds/2 {
+					if err := s.Snapshot(); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}
+		})
+	}
+	wg.Wait()
+	if n := logSize(t, dir); n != 0 {
+		t.Errorf("views grew the log to %d bytes", n)
+	}
+
+	if err := s.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	p, err := openStore(t, dir).Posts().GetByID("p1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Views != workers*rounds {
+		t.Errorf("views after reopening = %d, want %d", p.Views, workers*rounds)
+	}
+}
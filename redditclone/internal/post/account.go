@@ -0,0 +1,306 @@
+package post
+
+import (
+	"database/sql"
+	"errors"
+	"slices"
+)
+
+// VotePolicy says what becomes of the votes of a deleted account.
+type VotePolicy string
+
+const (
+	// KeepVotes leaves the votes counted under an empty user ID, so that
+	// they no longer lead to anyone.
+	KeepVotes VotePolicy = "keep"
+	// RemoveVotes withdraws the votes, taking back the score and karma they
+	// gave.
+	RemoveVotes VotePolicy = "remove"
+)
+
+var ErrBadVotePolicy = errors.New("vote policy must be keep or remove")
+
+// ParseVotePolicy checks a policy given by name.
+func ParseVotePolicy(s string) (VotePolicy, error) {
+	switch p := VotePolicy(s); p {
+	case KeepVotes, RemoveVotes:
+		return p, nil
+	}
+	return "", ErrBadVotePolicy
+}
+
+// CastVote is a vote a user cast on a post or, when CommentID is set, on
+// one of its comments.
+type CastVote struct {
+	PostID    string `json:"post_id"`
+	CommentID string `json:"comment_id,omitempty"`
+	Vote      int    `json:"vote"`
+}
+
+// Activity is what a user contributed, deleted content included. Posts
+// come without their comments; the user's own comments are in Comments.
+type Activity struct {
+	Posts    []*Post        `json:"posts"`
+	Comments []*UserComment `json:"comments"`
+	Votes    []*CastVote    `json:"votes"`
+}
+
+func newActivity() *Activity {
+	return &Activity{Posts: []*Post{}, Comments: []*UserComment{}, Votes: []*CastVote{}}
+}
+
+// deletedAuthor is the author of the content of deleted accounts.
+func deletedAuthor() *Author {
+	return &Author{Username: DeletedPlaceholder}
+}
+
+func (r *MemoryRepo) Activity(userID string) (*Activity, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	a := newActivity()
+	for _, e := range sortedEntries(r.byID) {
+		p := e.post
+		if p.Author != nil && p.Author.ID == userID {
+			cp := p.Clone()
+			cp.Comments = []*Comment{}
+			a.Posts = append(a.Posts, cp)
+		}
+		if v := findVote(p.Votes, userID); v != 0 {
+			a.Votes = append(a.Votes, &CastVote{PostID: p.ID, Vote: v})
+		}
+		for _, c := range p.Comments {
+			if c.Author != nil && c.Author.ID == userID {
+				a.Comments = append(a.Comments, &UserComment{
+					Comment:   c.clone(),
+					PostID:    p.ID,
+					PostTitle: p.Title,
+					Category:  p.Category,
+				})
+			}
+			if v := findVote(c.Votes, userID); v != 0 {
+				a.Votes = append(a.Votes, &CastVote{PostID: p.ID, CommentID: c.ID, Vote: v})
+			}
+		}
+	}
+	return a, nil
+}
+
+func (r *MemoryRepo) Forget(userID string, votes VotePolicy) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, e := range r.byID {
+		p := e.post
+		if votes == RemoveVotes {
+			if findVote(p.Votes, userID) != 0 {
+				r.unrank(e)
+				earned := postKarma(p)
+				p.Vote(userID, 0)
+				r.credit(p.Author, postKarma(p)-earned, 0)
+				r.rank(e)
+			}
+			for _, c := range p.Comments {
+				if findVote(c.Votes, userID) != 0 {
+					earned := commentKarma(c)
+					c.Votes = castVote(c.Votes, &c.Score, userID, 0)
+					r.credit(c.Author, 0, commentKarma(c)-earned)
+				}
+			}
+		} else {
+			anonymize(p.Votes, userID)
+			for _, c := range p.Comments {
+				anonymize(c.Votes, userID)
+			}
+		}
+
+		if p.Author != nil && p.Author.ID == userID {
+			r.unrank(e)
+			p.Author = deletedAuthor()
+			r.rank(e)
+		}
+		for _, c := range p.Comments {
+			if c.Author != nil && c.Author.ID == userID {
+				r.unindexComment(c)
+				c.Author = deletedAuthor()
+				r.indexComment(e, c)
+			}
+		}
+		delete(e.savedBy, userID)
+		delete(e.hiddenBy, userID)
+	}
+	delete(r.saved, userID)
+	delete(r.hidden, userID)
+	delete(r.karma, userID)
+	return nil
+}
+
+// anonymize clears the user of their vote among votes, if any.
+func anonymize(votes []*Vote, userID string) {
+	for _, v := range votes {
+		if v.User == userID {
+			v.User = ""
+		}
+	}
+}
+
+// findVote returns the vote of the user among votes, 0 if none.
+func findVote(votes []*Vote, userID string) int {
+	i := slices.IndexFunc(votes, func(v *Vote) bool { return v.User == userID })
+	if i < 0 {
+		return 0
+	}
+	return votes[i].Vote
+}
+
+func (r *SQLRepo) Activity(userID string) (*Activity, error) {
+	a := newActivity()
+	err := r.read(func(tx *sql.Tx) error {
+		posts, err := queryPosts(tx, `author_id = ?`, userID)
+		if err != nil {
+			return err
+		}
+		for _, p := range posts {
+			p.Comments = []*Comment{}
+		}
+		a.Posts = posts
+
+		rows, err := tx.Query(`SELECT `+commentColumns+`, p.id, p.title, p.category FROM comments c JOIN posts p ON p.id = c.post_id
+			WHERE c.author_id = ? ORDER BY p.seq, c.created, c.rowid`, userID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		byID := make(map[string]*Comment)
+		for rows.Next() {
+			uc := &UserComment{}
+			uc.Comment, err = scanComment(rows, &uc.PostID, &uc.PostTitle, &uc.Category)
+			if err != nil {
+				return err
+			}
+			a.Comments = append(a.Comments, uc)
+			byID[uc.ID] = uc.Comment
+		}
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		commentAuthor := `comment_id IN (SELECT id FROM comments WHERE author_id = ?)`
+		if err = loadCommentVotes(tx, byID, commentAuthor, []any{userID}); err != nil {
+			return err
+		}
+
+		rows, err = tx.Query(`SELECT post_id, '', vote FROM votes WHERE user_id = ?
+			UNION ALL SELECT post_id, comment_id, vote FROM comment_votes WHERE user_id = ?`, userID, userID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			v := &CastVote{}
+			if err = rows.Scan(&v.PostID, &v.CommentID, &v.Vote); err != nil {
+				return err
+			}
+			a.Votes = append(a.Votes, v)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (r *SQLRepo) Forget(userID string, votes VotePolicy) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if votes == RemoveVotes {
+		if err = withdrawVotes(tx, userID); err != nil {
+			return err
+		}
+	}
+	for _, table := range []string{"votes", "comment_votes"} {
+		if _, err = tx.Exec(`UPDATE `+table+` SET user_id = '' WHERE user_id = ?`, userID); err != nil {
+			return err
+		}
+	}
+	for _, table := range []string{"posts", "comments"} {
+		_, err = tx.Exec(`UPDATE `+table+` SET author_id = '', author_username = ? WHERE author_id = ?`,
+			DeletedPlaceholder, userID)
+		if err != nil {
+			return err
+		}
+	}
+	for _, table := range []string{"saved_posts", "hidden_posts", "karma"} {
+		if _, err = tx.Exec(`DELETE FROM `+table+` WHERE user_id = ?`, userID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// withdrawVotes removes every vote of a user, taking back the score,
+// counts and karma they gave, and reranks the posts voted on.
+func withdrawVotes(tx *sql.Tx, userID string) error {
+	_, err := tx.Exec(`UPDATE karma SET post = post - (SELECT COALESCE(SUM(v.vote), 0)
+			FROM votes v JOIN posts p ON p.id = v.post_id
+			WHERE v.user_id = ? AND p.author_id = karma.user_id AND p.author_id != ?)
+		WHERE user_id IN (SELECT p.author_id FROM votes v JOIN posts p ON p.id = v.post_id WHERE v.user_id = ?)`,
+		userID, userID, userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE karma SET comment = comment - (SELECT COALESCE(SUM(v.vote), 0)
+			FROM comment_votes v JOIN comments c ON c.id = v.comment_id
+			WHERE v.user_id = ? AND c.author_id = karma.user_id AND c.author_id != ?)
+		WHERE user_id IN (SELECT c.author_id FROM comment_votes v JOIN comments c ON c.id = v.comment_id WHERE v.user_id = ?)`,
+		userID, userID, userID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(`SELECT post_id, vote FROM votes WHERE user_id = ?`, userID)
+	if err != nil {
+		return err
+	}
+	votes := make(map[string]int)
+	for rows.Next() {
+		var postID string
+		var vote int
+		if err = rows.Scan(&postID, &vote); err != nil {
+			rows.Close()
+			return err
+		}
+		votes[postID] = vote
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	for postID, vote := range votes {
+		_, err = tx.Exec(`UPDATE posts SET score = score - ?, ups = ups - ?, downs = downs - ? WHERE id = ?`,
+			vote, tally(vote, 1), tally(vote, -1), postID)
+		if err != nil {
+			return err
+		}
+		if err = rerank(tx, postID); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`UPDATE comments SET score = score - (SELECT v.vote FROM comment_votes v
+			WHERE v.comment_id = comments.id AND v.user_id = ?)
+		WHERE id IN (SELECT comment_id FROM comment_votes WHERE user_id = ?)`, userID, userID)
+	if err != nil {
+		return err
+	}
+	for _, table := range []string{"votes", "comment_votes"} {
+		if _, err = tx.Exec(`DELETE FROM `+table+` WHERE user_id = ?`, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package post
+
+import "testing"
+
+func TestRepoForgetVotes(t *testing.T) {
+	tests := []struct {
+		policy        VotePolicy
+		postScore     int
+		postVoters    []string
+		commentScore  int
+		commentVoters []string
+		karma         Karma // of alice
+	}{
+		{KeepVotes, 1, []string{"", "", "id-bob"}, 0, []string{"", ""}, Karma{Post: 1}},
+		{RemoveVotes, -1, []string{"id-bob"}, 0, nil, Karma{Post: -1}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			forEachRepo(t, func(t *testing.T, r Repo) {
+				addPosts(t, r, newPost("p1", "music", "alice", 0))
+				c := NewComment(&Author{ID: "id-alice", Username: "alice"}, "on p1")
+				_, err := r.AddComment("p1", c)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, v := range []struct {
+					user        string
+					post, onCom int
+				}{{"u1", 1, 1}, {"u2", 1, -1}, {"id-bob", -1, 0}} {
+					if _, err = r.Vote("p1", v.user, v.post); err != nil {
+						t.Fatal(err)
+					}
+					if _, err = r.VoteComment("p1", c.ID, v.user, v.onCom); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				// Two users who voted alike are forgotten.
+				for _, user := range []string{"u1", "u2"} {
+					if err = r.Forget(user, tt.policy); err != nil {
+						t.Fatal(err)
+					}
+				}
+				p, err := r.GetByID("p1")
+				if err != nil {
+					t.Fatal(err)
+				}
+				voters := func(votes []*Vote) []string {
+					var users []string
+					for _, v := range votes {
+						users = append(users, v.User)
+					}
+					return users
+				}
+				if p.Score != tt.postScore || !equal(voters(p.Votes), tt.postVoters) {
+					t.Errorf("post score %d with votes of %q, want %d with %q",
+						p.Score, voters(p.Votes), tt.postScore, tt.postVoters)
+				}
+				if pc := p.Comments[0]; pc.Score != tt.commentScore || !equal(voters(pc.Votes), tt.commentVoters) {
+					t.Errorf("comment score %d with votes of %q, want %d with %q",
+						pc.Score, voters(pc.Votes), tt.commentScore, tt.commentVoters)
+				}
+				if k, err := r.Karma("id-alice"); err != nil || k != tt.karma {
+					t.Errorf("karma of alice = %+v, %v, want %+v", k, err, tt.karma)
+				}
+				a, err := r.Activity("u1")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(a.Votes) != 0 {
+					t.Errorf("votes of the forgotten u1 = %+v, want none", a.Votes)
+				}
+
+				// The votes left behind do not stand in the way of new ones.
+				if p, err = r.Vote("p1", "id-bob", 1); err != nil {
+					t.Fatal(err)
+				}
+				if want := tt.postScore + 2; p.Score != want {
+					t.Errorf("score after bob changed their vote = %d, want %d", p.Score, want)
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package post
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// TestRepoParallelMutations runs votes, comments, views and reads on the
+// same post from many goroutines. Run with -race: readers scribble over the
+// copies they get, which must not reach the stored post.
+func TestRepoParallelMutations(t *testing.T) {
+	const workers, rounds = 8, 10
+	forEachRepo(t, func(t *testing.T, r Repo) {
+		addPosts(t, r, newPost("p1", "music", "alice", 0))
+
+		ops := []struct {
+			name string
+			do   func(worker, round int) error
+		}{
+			{"vote", func(w, i int) error {
+				// Every worker ends up upvoting once, whatever it voted before.
+				vote := 1
+				if i%2 == 0 && i != rounds-2 {
+					vote = -1
+				}
+				_, err := r.Vote("p1", fmt.Sprintf("u%d", w), vote)
+				return err
+			}},
+			{"comment", func(w, i int) error {
+				_, err := r.AddComment("p1", NewComment(&Author{ID: fmt.Sprintf("u%d", w), Username: fmt.Sprintf("user%d", w)}, "hi"))
+				return err
+			}},
+			{"view", func(w, i int) error {
+				p, err := r.IncrementViews("p1")
+				if err == nil {
+					p.Views = -1
+					p.Votes = nil
+				}
+				return err
+			}},
+			{"read", func(w, i int) error {
+				posts, err := r.GetAll()
+				if err != nil {
+					return err
+				}
+				for _, p := range posts {
+					p.Score = -100
+					p.Author.Username = "mallory"
+					for _, c := range p.Comments {
+						c.Body = ""
+					}
+				}
+				return nil
+			}},
+			{"list", func(w, i int) error {
+				page, err := r.List(ListQuery{Sort: SortHot, Limit: 10})
+				if err == nil && len(page.Posts) > 0 {
+					page.Posts[0].Comments = nil
+				}
+				return err
+			}},
+		}
+
+		var wg sync.WaitGroup
+		errs := make(chan error, workers*len(ops))
+		for w := range workers {
+			for _, op := range ops {
+				wg.Go(func() {
+					for i := range rounds {
+						if err := op.do(w, i); err != nil {
+							errs <- fmt.Errorf("%s: %w", op.name, err)
+							return
+						}
+					}
+				})
+			}
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Error(err)
+		}
+
+		p, err := r.GetByID("p1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Score != workers || len(p.Votes) != workers {
+			t.Errorf("score %d from %d votes, want %d upvotes", p.Score, len(p.Votes), workers)
+		}
+		if p.Views != workers*rounds {
+			t.Errorf("views = %d, want %d", p.Views, workers*rounds)
+		}
+		if len(p.Comments) != workers*rounds {
+			t.Errorf("%d comments, want %d", len(p.Comments), workers*rounds)
+		}
+		if p.Author.Username != "alice" {
+			t.Errorf("author = %q, changed through a copy", p.Author.Username)
+		}
+		for _, c := range p.Comments {
+			if c.Body != "hi" {
+				t.Fatalf("comment body = %q, changed through a copy", c.Body)
+			}
+		}
+	})
+}
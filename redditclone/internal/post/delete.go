@@ -0,0 +1,125 @@
+package post
+
+import (
+	"context"
+	"log"
+	"slices"
+	"time"
+)
+
+// DeletedPlaceholder replaces the content and author name of deleted items.
+const DeletedPlaceholder = "[deleted]"
+
+func (p *Post) IsDeleted() bool {
+	return !p.Deleted.IsZero()
+}
+
+func (c *Comment) IsDeleted() bool {
+	return !c.Deleted.IsZero()
+}
+
+// DeleteComment marks a comment as deleted; it stays in the thread until purged.
+func (p *Post) DeleteComment(commentID string, deleted time.Time) error {
+	c := p.comment(commentID)
+	if c == nil {
+		return ErrCommentNotFound
+	}
+	if c.IsDeleted() {
+		return ErrDeleted
+	}
+	c.Deleted = deleted
+	return nil
+}
+
+func (p *Post) RestoreComment(commentID string) error {
+	c := p.comment(commentID)
+	if c == nil {
+		return ErrCommentNotFound
+	}
+	if !c.IsDeleted() {
+		return ErrNotDeleted
+	}
+	c.Deleted = time.Time{}
+	return nil
+}
+
+// PurgeComments removes comments deleted before the given time and returns
+// them. Deleted comments with remaining replies are kept as tombstones so the
+// replies stay in their thread.
+func (p *Post) PurgeComments(before time.Time) []*Comment {
+	// Replies are deeper than their parents, so visiting the deepest
+	// comments first settles every reply before its parent.
+	byDepth := slices.Clone(p.Comments)
+	slices.SortStableFunc(byDepth, func(a, b *Comment) int { return b.Depth - a.Depth })
+	replies := make(map[string]int)
+	purge := make(map[*Comment]bool)
+	for _, c := range byDepth {
+		if c.IsDeleted() && c.Deleted.Before(before) && replies[c.ID] == 0 {
+			purge[c] = true
+		} else if c.ParentID != "" {
+			replies[c.ParentID]++
+		}
+	}
+
+	var purged []*Comment
+	kept := p.Comments[:0]
+	for _, c := range p.Comments {
+		if purge[c] {
+			purged = append(purged, c)
+		} else {
+			kept = append(kept, c)
+		}
+	}
+	clear(p.Comments[len(kept):])
+	p.Comments = kept
+	return purged
+}
+
+// Redact hides the content and authors of the post and of its comments if
+// they are deleted; replies to a deleted comment stay under its tombstone.
+// It is meant for copies about to be sent to clients.
+func (p *Post) Redact() {
+	if p.IsDeleted() {
+		p.Title = DeletedPlaceholder
+		p.URL = ""
+		p.Text = DeletedPlaceholder
+		p.Author = &Author{Username: DeletedPlaceholder}
+	}
+	for _, c := range p.Comments {
+		if c.IsDeleted() {
+			c.Body = DeletedPlaceholder
+			c.Author = &Author{Username: DeletedPlaceholder}
+		}
+	}
+}
+
+func (p *Post) comment(commentID string) *Comment {
+	for _, c := range p.Comments {
+		if c.ID == commentID {
+			return c
+		}
+	}
+	return nil
+}
+
+// RunPurge hard-deletes posts and comments that were deleted more than
+// retention ago, checking every interval until ctx is done.
+func RunPurge(ctx context.Context, repo Repo, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := repo.Purge(time.Now().Add(-retention))
+			if err != nil {
+				log.Printf("purge failed: %v", err)
+				continue
+			}
+			if n > 0 {
+				log.Printf("purged %d deleted posts and comments", n)
+			}
+		}
+	}
+}
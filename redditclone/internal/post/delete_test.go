@@ -0,0 +1,67 @@
+package post
+
+import (
+	"testing"
+	"time"
+)
+
+// TestRepoPurgeComparesInstants deletes a post and a comment at noon UTC,
+// written in another zone, and purges with cutoffs in various zones: only
+// the instant may matter, not how it is written.
+func TestRepoPurgeComparesInstants(t *testing.T) {
+	mst := time.FixedZone("MST", -7*3600)
+	jst := time.FixedZone("JST", 9*3600)
+	deleted := time.Date(2024, 3, 1, 5, 0, 0, 0, mst) // 12:00 UTC
+	tests := []struct {
+		name   string
+		before time.Time
+		purged bool
+	}{
+		{"earlier in UTC", time.Date(2024, 3, 1, 11, 0, 0, 0, time.UTC), false},
+		{"earlier, written later in the day", time.Date(2024, 3, 1, 20, 0, 0, 0, jst), false},
+		{"same instant", time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), false},
+		{"later in UTC", time.Date(2024, 3, 1, 13, 0, 0, 0, time.UTC), true},
+		{"later, written earlier in the day", time.Date(2024, 3, 1, 6, 0, 0, 0, mst), true},
+		{"later, on the next day", time.Date(2024, 3, 2, 0, 0, 0, 0, jst), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forEachRepo(t, func(t *testing.T, r Repo) {
+				addPosts(t, r, newPost("p1", "music", "alice", 0), newPost("p2", "music", "bob", 1))
+				p, err := r.AddComment("p2", NewComment(&Author{ID: "id-alice", Username: "alice"}, "hi"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				commentID := p.Comments[0].ID
+				if err = r.Delete("p1", deleted); err != nil {
+					t.Fatal(err)
+				}
+				if _, err = r.DeleteComment("p2", commentID, deleted); err != nil {
+					t.Fatal(err)
+				}
+
+				n, err := r.Purge(tt.before)
+				if err != nil {
+					t.Fatal(err)
+				}
+				want := 0
+				if tt.purged {
+					want = 2
+				}
+				if n != want {
+					t.Errorf("Purge(%v) = %d, want %d", tt.before, n, want)
+				}
+				if _, err = r.GetByID("p1"); (err == ErrNotFound) != tt.purged {
+					t.Errorf("GetByID(p1) error = %v, want purged %v", err, tt.purged)
+				}
+				p, err = r.GetByID("p2")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := len(p.Comments) == 0; got != tt.purged {
+					t.Errorf("p2 has %d comments, want purged %v", len(p.Comments), tt.purged)
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package post
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"slices"
+	"time"
+)
+
+var ErrBadCursor = errors.New("bad cursor")
+
+// Sort is the order of a listing.
+type Sort string
+
+const (
+	SortHot           Sort = "hot"           // score decayed by age
+	SortNew           Sort = "new"           // newest first
+	SortTop           Sort = "top"           // highest score first
+	SortRising        Sort = "rising"        // fastest growing recent posts
+	SortControversial Sort = "controversial" // many votes, evenly split
+)
+
+// Valid reports whether s is a known sort.
+func (s Sort) Valid() bool {
+	switch s {
+	case SortHot, SortNew, SortTop, SortRising, SortControversial:
+		return true
+	}
+	return false
+}
+
+const (
+	DefaultPageSize = 25
+	MaxPageSize     = 100
+)
+
+// ListQuery selects a page of a listing. Category and Author narrow the
+// listing down when set; a non-nil Categories keeps the posts of any of the
+// given categories, as the home feed does. Posts hidden by the Viewer, a
+// user ID, are left out. After and Before are cursors taken from a previous
+// Page; at most one of them should be set. A zero Limit returns everything.
+// Window limits top and controversial listings to posts created within it;
+// zero means all time. Now is the reference time for windows and rising
+// scores; it defaults to the current time, or to the time the cursor was
+// issued when paging.
+type ListQuery struct {
+	Category   string
+	Categories []string
+	Author     string
+	Viewer     string
+	Sort       Sort
+	Window     time.Duration
+	Limit      int
+	After      string
+	Before     string
+	Now        time.Time
+}
+
+// Page is one page of a listing. Next and Prev are empty when there is
+// nothing further in that direction.
+type Page struct {
+	Posts []*Post `json:"posts"`
+	Next  string  `json:"next,omitempty"`
+	Prev  string  `json:"prev,omitempty"`
+}
+
+// cursor is a position in a listing: the sort key of a post and its
+// insertion sequence number as a tie breaker. Pages are defined relative to
+// these values rather than to offsets, so posts inserted or voted on while a
+// client pages through a listing do not shift the remaining pages. At is the
+// reference time of the first page, so windows and rising scores stay put.
+type cursor struct {
+	Sort Sort    `json:"o"`
+	Key  float64 `json:"k"`
+	Seq  int64   `json:"s"`
+	At   int64   `json:"t"`
+}
+
+func (c cursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// less reports whether c comes after other in descending listing order.
+func (c cursor) less(other cursor) bool {
+	if c.Key != other.Key {
+		return c.Key < other.Key
+	}
+	return c.Seq < other.Seq
+}
+
+func decodeCursor(s string, sort Sort) (*cursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrBadCursor
+	}
+	var c cursor
+	if err = json.Unmarshal(data, &c); err != nil || c.Sort != sort {
+		return nil, ErrBadCursor
+	}
+	return &c, nil
+}
+
+// Normalize fills in defaults and validates the query.
+func (q *ListQuery) Normalize() error {
+	if q.Sort == "" {
+		q.Sort = SortTop
+	}
+	if !q.Sort.Valid() {
+		return errors.New("unknown sort")
+	}
+	if q.Window < 0 {
+		return errors.New("negative window")
+	}
+	if q.Limit < 0 {
+		return errors.New("negative limit")
+	}
+	q.Limit = min(q.Limit, MaxPageSize)
+	if q.After != "" && q.Before != "" {
+		return errors.New("after and before are mutually exclusive")
+	}
+	if q.Category != "" && q.Categories != nil {
+		return errors.New("category and categories are mutually exclusive")
+	}
+	if q.Categories != nil {
+		categories := slices.Clone(q.Categories)
+		slices.Sort(categories)
+		q.Categories = slices.Compact(categories)
+	}
+	c, err := decodeCursor(q.After+q.Before, q.Sort)
+	switch {
+	case err != nil:
+		return err
+	case c != nil:
+		q.Now = time.Unix(c.At, 0)
+	case q.Now.IsZero():
+		q.Now = time.Now()
+	}
+	q.Now = q.Now.Truncate(time.Second)
+	return nil
+}
+
+// ranked is a post with its position in a listing.
+type ranked struct {
+	post *Post
+	pos  cursor
+}
+
+// newPage builds a page out of items fetched in the direction of the query:
+// in listing order after the After cursor, or backwards from the Before
+// cursor. With a limit, the repos fetch one item more than fits on the page
+// to tell whether there is anything beyond it.
+func newPage(q ListQuery, items []ranked, after, before *cursor) *Page {
+	more := q.Limit > 0 && len(items) > q.Limit
+	if more {
+		items = items[:q.Limit]
+	}
+	if before != nil {
+		slices.Reverse(items)
+	}
+
+	page := &Page{Posts: make([]*Post, len(items))}
+	for i, it := range items {
+		page.Posts[i] = it.post
+	}
+	if q.Limit == 0 || len(items) == 0 {
+		return page
+	}
+
+	first, last := items[0].pos, items[len(items)-1].pos
+	first.Sort, first.At = q.Sort, q.Now.Unix()
+	last.Sort, last.At = q.Sort, q.Now.Unix()
+	if before != nil {
+		page.Next = last.encode()
+		if more {
+			page.Prev = first.encode()
+		}
+	} else {
+		if more {
+			page.Next = last.encode()
+		}
+		if after != nil {
+			page.Prev = first.encode()
+		}
+	}
+	return page
+}
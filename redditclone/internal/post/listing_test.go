@@ -0,0 +1,258 @@
+package post
+
+import (
+	"encoding/base64"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDecodeCursor(t *testing.T) {
+	valid := cursor{Sort: SortHot, Key: 1.5, Seq: 7, At: 1700000000}
+	tests := []struct {
+		name string
+		s    string
+		sort Sort
+		want *cursor
+		err  error
+	}{
+		{"empty", "", SortHot, nil, nil},
+		{"round trip", valid.encode(), SortHot, &valid, nil},
+		{"other sort", valid.encode(), SortTop, nil, ErrBadCursor},
+		{"not base64", "!!!", SortHot, nil, ErrBadCursor},
+		{"not json", base64.RawURLEncoding.EncodeToString([]byte("{")), SortHot, nil, ErrBadCursor},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeCursor(tt.s, tt.sort)
+			if err != tt.err {
+				t.Fatalf("error = %v, want %v", err, tt.err)
+			}
+			if (got == nil) != (tt.want == nil) || got != nil && *got != *tt.want {
+				t.Errorf("decodeCursor = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// addVotedPosts adds n posts to music, the i-th with i upvotes and i%3
+// downvotes, so that every sort orders them differently and some tie.
+func addVotedPosts(t *testing.T, r Repo, n int) {
+	t.Helper()
+	for i := range n {
+		id := fmt.Sprintf("p%d", i)
+		addPosts(t, r, newPost(id, "music", "alice", i))
+		for u := range i + i%3 {
+			vote := 1
+			if u >= i {
+				vote = -1
+			}
+			if _, err := r.Vote(id, fmt.Sprintf("u%d", u), vote); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+// TestRepoListPages pages through every sort forwards to the end and back
+// to the start: the pages must join up into the whole listing.
+func TestRepoListPages(t *testing.T) {
+	sorts := []Sort{SortHot, SortNew, SortTop, SortRising, SortControversial}
+	for _, sort := range sorts {
+		t.Run(string(sort), func(t *testing.T) {
+			forEachRepo(t, func(t *testing.T, r Repo) {
+				addVotedPosts(t, r, 10)
+				now := testTime.Add(time.Hour)
+				all, err := r.List(ListQuery{Sort: sort, Now: now})
+				if err != nil {
+					t.Fatal(err)
+				}
+				want := ids(all.Posts)
+				if len(want) != 10 {
+					t.Fatalf("listing has %d posts, want 10", len(want))
+				}
+
+				var forward, backward []string
+				q := ListQuery{Sort: sort, Limit: 3, Now: now}
+				var last *Page
+				for pages := 0; ; pages++ {
+					if pages > 10 {
+						t.Fatal("paging forwards does not end")
+					}
+					page, err := r.List(q)
+					if err != nil {
+						t.Fatal(err)
+					}
+					forward = append(forward, ids(page.Posts)...)
+					if (page.Prev != "") != (pages > 0) {
+						t.Errorf("page %d has prev %q", pages, page.Prev)
+					}
+					last = page
+					if page.Next == "" {
+						break
+					}
+					q.After = page.Next
+				}
+				if !equal(forward, want) {
+					t.Errorf("paging forwards = %v, want %v", forward, want)
+				}
+
+				backward = ids(last.Posts)
+				q = ListQuery{Sort: sort, Limit: 3, Before: last.Prev}
+				for pages := 0; q.Before != ""; pages++ {
+					if pages > 10 {
+						t.Fatal("paging backwards does not end")
+					}
+					page, err := r.List(q)
+					if err != nil {
+						t.Fatal(err)
+					}
+					backward = append(ids(page.Posts), backward...)
+					q.Before = page.Prev
+				}
+				if !equal(backward, want) {
+					t.Errorf("paging backwards = %v, want %v", backward, want)
+				}
+			})
+		})
+	}
+}
+
+// TestRepoListIsConsistent lists posts while their votes change: every
+// listed post must carry the votes its score was computed from. Run with
+// -race.
+func TestRepoListIsConsistent(t *testing.T) {
+	const workers, rounds = 4, 50
+	forEachRepo(t, func(t *testing.T, r Repo) {
+		addVotedPosts(t, r, 5)
+
+		var wg sync.WaitGroup
+		for w := range workers {
+			wg.Go(func() {
+				for i := range rounds {
+					id := fmt.Sprintf("p%d", i%5)
+					if _, err := r.Vote(id, fmt.Sprintf("voter%d", w), 1-2*(i%2)); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			})
+		}
+		for range workers {
+			wg.Go(func() {
+				for range rounds {
+					page, err := r.List(ListQuery{Sort: SortTop, Limit: 5})
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					for _, p := range page.Posts {
+						sum := 0
+						for _, v := range p.Votes {
+							sum += v.Vote
+						}
+						if sum != p.Score {
+							t.Errorf("%s has score %d and votes adding up to %d", p.ID, p.Score, sum)
+							return
+						}
+					}
+					scores := make([]int, len(page.Posts))
+					for i, p := range page.Posts {
+						scores[i] = -p.Score
+					}
+					if !slices.IsSorted(scores) {
+						t.Errorf("top listing out of order: %v", ids(page.Posts))
+						return
+					}
+				}
+			})
+		}
+		wg.Wait()
+	})
+}
+
+// TestRepoListFeed lists the home feed of categories merged together: it
+// must keep the order of the sort across categories and page like any
+// other listing.
+func TestRepoListFeed(t *testing.T) {
+	forEachRepo(t, func(t *testing.T, r Repo) {
+		scores := []struct {
+			id, category string
+			score        int
+		}{
+			{"m1", "music", 2},
+			{"n1", "news", 5},
+			{"v1", "videos", 9},
+			{"m2", "music", 0},
+			{"n2", "news", 3},
+			{"m3", "music", 4},
+			{"n3", "news", 1},
+		}
+		for i, s := range scores {
+			addPosts(t, r, newPost(s.id, s.category, "alice", i))
+			for u := range s.score {
+				if _, err := r.Vote(s.id, fmt.Sprintf("u%d", u), 1); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		// funny is subscribed to but has no posts.
+		feed := []string{"news", "funny", "music"}
+
+		tests := []struct {
+			sort Sort
+			want []string
+		}{
+			{SortNew, []string{"n3", "m3", "n2", "m2", "n1", "m1"}},
+			{SortTop, []string{"n1", "m3", "n2", "m1", "n3", "m2"}},
+		}
+		for _, tt := range tests {
+			t.Run(string(tt.sort), func(t *testing.T) {
+				all, err := r.List(ListQuery{Categories: feed, Sort: tt.sort})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := ids(all.Posts); !equal(got, tt.want) {
+					t.Errorf("feed = %v, want %v", got, tt.want)
+				}
+
+				var pages [][]string
+				var prev string
+				q := ListQuery{Categories: feed, Sort: tt.sort, Limit: 4}
+				for {
+					page, err := r.List(q)
+					if err != nil {
+						t.Fatal(err)
+					}
+					pages, prev = append(pages, ids(page.Posts)), page.Prev
+					if page.Next == "" || len(pages) > 3 {
+						break
+					}
+					q.After = page.Next
+				}
+				if len(pages) != 2 || !equal(slices.Concat(pages...), tt.want) {
+					t.Errorf("pages = %v, want %v in pages of 4", pages, tt.want)
+				}
+				back, err := r.List(ListQuery{Categories: feed, Sort: tt.sort, Limit: 4, Before: prev})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := ids(back.Posts); !equal(got, tt.want[:4]) {
+					t.Errorf("page before the last = %v, want %v", got, tt.want[:4])
+				}
+			})
+		}
+
+		for _, categories := range [][]string{{}, {"funny"}, {"nosuch"}} {
+			page, err := r.List(ListQuery{Categories: categories, Sort: SortHot, Limit: 4})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(page.Posts) != 0 || page.Next != "" || page.Prev != "" {
+				t.Errorf("feed of %q = %v, next %q, want an empty page", categories, ids(page.Posts), page.Next)
+			}
+		}
+	})
+}
@@ -0,0 +1,434 @@
+package post
+
+import (
+	"errors"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// entry is a stored post together with its insertion sequence number,
+// which keeps listings in insertion order regardless of map iteration.
+type entry struct {
+	seq       uint64
+	post      *Post
+	revisions []*Revision
+	keys      map[Sort]float64 // sort keys the post is ranked under; nil if unranked
+	savedBy   map[string]int64 // user ID -> when the user saved the post, in Unix microseconds
+	hiddenBy  map[string]bool  // IDs of the users who hid the post
+}
+
+// MemoryRepo keeps posts in memory, indexed by ID so that lookups do not
+// scan the whole data set. Live posts are also kept ranked per category,
+// per author username and overall (see rankSet), so neither lookups by
+// category or author nor listings ever sort.
+// Comments are indexed by author username and karma is kept per author ID.
+// Stored posts are never handed out: readers get clones and writers mutate
+// under the lock.
+type MemoryRepo struct {
+	mu       sync.RWMutex
+	seq      uint64
+	byID     map[string]*entry
+	rankings map[string]*rankSet // by rankScopes
+	// commentsByAuthor maps author usernames to comment IDs and the entries
+	// of the posts holding them.
+	commentsByAuthor map[string]map[string]*entry
+	karma            map[string]*Karma
+	saved            map[string]map[string]*entry // user ID -> post ID -> entry
+	hidden           map[string]map[string]*entry // user ID -> post ID -> entry
+}
+
+func NewMemoryRepo() *MemoryRepo {
+	return &MemoryRepo{
+		byID:             make(map[string]*entry),
+		rankings:         make(map[string]*rankSet),
+		commentsByAuthor: make(map[string]map[string]*entry),
+		karma:            make(map[string]*Karma),
+		saved:            make(map[string]map[string]*entry),
+		hidden:           make(map[string]map[string]*entry),
+	}
+}
+
+func (r *MemoryRepo) GetAll() ([]*Post, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.ranked(""), nil
+}
+
+func (r *MemoryRepo) GetByID(id string) (*Post, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	e, ok := r.byID[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return e.post.Clone(), nil
+}
+
+func (r *MemoryRepo) GetByCategory(category string) ([]*Post, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.ranked("category:" + category), nil
+}
+
+func (r *MemoryRepo) GetByAuthor(authorUsername string) ([]*Post, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.ranked("author:" + authorUsername), nil
+}
+
+func (r *MemoryRepo) List(q ListQuery) (*Page, error) {
+	if err := q.Normalize(); err != nil {
+		return nil, err
+	}
+	after, err := decodeCursor(q.After, q.Sort)
+	if err != nil {
+		return nil, err
+	}
+	before, err := decodeCursor(q.Before, q.Sort)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	accept := func(e *entry) bool {
+		if q.Viewer != "" && e.hiddenBy[q.Viewer] {
+			return false
+		}
+		return q.Author == "" || (e.post.Author != nil && e.post.Author.Username == q.Author)
+	}
+	sets := r.listed(q)
+	var items []ranked
+	if q.since().IsZero() {
+		rankings := make([]*ranking, len(sets))
+		for i, set := range sets {
+			rankings[i] = set.sorts[q.Sort]
+		}
+		walkMerged(rankings, after, before, func(n *rankNode) bool {
+			if q.Limit > 0 && len(items) > q.Limit {
+				return false
+			}
+			if accept(n.e) {
+				items = append(items, ranked{post: n.e.post, pos: n.pos})
+			}
+			return true
+		})
+	} else {
+		items = windowed(q, sets, after, before, accept)
+	}
+
+	page := newPage(q, items, after, before)
+	for i, p := range page.Posts {
+		page.Posts[i] = p.Clone()
+	}
+	return page, nil
+}
+
+func (r *MemoryRepo) Add(post *Post) (*Post, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.byID[post.ID]; ok {
+		return nil, errors.New("post already exists")
+	}
+	r.seq++
+	r.index(&entry{seq: r.seq, post: post.Clone()})
+	return post.Clone(), nil
+}
+
+func (r *MemoryRepo) Delete(id string, deleted time.Time) error {
+	_, err := r.mutate(id, func(e *entry) error {
+		if e.post.IsDeleted() {
+			return ErrDeleted
+		}
+		r.unrank(e)
+		e.post.Deleted = deleted
+		return nil
+	})
+	return err
+}
+
+func (r *MemoryRepo) Restore(id string) (*Post, error) {
+	return r.mutate(id, func(e *entry) error {
+		if !e.post.IsDeleted() {
+			return ErrNotDeleted
+		}
+		e.post.Deleted = time.Time{}
+		r.rank(e)
+		return nil
+	})
+}
+
+func (r *MemoryRepo) Vote(postID, userID string, vote int) (*Post, error) {
+	return r.mutate(postID, func(e *entry) error {
+		if e.post.IsDeleted() {
+			return ErrDeleted
+		}
+		r.unrank(e)
+		earned := postKarma(e.post)
+		e.post.Vote(userID, vote)
+		r.credit(e.post.Author, postKarma(e.post)-earned, 0)
+		r.rank(e)
+		return nil
+	})
+}
+
+func (r *MemoryRepo) AddComment(postID string, comment *Comment) (*Post, error) {
+	return r.mutate(postID, func(e *entry) error {
+		if e.post.IsDeleted() {
+			return ErrDeleted
+		}
+		c := *comment
+		if err := e.post.AddComment(&c); err != nil {
+			return err
+		}
+		r.indexComment(e, &c)
+		r.credit(c.Author, 0, commentKarma(&c))
+		return nil
+	})
+}
+
+func (r *MemoryRepo) VoteComment(postID, commentID, userID string, vote int) (*Post, error) {
+	return r.mutate(postID, func(e *entry) error {
+		if e.post.IsDeleted() {
+			return ErrDeleted
+		}
+		c := e.post.comment(commentID)
+		if c == nil {
+			return ErrCommentNotFound
+		}
+		earned := commentKarma(c)
+		if err := e.post.VoteComment(commentID, userID, vote); err != nil {
+			return err
+		}
+		r.credit(c.Author, 0, commentKarma(c)-earned)
+		return nil
+	})
+}
+
+func (r *MemoryRepo) DeleteComment(postID, commentID string, deleted time.Time) (*Post, error) {
+	return r.mutate(postID, func(e *entry) error {
+		return e.post.DeleteComment(commentID, deleted)
+	})
+}
+
+func (r *MemoryRepo) RestoreComment(postID, commentID string) (*Post, error) {
+	return r.mutate(postID, func(e *entry) error {
+		return e.post.RestoreComment(commentID)
+	})
+}
+
+func (r *MemoryRepo) Purge(before time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	purged := 0
+	for _, e := range r.byID {
+		if e.post.IsDeleted() && e.post.Deleted.Before(before) {
+			r.unindex(e)
+			purged++
+			continue
+		}
+		if removed := e.post.PurgeComments(before); len(removed) > 0 {
+			purged += len(removed)
+			for _, c := range removed {
+				r.unindexComment(c)
+				r.credit(c.Author, 0, -commentKarma(c))
+			}
+			e.revisions = slices.DeleteFunc(e.revisions, func(rev *Revision) bool {
+				return rev.CommentID != "" && e.post.comment(rev.CommentID) == nil
+			})
+		}
+	}
+	return purged, nil
+}
+
+func (r *MemoryRepo) IncrementViews(postID string) (*Post, error) {
+	return r.mutate(postID, func(e *entry) error {
+		e.post.Views++
+		return nil
+	})
+}
+
+func (r *MemoryRepo) Edit(postID string, edit Edit) (*Post, error) {
+	return r.mutate(postID, func(e *entry) error {
+		if e.post.IsDeleted() {
+			return ErrDeleted
+		}
+		e.revisions = append(e.revisions, e.post.Edit(edit))
+		return nil
+	})
+}
+
+func (r *MemoryRepo) EditComment(postID, commentID, body string, edited time.Time) (*Post, error) {
+	return r.mutate(postID, func(e *entry) error {
+		if e.post.IsDeleted() {
+			return ErrDeleted
+		}
+		rev, err := e.post.EditComment(commentID, body, edited)
+		if err != nil {
+			return err
+		}
+		e.revisions = append(e.revisions, rev)
+		return nil
+	})
+}
+
+func (r *MemoryRepo) History(postID, commentID string) ([]*Revision, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	e, ok := r.byID[postID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if commentID != "" && e.post.comment(commentID) == nil {
+		return nil, ErrCommentNotFound
+	}
+
+	revisions := make([]*Revision, 0)
+	for _, rev := range e.revisions {
+		if rev.CommentID == commentID {
+			c := *rev
+			revisions = append(revisions, &c)
+		}
+	}
+	return revisions, nil
+}
+
+// Records returns every stored post with its revisions, in insertion order.
+func (r *MemoryRepo) Records() []Record {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	entries := sortedEntries(r.byID)
+	records := make([]Record, len(entries))
+	for i, e := range entries {
+		revisions := make([]*Revision, len(e.revisions))
+		for j, rev := range e.revisions {
+			c := *rev
+			revisions[j] = &c
+		}
+		records[i] = Record{Post: e.post.Clone(), Revisions: revisions}
+		for userID, at := range e.savedBy {
+			if records[i].SavedBy == nil {
+				records[i].SavedBy = make(map[string]time.Time)
+			}
+			records[i].SavedBy[userID] = time.UnixMicro(at)
+		}
+		for userID := range e.hiddenBy {
+			records[i].HiddenBy = append(records[i].HiddenBy, userID)
+		}
+		slices.Sort(records[i].HiddenBy)
+	}
+	return records
+}
+
+// Load inserts a previously stored post together with its revisions.
+func (r *MemoryRepo) Load(rec Record) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.byID[rec.Post.ID]; ok {
+		return errors.New("post already exists")
+	}
+	r.seq++
+	e := &entry{seq: r.seq, post: rec.Post.Clone(), revisions: rec.Revisions}
+	r.index(e)
+	for userID, at := range rec.SavedBy {
+		r.save(e, userID, at.UnixMicro())
+	}
+	for _, userID := range rec.HiddenBy {
+		r.hide(e, userID)
+	}
+	return nil
+}
+
+// mutate applies fn to the stored entry under the write lock and returns a copy of the post.
+func (r *MemoryRepo) mutate(postID string, fn func(e *entry) error) (*Post, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, ok := r.byID[postID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if err := fn(e); err != nil {
+		return nil, err
+	}
+	return e.post.Clone(), nil
+}
+
+func (r *MemoryRepo) index(e *entry) {
+	r.rank(e)
+	r.byID[e.post.ID] = e
+	r.credit(e.post.Author, postKarma(e.post), 0)
+	for _, c := range e.post.Comments {
+		r.indexComment(e, c)
+		r.credit(c.Author, 0, commentKarma(c))
+	}
+}
+
+func (r *MemoryRepo) unindex(e *entry) {
+	r.unrank(e)
+	delete(r.byID, e.post.ID)
+	r.credit(e.post.Author, -postKarma(e.post), 0)
+	for _, c := range e.post.Comments {
+		r.unindexComment(c)
+		r.credit(c.Author, 0, -commentKarma(c))
+	}
+	for userID := range e.savedBy {
+		removeFromIndex(r.saved, userID, e.post.ID)
+	}
+	for userID := range e.hiddenBy {
+		removeFromIndex(r.hidden, userID, e.post.ID)
+	}
+}
+
+func (r *MemoryRepo) indexComment(e *entry, c *Comment) {
+	if c.Author == nil {
+		return
+	}
+	bucket, ok := r.commentsByAuthor[c.Author.Username]
+	if !ok {
+		bucket = make(map[string]*entry)
+		r.commentsByAuthor[c.Author.Username] = bucket
+	}
+	bucket[c.ID] = e
+}
+
+func (r *MemoryRepo) unindexComment(c *Comment) {
+	if c.Author == nil {
+		return
+	}
+	removeFromIndex(r.commentsByAuthor, c.Author.Username, c.ID)
+}
+
+func addToIndex(idx map[string]map[string]*entry, key string, e *entry) {
+	bucket, ok := idx[key]
+	if !ok {
+		bucket = make(map[string]*entry)
+		idx[key] = bucket
+	}
+	bucket[e.post.ID] = e
+}
+
+func removeFromIndex(idx map[string]map[string]*entry, key, id string) {
+	bucket := idx[key]
+	delete(bucket, id)
+	if len(bucket) == 0 {
+		delete(idx, key)
+	}
+}
+
+// sortedEntries returns the entries of a map, such as byID, in insertion
+// order. The entries are not copied and deleted posts are included.
+func sortedEntries(bucket map[string]*entry) []*entry {
+	entries := make([]*entry, 0, len(bucket))
+	for _, e := range bucket {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].seq < entries[j].seq
+	})
+	return entries
+}
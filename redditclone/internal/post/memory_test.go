@@ -0,0 +1,155 @@
+package post
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryRepoLookupsFollowChanges(t *testing.T) {
+	tests := []struct {
+		name     string
+		change   func(t *testing.T, r *MemoryRepo)
+		category []string // posts in music
+		author   []string // posts by alice
+	}{
+		{"added", func(t *testing.T, r *MemoryRepo) {}, []string{"p1", "p2", "p3"}, []string{"p1", "p3"}},
+		{"voted", func(t *testing.T, r *MemoryRepo) {
+			// Voting re-ranks a post but keeps it in insertion order.
+			if _, err := r.Vote("p1", "u9", -1); err != nil {
+				t.Fatal(err)
+			}
+		}, []string{"p1", "p2", "p3"}, []string{"p1", "p3"}},
+		{"deleted", func(t *testing.T, r *MemoryRepo) {
+			if err := r.Delete("p1", testTime); err != nil {
+				t.Fatal(err)
+			}
+		}, []string{"p2", "p3"}, []string{"p3"}},
+		{"restored", func(t *testing.T, r *MemoryRepo) {
+			if err := r.Delete("p1", testTime); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := r.Restore("p1"); err != nil {
+				t.Fatal(err)
+			}
+		}, []string{"p1", "p2", "p3"}, []string{"p1", "p3"}},
+		{"purged", func(t *testing.T, r *MemoryRepo) {
+			if err := r.Delete("p3", testTime); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := r.Purge(testTime.Add(time.Second)); err != nil {
+				t.Fatal(err)
+			}
+		}, []string{"p1", "p2"}, []string{"p1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewMemoryRepo()
+			addPosts(t, r,
+				newPost("p1", "music", "alice", 2),
+				newPost("p2", "music", "bob", 1),
+				newPost("p3", "music", "alice", 0),
+				newPost("p4", "news", "alice", 3),
+			)
+			tt.change(t, r)
+
+			byCategory, _ := r.GetByCategory("music")
+			if got := ids(byCategory); !equal(got, tt.category) {
+				t.Errorf("GetByCategory = %v, want %v", got, tt.category)
+			}
+			byAuthor, _ := r.GetByAuthor("alice")
+			if got := ids(byAuthor); !equal(got, append(tt.author, "p4")) {
+				t.Errorf("GetByAuthor = %v, want %v", got, append(tt.author, "p4"))
+			}
+		})
+	}
+}
+
+// benchSizes are the numbers of posts the lookups are benchmarked at. Each
+// category holds 1000 posts and each author 10, whatever the total.
+var benchSizes = []int{10_000, 100_000, 1_000_000}
+
+var benchRepos struct {
+	sync.Mutex
+	repos map[int]*MemoryRepo
+}
+
+// benchRepo returns a repo of n posts, built once per size.
+func benchRepo(b *testing.B, n int) *MemoryRepo {
+	b.Helper()
+	benchRepos.Lock()
+	defer benchRepos.Unlock()
+	if r, ok := benchRepos.repos[n]; ok {
+		return r
+	}
+	if benchRepos.repos == nil {
+		benchRepos.repos = make(map[int]*MemoryRepo)
+	}
+	r := NewMemoryRepo()
+	for i := range n {
+		p := newPost(fmt.Sprintf("p%d", i), fmt.Sprintf("c%d", i%(n/1000)), fmt.Sprintf("u%d", i%(n/10)), i)
+		if _, err := r.Add(p); err != nil {
+			b.Fatal(err)
+		}
+	}
+	benchRepos.repos[n] = r
+	return r
+}
+
+// benchLookup runs lookup at every size in benchSizes, for the i-th of n
+// posts, categories or authors.
+func benchLookup(b *testing.B, lookup func(r *MemoryRepo, i, n int) error) {
+	for _, n := range benchSizes {
+		r := benchRepo(b, n)
+		b.Run(fmt.Sprintf("posts=%d", n), func(b *testing.B) {
+			i := 0
+			for b.Loop() {
+				if err := lookup(r, i, n); err != nil {
+					b.Fatal(err)
+				}
+				i = (i + 7919) % n
+			}
+		})
+	}
+}
+
+func BenchmarkMemoryRepoGetByID(b *testing.B) {
+	benchLookup(b, func(r *MemoryRepo, i, n int) error {
+		_, err := r.GetByID(fmt.Sprintf("p%d", i))
+		return err
+	})
+}
+
+func BenchmarkMemoryRepoGetByCategory(b *testing.B) {
+	benchLookup(b, func(r *MemoryRepo, i, n int) error {
+		posts, err := r.GetByCategory(fmt.Sprintf("c%d", i%(n/1000)))
+		if err == nil && len(posts) != 1000 {
+			err = fmt.Errorf("%d posts in a category, want 1000", len(posts))
+		}
+		return err
+	})
+}
+
+func BenchmarkMemoryRepoGetByAuthor(b *testing.B) {
+	benchLookup(b, func(r *MemoryRepo, i, n int) error {
+		posts, err := r.GetByAuthor(fmt.Sprintf("u%d", i%(n/10)))
+		if err == nil && len(posts) != 10 {
+			err = fmt.Errorf("%d posts by an author, want 10", len(posts))
+		}
+		return err
+	})
+}
+
+// BenchmarkMemoryRepoDelete deletes a post and restores it, so that the
+// repo stays the same size.
+func BenchmarkMemoryRepoDelete(b *testing.B) {
+	benchLookup(b, func(r *MemoryRepo, i, n int) error {
+		id := fmt.Sprintf("p%d", i)
+		if err := r.Delete(id, testTime); err != nil {
+			return err
+		}
+		_, err := r.Restore(id)
+		return err
+	})
+}
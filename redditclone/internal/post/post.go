@@ -2,22 +2,37 @@ package post
 
 import (
 	"errors"
-	"sync"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+var (
+	ErrNotFound        = errors.New("post not found")
+	ErrCommentNotFound = errors.New("comment not found")
+	ErrDeleted         = errors.New("deleted")
+	ErrNotDeleted      = errors.New("not deleted")
+	ErrTooDeep         = errors.New("comment nested too deeply")
+)
+
 type Author struct {
 	ID       string `json:"id"`
 	Username string `json:"username"`
 }
 
+// Comment is a comment on a post or, when ParentID is set, a reply to
+// another comment of the same post. Depth is 0 for top-level comments.
 type Comment struct {
-	ID      string    `json:"id"`
-	Author  *Author   `json:"author"`
-	Body    string    `json:"body"`
-	Created time.Time `json:"created"`
+	ID       string    `json:"id"`
+	ParentID string    `json:"parent_id,omitempty"`
+	Depth    int       `json:"depth"`
+	Author   *Author   `json:"author"`
+	Body     string    `json:"body"`
+	Score    int       `json:"score"`
+	Votes    []*Vote   `json:"votes"`
+	Created  time.Time `json:"created"`
+	Edited   time.Time `json:"edited,omitzero"`
+	Deleted  time.Time `json:"deleted,omitzero"`
 }
 
 type Vote struct {
@@ -39,91 +54,88 @@ type Post struct {
 	Type             string     `json:"type"`
 	Text             string     `json:"text,omitempty"`
 	UpvotePercentage int        `json:"upvotePercentage"`
+	Edited           time.Time  `json:"edited,omitzero"`
+	Deleted          time.Time  `json:"deleted,omitzero"`
 }
 
+// Repo stores posts. Returned posts are copies owned by the caller: changes
+// to stored posts go through the mutating methods, which apply them
+// atomically and return the updated post.
+//
+// Deleting is soft: the post or comment is marked deleted and can be
+// restored until Purge removes it for good. Listings skip deleted posts,
+// GetByID still returns them.
 type Repo interface {
 	GetAll() ([]*Post, error)
 	GetByID(id string) (*Post, error)
 	GetByCategory(category string) ([]*Post, error)
 	GetByAuthor(authorUsername string) ([]*Post, error)
+	// List returns one page of a listing, skipping deleted posts.
+	List(q ListQuery) (*Page, error)
 	Add(post *Post) (*Post, error)
-	Delete(id string) error
-}
-
-type MemoryRepo struct {
-	mu    sync.RWMutex
-	posts []*Post
-}
-
-func NewMemoryRepo() *MemoryRepo {
-	return &MemoryRepo{
-		posts: make([]*Post, 0),
-	}
-}
-
-func (r *MemoryRepo) GetAll() ([]*Post, error) {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
-	return r.posts, nil
+	Delete(id string, deleted time.Time) error
+	Restore(id string) (*Post, error)
+	Vote(postID, userID string, vote int) (*Post, error)
+	AddComment(postID string, comment *Comment) (*Post, error)
+	VoteComment(postID, commentID, userID string, vote int) (*Post, error)
+	DeleteComment(postID, commentID string, deleted time.Time) (*Post, error)
+	RestoreComment(postID, commentID string) (*Post, error)
+	// Purge hard-deletes posts and comments deleted before the given time
+	// and returns how many were removed.
+	Purge(before time.Time) (int, error)
+	IncrementViews(postID string) (*Post, error)
+	Edit(postID string, edit Edit) (*Post, error)
+	EditComment(postID, commentID, body string, edited time.Time) (*Post, error)
+	// History returns the previous versions of a post, or of one of its
+	// comments when commentID is not empty, oldest first.
+	History(postID, commentID string) ([]*Revision, error)
+	// ListComments returns one page of the live comments of an author.
+	ListComments(q CommentQuery) (*CommentPage, error)
+	// Karma returns the karma of a user, kept up to date as votes change.
+	Karma(userID string) (Karma, error)
+	// Save, Unsave, Hide and Unhide are idempotent. Only live posts can be
+	// saved or hidden.
+	Save(postID, userID string, saved time.Time) error
+	Unsave(postID, userID string) error
+	Hide(postID, userID string) error
+	Unhide(postID, userID string) error
+	// ListSaved returns one page of the posts a user saved.
+	ListSaved(q SavedQuery) (*Page, error)
+	// Hidden returns the IDs of the posts a user hid, for the listings
+	// that cannot leave them out by a ListQuery.
+	Hidden(userID string) ([]string, error)
+	// Activity returns the posts, comments and votes of a user, for the
+	// export of their data.
+	Activity(userID string) (*Activity, error)
+	// Forget is called when a user deletes their account: their posts and
+	// comments are credited to DeletedPlaceholder, their saved and hidden
+	// posts and their karma are dropped, and their votes are kept,
+	// without the user, or removed as the policy says.
+	Forget(userID string, votes VotePolicy) error
 }
 
-func (r *MemoryRepo) GetByID(id string) (*Post, error) {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
-	for _, p := range r.posts {
-		if p.ID == id {
-			return p, nil
-		}
-	}
-	return nil, errors.New("post not found")
+func (p *Post) Vote(userID string, vote int) {
+	p.Votes = castVote(p.Votes, &p.Score, userID, vote)
 }
 
-func (r *MemoryRepo) GetByCategory(category string) ([]*Post, error) {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
-	var filtered []*Post
-	for _, p := range r.posts {
-		if p.Category == category {
-			filtered = append(filtered, p)
-		}
+// VoteComment applies a user's vote to a comment, like Vote does for the post.
+func (p *Post) VoteComment(commentID, userID string, vote int) error {
+	c := p.comment(commentID)
+	if c == nil {
+		return ErrCommentNotFound
 	}
-	return filtered, nil
-}
-
-func (r *MemoryRepo) GetByAuthor(authorUsername string) ([]*Post, error) {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
-	var filtered []*Post
-	for _, p := range r.posts {
-		if p.Author != nil && p.Author.Username == authorUsername {
-			filtered = append(filtered, p)
-		}
+	if c.IsDeleted() {
+		return ErrDeleted
 	}
-	return filtered, nil
-}
-
-func (r *MemoryRepo) Add(post *Post) (*Post, error) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	r.posts = append(r.posts, post)
-	return post, nil
+	c.Votes = castVote(c.Votes, &c.Score, userID, vote)
+	return nil
 }
 
-func (r *MemoryRepo) Delete(id string) error {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	for i, p := range r.posts {
-		if p.ID == id {
-			r.posts = append(r.posts[:i], r.posts[i+1:]...)
-			return nil
-		}
-	}
-	return errors.New("post not found")
-}
-
-func (p *Post) Vote(userID string, vote int) {
+// castVote replaces the user's vote in votes, keeping score in step, and
+// returns the updated votes. A zero vote withdraws the user's vote.
+func castVote(votes []*Vote, score *int, userID string, vote int) []*Vote {
 	existingVoteIndex := -1
-	for i, v := range p.Votes {
+	for i, v := range votes {
 		if v.User == userID {
 			existingVoteIndex = i
 			break
@@ -131,37 +143,43 @@ func (p *Post) Vote(userID string, vote int) {
 	}
 
 	if existingVoteIndex != -1 {
-		existingVote := p.Votes[existingVoteIndex]
-		p.Score -= existingVote.Vote
+		existingVote := votes[existingVoteIndex]
+		*score -= existingVote.Vote
 		if vote == 0 {
-			p.Votes = append(p.Votes[:existingVoteIndex], p.Votes[existingVoteIndex+1:]...)
+			votes = append(votes[:existingVoteIndex], votes[existingVoteIndex+1:]...)
 		} else {
-			p.Score += vote
+			*score += vote
 			existingVote.Vote = vote
 		}
 	} else if vote != 0 {
-		p.Score += vote
-		p.Votes = append(p.Votes, &Vote{User: userID, Vote: vote})
+		*score += vote
+		votes = append(votes, &Vote{User: userID, Vote: vote})
 	}
+	return votes
 }
 
-func (p *Post) AddComment(author *Author, body string) {
-	p.Comments = append(p.Comments, &Comment{
+func NewComment(author *Author, body string) *Comment {
+	return &Comment{
 		ID:      uuid.NewString(),
 		Author:  author,
 		Body:    body,
+		Votes:   make([]*Vote, 0),
 		Created: time.Now(),
-	})
+	}
 }
 
-func (p *Post) RemoveComment(commentID string) error {
-	for i, c := range p.Comments {
-		if c.ID == commentID {
-			p.Comments = append(p.Comments[:i], p.Comments[i+1:]...)
-			return nil
+// AddComment appends c to the post, setting its depth from its parent.
+func (p *Post) AddComment(c *Comment) error {
+	c.Depth = 0
+	if c.ParentID != "" {
+		depth, err := replyDepth(p.comment(c.ParentID))
+		if err != nil {
+			return err
 		}
+		c.Depth = depth
 	}
-	return errors.New("comment not found")
+	p.Comments = append(p.Comments, c)
+	return nil
 }
 
 func (p *Post) CalculateUpvotePercentage() {
@@ -175,5 +193,43 @@ func (p *Post) CalculateUpvotePercentage() {
 		}
 	}
 	totalVotes := upvotes + downvotes
+	if totalVotes == 0 {
+		p.UpvotePercentage = 0
+		return
+	}
 	p.UpvotePercentage = (upvotes * 100) / totalVotes
 }
+
+// Clone returns a deep copy of the post.
+func (p *Post) Clone() *Post {
+	c := *p
+	if p.Author != nil {
+		author := *p.Author
+		c.Author = &author
+	}
+	c.Votes = cloneVotes(p.Votes)
+	c.Comments = make([]*Comment, len(p.Comments))
+	for i, cm := range p.Comments {
+		c.Comments[i] = cm.clone()
+	}
+	return &c
+}
+
+func (c *Comment) clone() *Comment {
+	cp := *c
+	if c.Author != nil {
+		author := *c.Author
+		cp.Author = &author
+	}
+	cp.Votes = cloneVotes(c.Votes)
+	return &cp
+}
+
+func cloneVotes(votes []*Vote) []*Vote {
+	c := make([]*Vote, len(votes))
+	for i, v := range votes {
+		vote := *v
+		c[i] = &vote
+	}
+	return c
+}
@@ -0,0 +1,179 @@
+package post
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"slices"
+)
+
+// Karma is what a user's posts and comments have earned: the sum of the
+// votes other users cast on them. Self-votes, like the upvote every post
+// starts with, do not count. Deleted content keeps its karma until it is
+// purged.
+type Karma struct {
+	Post    int `json:"post_karma"`
+	Comment int `json:"comment_karma"`
+}
+
+// CommentQuery selects a page of the live comments of an author, newest
+// first. After is the Next cursor of the previous page. A zero Limit returns
+// everything.
+type CommentQuery struct {
+	Author string
+	Limit  int
+	After  string
+}
+
+// UserComment is a comment listed on its author's profile, with the post it
+// was left on.
+type UserComment struct {
+	*Comment
+	PostID    string `json:"post_id"`
+	PostTitle string `json:"post_title"`
+	Category  string `json:"category"`
+}
+
+// CommentPage is one page of an author's comments. Next is empty on the last
+// page.
+type CommentPage struct {
+	Comments []*UserComment `json:"comments"`
+	Next     string         `json:"next,omitempty"`
+}
+
+// commentCursor is the position of a comment in its author's listing: its
+// creation time in seconds, ties broken by ID.
+type commentCursor struct {
+	Created int64  `json:"c"`
+	ID      string `json:"i"`
+}
+
+func (c commentCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// before reports whether c comes before other in the listing.
+func (c commentCursor) before(other commentCursor) bool {
+	if c.Created != other.Created {
+		return c.Created > other.Created
+	}
+	return c.ID > other.ID
+}
+
+func decodeCommentCursor(s string) (*commentCursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrBadCursor
+	}
+	var c commentCursor
+	if err = json.Unmarshal(data, &c); err != nil || c.ID == "" {
+		return nil, ErrBadCursor
+	}
+	return &c, nil
+}
+
+func positionOf(c *Comment) commentCursor {
+	return commentCursor{Created: c.Created.Unix(), ID: c.ID}
+}
+
+// newCommentPage trims comments fetched in listing order, one more than the
+// limit, to a page.
+func newCommentPage(q CommentQuery, comments []*UserComment) *CommentPage {
+	if comments == nil {
+		comments = make([]*UserComment, 0)
+	}
+	page := &CommentPage{Comments: comments}
+	if q.Limit > 0 && len(comments) > q.Limit {
+		page.Comments = comments[:q.Limit]
+		page.Next = positionOf(page.Comments[q.Limit-1].Comment).encode()
+	}
+	return page
+}
+
+// postKarma is the karma the post itself earns its author.
+func postKarma(p *Post) int {
+	return earned(p.Votes, p.Author)
+}
+
+// commentKarma is the karma a comment earns its author.
+func commentKarma(c *Comment) int {
+	return earned(c.Votes, c.Author)
+}
+
+func earned(votes []*Vote, author *Author) int {
+	sum := 0
+	for _, v := range votes {
+		if author == nil || v.User != author.ID {
+			sum += v.Vote
+		}
+	}
+	return sum
+}
+
+// credit adds karma to an author.
+func (r *MemoryRepo) credit(author *Author, post, comment int) {
+	if author == nil || author.ID == "" || post == 0 && comment == 0 {
+		return
+	}
+	k, ok := r.karma[author.ID]
+	if !ok {
+		k = &Karma{}
+		r.karma[author.ID] = k
+	}
+	k.Post += post
+	k.Comment += comment
+}
+
+func (r *MemoryRepo) Karma(userID string) (Karma, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if k, ok := r.karma[userID]; ok {
+		return *k, nil
+	}
+	return Karma{}, nil
+}
+
+func (r *MemoryRepo) ListComments(q CommentQuery) (*CommentPage, error) {
+	after, err := decodeCommentCursor(q.After)
+	if err != nil {
+		return nil, err
+	}
+	q.Limit = min(q.Limit, MaxPageSize)
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var comments []*UserComment
+	for id, e := range r.commentsByAuthor[q.Author] {
+		c := e.post.comment(id)
+		if c.IsDeleted() || e.post.IsDeleted() || after != nil && !after.before(positionOf(c)) {
+			continue
+		}
+		comments = append(comments, &UserComment{
+			Comment:   c,
+			PostID:    e.post.ID,
+			PostTitle: e.post.Title,
+			Category:  e.post.Category,
+		})
+	}
+	slices.SortFunc(comments, func(a, b *UserComment) int {
+		pa, pb := positionOf(a.Comment), positionOf(b.Comment)
+		if pa == pb {
+			return 0
+		}
+		if pa.before(pb) {
+			return -1
+		}
+		return 1
+	})
+	if q.Limit > 0 && len(comments) > q.Limit+1 {
+		comments = comments[:q.Limit+1]
+	}
+	for _, uc := range comments {
+		uc.Comment = uc.Comment.clone()
+	}
+	return newCommentPage(q, comments), nil
+}
@@ -0,0 +1,201 @@
+package post
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+// recomputedKarma adds up the karma of every user from the votes on the
+// posts, deleted or not, the way Karma must keep it.
+func recomputedKarma(t *testing.T, r Repo, postIDs ...string) map[string]Karma {
+	t.Helper()
+	karma := make(map[string]Karma)
+	for _, id := range postIDs {
+		p, err := r.GetByID(id)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		k := karma[p.Author.ID]
+		k.Post += postKarma(p)
+		karma[p.Author.ID] = k
+		for _, c := range p.Comments {
+			k := karma[c.Author.ID]
+			k.Comment += commentKarma(c)
+			karma[c.Author.ID] = k
+		}
+	}
+	return karma
+}
+
+func TestRepoKarma(t *testing.T) {
+	forEachRepo(t, func(t *testing.T, r Repo) {
+		addPosts(t, r, newPost("p1", "music", "alice", 0), newPost("p2", "music", "bob", 1))
+		onP1 := NewComment(&Author{ID: "id-bob", Username: "bob"}, "on p1")
+		onP2 := NewComment(&Author{ID: "id-alice", Username: "alice"}, "on p2")
+		for postID, c := range map[string]*Comment{"p1": onP1, "p2": onP2} {
+			if _, err := r.AddComment(postID, c); err != nil {
+				t.Fatal(err)
+			}
+		}
+		vote := func(postID, userID string, v int) func() error {
+			return func() error {
+				_, err := r.Vote(postID, userID, v)
+				return err
+			}
+		}
+		voteComment := func(postID, commentID, userID string, v int) func() error {
+			return func() error {
+				_, err := r.VoteComment(postID, commentID, userID, v)
+				return err
+			}
+		}
+
+		steps := []struct {
+			name    string
+			changes []func() error
+			want    map[string]Karma // by user ID, checked on top of the recount
+		}{
+			{"votes on a post", []func() error{vote("p1", "id-bob", 1), vote("p1", "u1", 1), vote("p1", "u2", -1)},
+				map[string]Karma{"id-alice": {Post: 1}}},
+			{"self-vote on a post", []func() error{vote("p1", "id-alice", 1)},
+				map[string]Karma{"id-alice": {Post: 1}}},
+			{"changed vote", []func() error{vote("p1", "u1", -1)},
+				map[string]Karma{"id-alice": {Post: -1}}},
+			{"withdrawn vote", []func() error{vote("p1", "u2", 0), vote("p1", "u3", 1)},
+				map[string]Karma{"id-alice": {Post: 1}}},
+			{"votes on comments", []func() error{
+				voteComment("p1", onP1.ID, "id-alice", 1), voteComment("p2", onP2.ID, "u1", -1),
+				voteComment("p2", onP2.ID, "u2", -1),
+			}, map[string]Karma{"id-alice": {Post: 1, Comment: -2}, "id-bob": {Comment: 1}}},
+			{"self-votes on comments", []func() error{
+				voteComment("p1", onP1.ID, "id-bob", -1), voteComment("p2", onP2.ID, "id-alice", 1),
+			}, map[string]Karma{"id-alice": {Post: 1, Comment: -2}, "id-bob": {Comment: 1}}},
+			{"changed and withdrawn comment votes", []func() error{
+				voteComment("p2", onP2.ID, "u1", 1), voteComment("p2", onP2.ID, "u2", 0),
+			}, map[string]Karma{"id-alice": {Post: 1, Comment: 1}}},
+			{"votes on the other post", []func() error{vote("p2", "id-bob", 1), vote("p2", "id-alice", -1)},
+				map[string]Karma{"id-bob": {Post: -1, Comment: 1}}},
+			{"deleted content keeps its karma", []func() error{
+				func() error {
+					_, err := r.DeleteComment("p1", onP1.ID, testTime)
+					return err
+				},
+				func() error { return r.Delete("p2", testTime) },
+			}, map[string]Karma{"id-alice": {Post: 1, Comment: 1}, "id-bob": {Post: -1, Comment: 1}}},
+			{"purged content takes it along", []func() error{
+				func() error {
+					_, err := r.Purge(testTime.Add(time.Minute))
+					return err
+				},
+			}, map[string]Karma{"id-alice": {Post: 1}, "id-bob": {}}},
+		}
+		for _, step := range steps {
+			for _, change := range step.changes {
+				if err := change(); err != nil {
+					t.Fatalf("%s: %v", step.name, err)
+				}
+			}
+			want := recomputedKarma(t, r, "p1", "p2")
+			for _, user := range []string{"id-alice", "id-bob", "u1", "u2", "u3"} {
+				got, err := r.Karma(user)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != want[user] {
+					t.Errorf("%s: karma of %s = %+v, recomputed %+v", step.name, user, got, want[user])
+				}
+				if w, ok := step.want[user]; ok && got != w {
+					t.Errorf("%s: karma of %s = %+v, want %+v", step.name, user, got, w)
+				}
+			}
+		}
+	})
+}
+
+func TestRepoListComments(t *testing.T) {
+	forEachRepo(t, func(t *testing.T, r Repo) {
+		addPosts(t, r, newPost("p1", "music", "alice", 0), newPost("p2", "news", "alice", 1),
+			newPost("p3", "music", "alice", 2))
+		comment := func(id, postID, author string, n int) {
+			t.Helper()
+			c := NewComment(&Author{ID: "id-" + author, Username: author}, "body of "+id)
+			c.ID, c.Created = id, testTime.Add(time.Duration(n)*time.Minute)
+			if _, err := r.AddComment(postID, c); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// c2 and c3 are made at the same time: the higher ID comes first.
+		postOf := make(map[string]string)
+		for i, postID := range []string{"p1", "p2", "p1", "p2", "p1", "p1", "p2"} {
+			id, n := fmt.Sprintf("c%d", i), i
+			if i == 3 {
+				n = 2
+			}
+			comment(id, postID, "bob", n)
+			postOf[id] = postID
+		}
+		comment("by-alice", "p1", "alice", 10)
+		comment("deleted", "p1", "bob", 11)
+		if _, err := r.DeleteComment("p1", "deleted", testTime); err != nil {
+			t.Fatal(err)
+		}
+		comment("on-deleted-post", "p3", "bob", 12)
+		if err := r.Delete("p3", testTime); err != nil {
+			t.Fatal(err)
+		}
+
+		want := []string{"c6", "c5", "c4", "c3", "c2", "c1", "c0"}
+		all, err := r.ListComments(CommentQuery{Author: "bob"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, uc := range all.Comments {
+			got = append(got, uc.ID)
+			if uc.PostID != postOf[uc.ID] {
+				t.Errorf("%s listed on %s, want %s", uc.ID, uc.PostID, postOf[uc.ID])
+			}
+		}
+		if !equal(got, want) || all.Next != "" {
+			t.Errorf("comments = %v, next %q, want %v on one page", got, all.Next, want)
+		}
+
+		var pages [][]string
+		q := CommentQuery{Author: "bob", Limit: 3}
+		for len(pages) < 5 {
+			page, err := r.ListComments(q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, uc := range page.Comments {
+				ids = append(ids, uc.ID)
+			}
+			pages = append(pages, ids)
+			if page.Next == "" {
+				break
+			}
+			q.After = page.Next
+		}
+		if len(pages) != 3 || !equal(slices.Concat(pages...), want) {
+			t.Errorf("pages = %v, want %v in pages of 3", pages, want)
+		}
+
+		if _, err := r.ListComments(CommentQuery{Author: "bob", After: "%%%"}); err != ErrBadCursor {
+			t.Errorf("malformed cursor error = %v, want ErrBadCursor", err)
+		}
+		empty, err := r.ListComments(CommentQuery{Author: "carol", Limit: 3})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(empty.Comments) != 0 || empty.Next != "" {
+			t.Errorf("comments of carol = %d, next %q, want none", len(empty.Comments), empty.Next)
+		}
+	})
+}
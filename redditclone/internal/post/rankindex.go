@@ -0,0 +1,318 @@
+package post
+
+import (
+	"math/rand/v2"
+	"slices"
+)
+
+// indexedSorts are the listing orders kept in a ranking. Their keys only
+// change when a post is voted on; rising depends on the current time and is
+// answered from these rankings by windowed.
+var indexedSorts = []Sort{SortHot, SortNew, SortTop, SortControversial}
+
+const maxRankLevel = 32
+
+// ranking is a skip list of posts in listing order (highest key first, ties
+// broken by the newer sequence number). Insertion, removal and seeking to a
+// cursor take O(log n) expected time, so a page of a listing costs
+// O(log n + limit) regardless of how many posts there are.
+type ranking struct {
+	head  rankNode // sentinel; only next is used
+	level int
+	len   int
+}
+
+type rankNode struct {
+	pos  cursor
+	e    *entry
+	prev *rankNode // nil for the first node
+	next []*rankNode
+}
+
+func newRanking() *ranking {
+	return &ranking{head: rankNode{next: make([]*rankNode, maxRankLevel)}, level: 1}
+}
+
+// precedes reports whether a comes before b in listing order.
+func precedes(a, b cursor) bool {
+	return b.less(a)
+}
+
+// insert adds e at pos. pos must not be in the ranking yet.
+func (r *ranking) insert(pos cursor, e *entry) {
+	var update [maxRankLevel]*rankNode
+	x := &r.head
+	for i := r.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && precedes(x.next[i].pos, pos) {
+			x = x.next[i]
+		}
+		update[i] = x
+	}
+
+	level := 1
+	for level < maxRankLevel && rand.Uint32()&3 == 0 {
+		level++
+	}
+	for ; r.level < level; r.level++ {
+		update[r.level] = &r.head
+	}
+
+	n := &rankNode{pos: pos, e: e, next: make([]*rankNode, level)}
+	for i := range level {
+		n.next[i] = update[i].next[i]
+		update[i].next[i] = n
+	}
+	if update[0] != &r.head {
+		n.prev = update[0]
+	}
+	if n.next[0] != nil {
+		n.next[0].prev = n
+	}
+	r.len++
+}
+
+// remove deletes the node at pos, if there is one.
+func (r *ranking) remove(pos cursor) {
+	var update [maxRankLevel]*rankNode
+	x := &r.head
+	for i := r.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && precedes(x.next[i].pos, pos) {
+			x = x.next[i]
+		}
+		update[i] = x
+	}
+
+	n := x.next[0]
+	if n == nil || n.pos.Key != pos.Key || n.pos.Seq != pos.Seq {
+		return
+	}
+	for i := range n.next {
+		update[i].next[i] = n.next[i]
+	}
+	if n.next[0] != nil {
+		n.next[0].prev = n.prev
+	}
+	for r.level > 1 && r.head.next[r.level-1] == nil {
+		r.level--
+	}
+	r.len--
+}
+
+// after returns the first node that comes after c, or the first node if c is nil.
+func (r *ranking) after(c *cursor) *rankNode {
+	x := &r.head
+	if c != nil {
+		for i := r.level - 1; i >= 0; i-- {
+			for x.next[i] != nil && !precedes(*c, x.next[i].pos) {
+				x = x.next[i]
+			}
+		}
+	}
+	return x.next[0]
+}
+
+// before returns the last node that comes before c.
+func (r *ranking) before(c cursor) *rankNode {
+	x := &r.head
+	for i := r.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && precedes(x.next[i].pos, c) {
+			x = x.next[i]
+		}
+	}
+	if x == &r.head {
+		return nil
+	}
+	return x
+}
+
+// walk calls fn for the nodes after the after cursor in listing order or,
+// if before is set, for the nodes before it in reverse order, until fn
+// returns false.
+func (r *ranking) walk(after, before *cursor, fn func(n *rankNode) bool) {
+	if before != nil {
+		for n := r.before(*before); n != nil && fn(n); n = n.prev {
+		}
+		return
+	}
+	for n := r.after(after); n != nil && fn(n); n = n.next[0] {
+	}
+}
+
+// rankSet holds the rankings of one listing: a category, an author or all
+// posts. byCreated orders posts by creation time, newest first, and bounds
+// the scan for time windows and rising. bySeq orders them by insertion,
+// newest first, for the unpaged lookups.
+type rankSet struct {
+	sorts     map[Sort]*ranking
+	byCreated *ranking
+	bySeq     *ranking
+}
+
+func newRankSet() *rankSet {
+	s := &rankSet{sorts: make(map[Sort]*ranking, len(indexedSorts)), byCreated: newRanking(), bySeq: newRanking()}
+	for _, sort := range indexedSorts {
+		s.sorts[sort] = newRanking()
+	}
+	return s
+}
+
+func (s *rankSet) insert(e *entry) {
+	for _, sort := range indexedSorts {
+		s.sorts[sort].insert(e.pos(sort), e)
+	}
+	s.byCreated.insert(e.createdPos(), e)
+	s.bySeq.insert(cursor{Seq: int64(e.seq)}, e)
+}
+
+func (s *rankSet) remove(e *entry) {
+	for _, sort := range indexedSorts {
+		s.sorts[sort].remove(e.pos(sort))
+	}
+	s.byCreated.remove(e.createdPos())
+	s.bySeq.remove(cursor{Seq: int64(e.seq)})
+}
+
+// pos is the position of the entry in the ranking for sort, as of its last
+// (re)ranking.
+func (e *entry) pos(sort Sort) cursor {
+	return cursor{Key: e.keys[sort], Seq: int64(e.seq)}
+}
+
+func (e *entry) createdPos() cursor {
+	return cursor{Key: float64(e.post.Created.Unix()), Seq: int64(e.seq)}
+}
+
+// rankScopes are the rankings a post is listed in.
+func rankScopes(p *Post) []string {
+	scopes := []string{"", "category:" + p.Category}
+	if p.Author != nil {
+		scopes = append(scopes, "author:"+p.Author.Username)
+	}
+	return scopes
+}
+
+// rank inserts a live post into its rankings.
+func (r *MemoryRepo) rank(e *entry) {
+	if e.keys != nil || e.post.IsDeleted() {
+		return
+	}
+	seq := int64(e.seq)
+	e.keys = make(map[Sort]float64, len(indexedSorts))
+	for _, sort := range indexedSorts {
+		e.keys[sort] = sortKey(e.post, seq, sort, 0)
+	}
+	for _, scope := range rankScopes(e.post) {
+		set, ok := r.rankings[scope]
+		if !ok {
+			set = newRankSet()
+			r.rankings[scope] = set
+		}
+		set.insert(e)
+	}
+}
+
+// unrank removes a post from its rankings. It must be called before any
+// change to the post that affects its sort keys.
+func (r *MemoryRepo) unrank(e *entry) {
+	if e.keys == nil {
+		return
+	}
+	for _, scope := range rankScopes(e.post) {
+		set, ok := r.rankings[scope]
+		if !ok {
+			continue
+		}
+		set.remove(e)
+		if set.byCreated.len == 0 {
+			delete(r.rankings, scope)
+		}
+	}
+	e.keys = nil
+}
+
+// ranked returns clones of the live posts in scope in insertion order. It
+// walks the scope's bySeq ranking, so it costs O(k) for k posts.
+func (r *MemoryRepo) ranked(scope string) []*Post {
+	set, ok := r.rankings[scope]
+	if !ok {
+		return []*Post{}
+	}
+	posts := make([]*Post, set.bySeq.len)
+	i := len(posts)
+	set.bySeq.walk(nil, nil, func(n *rankNode) bool {
+		i--
+		posts[i] = n.e.post.Clone()
+		return true
+	})
+	return posts
+}
+
+// listed returns the rank sets of the posts a query lists: one per category
+// of a feed, otherwise a single one, or none if nothing is in scope.
+func (r *MemoryRepo) listed(q ListQuery) []*rankSet {
+	var scopes []string
+	switch {
+	case q.Categories != nil:
+		for _, category := range q.Categories {
+			scopes = append(scopes, "category:"+category)
+		}
+	case q.Category != "":
+		scopes = []string{"category:" + q.Category}
+	case q.Author != "":
+		scopes = []string{"author:" + q.Author}
+	default:
+		scopes = []string{""}
+	}
+	var sets []*rankSet
+	for _, scope := range scopes {
+		if set, ok := r.rankings[scope]; ok {
+			sets = append(sets, set)
+		}
+	}
+	return sets
+}
+
+// walkMerged walks rankings that share no posts as if they were one, in the
+// same way as ranking.walk. Every step picks the best of the current nodes,
+// which is cheap for the handful of rankings a feed merges.
+func walkMerged(rankings []*ranking, after, before *cursor, fn func(n *rankNode) bool) {
+	if len(rankings) == 1 {
+		rankings[0].walk(after, before, fn)
+		return
+	}
+
+	heads := make([]*rankNode, 0, len(rankings))
+	for _, r := range rankings {
+		var n *rankNode
+		if before != nil {
+			n = r.before(*before)
+		} else {
+			n = r.after(after)
+		}
+		if n != nil {
+			heads = append(heads, n)
+		}
+	}
+	for len(heads) > 0 {
+		best := 0
+		for i, n := range heads[1:] {
+			if before == nil && precedes(n.pos, heads[best].pos) ||
+				before != nil && precedes(heads[best].pos, n.pos) {
+				best = i + 1
+			}
+		}
+		n := heads[best]
+		if !fn(n) {
+			return
+		}
+		next := n.next[0]
+		if before != nil {
+			next = n.prev
+		}
+		if next == nil {
+			heads = slices.Delete(heads, best, best+1)
+		} else {
+			heads[best] = next
+		}
+	}
+}
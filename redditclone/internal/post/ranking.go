@@ -0,0 +1,107 @@
+package post
+
+import (
+	"math"
+	"time"
+)
+
+// hotEpoch is the reference point of the hot score (2005-12-08 07:46:43 UTC).
+// Every 45000 seconds after it is worth as much as a tenfold score.
+const hotEpoch = 1134028003
+
+// RisingWindow is how recent a post must be to show up in the rising listing.
+const RisingWindow = 24 * time.Hour
+
+// Windows accepted by the ?t= parameter of top and controversial listings.
+var Windows = map[string]time.Duration{
+	"hour":  time.Hour,
+	"day":   24 * time.Hour,
+	"week":  7 * 24 * time.Hour,
+	"month": 30 * 24 * time.Hour,
+	"year":  365 * 24 * time.Hour,
+	"all":   0,
+}
+
+// Hot is the time-decayed ranking of a post: the order of magnitude of its
+// score plus a bonus that grows with its creation time. It does not change
+// as the post ages, so it can be stored and indexed.
+func Hot(score int, createdUnix int64) float64 {
+	order := math.Log10(math.Max(math.Abs(float64(score)), 1))
+	sign := 0.0
+	if score > 0 {
+		sign = 1
+	} else if score < 0 {
+		sign = -1
+	}
+	return sign*order + float64(createdUnix-hotEpoch)/45000
+}
+
+// Controversy is high for posts with many votes split evenly between up and
+// down, and zero for posts without votes in both directions.
+func Controversy(ups, downs int) float64 {
+	if ups <= 0 || downs <= 0 {
+		return 0
+	}
+	magnitude := float64(ups + downs)
+	balance := float64(downs) / float64(ups)
+	if ups < downs {
+		balance = float64(ups) / float64(downs)
+	}
+	return math.Pow(magnitude, balance)
+}
+
+// Rising is the score a post gained per hour of its life, damped for very
+// young posts.
+func Rising(score int, createdUnix, nowUnix int64) float64 {
+	return float64(score) / (float64(nowUnix-createdUnix)/3600 + 2)
+}
+
+// Tally counts the up and down votes of the post.
+func (p *Post) Tally() (ups, downs int) {
+	return countVotes(p.Votes)
+}
+
+// Tally counts the up and down votes of the comment.
+func (c *Comment) Tally() (ups, downs int) {
+	return countVotes(c.Votes)
+}
+
+func countVotes(votes []*Vote) (ups, downs int) {
+	for _, v := range votes {
+		switch v.Vote {
+		case 1:
+			ups++
+		case -1:
+			downs++
+		}
+	}
+	return ups, downs
+}
+
+// sortKey is the value posts are ordered by, highest first.
+func sortKey(p *Post, seq int64, sort Sort, nowUnix int64) float64 {
+	switch sort {
+	case SortNew:
+		return float64(seq)
+	case SortHot:
+		return Hot(p.Score, p.Created.Unix())
+	case SortRising:
+		return Rising(p.Score, p.Created.Unix(), nowUnix)
+	case SortControversial:
+		return Controversy(p.Tally())
+	default:
+		return float64(p.Score)
+	}
+}
+
+// since is the earliest creation time of posts in the listing, zero if unbounded.
+func (q *ListQuery) since() time.Time {
+	switch {
+	case q.Sort == SortRising:
+		return q.Now.Add(-RisingWindow)
+	case (q.Sort == SortTop || q.Sort == SortControversial) && q.Window > 0:
+		return q.Now.Add(-q.Window)
+	default:
+		return time.Time{}
+	}
+}
@@ -0,0 +1,234 @@
+package post
+
+import (
+	"maps"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"redditclone/internal/storage"
+)
+
+// testTime is when test posts are created; later posts are created a minute
+// apart.
+var testTime = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// forEachRepo runs test against an empty repo of each implementation.
+func forEachRepo(t *testing.T, test func(t *testing.T, r Repo)) {
+	t.Helper()
+	repos := []struct {
+		name string
+		new  func(t *testing.T) Repo
+	}{
+		{"memory", func(t *testing.T) Repo { return NewMemoryRepo() }},
+		{"sql", newSQLRepo},
+	}
+	for _, tt := range repos {
+		t.Run(tt.name, func(t *testing.T) {
+			test(t, tt.new(t))
+		})
+	}
+}
+
+func newSQLRepo(t *testing.T) Repo {
+	t.Helper()
+	db, err := storage.Open("sqlite", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return NewSQLRepo(db)
+}
+
+// newPost returns a text post by author in category, created n minutes
+// after testTime.
+func newPost(id, category, author string, n int) *Post {
+	return &Post{
+		ID:       id,
+		Title:    "title " + id,
+		Author:   &Author{ID: "id-" + author, Username: author},
+		Category: category,
+		Votes:    []*Vote{},
+		Comments: []*Comment{},
+		Created:  testTime.Add(time.Duration(n) * time.Minute),
+		Type:     "text",
+		Text:     "text of " + id,
+	}
+}
+
+// addPosts adds the posts to r, failing the test on error.
+func addPosts(t *testing.T, r Repo, posts ...*Post) {
+	t.Helper()
+	for _, p := range posts {
+		if _, err := r.Add(p); err != nil {
+			t.Fatalf("Add(%s): %v", p.ID, err)
+		}
+	}
+}
+
+// ids returns the IDs of posts in order.
+func ids(posts []*Post) []string {
+	list := make([]string, len(posts))
+	for i, p := range posts {
+		list[i] = p.ID
+	}
+	return list
+}
+
+func TestRepoRoundTrip(t *testing.T) {
+	forEachRepo(t, func(t *testing.T, r Repo) {
+		p := newPost("p1", "music", "alice", 0)
+		p.Created = time.Date(2024, 3, 1, 5, 6, 7, 123456789, time.FixedZone("MST", -7*3600))
+		p.URL = "https://example.com"
+		addPosts(t, r, p)
+		if _, err := r.Vote("p1", "id-bob", 1); err != nil {
+			t.Fatal(err)
+		}
+		c := NewComment(&Author{ID: "id-bob", Username: "bob"}, "first")
+		if _, err := r.AddComment("p1", c); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := r.GetByID("p1")
+		if err != nil {
+			t.Fatalf("GetByID: %v", err)
+		}
+		if got.Title != p.Title || got.URL != p.URL || got.Text != p.Text || got.Category != "music" || got.Type != "text" {
+			t.Errorf("GetByID = %+v, want the fields of %+v", got, p)
+		}
+		if got.Author.ID != "id-alice" || got.Author.Username != "alice" {
+			t.Errorf("author = %+v, want alice", got.Author)
+		}
+		if !sameTime(got.Created, p.Created) {
+			t.Errorf("created = %v, want %v", got.Created, p.Created)
+		}
+		if got.Score != 1 || len(got.Votes) != 1 || got.Votes[0].User != "id-bob" {
+			t.Errorf("score %d with votes %v, want 1 from bob", got.Score, got.Votes)
+		}
+		if len(got.Comments) != 1 || got.Comments[0].ID != c.ID || got.Comments[0].Body != "first" {
+			t.Errorf("comments = %v, want the one added", got.Comments)
+		}
+		if !sameTime(got.Comments[0].Created, c.Created) {
+			t.Errorf("comment created = %v, want %v", got.Comments[0].Created, c.Created)
+		}
+
+		if _, err = r.GetByID("missing"); err != ErrNotFound {
+			t.Errorf("GetByID(missing) error = %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestRepoLookups(t *testing.T) {
+	forEachRepo(t, func(t *testing.T, r Repo) {
+		addPosts(t, r,
+			newPost("p1", "music", "alice", 0),
+			newPost("p2", "news", "bob", 1),
+			newPost("p3", "music", "bob", 2),
+			newPost("p4", "music", "alice", 3),
+		)
+		if err := r.Delete("p4", testTime.Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			name string
+			get  func() ([]*Post, error)
+			want []string
+		}{
+			{"all", r.GetAll, []string{"p1", "p2", "p3"}},
+			{"category", func() ([]*Post, error) { return r.GetByCategory("music") }, []string{"p1", "p3"}},
+			{"empty category", func() ([]*Post, error) { return r.GetByCategory("videos") }, []string{}},
+			{"author", func() ([]*Post, error) { return r.GetByAuthor("bob") }, []string{"p2", "p3"}},
+			{"unknown author", func() ([]*Post, error) { return r.GetByAuthor("carol") }, []string{}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				posts, err := tt.get()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := ids(posts); !equal(got, tt.want) {
+					t.Errorf("got %v, want %v", got, tt.want)
+				}
+			})
+		}
+	})
+}
+
+func TestRepoVoteComment(t *testing.T) {
+	forEachRepo(t, func(t *testing.T, r Repo) {
+		addPosts(t, r, newPost("p1", "music", "alice", 0))
+		c := NewComment(&Author{ID: "id-alice", Username: "alice"}, "first")
+		if _, err := r.AddComment("p1", c); err != nil {
+			t.Fatal(err)
+		}
+
+		steps := []struct {
+			name  string
+			user  string
+			vote  int
+			score int
+			votes map[string]int // by user
+		}{
+			{"upvote", "u1", 1, 1, map[string]int{"u1": 1}},
+			{"second upvote", "u2", 1, 2, map[string]int{"u1": 1, "u2": 1}},
+			{"downvote", "u3", -1, 1, map[string]int{"u1": 1, "u2": 1, "u3": -1}},
+			{"switch to down", "u1", -1, -1, map[string]int{"u1": -1, "u2": 1, "u3": -1}},
+			{"same vote again", "u1", -1, -1, map[string]int{"u1": -1, "u2": 1, "u3": -1}},
+			{"withdraw", "u3", 0, 0, map[string]int{"u1": -1, "u2": 1}},
+			{"withdraw without a vote", "u4", 0, 0, map[string]int{"u1": -1, "u2": 1}},
+		}
+		for _, step := range steps {
+			p, err := r.VoteComment("p1", c.ID, step.user, step.vote)
+			if err != nil {
+				t.Fatalf("%s: %v", step.name, err)
+			}
+			// The stored comment, not just the returned one, must agree.
+			stored, err := r.GetByID("p1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, got := range []*Post{p, stored} {
+				cm := got.Comments[0]
+				votes := make(map[string]int)
+				for _, v := range cm.Votes {
+					votes[v.User] = v.Vote
+				}
+				if cm.Score != step.score || !maps.Equal(votes, step.votes) {
+					t.Errorf("%s: score %d with votes %v, want %d with %v", step.name, cm.Score, votes, step.score, step.votes)
+				}
+			}
+			if stored.Score != 0 || len(stored.Votes) != 0 {
+				t.Errorf("%s: post score %d, want comment votes kept off the post", step.name, stored.Score)
+			}
+		}
+
+		if _, err := r.VoteComment("p1", "missing", "u1", 1); err != ErrCommentNotFound {
+			t.Errorf("vote on a missing comment error = %v, want ErrCommentNotFound", err)
+		}
+		if _, err := r.DeleteComment("p1", c.ID, testTime); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.VoteComment("p1", c.ID, "u1", 1); err != ErrDeleted {
+			t.Errorf("vote on a deleted comment error = %v, want ErrDeleted", err)
+		}
+	})
+}
+
+// sameTime reports whether a and b are the same instant to the microsecond
+// precision the SQL repo stores.
+func sameTime(a, b time.Time) bool {
+	return a.Truncate(time.Microsecond).Equal(b.Truncate(time.Microsecond))
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,320 @@
+package post
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Revision is a previous version of a post or of one of its comments.
+// For comment revisions CommentID is set and Text holds the comment body.
+type Revision struct {
+	CommentID string    `json:"comment_id,omitempty"`
+	Title     string    `json:"title,omitempty"`
+	URL       string    `json:"url,omitempty"`
+	Text      string    `json:"text,omitempty"`
+	Created   time.Time `json:"created"`
+}
+
+// Edit holds the new content of a post. Title, URL and Text replace the
+// current values as a whole; fields left nil keep theirs. The repo applies
+// an edit to the post as it is at that moment, so concurrent edits of
+// different fields do not undo each other.
+type Edit struct {
+	Title  *string   `json:"title,omitempty"`
+	URL    *string   `json:"url,omitempty"`
+	Text   *string   `json:"text,omitempty"`
+	Edited time.Time `json:"edited"`
+}
+
+// Record is a post together with the revisions of the post and its comments
+// and the users who saved or hid it. It is used to persist and restore the
+// memory repo.
+type Record struct {
+	Post      *Post       `json:"post"`
+	Revisions []*Revision `json:"revisions,omitempty"`
+	// SavedBy maps the IDs of the users who saved the post to when they did.
+	SavedBy  map[string]time.Time `json:"saved_by,omitempty"`
+	HiddenBy []string             `json:"hidden_by,omitempty"`
+}
+
+// Edit stores the current content as a revision and applies e.
+func (p *Post) Edit(e Edit) *Revision {
+	rev := &Revision{
+		Title:   p.Title,
+		URL:     p.URL,
+		Text:    p.Text,
+		Created: p.lastChange(),
+	}
+	if e.Title != nil {
+		p.Title = *e.Title
+	}
+	if e.URL != nil {
+		p.URL = *e.URL
+	}
+	if e.Text != nil {
+		p.Text = *e.Text
+	}
+	p.Edited = e.Edited
+	return rev
+}
+
+// EditComment stores the current body of the comment as a revision and replaces it.
+func (p *Post) EditComment(commentID, body string, edited time.Time) (*Revision, error) {
+	c := p.comment(commentID)
+	if c == nil {
+		return nil, ErrCommentNotFound
+	}
+	if c.IsDeleted() {
+		return nil, ErrDeleted
+	}
+	rev := &Revision{
+		CommentID: c.ID,
+		Text:      c.Body,
+		Created:   c.lastChange(),
+	}
+	c.Body = body
+	c.Edited = edited
+	return rev, nil
+}
+
+func (p *Post) lastChange() time.Time {
+	if !p.Edited.IsZero() {
+		return p.Edited
+	}
+	return p.Created
+}
+
+func (c *Comment) lastChange() time.Time {
+	if !c.Edited.IsZero() {
+		return c.Edited
+	}
+	return c.Created
+}
+
+// DiffOp is one chunk of a word-level diff.
+type DiffOp struct {
+	Op   string `json:"op"` // "equal", "insert" or "delete"
+	Text string `json:"text"`
+}
+
+// Diffs are bounded so that public history listings stay cheap: texts of
+// more than maxDiffWords words in all, or whose parts between common runs
+// are more than maxDiffEdits words apart, are reported as replaced as a
+// whole. Diff takes O((n+m)·d) time and O(n+m) space for texts of n and m
+// words d edits apart.
+const (
+	maxDiffWords = 20_000
+	maxDiffEdits = 1_000
+)
+
+var diffTokenRe = regexp.MustCompile(`\s+|[^\s]+`)
+
+// Diff returns a word-level diff turning a into b, using Myers' algorithm
+// in linear space (see "An O(ND) Difference Algorithm and Its Variations").
+func Diff(a, b string) []DiffOp {
+	if a == b {
+		if a == "" {
+			return nil
+		}
+		return []DiffOp{{Op: "equal", Text: a}}
+	}
+
+	d := differ{x: diffTokenRe.FindAllString(a, -1), y: diffTokenRe.FindAllString(b, -1)}
+	if len(d.x)+len(d.y) > maxDiffWords {
+		return appendOp(appendOp(nil, "delete", a), "insert", b)
+	}
+	d.diff(0, len(d.x), 0, len(d.y))
+	return d.ops
+}
+
+// differ diffs the words x and y, collecting the result in ops.
+type differ struct {
+	x, y []string
+	ops  []DiffOp
+}
+
+// diff appends the diff of x[x0:x1] and y[y0:y1] to ops.
+func (d *differ) diff(x0, x1, y0, y1 int) {
+	for x0 < x1 && y0 < y1 && d.x[x0] == d.y[y0] {
+		d.ops = appendOp(d.ops, "equal", d.x[x0])
+		x0++
+		y0++
+	}
+	suffix := 0
+	for x1-suffix > x0 && y1-suffix > y0 && d.x[x1-suffix-1] == d.y[y1-suffix-1] {
+		suffix++
+	}
+	x1 -= suffix
+	y1 -= suffix
+
+	if x0 < x1 && y0 < y1 {
+		if xm, ym, ok := d.middle(x0, x1, y0, y1); ok {
+			d.diff(x0, xm, y0, ym)
+			d.diff(xm, x1, ym, y1)
+		} else {
+			d.emit("delete", d.x[x0:x1])
+			d.emit("insert", d.y[y0:y1])
+		}
+	} else {
+		d.emit("delete", d.x[x0:x1])
+		d.emit("insert", d.y[y0:y1])
+	}
+	d.emit("equal", d.x[x1:x1+suffix])
+}
+
+func (d *differ) emit(op string, words []string) {
+	for _, w := range words {
+		d.ops = appendOp(d.ops, op, w)
+	}
+}
+
+// middle finds the middle snake of a shortest edit script turning x[x0:x1]
+// into y[y0:y1], searching forward from the start and backward from the end
+// at once, and returns the point to split the diff at. Both ranges must be
+// non-empty and differ in their first and last words. It gives up once the
+// search passes maxDiffEdits.
+func (d *differ) middle(x0, x1, y0, y1 int) (int, int, bool) {
+	n, m := x1-x0, y1-y0
+	maxD := min((n+m+1)/2, maxDiffEdits)
+	offset := maxD + 1
+	// forward[offset+k] and backward[offset+k] are the furthest x reached on
+	// diagonal k, counted from the start and from the end respectively.
+	forward := make([]int, 2*offset+1)
+	backward := make([]int, 2*offset+1)
+	for i := range forward {
+		forward[i], backward[i] = -1, -1
+	}
+	forward[offset+1], backward[offset+1] = 0, 0
+	delta := n - m
+	odd := delta%2 != 0
+
+	// Diagonals that ran off the edit graph are skipped from then on.
+	var kfStart, kfEnd, kbStart, kbEnd int
+	for e := 0; e < maxD; e++ {
+		for k := -e + kfStart; k <= e-kfEnd; k += 2 {
+			i := offset + k
+			var x int
+			if k == -e || k != e && forward[i-1] < forward[i+1] {
+				x = forward[i+1]
+			} else {
+				x = forward[i-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && d.x[x0+x] == d.y[y0+y] {
+				x++
+				y++
+			}
+			forward[i] = x
+			switch {
+			case x > n:
+				kfEnd += 2
+			case y > m:
+				kfStart += 2
+			case odd:
+				if j := offset + delta - k; j >= 0 && j < len(backward) && backward[j] != -1 && x >= n-backward[j] {
+					return x0 + x, y0 + y, true
+				}
+			}
+		}
+
+		for k := -e + kbStart; k <= e-kbEnd; k += 2 {
+			i := offset + k
+			var x int
+			if k == -e || k != e && backward[i-1] < backward[i+1] {
+				x = backward[i+1]
+			} else {
+				x = backward[i-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && d.x[x1-x-1] == d.y[y1-y-1] {
+				x++
+				y++
+			}
+			backward[i] = x
+			switch {
+			case x > n:
+				kbEnd += 2
+			case y > m:
+				kbStart += 2
+			case !odd:
+				if j := offset + delta - k; j >= 0 && j < len(forward) && forward[j] != -1 {
+					fx := forward[j]
+					if fx >= n-x {
+						return x0 + fx, y0 + fx - (j - offset), true
+					}
+				}
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// DiffCache remembers the diffs of the most recently diffed pairs of texts.
+// Revisions never change, so history listings diff the same pairs again and
+// again.
+type DiffCache struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List // of *diffEntry, most recently used first
+	byKey map[diffKey]*list.Element
+}
+
+type diffKey [sha256.Size]byte
+
+type diffEntry struct {
+	key diffKey
+	ops []DiffOp
+}
+
+// NewDiffCache returns a cache of the diffs of up to size pairs of texts.
+func NewDiffCache(size int) *DiffCache {
+	return &DiffCache{size: size, order: list.New(), byKey: make(map[diffKey]*list.Element)}
+}
+
+// Diff returns Diff(a, b), computing it only if the pair is not cached.
+func (c *DiffCache) Diff(a, b string) []DiffOp {
+	h := sha256.New()
+	h.Write(binary.AppendUvarint(nil, uint64(len(a))))
+	h.Write([]byte(a))
+	h.Write([]byte(b))
+	var key diffKey
+	h.Sum(key[:0])
+
+	c.mu.Lock()
+	if el, ok := c.byKey[key]; ok {
+		c.order.MoveToFront(el)
+		ops := slices.Clone(el.Value.(*diffEntry).ops)
+		c.mu.Unlock()
+		return ops
+	}
+	c.mu.Unlock()
+
+	ops := Diff(a, b)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.byKey[key]; !ok {
+		c.byKey[key] = c.order.PushFront(&diffEntry{key: key, ops: ops})
+		for c.order.Len() > c.size {
+			oldest := c.order.Remove(c.order.Back()).(*diffEntry)
+			delete(c.byKey, oldest.key)
+		}
+	}
+	return slices.Clone(ops)
+}
+
+// appendOp appends text to ops, merging it into the last chunk when the op matches.
+func appendOp(ops []DiffOp, op, text string) []DiffOp {
+	if text == "" {
+		return ops
+	}
+	if n := len(ops); n > 0 && ops[n-1].Op == op {
+		ops[n-1].Text += text
+		return ops
+	}
+	return append(ops, DiffOp{Op: op, Text: text})
+}
@@ -0,0 +1,217 @@
+package post
+
+import (
+	"math/rand/v2"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want []DiffOp
+	}{
+		{"both empty", "", "", nil},
+		{"same", "hello world", "hello world", []DiffOp{{"equal", "hello world"}}},
+		{"from empty", "", "hello", []DiffOp{{"insert", "hello"}}},
+		{"to empty", "hello", "", []DiffOp{{"delete", "hello"}}},
+		{"word replaced", "the quick fox", "the slow fox", []DiffOp{
+			{"equal", "the "}, {"delete", "quick"}, {"insert", "slow"}, {"equal", " fox"},
+		}},
+		{"word appended", "hello", "hello world", []DiffOp{{"equal", "hello"}, {"insert", " world"}}},
+		{"word removed", "a b c", "a c", []DiffOp{{"equal", "a "}, {"delete", "b "}, {"equal", "c"}}},
+		{"whitespace only", "a b", "a  b", []DiffOp{{"equal", "a"}, {"delete", " "}, {"insert", "  "}, {"equal", "b"}}},
+		{"nothing in common", "a", "b", []DiffOp{{"delete", "a"}, {"insert", "b"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Diff(tt.a, tt.b); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestDiffIsMinimal compares random diffs against the longest common
+// subsequence: a diff must rebuild both texts and keep as many words as
+// possible.
+func TestDiffIsMinimal(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	words := []string{"a", "b", "c", "d"}
+	text := func() string {
+		w := make([]string, rng.IntN(30))
+		for i := range w {
+			w[i] = words[rng.IntN(len(words))]
+		}
+		return strings.Join(w, " ")
+	}
+	for range 2000 {
+		a, b := text(), text()
+		ops := Diff(a, b)
+
+		var from, to strings.Builder
+		kept := 0
+		for _, op := range ops {
+			switch op.Op {
+			case "equal":
+				from.WriteString(op.Text)
+				to.WriteString(op.Text)
+				kept += len(diffTokenRe.FindAllString(op.Text, -1))
+			case "delete":
+				from.WriteString(op.Text)
+			case "insert":
+				to.WriteString(op.Text)
+			}
+		}
+		if from.String() != a || to.String() != b {
+			t.Fatalf("Diff(%q, %q) = %v rebuilds %q and %q", a, b, ops, from.String(), to.String())
+		}
+		if want := lcs(diffTokenRe.FindAllString(a, -1), diffTokenRe.FindAllString(b, -1)); kept != want {
+			t.Fatalf("Diff(%q, %q) keeps %d words, want %d", a, b, kept, want)
+		}
+	}
+}
+
+func lcs(x, y []string) int {
+	prev := make([]int, len(y)+1)
+	cur := make([]int, len(y)+1)
+	for i := range x {
+		for j := range y {
+			if x[i] == y[j] {
+				cur[j+1] = prev[j] + 1
+			} else {
+				cur[j+1] = max(prev[j+1], cur[j])
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(y)]
+}
+
+func TestDiffBounds(t *testing.T) {
+	words := func(n int, prefix string) string {
+		w := make([]string, n)
+		for i := range w {
+			w[i] = prefix + strings.Repeat("x", i%7)
+		}
+		return strings.Join(w, " ")
+	}
+	tests := []struct {
+		name string
+		a, b string
+	}{
+		{"too many words", words(maxDiffWords, "a"), words(maxDiffWords, "a") + " b"},
+		{"too far apart", words(2*maxDiffEdits, "a"), words(2*maxDiffEdits, "b")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := []DiffOp{{"delete", tt.a}, {"insert", tt.b}}
+			if got := Diff(tt.a, tt.b); !reflect.DeepEqual(got, want) {
+				t.Errorf("got %d ops, want the whole text replaced", len(got))
+			}
+		})
+	}
+
+	// Long texts with a few changes still get a proper diff. Spaces count
+	// as words too.
+	w := strings.Fields(words(maxDiffWords/4, "a"))
+	a := strings.Join(w, " ")
+	for _, i := range []int{100, 2000, 4000} {
+		w[i] = "changed"
+	}
+	if ops := Diff(a, strings.Join(w, " ")); len(ops) != 10 {
+		t.Errorf("got %d ops for 3 changed words, want 10", len(ops))
+	}
+}
+
+func TestDiffCache(t *testing.T) {
+	c := NewDiffCache(2)
+	first := c.Diff("a b", "a c")
+	if want := Diff("a b", "a c"); !reflect.DeepEqual(first, want) {
+		t.Fatalf("Diff = %v, want %v", first, want)
+	}
+	first[0].Text = "changed"
+	if got := c.Diff("a b", "a c"); got[0].Text != "a " {
+		t.Errorf("cached diff changed through a returned copy: %v", got)
+	}
+
+	// Pairs that concatenate alike are different keys.
+	if got := c.Diff("a", "bc"); !reflect.DeepEqual(got, Diff("a", "bc")) {
+		t.Errorf("Diff(a, bc) = %v", got)
+	}
+	if got := c.Diff("ab", "c"); !reflect.DeepEqual(got, Diff("ab", "c")) {
+		t.Errorf("Diff(ab, c) = %v", got)
+	}
+	if c.order.Len() != 2 || len(c.byKey) != 2 {
+		t.Errorf("cache holds %d entries and %d keys, want 2", c.order.Len(), len(c.byKey))
+	}
+}
+
+func TestRepoEditKeepsUnsetFields(t *testing.T) {
+	title, text := "new title", "new text"
+	tests := []struct {
+		name string
+		edit Edit
+		want [3]string // title, url, text
+	}{
+		{"title", Edit{Title: &title}, [3]string{"new title", "https://example.com", "text of p1"}},
+		{"text", Edit{Text: &text}, [3]string{"title p1", "https://example.com", "new text"}},
+		{"clear url", Edit{URL: new(string)}, [3]string{"title p1", "", "text of p1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forEachRepo(t, func(t *testing.T, r Repo) {
+				p := newPost("p1", "music", "alice", 0)
+				p.URL = "https://example.com"
+				addPosts(t, r, p)
+				tt.edit.Edited = testTime.Add(time.Hour)
+				got, err := r.Edit("p1", tt.edit)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if fields := [3]string{got.Title, got.URL, got.Text}; fields != tt.want {
+					t.Errorf("after Edit = %q, want %q", fields, tt.want)
+				}
+				history, err := r.History("p1", "")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(history) != 1 || history[0].Title != "title p1" || history[0].URL != "https://example.com" {
+					t.Errorf("history = %+v, want the original post", history)
+				}
+			})
+		})
+	}
+}
+
+// TestRepoConcurrentEdits edits different fields of a post at once: none
+// of the edits may undo another.
+func TestRepoConcurrentEdits(t *testing.T) {
+	forEachRepo(t, func(t *testing.T, r Repo) {
+		addPosts(t, r, newPost("p1", "music", "alice", 0))
+		title, text := "new title", "new text"
+		edits := []Edit{{Title: &title}, {Text: &text}}
+		done := make(chan error, len(edits))
+		for _, e := range edits {
+			go func() {
+				_, err := r.Edit("p1", e)
+				done <- err
+			}()
+		}
+		for range edits {
+			if err := <-done; err != nil {
+				t.Fatal(err)
+			}
+		}
+		p, err := r.GetByID("p1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Title != title || p.Text != text {
+			t.Errorf("title %q and text %q, want both edits", p.Title, p.Text)
+		}
+	})
+}
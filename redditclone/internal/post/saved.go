@@ -0,0 +1,176 @@
+package post
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"maps"
+	"slices"
+	"time"
+)
+
+// SavedQuery selects a page of the live posts a user saved, most recently
+// saved first. After is the Next cursor of the previous page. A zero Limit
+// returns everything.
+type SavedQuery struct {
+	UserID string
+	Limit  int
+	After  string
+}
+
+// savedCursor is the position of a post in a user's saved listing: when it
+// was saved, in Unix microseconds, ties broken by post ID.
+type savedCursor struct {
+	At     int64  `json:"a"`
+	PostID string `json:"p"`
+}
+
+func (c savedCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// before reports whether c comes before other in the listing.
+func (c savedCursor) before(other savedCursor) bool {
+	if c.At != other.At {
+		return c.At > other.At
+	}
+	return c.PostID > other.PostID
+}
+
+func decodeSavedCursor(s string) (*savedCursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrBadCursor
+	}
+	var c savedCursor
+	if err = json.Unmarshal(data, &c); err != nil || c.PostID == "" {
+		return nil, ErrBadCursor
+	}
+	return &c, nil
+}
+
+// newSavedPage trims posts fetched in listing order, one more than the
+// limit, to a page.
+func newSavedPage(q SavedQuery, posts []*Post, pos []savedCursor) *Page {
+	page := &Page{Posts: posts}
+	if q.Limit > 0 && len(posts) > q.Limit {
+		page.Posts = posts[:q.Limit]
+		page.Next = pos[q.Limit-1].encode()
+	}
+	return page
+}
+
+// Save bookmarks a live post for the user. Saving it again keeps the
+// original time.
+func (r *MemoryRepo) Save(postID, userID string, saved time.Time) error {
+	_, err := r.mutate(postID, func(e *entry) error {
+		if e.post.IsDeleted() {
+			return ErrDeleted
+		}
+		if _, ok := e.savedBy[userID]; !ok {
+			r.save(e, userID, saved.UnixMicro())
+		}
+		return nil
+	})
+	return err
+}
+
+func (r *MemoryRepo) save(e *entry, userID string, at int64) {
+	if e.savedBy == nil {
+		e.savedBy = make(map[string]int64)
+	}
+	e.savedBy[userID] = at
+	addToIndex(r.saved, userID, e)
+}
+
+func (r *MemoryRepo) Unsave(postID, userID string) error {
+	_, err := r.mutate(postID, func(e *entry) error {
+		delete(e.savedBy, userID)
+		removeFromIndex(r.saved, userID, postID)
+		return nil
+	})
+	return err
+}
+
+// Hide leaves a live post out of the listings the user asks for.
+func (r *MemoryRepo) Hide(postID, userID string) error {
+	_, err := r.mutate(postID, func(e *entry) error {
+		if e.post.IsDeleted() {
+			return ErrDeleted
+		}
+		r.hide(e, userID)
+		return nil
+	})
+	return err
+}
+
+func (r *MemoryRepo) hide(e *entry, userID string) {
+	if e.hiddenBy == nil {
+		e.hiddenBy = make(map[string]bool)
+	}
+	e.hiddenBy[userID] = true
+	addToIndex(r.hidden, userID, e)
+}
+
+func (r *MemoryRepo) Unhide(postID, userID string) error {
+	_, err := r.mutate(postID, func(e *entry) error {
+		delete(e.hiddenBy, userID)
+		removeFromIndex(r.hidden, userID, postID)
+		return nil
+	})
+	return err
+}
+
+// Hidden returns the IDs of the posts the user hid, deleted ones included.
+func (r *MemoryRepo) Hidden(userID string) ([]string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return slices.Collect(maps.Keys(r.hidden[userID])), nil
+}
+
+func (r *MemoryRepo) ListSaved(q SavedQuery) (*Page, error) {
+	after, err := decodeSavedCursor(q.After)
+	if err != nil {
+		return nil, err
+	}
+	q.Limit = min(q.Limit, MaxPageSize)
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	type savedEntry struct {
+		e   *entry
+		pos savedCursor
+	}
+	var entries []savedEntry
+	for _, e := range r.saved[q.UserID] {
+		pos := savedCursor{At: e.savedBy[q.UserID], PostID: e.post.ID}
+		if e.post.IsDeleted() || after != nil && !after.before(pos) {
+			continue
+		}
+		entries = append(entries, savedEntry{e: e, pos: pos})
+	}
+	slices.SortFunc(entries, func(a, b savedEntry) int {
+		if a.pos == b.pos {
+			return 0
+		}
+		if a.pos.before(b.pos) {
+			return -1
+		}
+		return 1
+	})
+	if q.Limit > 0 && len(entries) > q.Limit+1 {
+		entries = entries[:q.Limit+1]
+	}
+
+	posts := make([]*Post, len(entries))
+	pos := make([]savedCursor, len(entries))
+	for i, se := range entries {
+		posts[i] = se.e.post.Clone()
+		pos[i] = se.pos
+	}
+	return newSavedPage(q, posts, pos), nil
+}
@@ -0,0 +1,58 @@
+package post
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRepoHidden(t *testing.T) {
+	forEachRepo(t, func(t *testing.T, r Repo) {
+		addPosts(t, r, newPost("p1", "music", "alice", 0), newPost("p2", "music", "alice", 1),
+			newPost("p3", "news", "bob", 2), newPost("p4", "news", "bob", 3))
+		if err := r.Delete("p4", testTime); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Hide("p4", "u1"); err != ErrDeleted {
+			t.Errorf("hiding a deleted post: error = %v, want ErrDeleted", err)
+		}
+
+		steps := []struct {
+			name   string
+			change func() error
+			want   map[string][]string // user ID -> hidden post IDs
+		}{
+			{"hidden", func() error {
+				for _, h := range [][2]string{{"p1", "u1"}, {"p2", "u1"}, {"p3", "u2"}, {"p1", "u1"}} {
+					if err := r.Hide(h[0], h[1]); err != nil {
+						return err
+					}
+				}
+				return nil
+			}, map[string][]string{"u1": {"p1", "p2"}, "u2": {"p3"}, "u3": nil}},
+			{"unhidden", func() error { return r.Unhide("p2", "u1") },
+				map[string][]string{"u1": {"p1"}, "u2": {"p3"}}},
+			{"deleted post", func() error { return r.Delete("p1", testTime) },
+				map[string][]string{"u1": {"p1"}}},
+			{"purged post", func() error {
+				_, err := r.Purge(testTime.Add(time.Minute))
+				return err
+			}, map[string][]string{"u1": nil, "u2": {"p3"}}},
+		}
+		for _, step := range steps {
+			if err := step.change(); err != nil {
+				t.Fatalf("%s: %v", step.name, err)
+			}
+			for user, want := range step.want {
+				got, err := r.Hidden(user)
+				if err != nil {
+					t.Fatal(err)
+				}
+				slices.Sort(got)
+				if !slices.Equal(got, want) {
+					t.Errorf("%s: %s hid %v, want %v", step.name, user, got, want)
+				}
+			}
+		}
+	})
+}
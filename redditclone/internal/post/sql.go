@@ -0,0 +1,963 @@
+package post
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SQLRepo stores posts, votes and comments in normalized tables.
+// The schema is created by the storage package migrations.
+type SQLRepo struct {
+	db *sql.DB
+}
+
+// querier is implemented by both *sql.DB and *sql.Tx.
+type querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+func NewSQLRepo(db *sql.DB) *SQLRepo {
+	return &SQLRepo{db: db}
+}
+
+func (r *SQLRepo) GetAll() ([]*Post, error) {
+	return r.readPosts(`deleted IS NULL`)
+}
+
+func (r *SQLRepo) GetByID(id string) (*Post, error) {
+	posts, err := r.readPosts(`id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(posts) == 0 {
+		return nil, ErrNotFound
+	}
+	return posts[0], nil
+}
+
+func (r *SQLRepo) GetByCategory(category string) ([]*Post, error) {
+	return r.readPosts(`category = ? AND deleted IS NULL`, category)
+}
+
+func (r *SQLRepo) GetByAuthor(authorUsername string) ([]*Post, error) {
+	return r.readPosts(`author_username = ? AND deleted IS NULL`, authorUsername)
+}
+
+func (r *SQLRepo) List(q ListQuery) (*Page, error) {
+	if err := q.Normalize(); err != nil {
+		return nil, err
+	}
+	after, err := decodeCursor(q.After, q.Sort)
+	if err != nil {
+		return nil, err
+	}
+	before, err := decodeCursor(q.Before, q.Sort)
+	if err != nil {
+		return nil, err
+	}
+
+	now := q.Now.Unix()
+	key := sqlSortKey(q.Sort, now)
+
+	where := []string{"deleted IS NULL"}
+	var args []any
+	if since := q.since(); !since.IsZero() {
+		where = append(where, "created_unix >= ?")
+		args = append(args, since.Unix())
+	}
+	if q.Category != "" {
+		where = append(where, "category = ?")
+		args = append(args, q.Category)
+	}
+	if q.Categories != nil {
+		where = append(where, "category IN ("+placeholders(len(q.Categories))+")")
+		for _, c := range q.Categories {
+			args = append(args, c)
+		}
+	}
+	if q.Author != "" {
+		where = append(where, "author_username = ?")
+		args = append(args, q.Author)
+	}
+	if q.Viewer != "" {
+		where = append(where, "id NOT IN (SELECT post_id FROM hidden_posts WHERE user_id = ?)")
+		args = append(args, q.Viewer)
+	}
+	order := "DESC"
+	switch {
+	case after != nil:
+		where = append(where, "("+key+" < ? OR ("+key+" = ? AND seq < ?))")
+		args = append(args, after.Key, after.Key, after.Seq)
+	case before != nil:
+		where = append(where, "("+key+" > ? OR ("+key+" = ? AND seq > ?))")
+		args = append(args, before.Key, before.Key, before.Seq)
+		order = "ASC"
+	}
+
+	clause := "WHERE " + strings.Join(where, " AND ") + " ORDER BY " + key + " " + order + ", seq " + order
+	if q.Limit > 0 {
+		// One extra row tells whether there is another page.
+		clause += " LIMIT " + strconv.Itoa(q.Limit+1)
+	}
+
+	var posts []*Post
+	var pos []cursor
+	err = r.read(func(tx *sql.Tx) (err error) {
+		posts, pos, err = selectPosts(tx, key, clause, args...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	items := make([]ranked, len(posts))
+	for i, p := range posts {
+		items[i] = ranked{post: p, pos: pos[i]}
+	}
+	return newPage(q, items, after, before), nil
+}
+
+func (r *SQLRepo) Add(post *Post) (*Post, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	ups, downs := post.Tally()
+	_, err = tx.Exec(`INSERT INTO posts (id, title, url, author_id, author_username, category, score, created, views, type, text,
+			created_unix, ups, downs, hot, controversy, seq)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, (SELECT COALESCE(MAX(seq), 0) + 1 FROM posts))`,
+		post.ID, post.Title, post.URL, authorID(post.Author), authorUsername(post.Author),
+		post.Category, post.Score, post.Created, post.Views, post.Type, post.Text,
+		post.Created.Unix(), ups, downs, Hot(post.Score, post.Created.Unix()), Controversy(ups, downs))
+	if err != nil {
+		return nil, err
+	}
+	if err = writeRelations(tx, post); err != nil {
+		return nil, err
+	}
+	if err = credit(tx, authorID(post.Author), postKarma(post), 0); err != nil {
+		return nil, err
+	}
+	for _, c := range post.Comments {
+		if err = credit(tx, authorID(c.Author), 0, commentKarma(c)); err != nil {
+			return nil, err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return post, nil
+}
+
+func (r *SQLRepo) Delete(id string, deleted time.Time) error {
+	_, err := r.mutate(id, func(tx *sql.Tx) error {
+		if err := requireLive(tx, id); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`UPDATE posts SET deleted = ? WHERE id = ?`, deleted, id)
+		return err
+	})
+	return err
+}
+
+func (r *SQLRepo) Restore(id string) (*Post, error) {
+	return r.mutate(id, func(tx *sql.Tx) error {
+		res, err := tx.Exec(`UPDATE posts SET deleted = NULL WHERE id = ? AND deleted IS NOT NULL`, id)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrNotDeleted
+		}
+		return nil
+	})
+}
+
+func (r *SQLRepo) Purge(before time.Time) (int, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// deleted holds Unix microseconds, so the cutoff is compared as a number.
+	cutoff := before.UnixMicro()
+
+	// Purged posts and comments take the karma they earned with them.
+	_, err = tx.Exec(`UPDATE karma SET post = post - (SELECT COALESCE(SUM(v.vote), 0)
+			FROM votes v JOIN posts p ON p.id = v.post_id
+			WHERE p.deleted < ? AND p.author_id = karma.user_id AND v.user_id != p.author_id)
+		WHERE user_id IN (SELECT author_id FROM posts WHERE deleted < ?)`, cutoff, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	deletedPosts := `SELECT id FROM posts WHERE deleted < ?`
+	if err = debitComments(tx, `SELECT id FROM comments WHERE post_id IN (`+deletedPosts+`)`, cutoff); err != nil {
+		return 0, err
+	}
+	for _, table := range []string{"votes", "comment_votes", "comments", "revisions", "saved_posts", "hidden_posts"} {
+		if _, err = tx.Exec(`DELETE FROM `+table+` WHERE post_id IN (`+deletedPosts+`)`, cutoff); err != nil {
+			return 0, err
+		}
+	}
+	res, err := tx.Exec(`DELETE FROM posts WHERE deleted < ?`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	posts, _ := res.RowsAffected()
+
+	// Deleted comments with replies stay as tombstones. Every pass removes
+	// the purgeable leaves, which may turn their parents into leaves, so
+	// threads are cleared bottom-up in at most MaxCommentDepth+1 passes.
+	purgeable := `SELECT id FROM comments c WHERE deleted < ?
+		AND NOT EXISTS (SELECT 1 FROM comments r WHERE r.parent_id = c.id)`
+	var comments int64
+	for {
+		if err = debitComments(tx, purgeable, cutoff); err != nil {
+			return 0, err
+		}
+		for _, table := range []string{"revisions", "comment_votes"} {
+			_, err = tx.Exec(`DELETE FROM `+table+` WHERE comment_id IN (`+purgeable+`)`, cutoff)
+			if err != nil {
+				return 0, err
+			}
+		}
+		res, err = tx.Exec(`DELETE FROM comments WHERE id IN (`+purgeable+`)`, cutoff)
+		if err != nil {
+			return 0, err
+		}
+		n, _ := res.RowsAffected()
+		if n == 0 {
+			break
+		}
+		comments += n
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return int(posts + comments), nil
+}
+
+func (r *SQLRepo) Vote(postID, userID string, vote int) (*Post, error) {
+	return r.mutate(postID, func(tx *sql.Tx) error {
+		if err := requireLive(tx, postID); err != nil {
+			return err
+		}
+		var prev int
+		err := tx.QueryRow(`SELECT vote FROM votes WHERE post_id = ? AND user_id = ?`, postID, userID).Scan(&prev)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		if vote == 0 {
+			_, err = tx.Exec(`DELETE FROM votes WHERE post_id = ? AND user_id = ?`, postID, userID)
+		} else {
+			_, err = tx.Exec(`INSERT INTO votes (post_id, user_id, vote) VALUES (?, ?, ?)
+				ON CONFLICT (post_id, user_id) WHERE user_id != '' DO UPDATE SET vote = excluded.vote`, postID, userID, vote)
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`UPDATE posts SET score = score + ?, ups = ups + ?, downs = downs + ? WHERE id = ?`,
+			vote-prev, tally(vote, 1)-tally(prev, 1), tally(vote, -1)-tally(prev, -1), postID)
+		if err != nil {
+			return err
+		}
+		var author string
+		if err = tx.QueryRow(`SELECT author_id FROM posts WHERE id = ?`, postID).Scan(&author); err != nil {
+			return err
+		}
+		if author != userID {
+			if err = credit(tx, author, vote-prev, 0); err != nil {
+				return err
+			}
+		}
+		return rerank(tx, postID)
+	})
+}
+
+func (r *SQLRepo) ListComments(q CommentQuery) (*CommentPage, error) {
+	after, err := decodeCommentCursor(q.After)
+	if err != nil {
+		return nil, err
+	}
+	q.Limit = min(q.Limit, MaxPageSize)
+
+	where := `c.author_username = ? AND c.deleted IS NULL AND p.deleted IS NULL`
+	args := []any{q.Author}
+	if after != nil {
+		where += ` AND (c.created_unix < ? OR (c.created_unix = ? AND c.id < ?))`
+		args = append(args, after.Created, after.Created, after.ID)
+	}
+	query := `SELECT ` + commentColumns + `, p.id, p.title, p.category FROM comments c JOIN posts p ON p.id = c.post_id
+		WHERE ` + where + ` ORDER BY c.created_unix DESC, c.id DESC`
+	if q.Limit > 0 {
+		// One extra row tells whether there is another page.
+		query += ` LIMIT ` + strconv.Itoa(q.Limit+1)
+	}
+
+	var comments []*UserComment
+	err = r.read(func(tx *sql.Tx) error {
+		rows, err := tx.Query(query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		byID := make(map[string]*Comment)
+		for rows.Next() {
+			uc := &UserComment{}
+			uc.Comment, err = scanComment(rows, &uc.PostID, &uc.PostTitle, &uc.Category)
+			if err != nil {
+				return err
+			}
+			comments = append(comments, uc)
+			byID[uc.ID] = uc.Comment
+		}
+		if err = rows.Err(); err != nil {
+			return err
+		}
+
+		ids := make([]any, 0, len(byID))
+		for id := range byID {
+			ids = append(ids, id)
+		}
+		return loadCommentVotes(tx, byID, `comment_id IN (`+placeholders(len(ids))+`)`, ids)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newCommentPage(q, comments), nil
+}
+
+func (r *SQLRepo) Karma(userID string) (Karma, error) {
+	var k Karma
+	err := r.db.QueryRow(`SELECT post, comment FROM karma WHERE user_id = ?`, userID).Scan(&k.Post, &k.Comment)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Karma{}, nil
+	}
+	return k, err
+}
+
+func (r *SQLRepo) Save(postID, userID string, saved time.Time) error {
+	_, err := r.mutate(postID, func(tx *sql.Tx) error {
+		if err := requireLive(tx, postID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`INSERT INTO saved_posts (user_id, post_id, saved_at) VALUES (?, ?, ?)
+			ON CONFLICT DO NOTHING`, userID, postID, saved.UnixMicro())
+		return err
+	})
+	return err
+}
+
+func (r *SQLRepo) Unsave(postID, userID string) error {
+	_, err := r.mutate(postID, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`DELETE FROM saved_posts WHERE user_id = ? AND post_id = ?`, userID, postID)
+		return err
+	})
+	return err
+}
+
+func (r *SQLRepo) Hide(postID, userID string) error {
+	_, err := r.mutate(postID, func(tx *sql.Tx) error {
+		if err := requireLive(tx, postID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`INSERT INTO hidden_posts (user_id, post_id) VALUES (?, ?) ON CONFLICT DO NOTHING`, userID, postID)
+		return err
+	})
+	return err
+}
+
+func (r *SQLRepo) Unhide(postID, userID string) error {
+	_, err := r.mutate(postID, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`DELETE FROM hidden_posts WHERE user_id = ? AND post_id = ?`, userID, postID)
+		return err
+	})
+	return err
+}
+
+func (r *SQLRepo) Hidden(userID string) ([]string, error) {
+	rows, err := r.db.Query(`SELECT post_id FROM hidden_posts WHERE user_id = ?`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (r *SQLRepo) ListSaved(q SavedQuery) (*Page, error) {
+	after, err := decodeSavedCursor(q.After)
+	if err != nil {
+		return nil, err
+	}
+	q.Limit = min(q.Limit, MaxPageSize)
+
+	clause := `JOIN saved_posts s ON s.post_id = posts.id WHERE s.user_id = ? AND deleted IS NULL`
+	args := []any{q.UserID}
+	if after != nil {
+		clause += ` AND (s.saved_at < ? OR (s.saved_at = ? AND posts.id < ?))`
+		args = append(args, after.At, after.At, after.PostID)
+	}
+	clause += ` ORDER BY s.saved_at DESC, posts.id DESC`
+	if q.Limit > 0 {
+		// One extra row tells whether there is another page.
+		clause += ` LIMIT ` + strconv.Itoa(q.Limit+1)
+	}
+
+	// Microseconds fit a float64 exactly, so the cursor keys carry saved_at.
+	var posts []*Post
+	var keys []cursor
+	err = r.read(func(tx *sql.Tx) (err error) {
+		posts, keys, err = selectPosts(tx, "s.saved_at", clause, args...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	pos := make([]savedCursor, len(posts))
+	for i, p := range posts {
+		pos[i] = savedCursor{At: int64(keys[i].Key), PostID: p.ID}
+	}
+	return newSavedPage(q, posts, pos), nil
+}
+
+// credit adds karma to a user.
+func credit(tx *sql.Tx, userID string, post, comment int) error {
+	if userID == "" || post == 0 && comment == 0 {
+		return nil
+	}
+	_, err := tx.Exec(`INSERT INTO karma (user_id, post, comment) VALUES (?, ?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET post = post + excluded.post, comment = comment + excluded.comment`,
+		userID, post, comment)
+	return err
+}
+
+// debitComments takes away the karma the comments selected by the ids query
+// earned their authors. The query takes arg once.
+func debitComments(tx *sql.Tx, ids string, arg any) error {
+	_, err := tx.Exec(`UPDATE karma SET comment = comment - (SELECT COALESCE(SUM(v.vote), 0)
+			FROM comment_votes v JOIN comments c ON c.id = v.comment_id
+			WHERE c.id IN (`+ids+`) AND c.author_id = karma.user_id AND v.user_id != c.author_id)
+		WHERE user_id IN (SELECT author_id FROM comments WHERE id IN (`+ids+`))`, arg, arg)
+	return err
+}
+
+// rerank recomputes the stored hot and controversy scores of a post.
+func rerank(tx *sql.Tx, postID string) error {
+	var score, ups, downs int
+	var created int64
+	err := tx.QueryRow(`SELECT score, ups, downs, created_unix FROM posts WHERE id = ?`, postID).
+		Scan(&score, &ups, &downs, &created)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE posts SET hot = ?, controversy = ? WHERE id = ?`,
+		Hot(score, created), Controversy(ups, downs), postID)
+	return err
+}
+
+// tally is 1 if vote counts towards dir and 0 otherwise.
+func tally(vote, dir int) int {
+	if vote == dir {
+		return 1
+	}
+	return 0
+}
+
+func (r *SQLRepo) AddComment(postID string, comment *Comment) (*Post, error) {
+	return r.mutate(postID, func(tx *sql.Tx) error {
+		if err := requireLive(tx, postID); err != nil {
+			return err
+		}
+		depth := 0
+		if comment.ParentID != "" {
+			parent, err := loadComment(tx, postID, comment.ParentID)
+			if err != nil {
+				return err
+			}
+			if depth, err = replyDepth(parent); err != nil {
+				return err
+			}
+		}
+		_, err := tx.Exec(`INSERT INTO comments (id, post_id, parent_id, depth, author_id, author_username, body, created, created_unix)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			comment.ID, postID, nullString(comment.ParentID), depth,
+			authorID(comment.Author), authorUsername(comment.Author), comment.Body, comment.Created, comment.Created.Unix())
+		return err
+	})
+}
+
+func (r *SQLRepo) VoteComment(postID, commentID, userID string, vote int) (*Post, error) {
+	return r.mutate(postID, func(tx *sql.Tx) error {
+		if err := requireLive(tx, postID); err != nil {
+			return err
+		}
+		isDeleted, err := commentDeleted(tx, postID, commentID)
+		if err != nil {
+			return err
+		}
+		if isDeleted {
+			return ErrDeleted
+		}
+		var prev int
+		err = tx.QueryRow(`SELECT vote FROM comment_votes WHERE comment_id = ? AND user_id = ?`, commentID, userID).Scan(&prev)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		if vote == 0 {
+			_, err = tx.Exec(`DELETE FROM comment_votes WHERE comment_id = ? AND user_id = ?`, commentID, userID)
+		} else {
+			_, err = tx.Exec(`INSERT INTO comment_votes (comment_id, post_id, user_id, vote) VALUES (?, ?, ?, ?)
+				ON CONFLICT (comment_id, user_id) WHERE user_id != '' DO UPDATE SET vote = excluded.vote`, commentID, postID, userID, vote)
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`UPDATE comments SET score = score + ? WHERE id = ?`, vote-prev, commentID)
+		if err != nil {
+			return err
+		}
+		var author string
+		if err = tx.QueryRow(`SELECT author_id FROM comments WHERE id = ?`, commentID).Scan(&author); err != nil {
+			return err
+		}
+		if author == userID {
+			return nil
+		}
+		return credit(tx, author, 0, vote-prev)
+	})
+}
+
+func (r *SQLRepo) DeleteComment(postID, commentID string, deleted time.Time) (*Post, error) {
+	return r.mutate(postID, func(tx *sql.Tx) error {
+		isDeleted, err := commentDeleted(tx, postID, commentID)
+		if err != nil {
+			return err
+		}
+		if isDeleted {
+			return ErrDeleted
+		}
+		_, err = tx.Exec(`UPDATE comments SET deleted = ? WHERE id = ?`, deleted, commentID)
+		return err
+	})
+}
+
+func (r *SQLRepo) RestoreComment(postID, commentID string) (*Post, error) {
+	return r.mutate(postID, func(tx *sql.Tx) error {
+		isDeleted, err := commentDeleted(tx, postID, commentID)
+		if err != nil {
+			return err
+		}
+		if !isDeleted {
+			return ErrNotDeleted
+		}
+		_, err = tx.Exec(`UPDATE comments SET deleted = NULL WHERE id = ?`, commentID)
+		return err
+	})
+}
+
+func (r *SQLRepo) IncrementViews(postID string) (*Post, error) {
+	return r.mutate(postID, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`UPDATE posts SET views = views + 1 WHERE id = ?`, postID)
+		return err
+	})
+}
+
+func (r *SQLRepo) Edit(postID string, edit Edit) (*Post, error) {
+	return r.mutate(postID, func(tx *sql.Tx) error {
+		if err := requireLive(tx, postID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`INSERT INTO revisions (post_id, title, url, text, created)
+			SELECT id, title, url, text, COALESCE(edited, created) FROM posts WHERE id = ?`, postID)
+		if err != nil {
+			return err
+		}
+		// Fields left out of the edit keep their value.
+		_, err = tx.Exec(`UPDATE posts SET title = COALESCE(?, title), url = COALESCE(?, url),
+			text = COALESCE(?, text), edited = ? WHERE id = ?`,
+			edit.Title, edit.URL, edit.Text, edit.Edited, postID)
+		return err
+	})
+}
+
+func (r *SQLRepo) EditComment(postID, commentID, body string, edited time.Time) (*Post, error) {
+	return r.mutate(postID, func(tx *sql.Tx) error {
+		if err := requireLive(tx, postID); err != nil {
+			return err
+		}
+		isDeleted, err := commentDeleted(tx, postID, commentID)
+		if err != nil {
+			return err
+		}
+		if isDeleted {
+			return ErrDeleted
+		}
+		_, err = tx.Exec(`INSERT INTO revisions (post_id, comment_id, text, created)
+			SELECT post_id, id, body, COALESCE(edited, created) FROM comments WHERE id = ?`, commentID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`UPDATE comments SET body = ?, edited = ? WHERE id = ?`, body, edited, commentID)
+		return err
+	})
+}
+
+func (r *SQLRepo) History(postID, commentID string) ([]*Revision, error) {
+	revisions := make([]*Revision, 0)
+	err := r.read(func(tx *sql.Tx) error {
+		var exists bool
+		err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM posts WHERE id = ?)`, postID).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrNotFound
+		}
+		if commentID != "" {
+			err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM comments WHERE id = ? AND post_id = ?)`, commentID, postID).Scan(&exists)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				return ErrCommentNotFound
+			}
+		}
+
+		rows, err := tx.Query(`SELECT comment_id, title, url, text, created FROM revisions
+			WHERE post_id = ? AND comment_id = ? ORDER BY id`, postID, commentID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			rev := &Revision{}
+			if err = rows.Scan(&rev.CommentID, &rev.Title, &rev.URL, &rev.Text, &rev.Created); err != nil {
+				return err
+			}
+			revisions = append(revisions, rev)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+// read runs fn in a transaction, so that the statements it runs see the
+// database as of one moment: the votes and comments loaded for a page of
+// posts are those of the same posts, whatever commits in between. Nothing
+// is written, so the transaction is rolled back when fn returns.
+func (r *SQLRepo) read(fn func(tx *sql.Tx) error) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	return fn(tx)
+}
+
+// mutate runs fn in a transaction and returns the post as seen at the end of it.
+func (r *SQLRepo) mutate(postID string, fn func(tx *sql.Tx) error) (*Post, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var exists bool
+	if err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM posts WHERE id = ?)`, postID).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrNotFound
+	}
+
+	if err = fn(tx); err != nil {
+		return nil, err
+	}
+
+	posts, err := queryPosts(tx, `id = ?`, postID)
+	if err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return posts[0], nil
+}
+
+// requireLive returns ErrDeleted if the post is deleted.
+func requireLive(tx *sql.Tx, postID string) error {
+	var deleted sql.NullTime
+	if err := tx.QueryRow(`SELECT deleted FROM posts WHERE id = ?`, postID).Scan(&deleted); err != nil {
+		return err
+	}
+	if deleted.Valid {
+		return ErrDeleted
+	}
+	return nil
+}
+
+// loadComment reads the depth and deletion time of a comment, which is all
+// replyDepth needs.
+func loadComment(tx *sql.Tx, postID, commentID string) (*Comment, error) {
+	c := &Comment{ID: commentID}
+	var deleted sql.NullTime
+	err := tx.QueryRow(`SELECT depth, deleted FROM comments WHERE id = ? AND post_id = ?`, commentID, postID).
+		Scan(&c.Depth, &deleted)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrCommentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.Deleted = deleted.Time
+	return c, nil
+}
+
+func commentDeleted(tx *sql.Tx, postID, commentID string) (bool, error) {
+	var deleted sql.NullTime
+	err := tx.QueryRow(`SELECT deleted FROM comments WHERE id = ? AND post_id = ?`, commentID, postID).Scan(&deleted)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, ErrCommentNotFound
+	}
+	if err != nil {
+		return false, err
+	}
+	return deleted.Valid, nil
+}
+
+func writeRelations(tx *sql.Tx, post *Post) error {
+	for _, v := range post.Votes {
+		_, err := tx.Exec(`INSERT INTO votes (post_id, user_id, vote) VALUES (?, ?, ?)`, post.ID, v.User, v.Vote)
+		if err != nil {
+			return err
+		}
+	}
+	for _, c := range post.Comments {
+		_, err := tx.Exec(`INSERT INTO comments (id, post_id, parent_id, depth, author_id, author_username, body, score, created, created_unix)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			c.ID, post.ID, nullString(c.ParentID), c.Depth, authorID(c.Author), authorUsername(c.Author), c.Body, c.Score,
+			c.Created, c.Created.Unix())
+		if err != nil {
+			return err
+		}
+		for _, v := range c.Votes {
+			_, err = tx.Exec(`INSERT INTO comment_votes (comment_id, post_id, user_id, vote) VALUES (?, ?, ?, ?)`,
+				c.ID, post.ID, v.User, v.Vote)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// queryPosts loads the posts matching where in insertion order, together with their votes and comments.
+func queryPosts(q querier, where string, args ...any) ([]*Post, error) {
+	posts, _, err := selectPosts(q, "seq", `WHERE `+where+` ORDER BY seq`, args...)
+	return posts, err
+}
+
+// readPosts runs queryPosts in a read transaction.
+func (r *SQLRepo) readPosts(where string, args ...any) (posts []*Post, err error) {
+	err = r.read(func(tx *sql.Tx) error {
+		posts, err = queryPosts(tx, where, args...)
+		return err
+	})
+	return posts, err
+}
+
+// sqlSortKey is the SQL expression posts are ordered by, highest first. It
+// must not contain placeholders, as it precedes the clause arguments.
+func sqlSortKey(sort Sort, now int64) string {
+	switch sort {
+	case SortNew:
+		return "seq"
+	case SortHot:
+		return "hot"
+	case SortRising:
+		// Same arithmetic as Rising.
+		return "(score * 1.0 / ((" + strconv.FormatInt(now, 10) + " - created_unix) / 3600.0 + 2))"
+	case SortControversial:
+		return "controversy"
+	default:
+		return "score"
+	}
+}
+
+// selectPosts loads the posts selected by clause (a WHERE clause, optionally
+// followed by ORDER BY and LIMIT) together with their votes and comments.
+// pos holds the value of the key expression and the sequence number of each post.
+func selectPosts(q querier, key, clause string, args ...any) (posts []*Post, pos []cursor, err error) {
+	rows, err := q.Query(`SELECT id, title, url, author_id, author_username, category, score, created, views, type, text, edited, deleted, seq, `+key+`
+		FROM posts `+clause, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	posts = make([]*Post, 0)
+	byID := make(map[string]*Post)
+	for rows.Next() {
+		p := &Post{
+			Author:   &Author{},
+			Votes:    make([]*Vote, 0),
+			Comments: make([]*Comment, 0),
+		}
+		var edited, deleted sql.NullTime
+		var c cursor
+		err = rows.Scan(&p.ID, &p.Title, &p.URL, &p.Author.ID, &p.Author.Username,
+			&p.Category, &p.Score, &p.Created, &p.Views, &p.Type, &p.Text, &edited, &deleted, &c.Seq, &c.Key)
+		if err != nil {
+			return nil, nil, err
+		}
+		p.Edited = edited.Time
+		p.Deleted = deleted.Time
+		posts = append(posts, p)
+		pos = append(pos, c)
+		byID[p.ID] = p
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	if len(posts) == 0 {
+		return posts, pos, nil
+	}
+
+	if err = loadRelations(q, byID, clause, args); err != nil {
+		return nil, nil, err
+	}
+	return posts, pos, nil
+}
+
+// loadRelations fills in votes and comments for the posts selected by clause.
+func loadRelations(q querier, byID map[string]*Post, clause string, args []any) error {
+	in := `(SELECT id FROM posts ` + clause + `)`
+
+	rows, err := q.Query(`SELECT post_id, user_id, vote FROM votes WHERE post_id IN `+in+` ORDER BY rowid`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var postID string
+		v := &Vote{}
+		if err = rows.Scan(&postID, &v.User, &v.Vote); err != nil {
+			return err
+		}
+		if p, ok := byID[postID]; ok {
+			p.Votes = append(p.Votes, v)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = q.Query(`SELECT `+commentColumns+`, c.post_id FROM comments c
+		WHERE c.post_id IN `+in+` ORDER BY c.created, c.rowid`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	comments := make(map[string]*Comment)
+	for rows.Next() {
+		var postID string
+		c, err := scanComment(rows, &postID)
+		if err != nil {
+			return err
+		}
+		if p, ok := byID[postID]; ok {
+			p.Comments = append(p.Comments, c)
+			comments[c.ID] = c
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	return loadCommentVotes(q, comments, `post_id IN `+in, args)
+}
+
+// commentColumns are the columns scanComment reads, from comments aliased as c.
+const commentColumns = `c.id, c.parent_id, c.depth, c.author_id, c.author_username, c.body, c.score, c.created, c.edited, c.deleted`
+
+// scanComment reads commentColumns followed by the extra destinations.
+func scanComment(rows *sql.Rows, extra ...any) (*Comment, error) {
+	var parentID sql.NullString
+	var edited, deleted sql.NullTime
+	c := &Comment{Author: &Author{}, Votes: make([]*Vote, 0)}
+	dest := []any{&c.ID, &parentID, &c.Depth, &c.Author.ID, &c.Author.Username, &c.Body, &c.Score,
+		&c.Created, &edited, &deleted}
+	if err := rows.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	c.ParentID = parentID.String
+	c.Edited = edited.Time
+	c.Deleted = deleted.Time
+	return c, nil
+}
+
+// loadCommentVotes attaches the comment votes matching where to the comments.
+func loadCommentVotes(q querier, comments map[string]*Comment, where string, args []any) error {
+	rows, err := q.Query(`SELECT comment_id, user_id, vote FROM comment_votes WHERE `+where+` ORDER BY rowid`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var commentID string
+		v := &Vote{}
+		if err = rows.Scan(&commentID, &v.User, &v.Vote); err != nil {
+			return err
+		}
+		if c, ok := comments[commentID]; ok {
+			c.Votes = append(c.Votes, v)
+		}
+	}
+	return rows.Err()
+}
+
+// placeholders returns n comma-separated parameter markers. SQLite accepts
+// an empty IN list, which matches nothing.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// nullString stores empty strings as NULL.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func authorID(a *Author) string {
+	if a == nil {
+		return ""
+	}
+	return a.ID
+}
+
+func authorUsername(a *Author) string {
+	if a == nil {
+		return ""
+	}
+	return a.Username
+}
@@ -0,0 +1,120 @@
+package post
+
+import (
+	"cmp"
+	"math"
+	"slices"
+)
+
+// MaxCommentDepth is the deepest level a reply can be at; top-level comments
+// are at depth 0.
+const MaxCommentDepth = 8
+
+// replyDepth returns the depth of a reply to parent, which must exist, be
+// live and leave room for another level.
+func replyDepth(parent *Comment) (int, error) {
+	switch {
+	case parent == nil:
+		return 0, ErrCommentNotFound
+	case parent.IsDeleted():
+		return 0, ErrDeleted
+	case parent.Depth >= MaxCommentDepth:
+		return 0, ErrTooDeep
+	}
+	return parent.Depth + 1, nil
+}
+
+// CommentSort is the order of sibling comments in a thread.
+type CommentSort string
+
+const (
+	CommentSortBest          CommentSort = "best"          // highest Wilson lower bound first
+	CommentSortTop           CommentSort = "top"           // highest score first
+	CommentSortNew           CommentSort = "new"           // newest first
+	CommentSortOld           CommentSort = "old"           // in the order they were added
+	CommentSortControversial CommentSort = "controversial" // many votes, evenly split
+)
+
+// Valid reports whether s is a known comment sort.
+func (s CommentSort) Valid() bool {
+	switch s {
+	case CommentSortBest, CommentSortTop, CommentSortNew, CommentSortOld, CommentSortControversial:
+		return true
+	}
+	return false
+}
+
+// wilsonZ is the z-score of the 80% confidence level used by Wilson.
+const wilsonZ = 1.281551565545
+
+// Wilson is the lower bound of the Wilson score interval for the share of
+// up votes: the fraction of voters who like the comment, estimated
+// pessimistically, so a few votes count for less than many at the same ratio.
+func Wilson(ups, downs int) float64 {
+	n := float64(ups + downs)
+	if n == 0 {
+		return 0
+	}
+	phat := float64(ups) / n
+	z2 := wilsonZ * wilsonZ
+	return (phat + z2/(2*n) - wilsonZ*math.Sqrt((phat*(1-phat)+z2/(4*n))/n)) / (1 + z2/n)
+}
+
+// commentKey returns the value siblings are ordered by for sort, highest
+// first, or nil to keep them in the order they were added.
+func commentKey(sort CommentSort) func(c *Comment) float64 {
+	switch sort {
+	case CommentSortBest:
+		return func(c *Comment) float64 { return Wilson(c.Tally()) }
+	case CommentSortTop:
+		return func(c *Comment) float64 { return float64(c.Score) }
+	case CommentSortNew:
+		return func(c *Comment) float64 { return float64(c.Created.UnixNano()) }
+	case CommentSortControversial:
+		return func(c *Comment) float64 { return Controversy(c.Tally()) }
+	default:
+		return nil
+	}
+}
+
+// Thread orders the comments so that each one is followed by its replies,
+// siblings ordered by sort. Comments whose parent is missing are treated as
+// top-level.
+func (p *Post) Thread(sort CommentSort) {
+	ids := make(map[string]bool, len(p.Comments))
+	for _, c := range p.Comments {
+		ids[c.ID] = true
+	}
+	var roots []*Comment
+	replies := make(map[string][]*Comment)
+	for _, c := range p.Comments {
+		if c.ParentID == "" || !ids[c.ParentID] {
+			roots = append(roots, c)
+		} else {
+			replies[c.ParentID] = append(replies[c.ParentID], c)
+		}
+	}
+
+	if key := commentKey(sort); key != nil {
+		keys := make(map[*Comment]float64, len(p.Comments))
+		for _, c := range p.Comments {
+			keys[c] = key(c)
+		}
+		order := func(a, b *Comment) int { return cmp.Compare(keys[b], keys[a]) }
+		slices.SortStableFunc(roots, order)
+		for _, siblings := range replies {
+			slices.SortStableFunc(siblings, order)
+		}
+	}
+
+	ordered := make([]*Comment, 0, len(p.Comments))
+	var visit func(comments []*Comment)
+	visit = func(comments []*Comment) {
+		for _, c := range comments {
+			ordered = append(ordered, c)
+			visit(replies[c.ID])
+		}
+	}
+	visit(roots)
+	p.Comments = ordered
+}
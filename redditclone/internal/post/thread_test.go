@@ -0,0 +1,219 @@
+package post
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+// reply adds a comment by bob to the post, as a reply to parentID unless it
+// is empty, and returns the comment with the error of AddComment.
+func reply(r Repo, postID, parentID, body string) (*Comment, error) {
+	c := NewComment(&Author{ID: "id-bob", Username: "bob"}, body)
+	c.ParentID = parentID
+	_, err := r.AddComment(postID, c)
+	return c, err
+}
+
+// mustReply is reply failing the test on error.
+func mustReply(t *testing.T, r Repo, postID, parentID, body string) *Comment {
+	t.Helper()
+	c, err := reply(r, postID, parentID, body)
+	if err != nil {
+		t.Fatalf("reply %q: %v", body, err)
+	}
+	return c
+}
+
+// bodies returns the bodies of comments in order.
+func bodies(comments []*Comment) []string {
+	list := make([]string, len(comments))
+	for i, c := range comments {
+		list[i] = c.Body
+	}
+	return list
+}
+
+func TestRepoRepliesNest(t *testing.T) {
+	forEachRepo(t, func(t *testing.T, r Repo) {
+		addPosts(t, r, newPost("p1", "music", "alice", 0))
+		first := mustReply(t, r, "p1", "", "first")
+		mustReply(t, r, "p1", "", "second")
+		answer := mustReply(t, r, "p1", first.ID, "answer")
+		mustReply(t, r, "p1", answer.ID, "answer to the answer")
+		mustReply(t, r, "p1", first.ID, "another answer")
+
+		p, err := r.GetByID("p1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		depths := make(map[string]int)
+		parents := make(map[string]string)
+		for _, c := range p.Comments {
+			depths[c.Body], parents[c.Body] = c.Depth, c.ParentID
+		}
+		tests := []struct {
+			body   string
+			parent string
+			depth  int
+		}{
+			{"first", "", 0},
+			{"second", "", 0},
+			{"answer", first.ID, 1},
+			{"answer to the answer", answer.ID, 2},
+			{"another answer", first.ID, 1},
+		}
+		for _, tt := range tests {
+			if parents[tt.body] != tt.parent || depths[tt.body] != tt.depth {
+				t.Errorf("%q has parent %q at depth %d, want %q at %d",
+					tt.body, parents[tt.body], depths[tt.body], tt.parent, tt.depth)
+			}
+		}
+
+		p.Thread(CommentSortOld)
+		want := []string{"first", "answer", "answer to the answer", "another answer", "second"}
+		if got := bodies(p.Comments); !equal(got, want) {
+			t.Errorf("thread = %q, want %q", got, want)
+		}
+	})
+}
+
+func TestRepoRejectedReplies(t *testing.T) {
+	forEachRepo(t, func(t *testing.T, r Repo) {
+		addPosts(t, r, newPost("p1", "music", "alice", 0), newPost("p2", "music", "alice", 1))
+		deleted := mustReply(t, r, "p1", "", "deleted")
+		if _, err := r.DeleteComment("p1", deleted.ID, testTime); err != nil {
+			t.Fatal(err)
+		}
+		elsewhere := mustReply(t, r, "p2", "", "on another post")
+
+		tests := []struct {
+			name   string
+			parent string
+			want   error
+		}{
+			{"missing parent", "missing", ErrCommentNotFound},
+			{"parent on another post", elsewhere.ID, ErrCommentNotFound},
+			{"deleted parent", deleted.ID, ErrDeleted},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if _, err := reply(r, "p1", tt.parent, "reply"); !errors.Is(err, tt.want) {
+					t.Errorf("error = %v, want %v", err, tt.want)
+				}
+			})
+		}
+		p, err := r.GetByID("p1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(p.Comments) != 1 {
+			t.Errorf("p1 has %d comments, want only the deleted one", len(p.Comments))
+		}
+	})
+}
+
+func TestRepoMaxCommentDepth(t *testing.T) {
+	forEachRepo(t, func(t *testing.T, r Repo) {
+		addPosts(t, r, newPost("p1", "music", "alice", 0))
+		parent := ""
+		for range MaxCommentDepth + 1 {
+			parent = mustReply(t, r, "p1", parent, "nested").ID
+		}
+		if _, err := reply(r, "p1", parent, "too deep"); !errors.Is(err, ErrTooDeep) {
+			t.Errorf("reply at depth %d error = %v, want ErrTooDeep", MaxCommentDepth+1, err)
+		}
+
+		p, err := r.GetByID("p1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := len(p.Comments); n != MaxCommentDepth+1 {
+			t.Fatalf("%d comments, want %d", n, MaxCommentDepth+1)
+		}
+		for _, c := range p.Comments {
+			if c.ID == parent && c.Depth != MaxCommentDepth {
+				t.Errorf("deepest comment at depth %d, want %d", c.Depth, MaxCommentDepth)
+			}
+		}
+	})
+}
+
+func TestWilson(t *testing.T) {
+	// Bounds worked out by hand from the formula at z = 1.2816.
+	tests := []struct {
+		ups, downs int
+		want       float64
+	}{
+		{0, 0, 0},
+		{0, 1, 0},
+		{1, 0, 0.3784},
+		{1, 1, 0.1643},
+		{2, 2, 0.2302},
+		{3, 0, 0.6462},
+		{5, 0, 0.7527},
+		{10, 2, 0.6578},
+		{20, 10, 0.5503},
+		{100, 20, 0.7853},
+	}
+	for _, tt := range tests {
+		if got := Wilson(tt.ups, tt.downs); math.Abs(got-tt.want) > 1e-4 {
+			t.Errorf("Wilson(%d, %d) = %.4f, want %.4f", tt.ups, tt.downs, got, tt.want)
+		}
+	}
+}
+
+// votedComment returns a comment with ups up and downs down votes, replying
+// to parentID unless it is empty, created n minutes after testTime.
+func votedComment(id, parentID string, ups, downs, n int) *Comment {
+	c := &Comment{ID: id, ParentID: parentID, Body: id, Created: testTime.Add(time.Duration(n) * time.Minute)}
+	for i := range ups + downs {
+		vote := 1
+		if i >= ups {
+			vote = -1
+		}
+		c.Votes = append(c.Votes, &Vote{User: fmt.Sprintf("u%d", i), Vote: vote})
+		c.Score += vote
+	}
+	return c
+}
+
+func TestThreadSorts(t *testing.T) {
+	// Wilson bounds are in brackets. d and e tie at 0 under best, as do a,
+	// c, d and e under controversial: ties keep the order they were added.
+	comments := func() []*Comment {
+		return []*Comment{
+			votedComment("a", "", 3, 0, 0),       // [0.6462] score 3
+			votedComment("b", "", 100, 20, 1),    // [0.7853] score 80
+			votedComment("c", "", 1, 0, 2),       // [0.3784] score 1
+			votedComment("d", "", 0, 0, 3),       // [0] score 0
+			votedComment("e", "", 0, 1, 4),       // [0] score -1
+			votedComment("a1", "a", 2, 2, 5),     // [0.2302] score 0
+			votedComment("a2", "a", 5, 0, 6),     // [0.7527] score 5
+			votedComment("a3", "a", 10, 2, 7),    // [0.6578] score 8
+			votedComment("a3x", "a3", 1, 1, 8),   // [0.1643] score 0
+			votedComment("a3y", "a3", 20, 10, 9), // [0.5503] score 10
+		}
+	}
+	tests := []struct {
+		sort CommentSort
+		want []string
+	}{
+		{CommentSortBest, []string{"b", "a", "a2", "a3", "a3y", "a3x", "a1", "c", "d", "e"}},
+		{CommentSortTop, []string{"b", "a", "a3", "a3y", "a3x", "a2", "a1", "c", "d", "e"}},
+		{CommentSortNew, []string{"e", "d", "c", "b", "a", "a3", "a3y", "a3x", "a2", "a1"}},
+		{CommentSortOld, []string{"a", "a1", "a2", "a3", "a3x", "a3y", "b", "c", "d", "e"}},
+		{CommentSortControversial, []string{"b", "a", "a1", "a3", "a3y", "a3x", "a2", "c", "d", "e"}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.sort), func(t *testing.T) {
+			p := &Post{Comments: comments()}
+			p.Thread(tt.sort)
+			if got := bodies(p.Comments); !equal(got, tt.want) {
+				t.Errorf("thread = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package post
+
+import (
+	"container/heap"
+	"iter"
+	"slices"
+)
+
+// Listings of recent posts, rising and top or controversial over a window,
+// cannot walk a ranking kept up to date: the all-time ranking holds older
+// posts too, and rising keys change with the time. windowed answers them
+// with two searches run in step, taking the page from whichever is done
+// first:
+//
+//   - scan goes through the posts created within the window, newest first,
+//     and keeps the best of them for the page. It costs O(w log limit) for
+//     w posts in the window, which suits short windows.
+//   - seek walks the all-time ranking, by score for rising, and skips the
+//     posts outside the window. For top and controversial its first
+//     matches make the page; for rising, the score of a post bounds its
+//     rising key, so seek stops once none of the posts left could make the
+//     page. It is quick when recent posts rank high, as on long windows.
+//
+// A page thus costs at most twice the cheaper of the two searches, and
+// never allocates more than the page.
+func windowed(q ListQuery, sets []*rankSet, after, before *cursor, accept func(e *entry) bool) []ranked {
+	since := float64(q.since().Unix())
+	now := q.Now.Unix()
+	// ahead reports whether a comes before b in the direction of the walk:
+	// in listing order, or in reverse when paging backwards.
+	ahead := func(a, b cursor) bool {
+		if before != nil {
+			return precedes(b, a)
+		}
+		return precedes(a, b)
+	}
+	inPage := func(pos cursor) bool {
+		switch {
+		case after != nil:
+			return precedes(*after, pos)
+		case before != nil:
+			return precedes(pos, *before)
+		}
+		return true
+	}
+	pos := func(e *entry) cursor {
+		if q.Sort == SortRising {
+			seq := int64(e.seq)
+			return cursor{Key: sortKey(e.post, seq, SortRising, now), Seq: seq}
+		}
+		return e.pos(q.Sort)
+	}
+
+	scanned := &pageHeap{ahead: ahead, need: q.Limit + 1}
+	if q.Limit == 0 {
+		scanned.need = -1
+	}
+	nextScanned, stop := iter.Pull(func(yield func(*rankNode) bool) {
+		for _, set := range sets {
+			for n := set.byCreated.after(nil); n != nil && n.pos.Key >= since; n = n.next[0] {
+				if !yield(n) {
+					return
+				}
+			}
+		}
+	})
+	defer stop()
+
+	seek := newSeek(q, sets, after, before, ahead)
+	if seek != nil {
+		defer seek.stop()
+	}
+	for {
+		n, ok := nextScanned()
+		if !ok {
+			return scanned.sorted()
+		}
+		if p := pos(n.e); inPage(p) && accept(n.e) {
+			scanned.offer(ranked{post: n.e.post, pos: p})
+		}
+
+		if seek == nil {
+			continue
+		}
+		n, ok = seek.next()
+		if !ok || seek.done != nil && seek.done(n.pos.Key) {
+			return seek.found.sorted()
+		}
+		if float64(n.e.post.Created.Unix()) < since || !accept(n.e) {
+			continue
+		}
+		if seek.done == nil {
+			// The ranking yields the page in order.
+			seek.found.items = append(seek.found.items, ranked{post: n.e.post, pos: n.pos})
+			if len(seek.found.items) == seek.found.need {
+				return seek.found.items
+			}
+		} else if p := pos(n.e); inPage(p) {
+			seek.found.offer(ranked{post: n.e.post, pos: p})
+		}
+	}
+}
+
+// seek walks the all-time rankings of a windowed listing; see windowed.
+type seek struct {
+	next  func() (*rankNode, bool)
+	stop  func()
+	found *pageHeap
+	// done reports whether no post left, scoring at most the given score,
+	// can make the page. It is nil unless the listing is rising, whose
+	// keys do not follow the ranking.
+	done func(score float64) bool
+}
+
+// newSeek starts a seek, or returns nil if the listing needs every post
+// of the window or, for rising, the scores cannot bound the keys.
+func newSeek(q ListQuery, sets []*rankSet, after, before *cursor, ahead func(a, b cursor) bool) *seek {
+	if q.Limit == 0 {
+		return nil
+	}
+	s := &seek{found: &pageHeap{ahead: ahead, need: q.Limit + 1}}
+	sort, from, to := q.Sort, after, before
+	if sort == SortRising {
+		// rising = score / (age in hours + 2), and the ages of the posts in
+		// the window lie between that of the newest post and the window.
+		newest := int64(0)
+		for _, set := range sets {
+			if n := set.byCreated.after(nil); n != nil {
+				newest = max(newest, int64(n.pos.Key))
+			}
+		}
+		now := q.Now.Unix()
+		minDiv := float64(now-newest)/3600 + 2
+		maxDiv := float64(now-q.since().Unix())/3600 + 2
+		if minDiv <= 0 {
+			return nil
+		}
+		bound := func(score float64) float64 {
+			if score > 0 {
+				return score / minDiv
+			}
+			return score / maxDiv
+		}
+		s.done = func(score float64) bool {
+			if before != nil {
+				// Paging backwards wants the keys just above the cursor.
+				return bound(score) < before.Key
+			}
+			return len(s.found.items) == s.found.need && bound(score) < s.found.items[0].pos.Key
+		}
+		// The rising cursor does not apply to the ranking by score.
+		sort, from, to = SortTop, nil, nil
+	}
+	rankings := make([]*ranking, len(sets))
+	for i, set := range sets {
+		rankings[i] = set.sorts[sort]
+	}
+	s.next, s.stop = iter.Pull(func(yield func(*rankNode) bool) {
+		walkMerged(rankings, from, to, yield)
+	})
+	return s
+}
+
+// pageHeap keeps the need items that come first in the direction of a
+// walk, or all items if need is negative. Its root is the item that would
+// be dropped first.
+type pageHeap struct {
+	items []ranked
+	ahead func(a, b cursor) bool
+	need  int
+}
+
+func (h *pageHeap) Len() int           { return len(h.items) }
+func (h *pageHeap) Less(i, j int) bool { return h.ahead(h.items[j].pos, h.items[i].pos) }
+func (h *pageHeap) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *pageHeap) Push(x any)         { h.items = append(h.items, x.(ranked)) }
+func (h *pageHeap) Pop() any {
+	it := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return it
+}
+
+func (h *pageHeap) offer(it ranked) {
+	switch {
+	case h.need < 0:
+		h.items = append(h.items, it)
+	case len(h.items) < h.need:
+		heap.Push(h, it)
+	case h.ahead(it.pos, h.items[0].pos):
+		h.items[0] = it
+		heap.Fix(h, 0)
+	}
+}
+
+// sorted returns the items in the direction of the walk.
+func (h *pageHeap) sorted() []ranked {
+	slices.SortFunc(h.items, func(a, b ranked) int {
+		if h.ahead(a.pos, b.pos) {
+			return -1
+		}
+		return 1
+	})
+	return h.items
+}
@@ -0,0 +1,193 @@
+package post
+
+import (
+	"cmp"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"testing"
+	"time"
+)
+
+// windowRepo fills a repo with n posts created over three days before now,
+// in music and news, scored by score(age in minutes, rng). Every fifth post
+// is hidden by viewer.
+func windowRepo(t *testing.T, n int, score func(age int, rng *rand.Rand) (ups, downs int)) (*MemoryRepo, time.Time) {
+	t.Helper()
+	rng := rand.New(rand.NewPCG(3, 4))
+	now := testTime.Add(72 * time.Hour)
+	r := NewMemoryRepo()
+	for i := range n {
+		age := rng.IntN(72 * 60)
+		category := []string{"music", "news"}[i%2]
+		p := newPost(fmt.Sprintf("p%d", i), category, "alice", 0)
+		p.Created = now.Add(-time.Duration(age) * time.Minute)
+		ups, downs := score(age, rng)
+		for v := range ups + downs {
+			vote := 1
+			if v >= ups {
+				vote = -1
+			}
+			p.Votes = append(p.Votes, &Vote{User: fmt.Sprintf("u%d", v), Vote: vote})
+			p.Score += vote
+		}
+		addPosts(t, r, p)
+		if i%5 == 0 {
+			if err := r.Hide(p.ID, "viewer"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return r, now
+}
+
+// wantWindow ranks the posts of a windowed query by brute force.
+func wantWindow(t *testing.T, r *MemoryRepo, q ListQuery) []string {
+	t.Helper()
+	all, err := r.GetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	since := q.since()
+	var items []ranked
+	for i, p := range all {
+		if p.Created.Unix() < since.Unix() || q.Category != "" && p.Category != q.Category ||
+			q.Viewer != "" && i%5 == 0 {
+			continue
+		}
+		seq := int64(i + 1)
+		items = append(items, ranked{post: p, pos: cursor{Key: sortKey(p, seq, q.Sort, q.Now.Unix()), Seq: seq}})
+	}
+	slices.SortFunc(items, func(a, b ranked) int {
+		return cmp.Or(cmp.Compare(b.pos.Key, a.pos.Key), cmp.Compare(b.pos.Seq, a.pos.Seq))
+	})
+	list := make([]string, len(items))
+	for i, it := range items {
+		list[i] = it.post.ID
+	}
+	return list
+}
+
+// TestMemoryRepoWindowedListings pages through windowed listings forwards
+// and backwards and checks them against the listings ranked by brute
+// force. The data sets favour each of the two searches of windowed.
+func TestMemoryRepoWindowedListings(t *testing.T) {
+	datasets := []struct {
+		name  string
+		score func(age int, rng *rand.Rand) (ups, downs int)
+	}{
+		{"random", func(age int, rng *rand.Rand) (int, int) { return rng.IntN(20), rng.IntN(10) }},
+		{"old posts score high", func(age int, rng *rand.Rand) (int, int) { return age / 100, rng.IntN(5) }},
+		{"new posts score high", func(age int, rng *rand.Rand) (int, int) { return 50 - age/100, rng.IntN(5) }},
+		{"negative", func(age int, rng *rand.Rand) (int, int) { return rng.IntN(3), rng.IntN(10) }},
+	}
+	queries := []ListQuery{
+		{Sort: SortRising},
+		{Sort: SortRising, Category: "news"},
+		{Sort: SortRising, Viewer: "viewer"},
+		{Sort: SortTop, Window: time.Hour},
+		{Sort: SortTop, Window: 48 * time.Hour, Category: "music", Viewer: "viewer"},
+		{Sort: SortControversial, Window: 24 * time.Hour},
+	}
+	for _, ds := range datasets {
+		r, now := windowRepo(t, 400, ds.score)
+		for _, base := range queries {
+			for _, limit := range []int{1, 7, 0} {
+				q := base
+				q.Now, q.Limit = now, limit
+				name := fmt.Sprintf("%s/%s/%s/%s/%d", ds.name, q.Sort, q.Window, q.Category+q.Viewer, limit)
+				t.Run(name, func(t *testing.T) {
+					want := wantWindow(t, r, q)
+					var got []string
+					var last *Page
+					for pages := 0; pages <= len(want)+1; pages++ {
+						page, err := r.List(q)
+						if err != nil {
+							t.Fatal(err)
+						}
+						got = append(got, ids(page.Posts)...)
+						last = page
+						if page.Next == "" {
+							break
+						}
+						q.After = page.Next
+					}
+					if !equal(got, want) {
+						t.Fatalf("forwards = %v, want %v", got, want)
+					}
+					if limit == 0 {
+						return
+					}
+
+					got = ids(last.Posts)
+					q.After, q.Before = "", last.Prev
+					for q.Before != "" {
+						page, err := r.List(q)
+						if err != nil {
+							t.Fatal(err)
+						}
+						got = append(ids(page.Posts), got...)
+						q.Before = page.Prev
+					}
+					if !equal(got, want) {
+						t.Errorf("backwards = %v, want %v", got, want)
+					}
+				})
+			}
+		}
+	}
+}
+
+// TestMemoryRepoRisingWithOldCursor pages rising with a cursor issued
+// before the newest posts were created, whose ages are negative then.
+func TestMemoryRepoRisingWithOldCursor(t *testing.T) {
+	r := NewMemoryRepo()
+	for i := range 6 {
+		p := newPost(fmt.Sprintf("p%d", i), "music", "alice", 0)
+		p.Created = testTime.Add(time.Duration(i) * time.Hour)
+		p.Votes = []*Vote{{User: "u1", Vote: 1}}
+		p.Score = 1
+		addPosts(t, r, p)
+	}
+	q := ListQuery{Sort: SortRising, Limit: 2, Now: testTime.Add(2 * time.Hour)}
+	want := wantWindow(t, r, q)
+	var got []string
+	for {
+		page, err := r.List(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, ids(page.Posts)...)
+		if page.Next == "" {
+			break
+		}
+		q.After = page.Next
+	}
+	if !equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func BenchmarkMemoryRepoListWindowed(b *testing.B) {
+	queries := []ListQuery{
+		{Sort: SortRising},
+		{Sort: SortTop, Window: 24 * time.Hour},
+		{Sort: SortTop, Window: 365 * 24 * time.Hour},
+		{Sort: SortControversial, Window: 7 * 24 * time.Hour},
+	}
+	for _, q := range queries {
+		b.Run(fmt.Sprintf("%s/%s", q.Sort, q.Window), func(b *testing.B) {
+			benchLookup(b, func(r *MemoryRepo, i, n int) error {
+				// Posts are created a minute apart, so the windows hold the
+				// posts of their length in minutes.
+				q := q
+				q.Now, q.Limit = testTime.Add(time.Duration(n)*time.Minute), DefaultPageSize
+				page, err := r.List(q)
+				if err == nil && len(page.Posts) != DefaultPageSize {
+					err = fmt.Errorf("%d posts on a page, want %d", len(page.Posts), DefaultPageSize)
+				}
+				return err
+			})
+		})
+	}
+}
@@ -0,0 +1,364 @@
+package report
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrNotFound  = errors.New("no pending reports")
+	ErrNoReason  = errors.New("reason required")
+	ErrTooLong   = errors.New("reason too long")
+	ErrDuplicate = errors.New("already reported")
+	ErrBadAction = errors.New("unknown action")
+	ErrBadSort   = errors.New("unknown sort")
+)
+
+// MaxReasonLength is the longest reason a report may give.
+const MaxReasonLength = 300
+
+// RemovalReason is the reason given by the records Removal makes.
+const RemovalReason = "removed by another user"
+
+// Action is how a moderator resolves the reports on a post or comment.
+type Action string
+
+const (
+	Approve Action = "approve" // the content stays; new reports queue it again
+	Remove  Action = "remove"  // the content is deleted and cannot be restored by its author
+	Ignore  Action = "ignore"  // the content stays and later reports on it are ignored too
+)
+
+func (a Action) valid() bool {
+	return a == Approve || a == Remove || a == Ignore
+}
+
+// Sort is the order of the moderation queue.
+type Sort string
+
+const (
+	SortReports Sort = "reports" // most reported first
+	SortNew     Sort = "new"     // most recently reported first
+)
+
+// Report flags a post, or a comment when CommentID is set, for the
+// moderators of its category. A report is pending until Resolution is set.
+type Report struct {
+	ID         string    `json:"id"`
+	PostID     string    `json:"post_id"`
+	CommentID  string    `json:"comment_id,omitempty"`
+	Category   string    `json:"category"`
+	ReporterID string    `json:"reporter_id"`
+	Reason     string    `json:"reason"`
+	Created    time.Time `json:"created"`
+	Resolution Action    `json:"resolution,omitempty"`
+	ResolvedBy string    `json:"resolved_by,omitempty"`
+	Resolved   time.Time `json:"resolved,omitzero"`
+}
+
+// New validates a report of the content by the user.
+func New(postID, commentID, category, reporterID, reason string, created time.Time) (*Report, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, ErrNoReason
+	}
+	if utf8.RuneCountInString(reason) > MaxReasonLength {
+		return nil, ErrTooLong
+	}
+	return &Report{
+		ID:         uuid.NewString(),
+		PostID:     postID,
+		CommentID:  commentID,
+		Category:   category,
+		ReporterID: reporterID,
+		Reason:     reason,
+		Created:    created,
+	}, nil
+}
+
+// Removal is the record of content deleted by someone other than its
+// author: a moderator, or the author of the post a comment was made on. It
+// needs no report to precede it.
+func Removal(postID, commentID, category, removerID string, removed time.Time) *Report {
+	return &Report{
+		ID:         uuid.NewString(),
+		PostID:     postID,
+		CommentID:  commentID,
+		Category:   category,
+		ReporterID: removerID,
+		Reason:     RemovalReason,
+		Created:    removed,
+		Resolution: Remove,
+		ResolvedBy: removerID,
+		Resolved:   removed,
+	}
+}
+
+func (r *Report) pending() bool {
+	return r.Resolution == ""
+}
+
+// Reason is a reason given for reporting a piece of content and how many
+// reports gave it.
+type Reason struct {
+	Reason string `json:"reason"`
+	Count  int    `json:"count"`
+}
+
+// Item is a post or comment in the moderation queue together with its
+// pending reports.
+type Item struct {
+	PostID        string    `json:"post_id"`
+	CommentID     string    `json:"comment_id,omitempty"`
+	Category      string    `json:"category"`
+	Reports       int       `json:"reports"`
+	Reasons       []Reason  `json:"reasons"`
+	FirstReported time.Time `json:"first_reported"`
+	LastReported  time.Time `json:"last_reported"`
+}
+
+// before reports whether i comes before other in the queue sorted by s.
+// Ties fall back to the most recently reported, then to the content IDs.
+func (i *Item) before(other *Item, s Sort) bool {
+	if s == SortReports && i.Reports != other.Reports {
+		return i.Reports > other.Reports
+	}
+	if !i.LastReported.Equal(other.LastReported) {
+		return i.LastReported.After(other.LastReported)
+	}
+	if i.PostID != other.PostID {
+		return i.PostID < other.PostID
+	}
+	return i.CommentID < other.CommentID
+}
+
+// content identifies a reported post or comment.
+type content struct {
+	PostID    string
+	CommentID string
+}
+
+// queue aggregates pending reports into queue items sorted by s.
+func queue(reports []*Report, s Sort) []*Item {
+	byContent := make(map[content]*Item)
+	reasons := make(map[content]map[string]int)
+	items := []*Item{}
+	for _, r := range reports {
+		key := content{r.PostID, r.CommentID}
+		item, ok := byContent[key]
+		if !ok {
+			item = &Item{PostID: r.PostID, CommentID: r.CommentID, Category: r.Category,
+				FirstReported: r.Created, LastReported: r.Created}
+			byContent[key] = item
+			reasons[key] = make(map[string]int)
+			items = append(items, item)
+		}
+		item.Reports++
+		reasons[key][r.Reason]++
+		if r.Created.Before(item.FirstReported) {
+			item.FirstReported = r.Created
+		}
+		if r.Created.After(item.LastReported) {
+			item.LastReported = r.Created
+		}
+	}
+
+	for key, item := range byContent {
+		for reason, n := range reasons[key] {
+			item.Reasons = append(item.Reasons, Reason{Reason: reason, Count: n})
+		}
+		slices.SortFunc(item.Reasons, func(a, b Reason) int {
+			if a.Count != b.Count {
+				return b.Count - a.Count
+			}
+			return strings.Compare(a.Reason, b.Reason)
+		})
+	}
+	slices.SortFunc(items, func(a, b *Item) int {
+		if a.before(b, s) {
+			return -1
+		}
+		return 1
+	})
+	return items
+}
+
+func checkSort(s Sort) (Sort, error) {
+	switch s {
+	case "":
+		return SortReports, nil
+	case SortReports, SortNew:
+		return s, nil
+	}
+	return "", ErrBadSort
+}
+
+type Repo interface {
+	// Add files a report. A user may have one pending report on a piece of
+	// content; reports on content a moderator ignored are ignored at once.
+	Add(r *Report) (*Report, error)
+	// Queue returns the content of a category with pending reports.
+	Queue(category string, sort Sort) ([]*Item, error)
+	// Resolve closes every pending report on a post, or on one of its
+	// comments when commentID is set, and returns how many it closed.
+	Resolve(postID, commentID string, action Action, moderatorID string, resolved time.Time) (int, error)
+	// Remove stores a Removal and closes the pending reports on its content
+	// as removed.
+	Remove(rep *Report) error
+	// Removed reports whether the content was removed by anyone but its
+	// author, through a report or a Removal.
+	Removed(postID, commentID string) (bool, error)
+}
+
+type MemoryRepo struct {
+	mu        sync.RWMutex
+	reports   []*Report
+	byContent map[content][]*Report
+	// pending indexes the content with pending reports by category.
+	pending map[string]map[content]bool
+}
+
+func NewMemoryRepo() *MemoryRepo {
+	return &MemoryRepo{
+		byContent: make(map[content][]*Report),
+		pending:   make(map[string]map[content]bool),
+	}
+}
+
+func (r *MemoryRepo) Add(rep *Report) (*Report, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rep = r.add(rep)
+	if rep == nil {
+		return nil, ErrDuplicate
+	}
+	cp := *rep
+	return &cp, nil
+}
+
+// add stores a copy of rep unless the reporter has a pending report on the
+// same content, in which case it returns nil.
+func (r *MemoryRepo) add(rep *Report) *Report {
+	key := content{rep.PostID, rep.CommentID}
+	cp := *rep
+	for _, other := range r.byContent[key] {
+		if other.pending() && other.ReporterID == rep.ReporterID {
+			return nil
+		}
+		if other.Resolution == Ignore && cp.pending() {
+			cp.Resolution, cp.ResolvedBy, cp.Resolved = Ignore, other.ResolvedBy, cp.Created
+		}
+	}
+	r.load(&cp)
+	return &cp
+}
+
+func (r *MemoryRepo) load(rep *Report) {
+	key := content{rep.PostID, rep.CommentID}
+	r.reports = append(r.reports, rep)
+	r.byContent[key] = append(r.byContent[key], rep)
+	if rep.pending() {
+		if r.pending[rep.Category] == nil {
+			r.pending[rep.Category] = make(map[content]bool)
+		}
+		r.pending[rep.Category][key] = true
+	}
+}
+
+func (r *MemoryRepo) Queue(category string, sort Sort) ([]*Item, error) {
+	sort, err := checkSort(sort)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var reports []*Report
+	for key := range r.pending[category] {
+		for _, rep := range r.byContent[key] {
+			if rep.pending() {
+				reports = append(reports, rep)
+			}
+		}
+	}
+	return queue(reports, sort), nil
+}
+
+func (r *MemoryRepo) Resolve(postID, commentID string, action Action, moderatorID string, resolved time.Time) (int, error) {
+	if !action.valid() {
+		return 0, ErrBadAction
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := r.resolve(content{postID, commentID}, action, moderatorID, resolved)
+	if n == 0 {
+		return 0, ErrNotFound
+	}
+	return n, nil
+}
+
+func (r *MemoryRepo) resolve(key content, action Action, moderatorID string, resolved time.Time) int {
+	n := 0
+	for _, rep := range r.byContent[key] {
+		if rep.pending() {
+			rep.Resolution, rep.ResolvedBy, rep.Resolved = action, moderatorID, resolved
+			delete(r.pending[rep.Category], key)
+			if len(r.pending[rep.Category]) == 0 {
+				delete(r.pending, rep.Category)
+			}
+			n++
+		}
+	}
+	return n
+}
+
+func (r *MemoryRepo) Remove(rep *Report) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.resolve(content{rep.PostID, rep.CommentID}, Remove, rep.ResolvedBy, rep.Resolved)
+	cp := *rep
+	r.load(&cp)
+	return nil
+}
+
+func (r *MemoryRepo) Removed(postID, commentID string) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return slices.ContainsFunc(r.byContent[content{postID, commentID}], func(rep *Report) bool {
+		return rep.Resolution == Remove
+	}), nil
+}
+
+// Records returns every report in the order it was filed.
+func (r *MemoryRepo) Records() []*Report {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	records := make([]*Report, len(r.reports))
+	for i, rep := range r.reports {
+		cp := *rep
+		records[i] = &cp
+	}
+	return records
+}
+
+// Load inserts a previously stored report as is.
+func (r *MemoryRepo) Load(rep *Report) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cp := *rep
+	r.load(&cp)
+}
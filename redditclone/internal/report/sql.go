@@ -0,0 +1,150 @@
+package report
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// SQLRepo stores reports in the reports table created by the storage
+// package migrations.
+type SQLRepo struct {
+	db *sql.DB
+}
+
+func NewSQLRepo(db *sql.DB) *SQLRepo {
+	return &SQLRepo{db: db}
+}
+
+func (r *SQLRepo) Add(rep *Report) (*Report, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var duplicate bool
+	err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM reports
+		WHERE post_id = ? AND comment_id = ? AND reporter_id = ? AND resolution = '')`,
+		rep.PostID, rep.CommentID, rep.ReporterID).Scan(&duplicate)
+	if err != nil {
+		return nil, err
+	}
+	if duplicate {
+		return nil, ErrDuplicate
+	}
+
+	cp := *rep
+	var ignoredBy string
+	err = tx.QueryRow(`SELECT resolved_by FROM reports
+		WHERE post_id = ? AND comment_id = ? AND resolution = ? LIMIT 1`,
+		rep.PostID, rep.CommentID, Ignore).Scan(&ignoredBy)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if err == nil && cp.pending() {
+		cp.Resolution, cp.ResolvedBy, cp.Resolved = Ignore, ignoredBy, cp.Created
+	}
+
+	var resolved sql.NullInt64
+	if !cp.Resolved.IsZero() {
+		resolved = sql.NullInt64{Int64: cp.Resolved.UnixMicro(), Valid: true}
+	}
+	_, err = tx.Exec(`INSERT INTO reports (id, post_id, comment_id, category, reporter_id, reason, created_at,
+			resolution, resolved_by, resolved_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		cp.ID, cp.PostID, cp.CommentID, cp.Category, cp.ReporterID, cp.Reason, cp.Created.UnixMicro(),
+		cp.Resolution, cp.ResolvedBy, resolved)
+	if err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	cp.Created = time.UnixMicro(cp.Created.UnixMicro())
+	if resolved.Valid {
+		cp.Resolved = time.UnixMicro(resolved.Int64)
+	}
+	return &cp, nil
+}
+
+func (r *SQLRepo) Queue(category string, sort Sort) ([]*Item, error) {
+	sort, err := checkSort(sort)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.db.Query(`SELECT id, post_id, comment_id, category, reporter_id, reason, created_at
+		FROM reports WHERE category = ? AND resolution = ''`, category)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reports []*Report
+	for rows.Next() {
+		rep := &Report{}
+		var created int64
+		if err = rows.Scan(&rep.ID, &rep.PostID, &rep.CommentID, &rep.Category, &rep.ReporterID, &rep.Reason, &created); err != nil {
+			return nil, err
+		}
+		rep.Created = time.UnixMicro(created)
+		reports = append(reports, rep)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return queue(reports, sort), nil
+}
+
+func (r *SQLRepo) Resolve(postID, commentID string, action Action, moderatorID string, resolved time.Time) (int, error) {
+	if !action.valid() {
+		return 0, ErrBadAction
+	}
+
+	res, err := r.db.Exec(`UPDATE reports SET resolution = ?, resolved_by = ?, resolved_at = ?
+		WHERE post_id = ? AND comment_id = ? AND resolution = ''`,
+		action, moderatorID, resolved.UnixMicro(), postID, commentID)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, ErrNotFound
+	}
+	return int(n), nil
+}
+
+func (r *SQLRepo) Remove(rep *Report) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE reports SET resolution = ?, resolved_by = ?, resolved_at = ?
+		WHERE post_id = ? AND comment_id = ? AND resolution = ''`,
+		Remove, rep.ResolvedBy, rep.Resolved.UnixMicro(), rep.PostID, rep.CommentID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO reports (id, post_id, comment_id, category, reporter_id, reason, created_at,
+			resolution, resolved_by, resolved_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		rep.ID, rep.PostID, rep.CommentID, rep.Category, rep.ReporterID, rep.Reason, rep.Created.UnixMicro(),
+		rep.Resolution, rep.ResolvedBy, rep.Resolved.UnixMicro())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *SQLRepo) Removed(postID, commentID string) (bool, error) {
+	var removed bool
+	err := r.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM reports WHERE post_id = ? AND comment_id = ? AND resolution = ?)`,
+		postID, commentID, Remove).Scan(&removed)
+	return removed, err
+}
@@ -0,0 +1,169 @@
+package reset
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrInvalid covers unknown, used and expired tokens alike, so a caller
+// learns nothing about which tokens exist.
+var ErrInvalid = errors.New("invalid or expired reset token")
+
+// Token lets the holder set a new password for a user once before it
+// expires. Only the hash of the token is kept.
+type Token struct {
+	Hash     string    `json:"hash"`
+	UserID   string    `json:"user_id"`
+	Username string    `json:"username"`
+	Created  time.Time `json:"created"`
+	Expires  time.Time `json:"expires"`
+	Used     time.Time `json:"used,omitzero"`
+}
+
+// New returns a reset token of the user lasting ttl, along with the secret
+// to send them.
+func New(userID, username string, created time.Time, ttl time.Duration) (*Token, string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(secret)
+	t := &Token{
+		Hash:     Hash(token),
+		UserID:   userID,
+		Username: username,
+		Created:  created,
+		Expires:  created.Add(ttl),
+	}
+	return t, token, nil
+}
+
+// Valid reports whether the token can still be used at now.
+func (t *Token) Valid(now time.Time) bool {
+	return t.Used.IsZero() && now.Before(t.Expires)
+}
+
+// Hash is how reset tokens are stored.
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+type Repo interface {
+	// Create stores a reset token. Tokens the user asked for earlier and
+	// has not used stop working.
+	Create(t *Token) (*Token, error)
+	// Consume marks the token with the hash as used at now and returns it.
+	// It returns ErrInvalid if the token is unknown, used or expired.
+	Consume(hash string, now time.Time) (*Token, error)
+	// DeleteUser deletes every token of a user, such as when their password
+	// changed or their account went.
+	DeleteUser(userID string) error
+	// Prune drops the tokens that no longer work at now, used or expired,
+	// and returns how many it dropped.
+	Prune(now time.Time) (int, error)
+}
+
+// MemoryRepo keeps only the tokens that may still work: at most one per
+// user, as a new token replaces the earlier ones, until it is used or
+// pruned.
+type MemoryRepo struct {
+	mu     sync.Mutex
+	tokens map[string]*Token // by hash
+	byUser map[string]string // user ID -> hash
+}
+
+func NewMemoryRepo() *MemoryRepo {
+	return &MemoryRepo{
+		tokens: make(map[string]*Token),
+		byUser: make(map[string]string),
+	}
+}
+
+func (r *MemoryRepo) Create(t *Token) (*Token, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.load(t)
+	cp := *t
+	return &cp, nil
+}
+
+func (r *MemoryRepo) load(t *Token) {
+	r.deleteUser(t.UserID)
+	cp := *t
+	r.tokens[t.Hash] = &cp
+	r.byUser[t.UserID] = t.Hash
+}
+
+func (r *MemoryRepo) Consume(hash string, now time.Time) (*Token, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, ok := r.tokens[hash]
+	if !ok || !t.Valid(now) {
+		return nil, ErrInvalid
+	}
+	r.deleteUser(t.UserID)
+	t.Used = now
+	return t, nil
+}
+
+func (r *MemoryRepo) DeleteUser(userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.deleteUser(userID)
+	return nil
+}
+
+func (r *MemoryRepo) deleteUser(userID string) {
+	if hash, ok := r.byUser[userID]; ok {
+		delete(r.tokens, hash)
+		delete(r.byUser, userID)
+	}
+}
+
+func (r *MemoryRepo) Prune(now time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := 0
+	for _, t := range r.tokens {
+		if !t.Valid(now) {
+			r.deleteUser(t.UserID)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Records returns every stored token.
+func (r *MemoryRepo) Records() []*Token {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	list := make([]*Token, 0, len(r.tokens))
+	for _, t := range r.tokens {
+		cp := *t
+		list = append(list, &cp)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Hash < list[j].Hash
+	})
+	return list
+}
+
+// Load inserts a previously stored token.
+func (r *MemoryRepo) Load(t *Token) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.load(t)
+}
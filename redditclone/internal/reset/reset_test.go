@@ -0,0 +1,130 @@
+package reset
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"redditclone/internal/storage"
+)
+
+var testTime = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// forEachRepo runs test against an empty repo of each implementation.
+func forEachRepo(t *testing.T, test func(t *testing.T, r Repo)) {
+	t.Helper()
+	repos := []struct {
+		name string
+		new  func(t *testing.T) Repo
+	}{
+		{"memory", func(t *testing.T) Repo { return NewMemoryRepo() }},
+		{"sql", func(t *testing.T) Repo {
+			db, err := storage.Open("sqlite", filepath.Join(t.TempDir(), "test.db"))
+			if err != nil {
+				t.Fatalf("open database: %v", err)
+			}
+			t.Cleanup(func() { db.Close() })
+			return NewSQLRepo(db)
+		}},
+	}
+	for _, tt := range repos {
+		t.Run(tt.name, func(t *testing.T) {
+			test(t, tt.new(t))
+		})
+	}
+}
+
+// create stores a token of the user created at created lasting an hour and
+// returns its hash.
+func create(t *testing.T, r Repo, userID string, created time.Time) string {
+	t.Helper()
+	tok, _, err := New(userID, "name-"+userID, created, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.Create(tok); err != nil {
+		t.Fatal(err)
+	}
+	return tok.Hash
+}
+
+func TestRepoConsume(t *testing.T) {
+	forEachRepo(t, func(t *testing.T, r Repo) {
+		first := create(t, r, "u1", testTime)
+		second := create(t, r, "u1", testTime.Add(time.Minute))
+		other := create(t, r, "u2", testTime)
+
+		tests := []struct {
+			name string
+			hash string
+			at   time.Duration // after testTime
+			ok   bool
+		}{
+			{"replaced by a newer token", first, 2 * time.Minute, false},
+			{"expired", other, 2 * time.Hour, false},
+			{"latest", second, 2 * time.Minute, true},
+			{"used", second, 3 * time.Minute, false},
+			{"unknown", "missing", 0, false},
+		}
+		for _, tt := range tests {
+			got, err := r.Consume(tt.hash, testTime.Add(tt.at))
+			if tt.ok && (err != nil || got.UserID != "u1") {
+				t.Errorf("%s: Consume = %+v, %v, want the token of u1", tt.name, got, err)
+			}
+			if !tt.ok && !errors.Is(err, ErrInvalid) {
+				t.Errorf("%s: error = %v, want ErrInvalid", tt.name, err)
+			}
+		}
+	})
+}
+
+func TestRepoDeleteUser(t *testing.T) {
+	forEachRepo(t, func(t *testing.T, r Repo) {
+		hash := create(t, r, "u1", testTime)
+		other := create(t, r, "u2", testTime)
+		if err := r.DeleteUser("u1"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.Consume(hash, testTime); !errors.Is(err, ErrInvalid) {
+			t.Errorf("deleted token: error = %v, want ErrInvalid", err)
+		}
+		if _, err := r.Consume(other, testTime); err != nil {
+			t.Errorf("token of another user: %v", err)
+		}
+	})
+}
+
+func TestRepoPrune(t *testing.T) {
+	forEachRepo(t, func(t *testing.T, r Repo) {
+		create(t, r, "u1", testTime)
+		used := create(t, r, "u2", testTime.Add(time.Hour))
+		if _, err := r.Consume(used, testTime.Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		live := create(t, r, "u3", testTime.Add(time.Hour))
+
+		// u1's token expired and u2's was used; the memory repo dropped the
+		// used one when it was consumed already.
+		want := 2
+		if _, ok := r.(*MemoryRepo); ok {
+			want = 1
+		}
+		if n, err := r.Prune(testTime.Add(90 * time.Minute)); err != nil || n != want {
+			t.Errorf("pruned %d tokens, %v, want %d", n, err, want)
+		}
+		if n, err := r.Prune(testTime.Add(90 * time.Minute)); err != nil || n != 0 {
+			t.Errorf("pruned %d tokens again, %v, want none", n, err)
+		}
+		// u3's token is kept until it expires in turn.
+		if n, err := r.Prune(testTime.Add(3 * time.Hour)); err != nil || n != 1 {
+			t.Errorf("pruned %d tokens once the last expired, %v, want 1", n, err)
+		}
+		if _, err := r.Consume(live, testTime.Add(90*time.Minute)); !errors.Is(err, ErrInvalid) {
+			t.Errorf("pruned token: error = %v, want ErrInvalid", err)
+		}
+		if m, ok := r.(*MemoryRepo); ok && (len(m.tokens) != 0 || len(m.byUser) != 0) {
+			t.Errorf("left %d tokens of %v, want none", len(m.tokens), m.byUser)
+		}
+	})
+}
@@ -0,0 +1,98 @@
+package reset
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// SQLRepo stores reset tokens in the password_resets table created by the
+// storage package migrations.
+type SQLRepo struct {
+	db *sql.DB
+}
+
+func NewSQLRepo(db *sql.DB) *SQLRepo {
+	return &SQLRepo{db: db}
+}
+
+func (r *SQLRepo) Create(t *Token) (*Token, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE password_resets SET used_at = ? WHERE user_id = ? AND used_at IS NULL`,
+		t.Created.UnixMicro(), t.UserID)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(`INSERT INTO password_resets (token_hash, user_id, username, created_at, expires_at) VALUES (?, ?, ?, ?, ?)`,
+		t.Hash, t.UserID, t.Username, t.Created.UnixMicro(), t.Expires.UnixMicro())
+	if err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	cp := *t
+	return &cp, nil
+}
+
+func (r *SQLRepo) Consume(hash string, now time.Time) (*Token, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	t := &Token{Hash: hash}
+	var created, expires int64
+	var used sql.NullInt64
+	err = tx.QueryRow(`SELECT user_id, username, created_at, expires_at, used_at FROM password_resets WHERE token_hash = ?`, hash).
+		Scan(&t.UserID, &t.Username, &created, &expires, &used)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	t.Created, t.Expires = time.UnixMicro(created), time.UnixMicro(expires)
+	if used.Valid {
+		t.Used = time.UnixMicro(used.Int64)
+	}
+	if !t.Valid(now) {
+		return nil, ErrInvalid
+	}
+
+	// The used_at check keeps a concurrent Consume from using it twice.
+	res, err := tx.Exec(`UPDATE password_resets SET used_at = ? WHERE token_hash = ? AND used_at IS NULL`, now.UnixMicro(), hash)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, ErrInvalid
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	t.Used = time.UnixMicro(now.UnixMicro())
+	return t, nil
+}
+
+func (r *SQLRepo) DeleteUser(userID string) error {
+	_, err := r.db.Exec(`DELETE FROM password_resets WHERE user_id = ?`, userID)
+	return err
+}
+
+func (r *SQLRepo) Prune(now time.Time) (int, error) {
+	res, err := r.db.Exec(`DELETE FROM password_resets WHERE used_at IS NOT NULL OR expires_at <= ?`, now.UnixMicro())
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
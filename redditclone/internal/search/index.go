@@ -0,0 +1,358 @@
+package search
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"redditclone/internal/post"
+)
+
+var (
+	ErrEmptyQuery = errors.New("empty query")
+	ErrBadCursor  = errors.New("bad cursor")
+	ErrBadSort    = errors.New("unknown sort")
+)
+
+// Sort is the order of search results.
+type Sort string
+
+const (
+	SortRelevance Sort = "relevance" // best BM25 score first
+	SortNew       Sort = "new"       // newest first
+)
+
+// BM25 parameters. Title words count titleWeight times, so a match in the
+// title outranks the same match in the text or a comment.
+const (
+	bm25K1      = 1.2
+	bm25B       = 0.75
+	titleWeight = 2
+)
+
+// Query is a search request. Text may contain words, "quoted phrases" and
+// -exclusions; Category and Author narrow the results down when set, and
+// the posts in Skip, such as those the caller hid, are left out. After is
+// the Next cursor of the previous page. A zero Limit returns everything.
+type Query struct {
+	Text     string
+	Category string
+	Author   string
+	Skip     map[string]bool // by post ID
+	Sort     Sort
+	Limit    int
+	After    string
+}
+
+// Result is one page of matching post IDs, best first. Next is empty on the
+// last page.
+type Result struct {
+	IDs  []string
+	Next string
+}
+
+// document is what the index knows about an indexed post.
+type document struct {
+	category string
+	author   string
+	created  time.Time
+	length   float64  // weighted number of terms
+	terms    []string // distinct terms, to find the postings on removal
+}
+
+// posting records the occurrences of a term in a document. Positions number
+// the terms of the title, the text and the live comments in turn, with a gap
+// between them so phrases do not match across fields.
+type posting struct {
+	tf        float64 // weighted term frequency
+	positions []int
+}
+
+// Index is an inverted index over the titles, texts and comment bodies of
+// live posts. It is safe for concurrent use.
+type Index struct {
+	mu          sync.RWMutex
+	docs        map[string]*document
+	postings    map[string]map[string]*posting // term -> post ID -> posting
+	totalLength float64
+}
+
+func NewIndex() *Index {
+	return &Index{
+		docs:     make(map[string]*document),
+		postings: make(map[string]map[string]*posting),
+	}
+}
+
+// Build indexes every live post of repo.
+func (ix *Index) Build(repo post.Repo) error {
+	posts, err := repo.GetAll()
+	if err != nil {
+		return err
+	}
+	for _, p := range posts {
+		ix.Put(p)
+	}
+	return nil
+}
+
+// Put indexes p, replacing what was indexed for it before. Deleted posts are
+// removed from the index and deleted comments are left out.
+func (ix *Index) Put(p *post.Post) {
+	if p.IsDeleted() {
+		ix.Remove(p.ID)
+		return
+	}
+
+	doc := &document{category: p.Category, created: p.Created}
+	if p.Author != nil {
+		doc.author = p.Author.Username
+	}
+	postings := make(map[string]*posting)
+	pos := 0
+	add := func(text string, weight float64) {
+		for _, term := range tokenize(text) {
+			pst, ok := postings[term]
+			if !ok {
+				pst = &posting{}
+				postings[term] = pst
+				doc.terms = append(doc.terms, term)
+			}
+			pst.tf += weight
+			pst.positions = append(pst.positions, pos)
+			doc.length += weight
+			pos++
+		}
+		pos++
+	}
+	add(p.Title, titleWeight)
+	add(p.Text, 1)
+	for _, c := range p.Comments {
+		if !c.IsDeleted() {
+			add(c.Body, 1)
+		}
+	}
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.remove(p.ID)
+	ix.docs[p.ID] = doc
+	ix.totalLength += doc.length
+	for term, pst := range postings {
+		docs, ok := ix.postings[term]
+		if !ok {
+			docs = make(map[string]*posting)
+			ix.postings[term] = docs
+		}
+		docs[p.ID] = pst
+	}
+}
+
+// Remove drops a post from the index.
+func (ix *Index) Remove(id string) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.remove(id)
+}
+
+func (ix *Index) remove(id string) {
+	doc, ok := ix.docs[id]
+	if !ok {
+		return
+	}
+	for _, term := range doc.terms {
+		docs := ix.postings[term]
+		delete(docs, id)
+		if len(docs) == 0 {
+			delete(ix.postings, term)
+		}
+	}
+	ix.totalLength -= doc.length
+	delete(ix.docs, id)
+}
+
+// hit is a matching post and the value results are ordered by.
+type hit struct {
+	ID  string  `json:"i"`
+	Key float64 `json:"k"`
+}
+
+// before reports whether h comes before other in result order: higher key
+// first, ties broken by ID.
+func (h hit) before(other hit) bool {
+	if h.Key != other.Key {
+		return h.Key > other.Key
+	}
+	return h.ID < other.ID
+}
+
+// cursor is the last hit of a page; the next page starts after it.
+type cursor struct {
+	hit
+	Sort Sort `json:"o"`
+}
+
+func (c cursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string, sort Sort) (*cursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrBadCursor
+	}
+	var c cursor
+	if err = json.Unmarshal(data, &c); err != nil || c.Sort != sort {
+		return nil, ErrBadCursor
+	}
+	return &c, nil
+}
+
+// Search returns the posts matching q. Relevance is scored with BM25 over the
+// words and phrases of the query; exclusions only filter.
+func (ix *Index) Search(q Query) (*Result, error) {
+	switch q.Sort {
+	case "":
+		q.Sort = SortRelevance
+	case SortRelevance, SortNew:
+	default:
+		return nil, ErrBadSort
+	}
+	after, err := decodeCursor(q.After, q.Sort)
+	if err != nil {
+		return nil, err
+	}
+	parsed := parseQuery(q.Text)
+	if len(parsed.must) == 0 {
+		return nil, ErrEmptyQuery
+	}
+
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	// Every match contains every required term, so the rarest one bounds
+	// the candidates.
+	rarest := ix.postings[parsed.must[0][0]]
+	for _, phrase := range parsed.must {
+		for _, term := range phrase {
+			if docs := ix.postings[term]; len(docs) < len(rarest) {
+				rarest = docs
+			}
+		}
+	}
+
+	var hits []hit
+	for id := range rarest {
+		if q.Skip[id] {
+			continue
+		}
+		doc := ix.docs[id]
+		if q.Category != "" && doc.category != q.Category {
+			continue
+		}
+		if q.Author != "" && doc.author != q.Author {
+			continue
+		}
+		if !ix.matchesAll(id, parsed.must) || ix.matchesAny(id, parsed.not) {
+			continue
+		}
+		h := hit{ID: id}
+		if q.Sort == SortNew {
+			h.Key = float64(doc.created.UnixMilli())
+		} else {
+			h.Key = ix.score(id, doc, parsed.must)
+		}
+		if after == nil || after.before(h) {
+			hits = append(hits, h)
+		}
+	}
+	slices.SortFunc(hits, func(a, b hit) int {
+		if a.before(b) {
+			return -1
+		}
+		return 1
+	})
+
+	res := &Result{IDs: make([]string, 0, len(hits))}
+	if q.Limit > 0 && len(hits) > q.Limit {
+		hits = hits[:q.Limit]
+		res.Next = cursor{hit: hits[len(hits)-1], Sort: q.Sort}.encode()
+	}
+	for _, h := range hits {
+		res.IDs = append(res.IDs, h.ID)
+	}
+	return res, nil
+}
+
+func (ix *Index) matchesAll(id string, phrases [][]string) bool {
+	for _, phrase := range phrases {
+		if !ix.matches(id, phrase) {
+			return false
+		}
+	}
+	return true
+}
+
+func (ix *Index) matchesAny(id string, phrases [][]string) bool {
+	for _, phrase := range phrases {
+		if ix.matches(id, phrase) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether the terms of phrase occur in the document one
+// right after another.
+func (ix *Index) matches(id string, phrase []string) bool {
+	postings := make([]*posting, len(phrase))
+	for i, term := range phrase {
+		pst, ok := ix.postings[term][id]
+		if !ok {
+			return false
+		}
+		postings[i] = pst
+	}
+	if len(phrase) == 1 {
+		return true
+	}
+
+next:
+	for _, start := range postings[0].positions {
+		for i, pst := range postings[1:] {
+			if _, ok := slices.BinarySearch(pst.positions, start+i+1); !ok {
+				continue next
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// score is the BM25 score of the document for the distinct terms of the query.
+func (ix *Index) score(id string, doc *document, phrases [][]string) float64 {
+	n := float64(len(ix.docs))
+	avgLength := ix.totalLength / n
+	seen := make(map[string]bool)
+	score := 0.0
+	for _, term := range slices.Concat(phrases...) {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+		docs := ix.postings[term]
+		df := float64(len(docs))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		tf := docs[id].tf
+		score += idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*doc.length/avgLength))
+	}
+	return score
+}
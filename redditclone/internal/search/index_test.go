@@ -0,0 +1,255 @@
+package search
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"redditclone/internal/post"
+)
+
+var testTime = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// newPost returns a text post by author in category, created n minutes
+// after testTime.
+func newPost(id, category, author, title, text string, n int) *post.Post {
+	return &post.Post{
+		ID:       id,
+		Title:    title,
+		Author:   &post.Author{ID: "id-" + author, Username: author},
+		Category: category,
+		Votes:    []*post.Vote{},
+		Comments: []*post.Comment{},
+		Created:  testTime.Add(time.Duration(n) * time.Minute),
+		Type:     "text",
+		Text:     text,
+	}
+}
+
+// newIndex indexes the posts.
+func newIndex(posts ...*post.Post) *Index {
+	ix := NewIndex()
+	for _, p := range posts {
+		ix.Put(p)
+	}
+	return ix
+}
+
+// search returns the IDs of every match of q, failing the test on error.
+func search(t *testing.T, ix *Index, q Query) []string {
+	t.Helper()
+	res, err := ix.Search(q)
+	if err != nil {
+		t.Fatalf("Search(%+v): %v", q, err)
+	}
+	return res.IDs
+}
+
+// guitarIndex returns an index of n posts about guitars, created a
+// minute apart.
+func guitarIndex(t *testing.T, n int) *Index {
+	t.Helper()
+	ix := NewIndex()
+	for i := range n {
+		ix.Put(newPost(string(rune('a'+i)), "music", "alice", "Guitar", "", i))
+	}
+	return ix
+}
+
+func TestSearchMatches(t *testing.T) {
+	ix := newIndex(
+		newPost("p1", "music", "alice", "New guitar", "I bought a red electric guitar", 0),
+		newPost("p2", "music", "bob", "Guitar strings", "Which strings for an electric bass?", 1),
+		newPost("p3", "news", "alice", "Red planet", "Electric cars on Mars", 2),
+		newPost("p4", "news", "carol", "Quiet week", "Nothing happened", 3),
+	)
+	tests := []struct {
+		name string
+		q    Query
+		want []string
+	}{
+		{"word", Query{Text: "guitar"}, []string{"p1", "p2"}},
+		{"case and punctuation", Query{Text: "GUITAR!"}, []string{"p1", "p2"}},
+		{"all words", Query{Text: "electric red"}, []string{"p1", "p3"}},
+		{"no match", Query{Text: "violin"}, []string{}},
+		{"phrase", Query{Text: `"electric guitar"`}, []string{"p1"}},
+		{"phrase terms apart", Query{Text: `"red guitar"`}, []string{}},
+		{"phrase in reverse", Query{Text: `"guitar electric"`}, []string{}},
+		{"phrase across title and text", Query{Text: `"guitar which"`}, []string{}},
+		{"unclosed phrase", Query{Text: `"electric cars`}, []string{"p3"}},
+		{"excluded word", Query{Text: "electric -bass"}, []string{"p1", "p3"}},
+		{"excluded phrase", Query{Text: `electric -"electric guitar"`}, []string{"p2", "p3"}},
+		{"excluded word elsewhere", Query{Text: "electric -mars"}, []string{"p1", "p2"}},
+		{"category", Query{Text: "electric", Category: "news"}, []string{"p3"}},
+		{"author", Query{Text: "electric", Author: "alice"}, []string{"p1", "p3"}},
+		{"category and author", Query{Text: "electric", Category: "music", Author: "alice"}, []string{"p1"}},
+		{"skipped", Query{Text: "electric", Skip: map[string]bool{"p1": true, "p4": true}}, []string{"p2", "p3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := search(t, ix, tt.q)
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchErrors(t *testing.T) {
+	ix := newIndex(newPost("p1", "music", "alice", "Guitar", "", 0))
+	next := func() string {
+		res, err := guitarIndex(t, 2).Search(Query{Text: "guitar", Limit: 1})
+		if err != nil || res.Next == "" {
+			t.Fatalf("first page: %+v, %v", res, err)
+		}
+		return res.Next
+	}()
+	tests := []struct {
+		name string
+		q    Query
+		want error
+	}{
+		{"empty", Query{Text: "  "}, ErrEmptyQuery},
+		{"punctuation only", Query{Text: `"!?"`}, ErrEmptyQuery},
+		{"exclusions only", Query{Text: "-guitar"}, ErrEmptyQuery},
+		{"unknown sort", Query{Text: "guitar", Sort: "top"}, ErrBadSort},
+		{"malformed cursor", Query{Text: "guitar", After: "%%%"}, ErrBadCursor},
+		{"cursor of another sort", Query{Text: "guitar", Sort: SortNew, After: next}, ErrBadCursor},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ix.Search(tt.q); !errors.Is(err, tt.want) {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchRanking(t *testing.T) {
+	filler := "and then some more words about nothing in particular at all"
+	ix := newIndex(
+		newPost("once", "music", "alice", "Question", "guitar tuning", 0),
+		newPost("twice", "music", "alice", "Question", "guitar tuning guitar", 1),
+		newPost("long", "music", "alice", "Question", "guitar tuning "+filler, 2),
+		newPost("title", "music", "alice", "Guitar", "tuning help", 3),
+		newPost("other", "music", "alice", "Drums", "drum tuning", 4),
+	)
+	tests := []struct {
+		name string
+		q    Query
+		want []string
+	}{
+		// A title word counts twice and the title is short; more
+		// occurrences rank higher; longer documents rank lower.
+		{"relevance", Query{Text: "guitar"}, []string{"title", "twice", "once", "long"}},
+		// A term of every document barely counts: the order stays.
+		{"common term", Query{Text: "guitar tuning"}, []string{"title", "twice", "once", "long"}},
+		{"new", Query{Text: "guitar", Sort: SortNew}, []string{"title", "long", "twice", "once"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := search(t, ix, tt.q); !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchPages(t *testing.T) {
+	ix := guitarIndex(t, 7)
+	// The posts tie under relevance, so its order is by ID.
+	for _, sort := range []Sort{SortRelevance, SortNew} {
+		t.Run(string(sort), func(t *testing.T) {
+			all := search(t, ix, Query{Text: "guitar", Sort: sort})
+			if len(all) != 7 {
+				t.Fatalf("%d results, want 7", len(all))
+			}
+			var got []string
+			q := Query{Text: "guitar", Sort: sort, Limit: 3}
+			for pages := 1; ; pages++ {
+				res, err := ix.Search(q)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, res.IDs...)
+				if res.Next == "" {
+					if pages != 3 {
+						t.Errorf("%d pages, want 3", pages)
+					}
+					break
+				}
+				q.After = res.Next
+			}
+			if !slices.Equal(got, all) {
+				t.Errorf("pages = %v, want %v", got, all)
+			}
+		})
+	}
+}
+
+func TestIndexedRepo(t *testing.T) {
+	ix := NewIndex()
+	repo := Indexed(post.NewMemoryRepo(), ix)
+	if _, err := repo.Add(newPost("p1", "music", "alice", "Guitar", "A red one", 0)); err != nil {
+		t.Fatal(err)
+	}
+	c := post.NewComment(&post.Author{ID: "id-bob", Username: "bob"}, "nice banjo")
+
+	steps := []struct {
+		name   string
+		change func() error
+		found  map[string]bool // query -> whether p1 matches it
+	}{
+		{"added", func() error { return nil },
+			map[string]bool{"guitar": true, "red": true}},
+		{"edited", func() error {
+			title := "Violin"
+			_, err := repo.Edit("p1", post.Edit{Title: &title, Edited: testTime})
+			return err
+		}, map[string]bool{"guitar": false, "violin": true, "red": true}},
+		{"commented", func() error {
+			_, err := repo.AddComment("p1", c)
+			return err
+		}, map[string]bool{"banjo": true}},
+		{"comment edited", func() error {
+			_, err := repo.EditComment("p1", c.ID, "nice ukulele", testTime)
+			return err
+		}, map[string]bool{"banjo": false, "ukulele": true}},
+		{"comment deleted", func() error {
+			_, err := repo.DeleteComment("p1", c.ID, testTime)
+			return err
+		}, map[string]bool{"ukulele": false, "violin": true}},
+		{"comment restored", func() error {
+			_, err := repo.RestoreComment("p1", c.ID)
+			return err
+		}, map[string]bool{"ukulele": true}},
+		{"deleted", func() error { return repo.Delete("p1", testTime) },
+			map[string]bool{"violin": false, "ukulele": false}},
+		{"restored", func() error {
+			_, err := repo.Restore("p1")
+			return err
+		}, map[string]bool{"violin": true, "ukulele": true}},
+	}
+	for _, step := range steps {
+		if err := step.change(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		for text, want := range step.found {
+			if got := len(search(t, ix, Query{Text: text})) == 1; got != want {
+				t.Errorf("%s: %q finds p1 = %v, want %v", step.name, text, got, want)
+			}
+		}
+	}
+
+	if err := repo.Forget("id-alice", post.KeepVotes); err != nil {
+		t.Fatal(err)
+	}
+	if got := search(t, ix, Query{Text: "violin", Author: "alice"}); len(got) != 0 {
+		t.Errorf("forgotten author still finds %v", got)
+	}
+	if got := search(t, ix, Query{Text: "violin", Author: post.DeletedPlaceholder}); !slices.Equal(got, []string{"p1"}) {
+		t.Errorf("placeholder author finds %v, want p1", got)
+	}
+}
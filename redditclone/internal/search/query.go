@@ -0,0 +1,66 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+)
+
+// query is a parsed search string. Every clause is a phrase: a run of terms
+// that must appear next to each other; single words are one-term phrases.
+// A document matches if it contains all of must and none of not.
+type query struct {
+	must [][]string
+	not  [][]string
+}
+
+// parseQuery splits s into words and "quoted phrases"; a leading '-' turns a
+// word or phrase into an exclusion.
+func parseQuery(s string) query {
+	var q query
+	for s != "" {
+		s = strings.TrimLeftFunc(s, unicode.IsSpace)
+		if s == "" {
+			break
+		}
+
+		exclude := false
+		if s[0] == '-' {
+			exclude = true
+			s = s[1:]
+		}
+
+		var clause string
+		if strings.HasPrefix(s, `"`) {
+			end := strings.IndexByte(s[1:], '"')
+			if end < 0 {
+				clause, s = s[1:], ""
+			} else {
+				clause, s = s[1:end+1], s[end+2:]
+			}
+		} else {
+			end := strings.IndexFunc(s, unicode.IsSpace)
+			if end < 0 {
+				end = len(s)
+			}
+			clause, s = s[:end], s[end:]
+		}
+
+		terms := tokenize(clause)
+		if len(terms) == 0 {
+			continue
+		}
+		if exclude {
+			q.not = append(q.not, terms)
+		} else {
+			q.must = append(q.must, terms)
+		}
+	}
+	return q
+}
+
+// tokenize lowercases s and splits it into runs of letters and digits.
+func tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
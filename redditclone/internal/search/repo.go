@@ -0,0 +1,101 @@
+package search
+
+import (
+	"sync"
+	"time"
+
+	"redditclone/internal/post"
+)
+
+// indexedRepo keeps an index in step with every change to the searchable
+// content of posts; everything else goes straight to the wrapped repo.
+type indexedRepo struct {
+	post.Repo
+	index *Index
+	mu    sync.Mutex // applies index updates in the order of the changes
+}
+
+// Indexed returns a repo that updates ix whenever a post is added, edited,
+// commented on or deleted through it.
+func Indexed(repo post.Repo, ix *Index) post.Repo {
+	return &indexedRepo{Repo: repo, index: ix}
+}
+
+// put indexes the post returned by a successful change.
+func (r *indexedRepo) put(p *post.Post, err error) (*post.Post, error) {
+	if err == nil {
+		r.index.Put(p)
+	}
+	return p, err
+}
+
+func (r *indexedRepo) Add(p *post.Post) (*post.Post, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.put(r.Repo.Add(p))
+}
+
+func (r *indexedRepo) Delete(id string, deleted time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.Repo.Delete(id, deleted); err != nil {
+		return err
+	}
+	r.index.Remove(id)
+	return nil
+}
+
+func (r *indexedRepo) Restore(id string) (*post.Post, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.put(r.Repo.Restore(id))
+}
+
+func (r *indexedRepo) AddComment(postID string, comment *post.Comment) (*post.Post, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.put(r.Repo.AddComment(postID, comment))
+}
+
+func (r *indexedRepo) DeleteComment(postID, commentID string, deleted time.Time) (*post.Post, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.put(r.Repo.DeleteComment(postID, commentID, deleted))
+}
+
+func (r *indexedRepo) RestoreComment(postID, commentID string) (*post.Post, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.put(r.Repo.RestoreComment(postID, commentID))
+}
+
+func (r *indexedRepo) Edit(postID string, edit post.Edit) (*post.Post, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.put(r.Repo.Edit(postID, edit))
+}
+
+func (r *indexedRepo) EditComment(postID, commentID, body string, edited time.Time) (*post.Post, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.put(r.Repo.EditComment(postID, commentID, body, edited))
+}
+
+// Forget reindexes the posts of the user, which change author.
+func (r *indexedRepo) Forget(userID string, votes post.VotePolicy) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	a, err := r.Repo.Activity(userID)
+	if err != nil {
+		return err
+	}
+	if err = r.Repo.Forget(userID, votes); err != nil {
+		return err
+	}
+	for _, p := range a.Posts {
+		if _, err = r.put(r.Repo.GetByID(p.ID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,293 @@
+package session
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrNotFound = errors.New("session not found")
+	ErrRevoked  = errors.New("session revoked or expired")
+	ErrReused   = errors.New("refresh token reused")
+	ErrBadToken = errors.New("malformed refresh token")
+)
+
+// MaxDeviceLength is how much of the User-Agent a session keeps.
+const MaxDeviceLength = 200
+
+// Session is a login on one device. Its refresh token is rotated on every
+// use; only the hash of the current token is kept, so a refresh token that
+// was already rotated away marks the session as stolen.
+type Session struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id"`
+	Username  string    `json:"username"`
+	Device    string    `json:"device"`
+	IP        string    `json:"ip"`
+	TokenHash string    `json:"token_hash"`
+	Created   time.Time `json:"created"`
+	LastUsed  time.Time `json:"last_used"`
+	Expires   time.Time `json:"expires"`
+	Revoked   time.Time `json:"revoked,omitzero"`
+}
+
+// New starts a session of the user lasting ttl and returns it with its
+// first refresh token.
+func New(userID, username, device, ip string, created time.Time, ttl time.Duration) (*Session, string, error) {
+	s := &Session{
+		ID:       uuid.NewString(),
+		UserID:   userID,
+		Username: username,
+		Device:   truncate(device, MaxDeviceLength),
+		IP:       ip,
+		Created:  created,
+		LastUsed: created,
+		Expires:  created.Add(ttl),
+	}
+	token, hash, err := NewToken(s.ID)
+	if err != nil {
+		return nil, "", err
+	}
+	s.TokenHash = hash
+	return s, token, nil
+}
+
+// Live reports whether the session can still be refreshed at now.
+func (s *Session) Live(now time.Time) bool {
+	return s.Revoked.IsZero() && now.Before(s.Expires)
+}
+
+// endedBefore reports whether the session was revoked or expired before t.
+func (s *Session) endedBefore(t time.Time) bool {
+	return !s.Revoked.IsZero() && s.Revoked.Before(t) || s.Expires.Before(t)
+}
+
+// Matches reports whether hash is that of the session's current refresh
+// token. The comparison takes the same time wherever the hashes differ.
+func (s *Session) Matches(hash string) bool {
+	return subtle.ConstantTimeCompare([]byte(s.TokenHash), []byte(hash)) == 1
+}
+
+// NewToken returns a fresh refresh token of the session and its hash.
+func NewToken(sessionID string) (token, hash string, err error) {
+	secret := make([]byte, 32)
+	if _, err = rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	token = sessionID + "." + base64.RawURLEncoding.EncodeToString(secret)
+	return token, Hash(token), nil
+}
+
+// ParseToken returns the session a refresh token belongs to and the token's
+// hash.
+func ParseToken(token string) (sessionID, hash string, err error) {
+	sessionID, secret, ok := strings.Cut(token, ".")
+	if !ok || sessionID == "" || secret == "" {
+		return "", "", ErrBadToken
+	}
+	return sessionID, Hash(token), nil
+}
+
+// Hash is how refresh tokens are stored.
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+type Repo interface {
+	// Create stores a new session.
+	Create(s *Session) (*Session, error)
+	// Get returns a session, revoked and expired ones included.
+	Get(id string) (*Session, error)
+	// Rotate swaps the refresh token of a live session from oldHash to
+	// newHash and extends it to expires. It returns ErrRevoked if the
+	// session is no longer live and ErrReused, leaving the session as is, if
+	// oldHash is not its current token.
+	Rotate(id, oldHash, newHash string, used, expires time.Time) (*Session, error)
+	// Revoke ends a session; revoking it again changes nothing.
+	Revoke(id string, revoked time.Time) error
+	// RevokeUser ends every session of a user except the one with the ID
+	// except, if any, and returns how many it ended.
+	RevokeUser(userID, except string, revoked time.Time) (int, error)
+	// List returns the live sessions of a user, most recently used first.
+	List(userID string, now time.Time) ([]*Session, error)
+	// Prune drops the sessions that ended, by revocation or expiry, before
+	// before and returns how many it dropped; the repo would keep them
+	// forever otherwise. Access tokens of a dropped session stop working,
+	// so before should lie at least an access token's lifetime in the past.
+	Prune(before time.Time) (int, error)
+}
+
+type MemoryRepo struct {
+	mu       sync.RWMutex
+	sessions map[string]*Session
+	byUser   map[string]map[string]bool // user ID -> session IDs
+}
+
+func NewMemoryRepo() *MemoryRepo {
+	return &MemoryRepo{
+		sessions: make(map[string]*Session),
+		byUser:   make(map[string]map[string]bool),
+	}
+}
+
+func (r *MemoryRepo) Create(s *Session) (*Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.load(s)
+	cp := *s
+	return &cp, nil
+}
+
+func (r *MemoryRepo) load(s *Session) {
+	cp := *s
+	r.sessions[s.ID] = &cp
+	if r.byUser[s.UserID] == nil {
+		r.byUser[s.UserID] = make(map[string]bool)
+	}
+	r.byUser[s.UserID][s.ID] = true
+}
+
+func (r *MemoryRepo) Get(id string) (*Session, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	s, ok := r.sessions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	cp := *s
+	return &cp, nil
+}
+
+func (r *MemoryRepo) Rotate(id, oldHash, newHash string, used, expires time.Time) (*Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.sessions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if !s.Live(used) {
+		return nil, ErrRevoked
+	}
+	if !s.Matches(oldHash) {
+		return nil, ErrReused
+	}
+	s.TokenHash, s.LastUsed, s.Expires = newHash, used, expires
+	cp := *s
+	return &cp, nil
+}
+
+func (r *MemoryRepo) Revoke(id string, revoked time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.sessions[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if s.Revoked.IsZero() {
+		s.Revoked = revoked
+	}
+	return nil
+}
+
+func (r *MemoryRepo) RevokeUser(userID, except string, revoked time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := 0
+	for id := range r.byUser[userID] {
+		if s := r.sessions[id]; id != except && s.Revoked.IsZero() {
+			s.Revoked = revoked
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r *MemoryRepo) List(userID string, now time.Time) ([]*Session, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	list := []*Session{}
+	for id := range r.byUser[userID] {
+		if s := r.sessions[id]; s.Live(now) {
+			cp := *s
+			list = append(list, &cp)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].LastUsed.Equal(list[j].LastUsed) {
+			return list[i].LastUsed.After(list[j].LastUsed)
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list, nil
+}
+
+func (r *MemoryRepo) Prune(before time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := 0
+	for id, s := range r.sessions {
+		if !s.endedBefore(before) {
+			continue
+		}
+		delete(r.sessions, id)
+		delete(r.byUser[s.UserID], id)
+		if len(r.byUser[s.UserID]) == 0 {
+			delete(r.byUser, s.UserID)
+		}
+		n++
+	}
+	return n, nil
+}
+
+// Records returns every stored session, revoked and expired ones included.
+func (r *MemoryRepo) Records() []*Session {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	list := make([]*Session, 0, len(r.sessions))
+	for _, s := range r.sessions {
+		cp := *s
+		list = append(list, &cp)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// Load inserts a previously stored session.
+func (r *MemoryRepo) Load(s *Session) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.load(s)
+}
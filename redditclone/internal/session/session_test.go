@@ -0,0 +1,197 @@
+package session
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"redditclone/internal/storage"
+)
+
+var testTime = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// forEachRepo runs test against an empty repo of each implementation.
+func forEachRepo(t *testing.T, test func(t *testing.T, r Repo)) {
+	t.Helper()
+	repos := []struct {
+		name string
+		new  func(t *testing.T) Repo
+	}{
+		{"memory", func(t *testing.T) Repo { return NewMemoryRepo() }},
+		{"sql", func(t *testing.T) Repo {
+			db, err := storage.Open("sqlite", filepath.Join(t.TempDir(), "test.db"))
+			if err != nil {
+				t.Fatalf("open database: %v", err)
+			}
+			t.Cleanup(func() { db.Close() })
+			return NewSQLRepo(db)
+		}},
+	}
+	for _, tt := range repos {
+		t.Run(tt.name, func(t *testing.T) {
+			test(t, tt.new(t))
+		})
+	}
+}
+
+// create starts a session of the user at testTime lasting an hour and
+// returns it with its refresh token.
+func create(t *testing.T, r Repo, userID string) (*Session, string) {
+	t.Helper()
+	s, token, err := New(userID, "name-"+userID, "test", "127.0.0.1", testTime, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.Create(s); err != nil {
+		t.Fatal(err)
+	}
+	return s, token
+}
+
+// rotate trades token for a new one at used, as a refresh does.
+func rotate(r Repo, token string, used time.Time) (string, error) {
+	id, hash, err := ParseToken(token)
+	if err != nil {
+		return "", err
+	}
+	next, newHash, err := NewToken(id)
+	if err != nil {
+		return "", err
+	}
+	_, err = r.Rotate(id, hash, newHash, used, used.Add(time.Hour))
+	return next, err
+}
+
+func TestRepoRotate(t *testing.T) {
+	forEachRepo(t, func(t *testing.T, r Repo) {
+		s, first := create(t, r, "u1")
+
+		second, err := rotate(r, first, testTime.Add(time.Minute))
+		if err != nil {
+			t.Fatalf("first refresh: %v", err)
+		}
+		got, err := r.Get(s.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Matches(Hash(second)) || got.Matches(Hash(first)) {
+			t.Error("the session does not hold the rotated token")
+		}
+		if want := testTime.Add(time.Minute); !got.LastUsed.Equal(want) || !got.Expires.Equal(want.Add(time.Hour)) {
+			t.Errorf("used %v until %v, want %v until %v", got.LastUsed, got.Expires, want, want.Add(time.Hour))
+		}
+
+		// The first token was traded away: whoever shows it again does not
+		// hold the session alone.
+		if _, err = rotate(r, first, testTime.Add(2*time.Minute)); !errors.Is(err, ErrReused) {
+			t.Fatalf("reusing the first token: error = %v, want ErrReused", err)
+		}
+		if got, _ = r.Get(s.ID); !got.Matches(Hash(second)) {
+			t.Error("a reused token changed the session")
+		}
+		if _, err = rotate(r, second, testTime.Add(3*time.Minute)); err != nil {
+			t.Errorf("refresh after a detected reuse: %v", err)
+		}
+
+		if _, err = rotate(r, s.ID+".forged", testTime.Add(4*time.Minute)); !errors.Is(err, ErrReused) {
+			t.Errorf("forged token: error = %v, want ErrReused", err)
+		}
+		if _, err = rotate(r, "missing.token", testTime); !errors.Is(err, ErrNotFound) {
+			t.Errorf("unknown session: error = %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestRepoRotateEnded(t *testing.T) {
+	forEachRepo(t, func(t *testing.T, r Repo) {
+		_, expired := create(t, r, "u1")
+		if _, err := rotate(r, expired, testTime.Add(time.Hour)); !errors.Is(err, ErrRevoked) {
+			t.Errorf("expired session: error = %v, want ErrRevoked", err)
+		}
+
+		s, revoked := create(t, r, "u1")
+		if err := r.Revoke(s.ID, testTime.Add(time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Revoke(s.ID, testTime.Add(2*time.Minute)); err != nil {
+			t.Fatalf("second Revoke: %v", err)
+		}
+		got, err := r.Get(s.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := testTime.Add(time.Minute); !got.Revoked.Equal(want) {
+			t.Errorf("revoked at %v, want the first revocation at %v", got.Revoked, want)
+		}
+		if _, err = rotate(r, revoked, testTime.Add(3*time.Minute)); !errors.Is(err, ErrRevoked) {
+			t.Errorf("revoked session: error = %v, want ErrRevoked", err)
+		}
+		if err = r.Revoke("missing", testTime); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Revoke(missing) error = %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestRepoRevokeUser(t *testing.T) {
+	forEachRepo(t, func(t *testing.T, r Repo) {
+		keep, _ := create(t, r, "u1")
+		create(t, r, "u1")
+		create(t, r, "u1")
+		other, _ := create(t, r, "u2")
+
+		n, err := r.RevokeUser("u1", keep.ID, testTime.Add(time.Minute))
+		if err != nil || n != 2 {
+			t.Fatalf("RevokeUser = %d, %v, want 2 sessions ended", n, err)
+		}
+		list, err := r.List("u1", testTime.Add(2*time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != 1 || list[0].ID != keep.ID {
+			t.Errorf("live sessions of u1 = %d, want only the kept one", len(list))
+		}
+		if list, _ = r.List("u2", testTime.Add(2*time.Minute)); len(list) != 1 || list[0].ID != other.ID {
+			t.Error("the sessions of u2 ended too")
+		}
+	})
+}
+
+func TestRepoPrune(t *testing.T) {
+	forEachRepo(t, func(t *testing.T, r Repo) {
+		live, _ := create(t, r, "u1")
+		revoked, _ := create(t, r, "u1")
+		if err := r.Revoke(revoked.ID, testTime.Add(time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+		justRevoked, _ := create(t, r, "u2")
+		if err := r.Revoke(justRevoked.ID, testTime.Add(30*time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+
+		if n, err := r.Prune(testTime.Add(10 * time.Minute)); err != nil || n != 1 {
+			t.Errorf("pruned %d sessions, %v, want the one revoked before", n, err)
+		}
+		if _, err := r.Get(revoked.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("revoked session after Prune: error = %v, want ErrNotFound", err)
+		}
+		for _, s := range []*Session{live, justRevoked} {
+			if _, err := r.Get(s.ID); err != nil {
+				t.Errorf("session %s after Prune: %v", s.ID, err)
+			}
+		}
+
+		// Sessions end once they expire, an hour after testTime.
+		if n, err := r.Prune(testTime.Add(2 * time.Hour)); err != nil || n != 2 {
+			t.Errorf("pruned %d sessions, %v, want the 2 left", n, err)
+		}
+		for _, s := range []*Session{live, justRevoked} {
+			if _, err := r.Get(s.ID); !errors.Is(err, ErrNotFound) {
+				t.Errorf("session %s after the second Prune: error = %v, want ErrNotFound", s.ID, err)
+			}
+		}
+		if m, ok := r.(*MemoryRepo); ok && len(m.byUser) != 0 {
+			t.Errorf("sessions of %d users left indexed", len(m.byUser))
+		}
+	})
+}
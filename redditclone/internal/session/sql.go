@@ -0,0 +1,140 @@
+package session
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// SQLRepo stores sessions in the sessions table created by the storage
+// package migrations.
+type SQLRepo struct {
+	db *sql.DB
+}
+
+func NewSQLRepo(db *sql.DB) *SQLRepo {
+	return &SQLRepo{db: db}
+}
+
+const columns = `id, user_id, username, device, ip, token_hash, created_at, last_used_at, expires_at, revoked_at`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanSession(row scanner) (*Session, error) {
+	s := &Session{}
+	var created, used, expires int64
+	var revoked sql.NullInt64
+	err := row.Scan(&s.ID, &s.UserID, &s.Username, &s.Device, &s.IP, &s.TokenHash, &created, &used, &expires, &revoked)
+	if err != nil {
+		return nil, err
+	}
+	s.Created, s.LastUsed, s.Expires = time.UnixMicro(created), time.UnixMicro(used), time.UnixMicro(expires)
+	if revoked.Valid {
+		s.Revoked = time.UnixMicro(revoked.Int64)
+	}
+	return s, nil
+}
+
+func (r *SQLRepo) Create(s *Session) (*Session, error) {
+	_, err := r.db.Exec(`INSERT INTO sessions (`+columns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, NULL)`,
+		s.ID, s.UserID, s.Username, s.Device, s.IP, s.TokenHash,
+		s.Created.UnixMicro(), s.LastUsed.UnixMicro(), s.Expires.UnixMicro())
+	if err != nil {
+		return nil, err
+	}
+	return r.Get(s.ID)
+}
+
+func (r *SQLRepo) Get(id string) (*Session, error) {
+	s, err := scanSession(r.db.QueryRow(`SELECT `+columns+` FROM sessions WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return s, err
+}
+
+func (r *SQLRepo) Rotate(id, oldHash, newHash string, used, expires time.Time) (*Session, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	s, err := scanSession(tx.QueryRow(`SELECT `+columns+` FROM sessions WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !s.Live(used) {
+		return nil, ErrRevoked
+	}
+	if !s.Matches(oldHash) {
+		return nil, ErrReused
+	}
+	_, err = tx.Exec(`UPDATE sessions SET token_hash = ?, last_used_at = ?, expires_at = ? WHERE id = ?`,
+		newHash, used.UnixMicro(), expires.UnixMicro(), id)
+	if err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	s.TokenHash, s.LastUsed, s.Expires = newHash, time.UnixMicro(used.UnixMicro()), time.UnixMicro(expires.UnixMicro())
+	return s, nil
+}
+
+func (r *SQLRepo) Revoke(id string, revoked time.Time) error {
+	res, err := r.db.Exec(`UPDATE sessions SET revoked_at = COALESCE(revoked_at, ?) WHERE id = ?`, revoked.UnixMicro(), id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *SQLRepo) RevokeUser(userID, except string, revoked time.Time) (int, error) {
+	res, err := r.db.Exec(`UPDATE sessions SET revoked_at = ? WHERE user_id = ? AND id != ? AND revoked_at IS NULL`,
+		revoked.UnixMicro(), userID, except)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (r *SQLRepo) List(userID string, now time.Time) ([]*Session, error) {
+	rows, err := r.db.Query(`SELECT `+columns+` FROM sessions
+		WHERE user_id = ? AND revoked_at IS NULL AND expires_at > ?
+		ORDER BY last_used_at DESC, id`, userID, now.UnixMicro())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []*Session{}
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, s)
+	}
+	return list, rows.Err()
+}
+
+func (r *SQLRepo) Prune(before time.Time) (int, error) {
+	res, err := r.db.Exec(`DELETE FROM sessions WHERE revoked_at < ? OR expires_at < ?`, before.UnixMicro(), before.UnixMicro())
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
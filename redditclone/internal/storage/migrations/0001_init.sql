@@ -0,0 +1,40 @@
+CREATE TABLE users (
+    id            TEXT PRIMARY KEY,
+    username      TEXT NOT NULL UNIQUE,
+    password_hash TEXT NOT NULL
+);
+
+CREATE TABLE posts (
+    id              TEXT PRIMARY KEY,
+    title           TEXT NOT NULL,
+    url             TEXT NOT NULL DEFAULT '',
+    author_id       TEXT NOT NULL,
+    author_username TEXT NOT NULL,
+    category        TEXT NOT NULL,
+    score           INTEGER NOT NULL DEFAULT 0,
+    created         TIMESTAMP NOT NULL,
+    views           INTEGER NOT NULL DEFAULT 0,
+    type            TEXT NOT NULL,
+    text            TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX posts_category_idx ON posts (category);
+CREATE INDEX posts_author_username_idx ON posts (author_username);
+
+CREATE TABLE votes (
+    post_id TEXT NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+    user_id TEXT NOT NULL,
+    vote    INTEGER NOT NULL,
+    PRIMARY KEY (post_id, user_id)
+);
+
+CREATE TABLE comments (
+    id              TEXT PRIMARY KEY,
+    post_id         TEXT NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+    author_id       TEXT NOT NULL,
+    author_username TEXT NOT NULL,
+    body            TEXT NOT NULL,
+    created         TIMESTAMP NOT NULL
+);
+
+CREATE INDEX comments_post_id_idx ON comments (post_id);
@@ -0,0 +1,14 @@
+ALTER TABLE posts ADD COLUMN edited TIMESTAMP NULL;
+ALTER TABLE comments ADD COLUMN edited TIMESTAMP NULL;
+
+CREATE TABLE revisions (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    post_id    TEXT NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+    comment_id TEXT NOT NULL DEFAULT '',
+    title      TEXT NOT NULL DEFAULT '',
+    url        TEXT NOT NULL DEFAULT '',
+    text       TEXT NOT NULL DEFAULT '',
+    created    TIMESTAMP NOT NULL
+);
+
+CREATE INDEX revisions_post_id_idx ON revisions (post_id, comment_id);
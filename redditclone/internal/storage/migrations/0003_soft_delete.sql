@@ -0,0 +1,5 @@
+ALTER TABLE posts ADD COLUMN deleted TIMESTAMP NULL;
+ALTER TABLE comments ADD COLUMN deleted TIMESTAMP NULL;
+
+CREATE INDEX posts_deleted_idx ON posts (deleted);
+CREATE INDEX comments_deleted_idx ON comments (deleted);
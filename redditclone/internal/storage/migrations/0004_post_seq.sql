@@ -0,0 +1,6 @@
+-- seq orders posts by insertion and breaks ties in paginated listings.
+ALTER TABLE posts ADD COLUMN seq INTEGER NOT NULL DEFAULT 0;
+UPDATE posts SET seq = rowid;
+
+CREATE INDEX posts_seq_idx ON posts (seq);
+CREATE INDEX posts_score_seq_idx ON posts (score, seq);
@@ -0,0 +1,24 @@
+-- Columns behind the hot, rising and controversial listings. created_unix
+-- is the creation time in seconds, which the hot score is computed from;
+-- created is in Unix microseconds.
+ALTER TABLE posts ADD COLUMN created_unix INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE posts ADD COLUMN ups INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE posts ADD COLUMN downs INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE posts ADD COLUMN hot REAL NOT NULL DEFAULT 0;
+ALTER TABLE posts ADD COLUMN controversy REAL NOT NULL DEFAULT 0;
+
+UPDATE posts SET created_unix = created / 1000000;
+
+UPDATE posts SET
+	ups = (SELECT COUNT(*) FROM votes WHERE votes.post_id = posts.id AND vote = 1),
+	downs = (SELECT COUNT(*) FROM votes WHERE votes.post_id = posts.id AND vote = -1);
+
+UPDATE posts SET
+	hot = sign(score) * log10(max(abs(score), 1)) + (created_unix - 1134028003) / 45000.0,
+	controversy = CASE WHEN ups > 0 AND downs > 0
+		THEN pow(ups + downs, CASE WHEN ups > downs THEN downs * 1.0 / ups ELSE ups * 1.0 / downs END)
+		ELSE 0 END;
+
+CREATE INDEX posts_hot_seq_idx ON posts (hot, seq);
+CREATE INDEX posts_controversy_seq_idx ON posts (controversy, seq);
+CREATE INDEX posts_created_unix_idx ON posts (created_unix);
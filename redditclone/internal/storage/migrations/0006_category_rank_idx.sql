@@ -0,0 +1,6 @@
+-- Let category and author listings walk an index instead of sorting.
+CREATE INDEX posts_category_hot_idx ON posts (category, hot, seq);
+CREATE INDEX posts_category_score_idx ON posts (category, score, seq);
+CREATE INDEX posts_category_controversy_idx ON posts (category, controversy, seq);
+CREATE INDEX posts_category_seq_idx ON posts (category, seq);
+CREATE INDEX posts_author_seq_idx ON posts (author_username, seq);
@@ -0,0 +1,5 @@
+-- Replies point at their parent comment; depth is 0 for top-level comments.
+ALTER TABLE comments ADD COLUMN parent_id TEXT;
+ALTER TABLE comments ADD COLUMN depth INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX comments_parent_id_idx ON comments (parent_id);
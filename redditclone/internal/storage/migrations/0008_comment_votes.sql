@@ -0,0 +1,13 @@
+-- Comments are voted on like posts. post_id lets a post's comment votes be
+-- loaded together with the post.
+ALTER TABLE comments ADD COLUMN score INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE comment_votes (
+    comment_id TEXT NOT NULL REFERENCES comments (id) ON DELETE CASCADE,
+    post_id    TEXT NOT NULL,
+    user_id    TEXT NOT NULL,
+    vote       INTEGER NOT NULL,
+    PRIMARY KEY (comment_id, user_id)
+);
+
+CREATE INDEX comment_votes_post_id_idx ON comment_votes (post_id);
@@ -0,0 +1,27 @@
+-- Posts are filed under communities, which have to exist before anything can
+-- be posted to them. Rules are stored as a JSON array of strings; creator_id
+-- is NULL for the default communities and for those backfilled below.
+CREATE TABLE communities (
+    name             TEXT PRIMARY KEY,
+    description      TEXT NOT NULL DEFAULT '',
+    rules            TEXT NOT NULL DEFAULT '[]',
+    creator_id       TEXT,
+    creator_username TEXT,
+    created          TIMESTAMP
+);
+
+CREATE TABLE community_moderators (
+    community TEXT NOT NULL REFERENCES communities (name) ON DELETE CASCADE,
+    user_id   TEXT NOT NULL,
+    username  TEXT NOT NULL,
+    position  INTEGER NOT NULL,
+    PRIMARY KEY (community, user_id)
+);
+
+CREATE INDEX community_moderators_user_id_idx ON community_moderators (user_id);
+
+-- The categories the bundled frontend offers, plus every category posted to
+-- before communities existed.
+INSERT INTO communities (name) VALUES
+    ('music'), ('funny'), ('videos'), ('programming'), ('news'), ('fashion');
+INSERT OR IGNORE INTO communities (name) SELECT DISTINCT category FROM posts;
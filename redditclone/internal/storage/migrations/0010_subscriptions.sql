@@ -0,0 +1,8 @@
+-- Users subscribe to communities to build their home feed.
+CREATE TABLE community_subscribers (
+    community TEXT NOT NULL REFERENCES communities (name) ON DELETE CASCADE,
+    user_id   TEXT NOT NULL,
+    PRIMARY KEY (community, user_id)
+);
+
+CREATE INDEX community_subscribers_user_id_idx ON community_subscribers (user_id, community);
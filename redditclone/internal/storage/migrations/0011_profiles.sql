@@ -0,0 +1,29 @@
+-- Profiles show when a user registered, their karma and their comments.
+-- Users registered before this migration have no registration date.
+ALTER TABLE users ADD COLUMN registered TIMESTAMP;
+
+-- created_unix orders an author's comments, like it does posts.
+ALTER TABLE comments ADD COLUMN created_unix INTEGER NOT NULL DEFAULT 0;
+
+UPDATE comments SET created_unix = created / 1000000;
+
+CREATE INDEX comments_author_username_idx ON comments (author_username, created_unix, id);
+
+-- Karma is kept up to date as votes are cast instead of being summed over
+-- every post. Votes users cast on their own posts and comments do not count.
+CREATE TABLE karma (
+    user_id TEXT PRIMARY KEY,
+    post    INTEGER NOT NULL DEFAULT 0,
+    comment INTEGER NOT NULL DEFAULT 0
+);
+
+INSERT INTO karma (user_id, post)
+SELECT p.author_id, SUM(v.vote) FROM votes v JOIN posts p ON p.id = v.post_id
+WHERE v.user_id != p.author_id
+GROUP BY p.author_id;
+
+INSERT INTO karma (user_id, comment)
+SELECT c.author_id, SUM(v.vote) FROM comment_votes v JOIN comments c ON c.id = v.comment_id
+WHERE v.user_id != c.author_id
+GROUP BY c.author_id
+ON CONFLICT (user_id) DO UPDATE SET comment = excluded.comment;
@@ -0,0 +1,19 @@
+-- Posts users bookmarked or hid. saved_at is in Unix microseconds and orders
+-- the saved listing, most recent first.
+CREATE TABLE saved_posts (
+    user_id  TEXT NOT NULL,
+    post_id  TEXT NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+    saved_at INTEGER NOT NULL,
+    PRIMARY KEY (user_id, post_id)
+);
+
+CREATE INDEX saved_posts_user_id_saved_at_idx ON saved_posts (user_id, saved_at, post_id);
+CREATE INDEX saved_posts_post_id_idx ON saved_posts (post_id);
+
+CREATE TABLE hidden_posts (
+    user_id TEXT NOT NULL,
+    post_id TEXT NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+    PRIMARY KEY (user_id, post_id)
+);
+
+CREATE INDEX hidden_posts_post_id_idx ON hidden_posts (post_id);
@@ -0,0 +1,18 @@
+-- Users report posts and comments to the moderators of their community. An
+-- empty comment_id reports the post itself; an empty resolution means the
+-- report is pending. Times are in Unix microseconds.
+CREATE TABLE reports (
+    id            TEXT PRIMARY KEY,
+    post_id       TEXT NOT NULL,
+    comment_id    TEXT NOT NULL DEFAULT '',
+    category      TEXT NOT NULL,
+    reporter_id   TEXT NOT NULL,
+    reason        TEXT NOT NULL,
+    created_at    INTEGER NOT NULL,
+    resolution    TEXT NOT NULL DEFAULT '',
+    resolved_by   TEXT NOT NULL DEFAULT '',
+    resolved_at   INTEGER
+);
+
+CREATE INDEX reports_content_idx ON reports (post_id, comment_id);
+CREATE INDEX reports_queue_idx ON reports (category, resolution);
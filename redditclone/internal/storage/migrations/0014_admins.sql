@@ -0,0 +1,3 @@
+-- Site-wide admins. Per-category moderators are listed in
+-- community_moderators.
+ALTER TABLE users ADD COLUMN admin INTEGER NOT NULL DEFAULT 0;
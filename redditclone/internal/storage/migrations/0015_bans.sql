@@ -0,0 +1,17 @@
+-- Bans keep users out of the whole site (an empty category) or out of one
+-- category. by_admin is set when banned_by acted as an admin, whose bans
+-- moderators may not lift. Times are in Unix microseconds; expires_at is
+-- NULL for a permanent ban.
+CREATE TABLE bans (
+    user_id    TEXT NOT NULL,
+    category   TEXT NOT NULL DEFAULT '',
+    username   TEXT NOT NULL,
+    reason     TEXT NOT NULL,
+    banned_by  TEXT NOT NULL,
+    by_admin   INTEGER NOT NULL DEFAULT 0,
+    created_at INTEGER NOT NULL,
+    expires_at INTEGER,
+    PRIMARY KEY (user_id, category)
+);
+
+CREATE INDEX bans_category_idx ON bans (category, created_at);
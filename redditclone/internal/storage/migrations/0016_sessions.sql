@@ -0,0 +1,16 @@
+-- Sessions hold the refresh token of each login; only the SHA-256 of the
+-- current token is stored. Times are in Unix microseconds.
+CREATE TABLE sessions (
+    id           TEXT PRIMARY KEY,
+    user_id      TEXT NOT NULL,
+    username     TEXT NOT NULL,
+    device       TEXT NOT NULL DEFAULT '',
+    ip           TEXT NOT NULL DEFAULT '',
+    token_hash   TEXT NOT NULL,
+    created_at   INTEGER NOT NULL,
+    last_used_at INTEGER NOT NULL,
+    expires_at   INTEGER NOT NULL,
+    revoked_at   INTEGER
+);
+
+CREATE INDEX sessions_user_idx ON sessions (user_id, last_used_at);
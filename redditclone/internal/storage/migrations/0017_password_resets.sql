@@ -0,0 +1,15 @@
+-- Users may give an email address to receive password resets at.
+ALTER TABLE users ADD COLUMN email TEXT NOT NULL DEFAULT '';
+
+-- Single-use password reset tokens; only the SHA-256 of each token is
+-- stored. Times are in Unix microseconds.
+CREATE TABLE password_resets (
+    token_hash TEXT PRIMARY KEY,
+    user_id    TEXT NOT NULL,
+    username   TEXT NOT NULL,
+    created_at INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL,
+    used_at    INTEGER
+);
+
+CREATE INDEX password_resets_user_idx ON password_resets (user_id);
@@ -0,0 +1,11 @@
+-- Token buckets of the rate limiter, shared by the servers using this
+-- database. Times are in Unix microseconds; buckets are dropped once full.
+CREATE TABLE rate_buckets (
+    key     TEXT PRIMARY KEY,
+    tokens  REAL NOT NULL,
+    updated INTEGER NOT NULL,
+    full_at INTEGER NOT NULL,
+    allowed INTEGER NOT NULL
+);
+
+CREATE INDEX rate_buckets_full_at_idx ON rate_buckets (full_at);
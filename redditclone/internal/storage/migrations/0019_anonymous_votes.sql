@@ -0,0 +1,26 @@
+-- The votes a deleted user leaves behind are kept under an empty user ID, so
+-- a post or comment may hold several of them: only the votes of existing
+-- users are unique. SQLite cannot drop a primary key, so the tables are
+-- rebuilt, keeping the order votes were cast in.
+CREATE TABLE votes_new (
+    post_id TEXT NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+    user_id TEXT NOT NULL,
+    vote    INTEGER NOT NULL
+);
+INSERT INTO votes_new (post_id, user_id, vote) SELECT post_id, user_id, vote FROM votes ORDER BY rowid;
+DROP TABLE votes;
+ALTER TABLE votes_new RENAME TO votes;
+CREATE UNIQUE INDEX votes_post_id_user_id_idx ON votes (post_id, user_id) WHERE user_id != '';
+
+CREATE TABLE comment_votes_new (
+    comment_id TEXT NOT NULL REFERENCES comments (id) ON DELETE CASCADE,
+    post_id    TEXT NOT NULL,
+    user_id    TEXT NOT NULL,
+    vote       INTEGER NOT NULL
+);
+INSERT INTO comment_votes_new (comment_id, post_id, user_id, vote)
+SELECT comment_id, post_id, user_id, vote FROM comment_votes ORDER BY rowid;
+DROP TABLE comment_votes;
+ALTER TABLE comment_votes_new RENAME TO comment_votes;
+CREATE UNIQUE INDEX comment_votes_comment_id_user_id_idx ON comment_votes (comment_id, user_id) WHERE user_id != '';
+CREATE INDEX comment_votes_post_id_idx ON comment_votes (post_id);
@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite" // registers the "sqlite" driver
+)
+
+//go:embed migrations/*.sql
+var migrationFS embed.FS
+
+type migration struct {
+	version int
+	name    string
+	query   string
+}
+
+// sqliteParams make SQLite enforce foreign keys, so that ON DELETE CASCADE
+// works, and store time.Time values as Unix microseconds, which sort and
+// compare as numbers. TIMESTAMP columns are read back as time.Time.
+var sqliteParams = []string{
+	"_pragma=foreign_keys(1)",
+	"_time_integer_format=unix_micro",
+	"_inttotime=1",
+}
+
+// Open connects to the database and applies all pending migrations.
+func Open(driver, dsn string) (*sql.DB, error) {
+	if driver == "sqlite" {
+		sep := "?"
+		if strings.Contains(dsn, "?") {
+			sep = "&"
+		}
+		dsn += sep + strings.Join(sqliteParams, "&")
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if driver == "sqlite" {
+		// SQLite allows a single writer; serialize access instead of failing with SQLITE_BUSY.
+		db.SetMaxOpenConns(1)
+	}
+	if err = db.Ping(); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	if err = Migrate(db); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Migrate applies every migration newer than the recorded schema version.
+// Each migration runs in its own transaction.
+func Migrate(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name    TEXT NOT NULL,
+		applied TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+
+	var current int
+	err = db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current)
+	if err != nil {
+		return fmt.Errorf("read schema version: %w", err)
+	}
+
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err = apply(db, m); err != nil {
+			return fmt.Errorf("migration %s: %w", m.name, err)
+		}
+	}
+	return nil
+}
+
+func apply(db *sql.DB, m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err = tx.Exec(m.query); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO schema_migrations (version, name, applied) VALUES (?, ?, ?)`,
+		m.version, m.name, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// loadMigrations reads migrations/NNNN_name.sql files ordered by version.
+func loadMigrations() ([]migration, error) {
+	entries, err := fs.ReadDir(migrationFS, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]migration, 0, len(entries))
+	seen := make(map[int]string)
+	for _, e := range entries {
+		name := e.Name()
+		prefix, _, ok := strings.Cut(name, "_")
+		if !ok || !strings.HasSuffix(name, ".sql") {
+			return nil, fmt.Errorf("bad migration file name %q", name)
+		}
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("bad migration version in %q", name)
+		}
+		if prev, dup := seen[version]; dup {
+			return nil, fmt.Errorf("duplicate migration version %d: %q and %q", version, prev, name)
+		}
+		seen[version] = name
+
+		query, err := fs.ReadFile(migrationFS, "migrations/"+name)
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration{version: version, name: name, query: string(query)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+	return migrations, nil
+}
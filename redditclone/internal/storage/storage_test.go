@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOpenAppliesMigrationsOnce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	for range 2 {
+		db, err := Open("sqlite", path)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		migrations, err := loadMigrations()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var n, last int
+		if err = db.QueryRow(`SELECT COUNT(*), MAX(version) FROM schema_migrations`).Scan(&n, &last); err != nil {
+			t.Fatal(err)
+		}
+		if want := migrations[len(migrations)-1].version; n != len(migrations) || last != want {
+			t.Errorf("schema_migrations has %d rows up to %d, want %d up to %d", n, last, len(migrations), want)
+		}
+		db.Close()
+	}
+}
+
+func TestOpenEnforcesForeignKeys(t *testing.T) {
+	db := open(t)
+	insertPost(t, db, "p1", time.Now())
+	if _, err := db.Exec(`INSERT INTO votes (post_id, user_id, vote) VALUES ('missing', 'u1', 1)`); err == nil {
+		t.Error("vote on a missing post was inserted")
+	}
+	if _, err := db.Exec(`INSERT INTO votes (post_id, user_id, vote) VALUES ('p1', 'u1', 1)`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`DELETE FROM posts WHERE id = 'p1'`); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM votes`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("%d votes left after their post was deleted, want 0", n)
+	}
+}
+
+func TestOpenStoresTimesAsMicros(t *testing.T) {
+	db := open(t)
+	created := time.Date(2024, 3, 1, 12, 30, 45, 123456789, time.FixedZone("X", -7*3600))
+	insertPost(t, db, "p1", created)
+
+	var typ string
+	var micros int64
+	if err := db.QueryRow(`SELECT typeof(created), created + 0 FROM posts`).Scan(&typ, &micros); err != nil {
+		t.Fatal(err)
+	}
+	if typ != "integer" || micros != created.UnixMicro() {
+		t.Errorf("created stored as %s %d, want integer %d", typ, micros, created.UnixMicro())
+	}
+
+	var got time.Time
+	if err := db.QueryRow(`SELECT created FROM posts`).Scan(&got); err != nil {
+		t.Fatal(err)
+	}
+	if want := created.Truncate(time.Microsecond); !got.Equal(want) {
+		t.Errorf("created read back as %v, want %v", got, want)
+	}
+}
+
+func open(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := Open("sqlite", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func insertPost(t *testing.T, db *sql.DB, id string, created time.Time) {
+	t.Helper()
+	_, err := db.Exec(`INSERT INTO posts (id, title, author_id, author_username, category, created, type)
+		VALUES (?, 'title', 'u1', 'alice', 'music', ?, 'text')`, id, created)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,358 @@
+package token
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var ErrInvalid = errors.New("invalid token")
+
+// Supported signing algorithms.
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	EdDSA = "EdDSA"
+)
+
+const (
+	// MinSecretLength is the shortest HS256 secret accepted, in bytes.
+	MinSecretLength = 32
+	// MinRSABits is the smallest RSA modulus accepted.
+	MinRSABits = 2048
+	// leeway absorbs clock skew between servers when checking exp and nbf.
+	leeway = 30 * time.Second
+)
+
+// Config says how tokens are signed and checked. It is usually read from a
+// JSON file with LoadConfig.
+type Config struct {
+	Issuer   string `json:"issuer"`
+	Audience string `json:"audience"`
+	// SigningKey is the kid of the key new tokens are signed with. The other
+	// keys only verify tokens, such as those signed before a rotation.
+	SigningKey string      `json:"signing_key"`
+	Keys       []KeyConfig `json:"keys"`
+	// TTL is how long issued tokens are valid.
+	TTL time.Duration `json:"-"`
+}
+
+// KeyConfig is one key of the config. HS256 keys take a secret, given
+// inline or, better, through an environment variable. RS256 and EdDSA keys
+// take a PEM file: a private key for the signing key, and a private or a
+// public key for keys that only verify.
+type KeyConfig struct {
+	ID        string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Secret    string `json:"secret,omitempty"`
+	SecretEnv string `json:"secret_env,omitempty"`
+	File      string `json:"file,omitempty"`
+}
+
+// DefaultIssuer and DefaultAudience fill in a config that names neither.
+const (
+	DefaultIssuer   = "redditclone"
+	DefaultAudience = "redditclone-api"
+)
+
+// SecretEnv is the environment variable DefaultConfig reads its secret from.
+const SecretEnv = "JWT_SECRET"
+
+// LoadConfig reads a JSON config. Key files are relative to the config's
+// directory.
+func LoadConfig(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	var cfg Config
+	if err = json.Unmarshal(data, &cfg); err != nil {
+		return Config{}, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for i, k := range cfg.Keys {
+		if k.File != "" && !filepath.IsAbs(k.File) {
+			cfg.Keys[i].File = filepath.Join(filepath.Dir(path), k.File)
+		}
+	}
+	return cfg, nil
+}
+
+// DefaultConfig signs with a single HS256 key taken from the JWT_SECRET
+// environment variable. Without it the secret is random, so tokens only
+// last until the server restarts.
+func DefaultConfig() Config {
+	key := KeyConfig{ID: "default", Algorithm: HS256, SecretEnv: SecretEnv}
+	if os.Getenv(SecretEnv) == "" {
+		secret := make([]byte, MinSecretLength)
+		_, _ = rand.Read(secret)
+		key.SecretEnv, key.Secret = "", base64.RawStdEncoding.EncodeToString(secret)
+	}
+	return Config{SigningKey: key.ID, Keys: []KeyConfig{key}}
+}
+
+// key is a loaded key. sign is nil for keys that only verify.
+type key struct {
+	id        string
+	algorithm string
+	method    jwt.SigningMethod
+	sign      any
+	verify    any
+}
+
+// Service issues and checks access tokens. It is safe for concurrent use.
+type Service struct {
+	issuer     string
+	audience   string
+	ttl        time.Duration
+	signing    *key
+	keys       map[string]*key
+	algorithms []string
+}
+
+// New loads the keys of the config.
+func New(cfg Config) (*Service, error) {
+	s := &Service{
+		issuer:   cfg.Issuer,
+		audience: cfg.Audience,
+		ttl:      cfg.TTL,
+		keys:     make(map[string]*key),
+	}
+	if s.issuer == "" {
+		s.issuer = DefaultIssuer
+	}
+	if s.audience == "" {
+		s.audience = DefaultAudience
+	}
+	if s.ttl <= 0 {
+		return nil, errors.New("token lifetime must be positive")
+	}
+
+	for _, kc := range cfg.Keys {
+		if kc.ID == "" {
+			return nil, errors.New("key without kid")
+		}
+		if _, ok := s.keys[kc.ID]; ok {
+			return nil, fmt.Errorf("duplicate kid %q", kc.ID)
+		}
+		k, err := loadKey(kc)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", kc.ID, err)
+		}
+		s.keys[k.id] = k
+		if !slices.Contains(s.algorithms, k.algorithm) {
+			s.algorithms = append(s.algorithms, k.algorithm)
+		}
+	}
+
+	s.signing = s.keys[cfg.SigningKey]
+	if s.signing == nil {
+		return nil, fmt.Errorf("signing key %q not configured", cfg.SigningKey)
+	}
+	if s.signing.sign == nil {
+		return nil, fmt.Errorf("signing key %q has no private key", cfg.SigningKey)
+	}
+	return s, nil
+}
+
+func loadKey(kc KeyConfig) (*key, error) {
+	k := &key{id: kc.ID, algorithm: kc.Algorithm}
+	switch kc.Algorithm {
+	case HS256:
+		secret := kc.Secret
+		if kc.SecretEnv != "" {
+			secret = os.Getenv(kc.SecretEnv)
+		}
+		if len(secret) < MinSecretLength {
+			return nil, fmt.Errorf("secret must be at least %d bytes", MinSecretLength)
+		}
+		k.method, k.sign, k.verify = jwt.SigningMethodHS256, []byte(secret), []byte(secret)
+		return k, nil
+	case RS256, EdDSA:
+		if kc.File == "" {
+			return nil, errors.New("key file required")
+		}
+		data, err := os.ReadFile(kc.File)
+		if err != nil {
+			return nil, err
+		}
+		if err = k.parsePEM(data); err != nil {
+			return nil, err
+		}
+		return k, nil
+	}
+	return nil, fmt.Errorf("unsupported algorithm %q", kc.Algorithm)
+}
+
+// parsePEM fills in the key from a PKCS#8 or PKCS#1 private key or a PKIX
+// public key, checking that it suits the key's algorithm.
+func (k *key) parsePEM(data []byte) error {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return errors.New("no PEM data")
+	}
+
+	var parsed any
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return err
+	}
+
+	switch pk := parsed.(type) {
+	case *rsa.PrivateKey:
+		k.sign, k.verify = pk, &pk.PublicKey
+	case *rsa.PublicKey:
+		k.verify = pk
+	case ed25519.PrivateKey:
+		k.sign, k.verify = pk, pk.Public()
+	case ed25519.PublicKey:
+		k.verify = pk
+	default:
+		return fmt.Errorf("unsupported key type %T", parsed)
+	}
+
+	switch pub := k.verify.(type) {
+	case *rsa.PublicKey:
+		if k.algorithm != RS256 {
+			return fmt.Errorf("RSA key cannot be used for %s", k.algorithm)
+		}
+		if pub.N.BitLen() < MinRSABits {
+			return fmt.Errorf("RSA key must be at least %d bits", MinRSABits)
+		}
+		k.method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		if k.algorithm != EdDSA {
+			return fmt.Errorf("Ed25519 key cannot be used for %s", k.algorithm)
+		}
+		k.method = jwt.SigningMethodEdDSA
+	}
+	return nil
+}
+
+// TTL is how long issued tokens are valid.
+func (s *Service) TTL() time.Duration {
+	return s.ttl
+}
+
+// Sign issues a token carrying claims with the signing key. It sets the
+// registered claims iss, aud, iat, nbf and exp itself.
+func (s *Service) Sign(claims jwt.MapClaims) (string, error) {
+	now := time.Now()
+	all := jwt.MapClaims{}
+	for name, v := range claims {
+		all[name] = v
+	}
+	all["iss"] = s.issuer
+	all["aud"] = s.audience
+	all["iat"] = now.Unix()
+	all["nbf"] = now.Unix()
+	all["exp"] = now.Add(s.ttl).Unix()
+
+	t := jwt.NewWithClaims(s.signing.method, all)
+	t.Header["kid"] = s.signing.id
+	return t.SignedString(s.signing.sign)
+}
+
+// Parse verifies a token and returns its claims. The token must name one of
+// the configured keys in its kid header and be signed with that key's
+// algorithm, whatever else its header claims; it must also come from the
+// configured issuer for the configured audience and be within nbf and exp.
+func (s *Service) Parse(tokenString string) (jwt.MapClaims, error) {
+	parser := jwt.NewParser(
+		jwt.WithValidMethods(s.algorithms),
+		jwt.WithIssuer(s.issuer),
+		jwt.WithAudience(s.audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(leeway),
+	)
+	claims := jwt.MapClaims{}
+	_, err := parser.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		k, ok := s.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown kid %q", kid)
+		}
+		if t.Method.Alg() != k.algorithm {
+			return nil, fmt.Errorf("key %q does not sign with %s", kid, t.Method.Alg())
+		}
+		return k.verify, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+	// The parser checks nbf only when present; tokens issued here always
+	// carry it.
+	if nbf, err := claims.GetNotBefore(); err != nil || nbf == nil {
+		return nil, fmt.Errorf("%w: missing nbf", ErrInvalid)
+	}
+	return claims, nil
+}
+
+// JWK is a public key in JSON Web Key form.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	ID        string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	// RSA keys
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519 keys
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+}
+
+// JWKSet is a JSON Web Key Set.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys tokens may be verified with, so other
+// services can check them without sharing a secret. HS256 keys are secret
+// and never listed.
+func (s *Service) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	for _, k := range s.keys {
+		jwk := JWK{ID: k.id, Algorithm: k.algorithm, Use: "sig"}
+		switch pub := k.verify.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType, jwk.Curve = "OKP", "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	slices.SortFunc(set.Keys, func(a, b JWK) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+	return set
+}
@@ -0,0 +1,259 @@
+package token
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var testSecret = strings.Repeat("s", MinSecretLength)
+
+// writeKey writes the Ed25519 key to a PEM file in dir, as a private key or
+// only its public half, and returns the path.
+func writeKey(t *testing.T, dir, name string, priv ed25519.PrivateKey, public bool) string {
+	t.Helper()
+	typ := "PRIVATE KEY"
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if public {
+		typ = "PUBLIC KEY"
+		der, err = x509.MarshalPKIXPublicKey(priv.Public())
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name+".pem")
+	if err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func newKey(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv
+}
+
+func newService(t *testing.T, cfg Config) *Service {
+	t.Helper()
+	cfg.TTL = time.Hour
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// claims are valid registered claims for the default issuer and audience,
+// changed by edit.
+func claims(edit func(c jwt.MapClaims)) jwt.MapClaims {
+	now := time.Now()
+	c := jwt.MapClaims{
+		"sub": "u1",
+		"iss": DefaultIssuer,
+		"aud": DefaultAudience,
+		"iat": now.Unix(),
+		"nbf": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	}
+	if edit != nil {
+		edit(c)
+	}
+	return c
+}
+
+// sign signs the claims with the method and key, naming kid in the header
+// unless it is empty.
+func sign(t *testing.T, method jwt.SigningMethod, signKey any, kid string, c jwt.MapClaims) string {
+	t.Helper()
+	tok := jwt.NewWithClaims(method, c)
+	if kid != "" {
+		tok.Header["kid"] = kid
+	}
+	s, err := tok.SignedString(signKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestParse(t *testing.T) {
+	dir := t.TempDir()
+	ed, retired := newKey(t), newKey(t)
+	s := newService(t, Config{
+		SigningKey: "ed",
+		Keys: []KeyConfig{
+			{ID: "ed", Algorithm: EdDSA, File: writeKey(t, dir, "ed", ed, false)},
+			{ID: "hs", Algorithm: HS256, Secret: testSecret},
+		},
+	})
+	issued, err := s.Sign(jwt.MapClaims{"sub": "u1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The public key as the HMAC secret: the classic algorithm confusion.
+	edPublic, err := x509.MarshalPKIXPublicKey(ed.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: edPublic})
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"issued", issued, true},
+		{"EdDSA key", sign(t, jwt.SigningMethodEdDSA, ed, "ed", claims(nil)), true},
+		{"HS256 key", sign(t, jwt.SigningMethodHS256, []byte(testSecret), "hs", claims(nil)), true},
+		{"expired within the leeway", sign(t, jwt.SigningMethodEdDSA, ed, "ed", claims(func(c jwt.MapClaims) {
+			c["exp"] = time.Now().Add(-10 * time.Second).Unix()
+		})), true},
+
+		{"HS256 under the EdDSA kid", sign(t, jwt.SigningMethodHS256, edPEM, "ed", claims(nil)), false},
+		{"EdDSA under the HS256 kid", sign(t, jwt.SigningMethodEdDSA, ed, "hs", claims(nil)), false},
+		{"alg none", sign(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "ed", claims(nil)), false},
+		{"no kid", sign(t, jwt.SigningMethodEdDSA, ed, "", claims(nil)), false},
+		{"unknown kid", sign(t, jwt.SigningMethodEdDSA, ed, "other", claims(nil)), false},
+		{"retired key", sign(t, jwt.SigningMethodEdDSA, retired, "ed", claims(nil)), false},
+		{"wrong secret", sign(t, jwt.SigningMethodHS256, []byte(strings.Repeat("x", MinSecretLength)), "hs", claims(nil)), false},
+		{"wrong audience", sign(t, jwt.SigningMethodEdDSA, ed, "ed", claims(func(c jwt.MapClaims) {
+			c["aud"] = "other-api"
+		})), false},
+		{"wrong issuer", sign(t, jwt.SigningMethodEdDSA, ed, "ed", claims(func(c jwt.MapClaims) {
+			c["iss"] = "other"
+		})), false},
+		{"expired", sign(t, jwt.SigningMethodEdDSA, ed, "ed", claims(func(c jwt.MapClaims) {
+			c["exp"] = time.Now().Add(-time.Minute).Unix()
+		})), false},
+		{"no exp", sign(t, jwt.SigningMethodEdDSA, ed, "ed", claims(func(c jwt.MapClaims) {
+			delete(c, "exp")
+		})), false},
+		{"nbf in the future", sign(t, jwt.SigningMethodEdDSA, ed, "ed", claims(func(c jwt.MapClaims) {
+			c["nbf"] = time.Now().Add(time.Minute).Unix()
+		})), false},
+		{"no nbf", sign(t, jwt.SigningMethodEdDSA, ed, "ed", claims(func(c jwt.MapClaims) {
+			delete(c, "nbf")
+		})), false},
+		{"issued in the future", sign(t, jwt.SigningMethodEdDSA, ed, "ed", claims(func(c jwt.MapClaims) {
+			c["iat"] = time.Now().Add(time.Minute).Unix()
+		})), false},
+		{"tampered", issued[:len(issued)-4] + "AAAA", false},
+	}
+	for _, tt := range tests {
+		got, err := s.Parse(tt.token)
+		if tt.ok && (err != nil || got["sub"] != "u1") {
+			t.Errorf("%s: Parse = %v, %v, want the claims", tt.name, got, err)
+		}
+		if !tt.ok && !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: error = %v, want ErrInvalid", tt.name, err)
+		}
+	}
+}
+
+func TestParseAfterRotation(t *testing.T) {
+	dir := t.TempDir()
+	oldPriv, newPriv := newKey(t), newKey(t)
+	before := newService(t, Config{
+		SigningKey: "old",
+		Keys:       []KeyConfig{{ID: "old", Algorithm: EdDSA, File: writeKey(t, dir, "old", oldPriv, false)}},
+	})
+	oldToken, err := before.Sign(jwt.MapClaims{"sub": "u1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKeyConfig := KeyConfig{ID: "new", Algorithm: EdDSA, File: writeKey(t, dir, "new", newPriv, false)}
+
+	// While the old key still verifies, tokens signed before the rotation
+	// keep working and new ones are signed with the new key.
+	rotated := newService(t, Config{
+		SigningKey: "new",
+		Keys: []KeyConfig{newKeyConfig,
+			{ID: "old", Algorithm: EdDSA, File: writeKey(t, dir, "old-public", oldPriv, true)}},
+	})
+	if _, err = rotated.Parse(oldToken); err != nil {
+		t.Errorf("token of the old key after the rotation: %v", err)
+	}
+	newToken, err := rotated.Sign(jwt.MapClaims{"sub": "u1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = before.Parse(newToken); !errors.Is(err, ErrInvalid) {
+		t.Errorf("token of the new key before the rotation: error = %v, want ErrInvalid", err)
+	}
+
+	// Once the old key is retired, its tokens stop working.
+	retired := newService(t, Config{SigningKey: "new", Keys: []KeyConfig{newKeyConfig}})
+	if _, err = retired.Parse(oldToken); !errors.Is(err, ErrInvalid) {
+		t.Errorf("token of the retired key: error = %v, want ErrInvalid", err)
+	}
+	if _, err = retired.Parse(newToken); err != nil {
+		t.Errorf("token of the new key: %v", err)
+	}
+}
+
+func TestNewRejectsBadKeys(t *testing.T) {
+	dir := t.TempDir()
+	edFile := writeKey(t, dir, "ed", newKey(t), false)
+	publicFile := writeKey(t, dir, "public", newKey(t), true)
+
+	tests := []struct {
+		name string
+		cfg  Config
+	}{
+		{"short secret", Config{SigningKey: "hs",
+			Keys: []KeyConfig{{ID: "hs", Algorithm: HS256, Secret: "short"}}}},
+		{"no kid", Config{SigningKey: "",
+			Keys: []KeyConfig{{Algorithm: HS256, Secret: testSecret}}}},
+		{"duplicate kid", Config{SigningKey: "hs",
+			Keys: []KeyConfig{{ID: "hs", Algorithm: HS256, Secret: testSecret}, {ID: "hs", Algorithm: HS256, Secret: testSecret}}}},
+		{"unknown algorithm", Config{SigningKey: "k",
+			Keys: []KeyConfig{{ID: "k", Algorithm: "HS512", Secret: testSecret}}}},
+		{"Ed25519 key for RS256", Config{SigningKey: "k",
+			Keys: []KeyConfig{{ID: "k", Algorithm: RS256, File: edFile}}}},
+		{"missing signing key", Config{SigningKey: "other",
+			Keys: []KeyConfig{{ID: "hs", Algorithm: HS256, Secret: testSecret}}}},
+		{"public signing key", Config{SigningKey: "ed",
+			Keys: []KeyConfig{{ID: "ed", Algorithm: EdDSA, File: publicFile}}}},
+	}
+	for _, tt := range tests {
+		tt.cfg.TTL = time.Hour
+		if _, err := New(tt.cfg); err == nil {
+			t.Errorf("%s: New succeeded", tt.name)
+		}
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	dir := t.TempDir()
+	b, a := newKey(t), newKey(t)
+	s := newService(t, Config{
+		SigningKey: "b",
+		Keys: []KeyConfig{
+			{ID: "b", Algorithm: EdDSA, File: writeKey(t, dir, "b", b, false)},
+			{ID: "hs", Algorithm: HS256, Secret: testSecret},
+			{ID: "a", Algorithm: EdDSA, File: writeKey(t, dir, "a", a, true)},
+		},
+	})
+	set := s.JWKS()
+	if len(set.Keys) != 2 || set.Keys[0].ID != "a" || set.Keys[1].ID != "b" {
+		t.Fatalf("JWKS = %+v, want the EdDSA keys a and b and no secret", set.Keys)
+	}
+	for _, k := range set.Keys {
+		if k.KeyType != "OKP" || k.Curve != "Ed25519" || k.Algorithm != EdDSA || k.Use != "sig" || k.X == "" {
+			t.Errorf("JWK %+v does not describe an Ed25519 signing key", k)
+		}
+	}
+}
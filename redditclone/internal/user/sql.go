@@ -0,0 +1,126 @@
+package user
+
+import (
+	"database/sql"
+	"errors"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// SQLRepo stores users in the users table created by the storage package migrations.
+type SQLRepo struct {
+	db *sql.DB
+}
+
+func NewSQLRepo(db *sql.DB) *SQLRepo {
+	return &SQLRepo{db: db}
+}
+
+func (r *SQLRepo) Register(username, password string) (*User, error) {
+	var exists bool
+	err := r.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE username = ?)`, username).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, ErrExists
+	}
+
+	rec, err := NewRecord(username, password)
+	if err != nil {
+		return nil, err
+	}
+	u := fromRecord(rec)
+	// The UNIQUE constraint on username still guards against a concurrent registration.
+	_, err = r.db.Exec(`INSERT INTO users (id, username, password_hash, registered) VALUES (?, ?, ?, ?)`,
+		u.ID, u.Username, u.password, u.Registered)
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func (r *SQLRepo) Authorize(username, password string) (*User, error) {
+	u, err := r.GetByUsername(username)
+	if err != nil {
+		return nil, err
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(u.password), []byte(password))
+	if err != nil {
+		return nil, errors.New("invalid password")
+	}
+
+	return u, nil
+}
+
+func (r *SQLRepo) GetByUsername(username string) (*User, error) {
+	u := &User{}
+	var registered sql.NullTime
+	err := r.db.QueryRow(`SELECT id, username, password_hash, registered, admin, email FROM users WHERE username = ?`, username).
+		Scan(&u.ID, &u.Username, &u.password, &registered, &u.Admin, &u.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	u.Registered = registered.Time
+	return u, nil
+}
+
+func (r *SQLRepo) SetAdmin(username string, admin bool) (*User, error) {
+	res, err := r.db.Exec(`UPDATE users SET admin = ? WHERE username = ?`, admin, username)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, ErrNotFound
+	}
+	return r.GetByUsername(username)
+}
+
+func (r *SQLRepo) SetPassword(username, password string) (*User, error) {
+	hash, err := HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	return r.update(username, `password_hash = ?`, hash)
+}
+
+func (r *SQLRepo) SetEmail(username, email string) (*User, error) {
+	email, err := NormalizeEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	return r.update(username, `email = ?`, email)
+}
+
+func (r *SQLRepo) Delete(username string) error {
+	res, err := r.db.Exec(`DELETE FROM users WHERE username = ?`, username)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// update sets a column of a user and returns the updated user.
+func (r *SQLRepo) update(username, set string, value any) (*User, error) {
+	res, err := r.db.Exec(`UPDATE users SET `+set+` WHERE username = ?`, value, username)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, ErrNotFound
+	}
+	return r.GetByUsername(username)
+}
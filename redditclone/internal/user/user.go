@@ -2,21 +2,91 @@ package user
 
 import (
 	"errors"
+	"net/mail"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 )
 
+var (
+	ErrNotFound = errors.New("user not found")
+	ErrExists   = errors.New("user already exists")
+	ErrBadEmail = errors.New("invalid email")
+)
+
+// MinPasswordLength is the shortest password a user may change to.
+const MinPasswordLength = 8
+
 type User struct {
-	ID       string
-	Username string
-	password string
+	ID         string
+	Username   string
+	Registered time.Time // zero for users registered before it was recorded
+	Admin      bool      // site-wide admin
+	Email      string    // where password resets are sent; empty if none
+	password   string
+}
+
+// Record is a user together with its password hash. It is used to persist
+// and restore users without ever handling the plain-text password.
+type Record struct {
+	ID           string    `json:"id"`
+	Username     string    `json:"username"`
+	PasswordHash string    `json:"password_hash"`
+	Registered   time.Time `json:"registered,omitzero"`
+	Admin        bool      `json:"admin,omitempty"`
+	Email        string    `json:"email,omitempty"`
+}
+
+func (u *User) Record() Record {
+	return Record{ID: u.ID, Username: u.Username, PasswordHash: u.password, Registered: u.Registered, Admin: u.Admin, Email: u.Email}
+}
+
+// NewRecord returns the record of a new user with a fresh ID and the bcrypt
+// hash of password. Hashing is slow, so callers do it before taking locks.
+func NewRecord(username, password string) (Record, error) {
+	hash, err := HashPassword(password)
+	if err != nil {
+		return Record{}, err
+	}
+	return Record{ID: uuid.NewString(), Username: username, PasswordHash: hash, Registered: time.Now()}, nil
+}
+
+// HashPassword returns the bcrypt hash stored for password.
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// NormalizeEmail checks a bare address such as alice@example.com and
+// returns it trimmed. An empty address stays empty.
+func NormalizeEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return "", nil
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Name != "" || addr.Address != email {
+		return "", ErrBadEmail
+	}
+	return addr.Address, nil
 }
 
 type Repo interface {
 	Register(username, password string) (*User, error)
 	Authorize(username, password string) (*User, error)
+	GetByUsername(username string) (*User, error)
+	// SetAdmin grants or revokes the site-wide admin role.
+	SetAdmin(username string, admin bool) (*User, error)
+	// SetPassword replaces the password of a user.
+	SetPassword(username, password string) (*User, error)
+	// SetEmail sets the address password resets are sent to; an empty
+	// address removes it.
+	SetEmail(username, email string) (*User, error)
+	// Delete removes a user; the username can then be registered again.
+	Delete(username string) error
 }
 
 type MemoryRepo struct {
@@ -31,40 +101,150 @@ func NewMemoryRepo() *MemoryRepo {
 }
 
 func (r *MemoryRepo) Register(username, password string) (*User, error) {
+	if _, err := r.GetByUsername(username); err == nil {
+		return nil, ErrExists
+	}
+	rec, err := NewRecord(username, password)
+	if err != nil {
+		return nil, err
+	}
+	return r.Insert(rec)
+}
+
+// Insert adds a new user from its record, failing if the username is taken.
+func (r *MemoryRepo) Insert(rec Record) (*User, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	if _, ok := r.users[username]; ok {
-		return nil, errors.New("user already exists")
+	if _, ok := r.users[rec.Username]; ok {
+		return nil, ErrExists
 	}
+	u := fromRecord(rec)
+	r.users[rec.Username] = u
+	c := *u
+	return &c, nil
+}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+// Authorize checks the password against a copy of the user, so that the
+// slow comparison runs outside the lock.
+func (r *MemoryRepo) Authorize(username, password string) (*User, error) {
+	u, err := r.GetByUsername(username)
 	if err != nil {
 		return nil, err
 	}
 
-	u := &User{
-		ID:       uuid.NewString(),
-		Username: username,
-		password: string(hashedPassword),
+	err = bcrypt.CompareHashAndPassword([]byte(u.password), []byte(password))
+	if err != nil {
+		return nil, errors.New("invalid password")
 	}
-	r.users[username] = u
+
 	return u, nil
 }
 
-func (r *MemoryRepo) Authorize(username, password string) (*User, error) {
+func (r *MemoryRepo) GetByUsername(username string) (*User, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
 	u, ok := r.users[username]
 	if !ok {
-		return nil, errors.New("user not found")
+		return nil, ErrNotFound
+	}
+	c := *u
+	return &c, nil
+}
+
+func (r *MemoryRepo) SetAdmin(username string, admin bool) (*User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.users[username]
+	if !ok {
+		return nil, ErrNotFound
 	}
+	u.Admin = admin
+	c := *u
+	return &c, nil
+}
 
-	err := bcrypt.CompareHashAndPassword([]byte(u.password), []byte(password))
+func (r *MemoryRepo) SetPassword(username, password string) (*User, error) {
+	hash, err := HashPassword(password)
 	if err != nil {
-		return nil, errors.New("invalid password")
+		return nil, err
 	}
+	return r.SetPasswordHash(username, hash)
+}
 
-	return u, nil
+// SetPasswordHash replaces the password of a user with one hashed by
+// HashPassword.
+func (r *MemoryRepo) SetPasswordHash(username, hash string) (*User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.users[username]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	u.password = hash
+	c := *u
+	return &c, nil
+}
+
+func (r *MemoryRepo) SetEmail(username, email string) (*User, error) {
+	email, err := NormalizeEmail(email)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.users[username]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	u.Email = email
+	c := *u
+	return &c, nil
+}
+
+func (r *MemoryRepo) Delete(username string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.users[username]; !ok {
+		return ErrNotFound
+	}
+	delete(r.users, username)
+	return nil
+}
+
+// Records returns every stored user.
+func (r *MemoryRepo) Records() []Record {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	records := make([]Record, 0, len(r.users))
+	for _, u := range r.users {
+		records = append(records, u.Record())
+	}
+	return records
+}
+
+// Load inserts a previously registered user, keeping its ID and password hash.
+func (r *MemoryRepo) Load(rec Record) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.users[rec.Username] = fromRecord(rec)
+}
+
+func fromRecord(rec Record) *User {
+	return &User{
+		ID:         rec.ID,
+		Username:   rec.Username,
+		Registered: rec.Registered,
+		Admin:      rec.Admin,
+		Email:      rec.Email,
+		password:   rec.PasswordHash,
+	}
 }
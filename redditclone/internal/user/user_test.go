@@ -0,0 +1,146 @@
+package user
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"redditclone/internal/storage"
+)
+
+// forEachRepo runs test against an empty repo of each implementation.
+func forEachRepo(t *testing.T, test func(t *testing.T, r Repo)) {
+	t.Helper()
+	repos := []struct {
+		name string
+		new  func(t *testing.T) Repo
+	}{
+		{"memory", func(t *testing.T) Repo { return NewMemoryRepo() }},
+		{"sql", func(t *testing.T) Repo {
+			db, err := storage.Open("sqlite", filepath.Join(t.TempDir(), "test.db"))
+			if err != nil {
+				t.Fatalf("open database: %v", err)
+			}
+			t.Cleanup(func() { db.Close() })
+			return NewSQLRepo(db)
+		}},
+	}
+	for _, tt := range repos {
+		t.Run(tt.name, func(t *testing.T) {
+			test(t, tt.new(t))
+		})
+	}
+}
+
+func TestRepoRegister(t *testing.T) {
+	forEachRepo(t, func(t *testing.T, r Repo) {
+		before := time.Now().Truncate(time.Microsecond)
+		u, err := r.Register("alice", "password1")
+		if err != nil {
+			t.Fatalf("Register: %v", err)
+		}
+		if _, err = r.Register("alice", "password2"); err == nil {
+			t.Error("registered alice twice")
+		}
+
+		got, err := r.GetByUsername("alice")
+		if err != nil {
+			t.Fatalf("GetByUsername: %v", err)
+		}
+		if got.ID != u.ID || got.Username != "alice" || got.Admin || got.Email != "" {
+			t.Errorf("GetByUsername = %+v, want %+v", got, u)
+		}
+		if got.Registered.Before(before) || got.Registered.After(time.Now()) {
+			t.Errorf("registered at %v, want between %v and now", got.Registered, before)
+		}
+
+		tests := []struct {
+			name, username, password string
+			ok                       bool
+		}{
+			{"right password", "alice", "password1", true},
+			{"wrong password", "alice", "password2", false},
+			{"unknown user", "bob", "password1", false},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				_, err := r.Authorize(tt.username, tt.password)
+				if ok := err == nil; ok != tt.ok {
+					t.Errorf("Authorize(%s, %s) error = %v, want ok %v", tt.username, tt.password, err, tt.ok)
+				}
+			})
+		}
+	})
+}
+
+func TestRepoUpdate(t *testing.T) {
+	forEachRepo(t, func(t *testing.T, r Repo) {
+		if _, err := r.Register("alice", "password1"); err != nil {
+			t.Fatal(err)
+		}
+		u, err := r.SetAdmin("alice", true)
+		if err != nil || !u.Admin {
+			t.Errorf("SetAdmin = %+v, %v, want an admin", u, err)
+		}
+		u, err = r.SetEmail("alice", " alice@example.com ")
+		if err != nil || u.Email != "alice@example.com" {
+			t.Errorf("SetEmail = %+v, %v, want alice@example.com", u, err)
+		}
+		if _, err = r.SetEmail("alice", "Alice <alice@example.com>"); err != ErrBadEmail {
+			t.Errorf("SetEmail with a name error = %v, want ErrBadEmail", err)
+		}
+		if _, err = r.SetPassword("alice", "password2"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = r.Authorize("alice", "password2"); err != nil {
+			t.Errorf("Authorize with the new password: %v", err)
+		}
+		if _, err = r.SetAdmin("bob", true); err != ErrNotFound {
+			t.Errorf("SetAdmin(bob) error = %v, want ErrNotFound", err)
+		}
+
+		if err = r.Delete("alice"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = r.GetByUsername("alice"); err != ErrNotFound {
+			t.Errorf("GetByUsername after Delete error = %v, want ErrNotFound", err)
+		}
+		if err = r.Delete("alice"); err != ErrNotFound {
+			t.Errorf("second Delete error = %v, want ErrNotFound", err)
+		}
+		if _, err = r.Register("alice", "password3"); err != nil {
+			t.Errorf("Register after Delete: %v", err)
+		}
+	})
+}
+
+// TestRepoAuthorizeReturnsCopy checks that the user Authorize returns is
+// the caller's to change; run with -race, as the repo changes it meanwhile.
+func TestRepoAuthorizeReturnsCopy(t *testing.T) {
+	forEachRepo(t, func(t *testing.T, r Repo) {
+		if _, err := r.Register("alice", "password1"); err != nil {
+			t.Fatal(err)
+		}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			if _, err := r.SetEmail("alice", "alice@example.com"); err != nil {
+				t.Error(err)
+			}
+		}()
+		u, err := r.Authorize("alice", "password1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		u.Admin = true
+		<-done
+
+		got, err := r.GetByUsername("alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Admin {
+			t.Error("changing the authorized user made alice an admin")
+		}
+	})
+}